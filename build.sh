@@ -24,16 +24,18 @@
 # - v: driver version
 # - i: path to main.go
 # - o: path to output
+# - t: build tags (optional), e.g. "debug"
 #
-# Usage: build.sh -v <version> -i <input> -o <output>
+# Usage: build.sh -v <version> -i <input> -o <output> [-t <tags>]
 
 set -euo pipefail
 
 IN=""
 OUT=""
 VERSION=""
+TAGS=""
 
-while getopts "i:o:v:" opt; do
+while getopts "i:o:v:t:" opt; do
   case $opt in
     i) IN="$OPTARG"
        ;;
@@ -41,6 +43,8 @@ while getopts "i:o:v:" opt; do
        ;;
     v) VERSION="$OPTARG"
        ;;
+    t) TAGS="$OPTARG"
+       ;;
     \?) echo "Invalid option: -$OPTARG" >&2; exit 1 ;;
   esac
 done
@@ -60,7 +64,7 @@ ensure_flag_set "-i" "$IN"
 ensure_flag_set "-o" "$OUT"
 
 if [ "$all_flags_set" = false ]; then
-  echo "Usage: build-driver -v <version> -i <input> -o <output>"
+  echo "Usage: build-driver -v <version> -i <input> -o <output> [-t <tags>]"
   exit 1
 fi
 
@@ -81,7 +85,8 @@ if [ $VERBOSE = "1" ]; then
   echo "GCO_ENABLED=${CGO_ENABLED:=}"
   echo "CC=${CC:=}"
   echo "LD_FLAGS=$LD_FLAGS"
+  echo "TAGS=$TAGS"
 fi
 
-go build  -ldflags "$LD_FLAGS" -o "$OUT" "$IN"
+go build -tags "$TAGS" -ldflags "$LD_FLAGS" -o "$OUT" "$IN"
 echo "Built $OUT"
@@ -7,6 +7,7 @@ require (
 	github.com/cskr/pubsub v1.0.2
 	github.com/denisbrodbeck/machineid v1.0.1
 	github.com/ebfe/scard v0.0.0-20190212122703-c3d1b1916a95
+	github.com/fxamacker/cbor/v2 v2.2.0
 	github.com/gorilla/websocket v1.4.2
 	github.com/kardianos/service v1.2.0
 
@@ -0,0 +1,93 @@
+package auth
+
+/* Roles of clients authenticated with a token.
+
+Roles are ordered, each role may do everything the previous ones may:
+
+- mirror: receive data and status, but send no commands (mirror endpoints only)
+- observer: receive data and status
+- operator: additionally control devices (connect, disconnect, send commands, pair)
+- maintenance: additionally update firmware
+
+Requests without a role, e.g. local connections to the default endpoints, are
+not restricted.
+
+*/
+
+import (
+	"context"
+	"fmt"
+)
+
+// Role of a client
+type Role int
+
+const (
+	Mirror Role = iota + 1
+	Observer
+	Operator
+	Maintenance
+)
+
+// ParseRole parses the name of a role as used in configuration
+func ParseRole(name string) (Role, error) {
+	switch name {
+	case "observer":
+		return Observer, nil
+	case "operator":
+		return Operator, nil
+	case "maintenance":
+		return Maintenance, nil
+	default:
+		return 0, fmt.Errorf("unknown role %q", name)
+	}
+}
+
+func (role Role) String() string {
+	switch role {
+	case Mirror:
+		return "mirror"
+	case Observer:
+		return "observer"
+	case Operator:
+		return "operator"
+	case Maintenance:
+		return "maintenance"
+	default:
+		return "unrestricted"
+	}
+}
+
+// Allows returns whether the role includes the required role
+func (role Role) Allows(required Role) bool {
+	// Unrestricted
+	if role == 0 {
+		return true
+	}
+	return role >= required
+}
+
+type contextKey struct{}
+
+// WithRole attaches the role of an authenticated client to a request context
+func WithRole(ctx context.Context, role Role) context.Context {
+	return context.WithValue(ctx, contextKey{}, role)
+}
+
+// RoleFrom returns the role attached to the context, zero (unrestricted) if none
+func RoleFrom(ctx context.Context) Role {
+	role, _ := ctx.Value(contextKey{}).(Role)
+	return role
+}
+
+// PermissionDenied describes a command refused because of the client's role
+type PermissionDenied struct {
+	Command  string `json:"command"`
+	Role     string `json:"role"`
+	Required string `json:"required"`
+}
+
+// Deny returns the description of a refused command
+func Deny(command string, role Role, required Role) PermissionDenied {
+	return PermissionDenied{Command: command, Role: role.String(), Required: required.String()}
+}
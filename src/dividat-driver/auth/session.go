@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sessions grant temporary read-only access, e.g. to let support staff observe
+// devices remotely. A session is the expiry in Unix seconds and an HMAC-SHA256
+// signature of it and the instance it is valid for, keyed with a token
+// granting maintenance.
+
+// MaxSessionDuration is the longest a session may last
+const MaxSessionDuration = 24 * time.Hour
+
+// SignSession returns a session for the instance valid until the given time
+func SignSession(key string, instance string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return expiry + "." + sign(key, instance, expiry)
+}
+
+// VerifySession returns the expiry of a session signed with the key for the
+// instance, false if the session is invalid, expired or expires further than
+// MaxSessionDuration from now.
+func VerifySession(key string, instance string, session string, now time.Time) (time.Time, bool) {
+	parts := strings.SplitN(session, ".", 2)
+	if len(parts) != 2 {
+		return time.Time{}, false
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(sign(key, instance, parts[0]))) {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	expires := time.Unix(seconds, 0)
+	if !now.Before(expires) || expires.Sub(now) > MaxSessionDuration {
+		return time.Time{}, false
+	}
+	return expires, true
+}
+
+func sign(key string, instance string, expiry string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("session:" + instance + ":" + expiry))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type expiryKey struct{}
+
+// WithExpiry attaches the end of a temporary session to a request context
+func WithExpiry(ctx context.Context, expires time.Time) context.Context {
+	return context.WithValue(ctx, expiryKey{}, expires)
+}
+
+// ExpiryFrom returns the end of the session attached to the context, false if access does not expire
+func ExpiryFrom(ctx context.Context) (time.Time, bool) {
+	expires, ok := ctx.Value(expiryKey{}).(time.Time)
+	return expires, ok
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifySession(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	expires := now.Add(30 * time.Minute)
+	session := SignSession("key", "ward", expires)
+
+	if verified, ok := VerifySession("key", "ward", session, now); !ok || !verified.Equal(expires) {
+		t.Errorf("Expected session valid until %v, got %v (valid %v)", expires, verified, ok)
+	}
+
+	for _, test := range []struct {
+		name     string
+		key      string
+		instance string
+		session  string
+		now      time.Time
+	}{
+		{"other key", "other", "ward", session, now},
+		{"other instance", "key", "lobby", session, now},
+		{"expired", "key", "ward", session, expires},
+		{"tampered expiry", "key", "ward", "1" + session, now},
+		{"malformed", "key", "ward", "1700000000", now},
+		{"too long", "key", "ward", SignSession("key", "ward", now.Add(MaxSessionDuration+time.Second)), now},
+	} {
+		if _, ok := VerifySession(test.key, test.instance, test.session, test.now); ok {
+			t.Errorf("%s: expected the session to be rejected", test.name)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package benchmark
+
+/* Measurements of the machine the driver runs on.
+
+`dividat-driver benchmark` measures how fast the machine parses Flex data
+from a mock device, encodes JSON messages and passes frames of various sizes
+through a local WebSocket, and prints a report comparing the results with
+thresholds. Installers run it to verify that a kiosk PC is fast enough before
+handing it over.
+
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/catalog"
+	"github.com/dividat/driver/src/dividat-driver/flex"
+	flexdevice "github.com/dividat/driver/src/dividat-driver/flex/device"
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+)
+
+// Thresholds, with plenty of headroom over what a Flex device at 100 Hz and
+// a handful of clients require
+const (
+	minSetsPerSecond     = 1000
+	minMessagesPerSecond = 20000
+	maxRoundTrip         = 10 * time.Millisecond
+)
+
+// Samples in each set of the mock device, a fully loaded mat
+const mockSamples = 2048
+
+// Sizes of WebSocket frames whose round trip is measured
+var frameSizes = []int{1 << 10, 8 << 10, 64 << 10}
+
+// Round trips measured per frame size
+const roundTrips = 200
+
+// Result of a measurement
+type Result struct {
+	Name      string  `json:"name"`
+	Value     float64 `json:"value"`
+	Unit      string  `json:"unit"`
+	Threshold float64 `json:"threshold"`
+	Passed    bool    `json:"passed"`
+	// Why the measurement could not be taken, empty if it was
+	Error string `json:"error,omitempty"`
+}
+
+// Command runs the measurements and prints a report, exiting with status 1 if
+// any measurement did not meet its threshold
+func Command(flags []string) {
+	benchmarkFlags := flag.NewFlagSet("benchmark", flag.ExitOnError)
+	duration := benchmarkFlags.Duration("duration", 3*time.Second, "Duration of each throughput measurement")
+	asJSON := benchmarkFlags.Bool("json", false, "Print the results as JSON")
+	benchmarkFlags.Parse(flags)
+
+	results := []Result{
+		atLeast("Flex parsing", "sets/s", minSetsPerSecond, func() (float64, error) { return flexParsing(*duration) }),
+		atLeast("JSON encoding", "messages/s", minMessagesPerSecond, func() (float64, error) { return jsonEncoding(*duration) }),
+	}
+	for _, size := range frameSizes {
+		name := fmt.Sprintf("WebSocket %d KiB", size>>10)
+		results = append(results, atMost(name, "ms (p99)", float64(maxRoundTrip)/float64(time.Millisecond), func() (float64, error) {
+			p99, err := webSocketRoundTrip(size)
+			return float64(p99) / float64(time.Millisecond), err
+		}))
+	}
+
+	failures := 0
+	for _, result := range results {
+		if !result.Passed {
+			failures++
+		}
+	}
+
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(results)
+	} else {
+		for _, result := range results {
+			printResult(result)
+		}
+		if failures > 0 {
+			fmt.Printf("\n%d measurement(s) below threshold.\n", failures)
+		} else {
+			fmt.Println("\nAll measurements within thresholds.")
+		}
+	}
+
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
+
+func atLeast(name string, unit string, threshold float64, measure func() (float64, error)) Result {
+	value, err := measure()
+	return newResult(name, unit, threshold, value, err, value >= threshold)
+}
+
+func atMost(name string, unit string, threshold float64, measure func() (float64, error)) Result {
+	value, err := measure()
+	return newResult(name, unit, threshold, value, err, value <= threshold)
+}
+
+func newResult(name string, unit string, threshold float64, value float64, err error, passed bool) Result {
+	result := Result{Name: name, Value: value, Unit: unit, Threshold: threshold, Passed: passed && err == nil}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func printResult(result Result) {
+	label := " OK "
+	if !result.Passed {
+		label = "FAIL"
+	}
+	if result.Error != "" {
+		fmt.Printf("[%s] %-20s %s\n", label, result.Name, result.Error)
+		return
+	}
+	fmt.Printf("[%s] %-20s %.1f %s (threshold %.0f)\n", label, result.Name, result.Value, result.Unit, result.Threshold)
+}
+
+// Sets per second the Flex protocol handler parses from a mock device
+// streaming fully loaded sets as fast as they are read
+func flexParsing(duration time.Duration) (float64, error) {
+	device := enumerator.Device{VID: 0x16C0}
+	_, handler, ok := flexdevice.For(device)
+	if !ok {
+		return 0, fmt.Errorf("no handler for Flex devices registered")
+	}
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+
+	sets := 0
+	started := time.Now()
+	handler(ctx, flexdevice.Conn{
+		Port:   &mockPort{ctx: ctx, set: mockSet(mockSamples)},
+		Logger: logger.WithField("package", "benchmark"),
+		Capabilities: flex.Capabilities{
+			Revision:  "mock",
+			Streaming: true,
+			Bitdepths: []int{8},
+			Commands:  flex.Commands{Start: []byte{'S', '\n'}},
+		},
+		Format:       flexdevice.Format{Bitdepth: 8, Command: []byte{'U', 'L', '\n'}, BytesPerSample: 3},
+		Valid:        func([]byte, int) bool { return true },
+		Received:     func() {},
+		Dropped:      func() {},
+		Resynced:     func() {},
+		Answered:     func(time.Duration) {},
+		Receive:      func([]byte, int) { sets++ },
+		Line:         func(string) {},
+		Unresponsive: func(catalog.Text) {},
+	})
+	return float64(sets) / time.Since(started).Seconds(), nil
+}
+
+// SensingTex set of 8-bit samples
+func mockSet(samples int) []byte {
+	set := []byte{'N', '\n', byte(samples >> 8), byte(samples), 'P', '\n'}
+	for i := 0; i < samples; i++ {
+		set = append(set, byte(i/64), byte(i%64), byte(i))
+	}
+	return set
+}
+
+// Sends the same set over and over, like a streaming device
+type mockPort struct {
+	ctx    context.Context
+	set    []byte
+	offset int
+}
+
+func (port *mockPort) Read(p []byte) (int, error) {
+	if port.ctx.Err() != nil {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) {
+		copied := copy(p[n:], port.set[port.offset:])
+		n += copied
+		port.offset = (port.offset + copied) % len(port.set)
+	}
+	return n, nil
+}
+
+func (port *mockPort) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Flex status messages encoded per second
+func jsonEncoding(duration time.Duration) (float64, error) {
+	bcdDevice, version := "0502", "5.02"
+	message := flex.Message{Status: &flex.Status{
+		Device: &flex.DeviceInfo{
+			Path:            "/dev/ttyACM0",
+			VID:             "16C0",
+			PID:             "0483",
+			SerialNumber:    "FLX0001",
+			BcdDevice:       &bcdDevice,
+			FirmwareVersion: &version,
+			Revision:        "v5",
+			Bitdepths:       []int{8, 12},
+			Acquisition:     "polled",
+			Handler:         "sensing-tex",
+			Bitdepth:        8,
+			BytesPerSample:  3,
+		},
+		PortsInUse: []string{},
+	}}
+
+	messages := 0
+	started := time.Now()
+	for time.Since(started) < duration {
+		_, err := json.Marshal(&message)
+		if err != nil {
+			return 0, err
+		}
+		messages++
+	}
+	return float64(messages) / time.Since(started).Seconds(), nil
+}
+
+// 99th percentile of round trips of binary frames through a local WebSocket echo server
+func webSocketRoundTrip(size int) (time.Duration, error) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if conn.WriteMessage(messageType, msg) != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	frame := make([]byte, size)
+	durations := make([]time.Duration, 0, roundTrips)
+	for i := 0; i < roundTrips; i++ {
+		started := time.Now()
+		err := conn.WriteMessage(websocket.BinaryMessage, frame)
+		if err != nil {
+			return 0, err
+		}
+		_, _, err = conn.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		durations = append(durations, time.Since(started))
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[len(durations)*99/100], nil
+}
@@ -0,0 +1,107 @@
+package broker
+
+/* Publish-subscribe broker whose subscriptions are owned by a context.
+
+Handlers subscribe for the lifetime of a connection and unsubscribe when it
+ends. A handler goroutine that dies without running its cleanup, e.g. after a
+panic, would leave its subscription behind, and every publication would keep
+offering messages to a channel nobody reads. Subscriptions taken with SubFor
+are therefore removed as soon as their context is done, whether or not the
+owner unsubscribed.
+
+*/
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cskr/pubsub"
+)
+
+// Broker wraps a pubsub.PubSub, tracking subscriptions owned by a context
+type Broker struct {
+	*pubsub.PubSub
+
+	mutex sync.Mutex
+	// Owned subscriptions that have not been removed yet
+	owned map[chan interface{}]bool
+	// Owned subscriptions removed only because their context was done
+	pruned int
+	// Unsubscribing blocks once the broker is shut down
+	shutdown bool
+}
+
+// New returns a broker creating channels of the given capacity
+func New(capacity int) *Broker {
+	return &Broker{
+		PubSub: pubsub.New(capacity),
+		owned:  map[chan interface{}]bool{},
+	}
+}
+
+// SubFor subscribes to the topics until the context is done or the channel is
+// unsubscribed, whichever happens first
+func (broker *Broker) SubFor(ctx context.Context, topics ...string) chan interface{} {
+	ch := broker.PubSub.Sub(topics...)
+
+	broker.mutex.Lock()
+	broker.owned[ch] = true
+	broker.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		broker.mutex.Lock()
+		defer broker.mutex.Unlock()
+		if !broker.owned[ch] {
+			return
+		}
+		delete(broker.owned, ch)
+		broker.pruned++
+		if !broker.shutdown {
+			broker.PubSub.Unsub(ch)
+		}
+	}()
+
+	return ch
+}
+
+// Unsub unsubscribes the channel from the topics, or from all topics if none
+// are given. Does nothing once the broker is shut down.
+func (broker *Broker) Unsub(ch chan interface{}, topics ...string) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if len(topics) == 0 {
+		delete(broker.owned, ch)
+	}
+	if broker.shutdown {
+		return
+	}
+	broker.PubSub.Unsub(ch, topics...)
+}
+
+// Shutdown closes all channels and stops the broker
+func (broker *Broker) Shutdown() {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if broker.shutdown {
+		return
+	}
+	broker.shutdown = true
+	broker.owned = map[chan interface{}]bool{}
+	broker.PubSub.Shutdown()
+}
+
+// Owned returns the number of owned subscriptions that are still open
+func (broker *Broker) Owned() int {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return len(broker.owned)
+}
+
+// Pruned returns the number of owned subscriptions that were left behind by
+// their owner and removed when its context was done
+func (broker *Broker) Pruned() int {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return broker.pruned
+}
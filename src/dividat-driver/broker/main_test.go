@@ -0,0 +1,116 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Wait for the channel to be closed by the broker, draining it
+func closedWithin(ch chan interface{}, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return true
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func TestSubscriptionOfPanickingHandlerIsPruned(t *testing.T) {
+	broker := New(1)
+	defer broker.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	subscribed := make(chan chan interface{})
+
+	// Handler dies before it gets to unsubscribe
+	go func() {
+		defer func() {
+			recover()
+		}()
+		ch := broker.SubFor(ctx, "rx")
+		subscribed <- ch
+		panic("handler failed")
+	}()
+	ch := <-subscribed
+
+	if broker.Owned() != 1 {
+		t.Fatalf("owned %d subscriptions, expected 1", broker.Owned())
+	}
+
+	cancel()
+	if !closedWithin(ch, time.Second) {
+		t.Fatalf("subscription of dead handler was not removed")
+	}
+	if broker.Owned() != 0 || broker.Pruned() != 1 {
+		t.Errorf("owned %d, pruned %d subscriptions, expected 0 and 1", broker.Owned(), broker.Pruned())
+	}
+}
+
+func TestSubscriptionOfAbandonedLoopIsPruned(t *testing.T) {
+	broker := New(1)
+	defer broker.Shutdown()
+
+	// Reader stops consuming without unsubscribing, the channel fills up
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := broker.SubFor(ctx, "rx")
+	for i := 0; i < 10; i++ {
+		broker.TryPub(i, "rx")
+	}
+
+	cancel()
+	if !closedWithin(ch, time.Second) {
+		t.Fatalf("subscription of abandoned loop was not removed")
+	}
+	if broker.Pruned() != 1 {
+		t.Errorf("pruned %d subscriptions, expected 1", broker.Pruned())
+	}
+}
+
+func TestUnsubscribedChannelIsNotPruned(t *testing.T) {
+	broker := New(1)
+	defer broker.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := broker.SubFor(ctx, "rx")
+	broker.Unsub(ch)
+	cancel()
+
+	if !closedWithin(ch, time.Second) {
+		t.Fatalf("unsubscribed channel was not closed")
+	}
+	// Give the supervisor a chance to run
+	time.Sleep(10 * time.Millisecond)
+	if broker.Owned() != 0 || broker.Pruned() != 0 {
+		t.Errorf("owned %d, pruned %d subscriptions, expected none", broker.Owned(), broker.Pruned())
+	}
+}
+
+func TestContextDoneAfterShutdown(t *testing.T) {
+	broker := New(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := broker.SubFor(ctx, "rx")
+	broker.Shutdown()
+	cancel()
+
+	if !closedWithin(ch, time.Second) {
+		t.Fatalf("channel was not closed on shutdown")
+	}
+	// Unsubscribing must not block on the stopped broker
+	done := make(chan struct{})
+	go func() {
+		broker.Unsub(ch)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("unsubscribing after shutdown blocked")
+	}
+}
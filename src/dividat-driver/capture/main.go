@@ -0,0 +1,211 @@
+package capture
+
+/* Capture file format for recorded device data.
+
+Captures are line-based text files, so they can be streamed while recording
+and still be read by the replay tools:
+
+    #DIVIDAT-CAPTURE 1
+    #META {"source":"ws://localhost:8382/senso","startedAt":"2024-01-01T12:00:00Z","deviceType":"senso","serialNumber":"SN123","firmwareVersion":"3.4.0"}
+    0, <base64 encoded chunk>
+    20, <base64 encoded chunk>
+    ...
+    #SHA256 <hex digest>
+
+Each chunk line is prefixed by the milliseconds elapsed since the previous
+chunk. The trailing line holds the SHA-256 digest of all preceding bytes,
+so a capture can be validated after transfer. A capture without trailer has
+been cut short.
+
+Lines starting with `#` are ignored by the replay tools, raw recordings
+without header remain replayable but can not be verified.
+
+Flex sets recorded in an envelope with sequence numbers (version 2) are checked
+for gaps, revealing sets the driver did not forward while recording.
+
+*/
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+)
+
+const magicLine = "#DIVIDAT-CAPTURE 1"
+const metadataPrefix = "#META "
+const checksumPrefix = "#SHA256 "
+
+// Metadata describes a capture
+type Metadata struct {
+	Source    string    `json:"source"`
+	StartedAt time.Time `json:"startedAt"`
+	// "senso" or "flex"
+	DeviceType string `json:"deviceType,omitempty"`
+	// As reported by the driver when recording started, empty if unknown
+	SerialNumber    string `json:"serialNumber,omitempty"`
+	FirmwareVersion string `json:"firmwareVersion,omitempty"`
+}
+
+// Writer writes a capture, computing the checksum while streaming
+type Writer struct {
+	out      io.Writer
+	checksum hash.Hash
+	previous time.Time
+}
+
+// NewWriter writes the header of a capture and returns a writer for chunks
+func NewWriter(out io.Writer, metadata Metadata) (*Writer, error) {
+	writer := Writer{
+		out:      out,
+		checksum: sha256.New(),
+		previous: metadata.StartedAt,
+	}
+
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.writeLine(magicLine)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.writeLine(metadataPrefix + string(encoded))
+	if err != nil {
+		return nil, err
+	}
+
+	return &writer, nil
+}
+
+// WriteChunk appends data received at the given time
+func (writer *Writer) WriteChunk(data []byte, at time.Time) error {
+	elapsed := at.Sub(writer.previous)
+	writer.previous = at
+	return writer.writeLine(fmt.Sprintf("%d, %s", elapsed.Nanoseconds()/1000000, base64.StdEncoding.EncodeToString(data)))
+}
+
+// Close writes the trailing checksum, no chunks may be written afterwards
+func (writer *Writer) Close() error {
+	_, err := io.WriteString(writer.out, checksumPrefix+hex.EncodeToString(writer.checksum.Sum(nil))+"\n")
+	return err
+}
+
+func (writer *Writer) writeLine(line string) error {
+	bytes := []byte(line + "\n")
+	writer.checksum.Write(bytes)
+	_, err := writer.out.Write(bytes)
+	return err
+}
+
+// Summary of a verified capture
+type Summary struct {
+	Metadata Metadata
+	Chunks   int
+	Duration time.Duration
+	// Sets missing between sequence numbers of Flex envelopes
+	Dropped uint64
+}
+
+// Version and size of the Flex envelope with sequence numbers
+const (
+	sequencedEnvelopeVersion = 2
+	sequencedHeaderSize      = 25
+)
+
+// Sequence number of a Flex set in a sequenced envelope
+func sequenceOf(chunk []byte) (uint64, bool) {
+	if len(chunk) < sequencedHeaderSize || chunk[0] != sequencedEnvelopeVersion {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(chunk[17:25]), true
+}
+
+// Verify reads a capture and checks its structure and checksum
+func Verify(in io.Reader) (*Summary, error) {
+	reader := bufio.NewReader(in)
+	checksum := sha256.New()
+	summary := Summary{}
+	var previous uint64
+	sequenced := false
+
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return "", io.EOF
+		} else if err != nil && err != io.EOF {
+			return "", err
+		}
+		return line, nil
+	}
+
+	line, err := readLine()
+	if err != nil || strings.TrimRight(line, "\n") != magicLine {
+		return nil, errors.New("missing capture header, this may be a raw recording")
+	}
+	checksum.Write([]byte(line))
+
+	line, err = readLine()
+	if err != nil || !strings.HasPrefix(line, metadataPrefix) {
+		return nil, errors.New("missing capture metadata")
+	}
+	err = json.Unmarshal([]byte(strings.TrimPrefix(strings.TrimRight(line, "\n"), metadataPrefix)), &summary.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("invalid capture metadata: %v", err)
+	}
+	checksum.Write([]byte(line))
+
+	for {
+		line, err = readLine()
+		if err == io.EOF || (err == nil && !strings.HasSuffix(line, "\n")) {
+			return nil, fmt.Errorf("missing checksum after %d chunks, capture is incomplete", summary.Chunks)
+		} else if err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(line, checksumPrefix) {
+			expected := strings.TrimSpace(strings.TrimPrefix(line, checksumPrefix))
+			actual := hex.EncodeToString(checksum.Sum(nil))
+			if expected != actual {
+				return nil, fmt.Errorf("checksum mismatch, capture has been modified or corrupted")
+			}
+			break
+		}
+
+		var elapsed int64
+		var encoded string
+		_, err = fmt.Sscanf(strings.TrimRight(line, "\n"), "%d, %s", &elapsed, &encoded)
+		if err != nil {
+			return nil, fmt.Errorf("malformed chunk %d: %v", summary.Chunks+1, err)
+		}
+		chunk, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("malformed data in chunk %d: %v", summary.Chunks+1, err)
+		}
+		if sequence, ok := sequenceOf(chunk); ok {
+			if sequenced && sequence > previous+1 {
+				summary.Dropped += sequence - previous - 1
+			}
+			previous, sequenced = sequence, true
+		}
+
+		checksum.Write([]byte(line))
+		summary.Chunks++
+		summary.Duration += time.Duration(elapsed) * time.Millisecond
+	}
+
+	if _, err = readLine(); err != io.EOF {
+		return nil, errors.New("unexpected data after checksum")
+	}
+
+	return &summary, nil
+}
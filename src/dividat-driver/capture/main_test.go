@@ -0,0 +1,92 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+var startedAt = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// Capture of the chunks, received 20ms apart
+func record(t *testing.T, metadata Metadata, chunks ...[]byte) string {
+	var out bytes.Buffer
+	writer, err := NewWriter(&out, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := metadata.StartedAt
+	for _, chunk := range chunks {
+		at = at.Add(20 * time.Millisecond)
+		if err := writer.WriteChunk(chunk, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// Flex set in an envelope with the sequence number
+func sequencedSet(sequence uint64) []byte {
+	chunk := make([]byte, sequencedHeaderSize+2)
+	chunk[0] = sequencedEnvelopeVersion
+	binary.BigEndian.PutUint64(chunk[17:25], sequence)
+	return chunk
+}
+
+func TestVerify(t *testing.T) {
+	metadata := Metadata{
+		Source:          "ws://localhost:8382/flex",
+		StartedAt:       startedAt,
+		DeviceType:      "flex",
+		SerialNumber:    "F123",
+		FirmwareVersion: "5.02",
+	}
+	summary, err := Verify(strings.NewReader(record(t, metadata, []byte{1, 2}, []byte{3})))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if summary.Metadata != metadata {
+		t.Errorf("Expected metadata %+v, got %+v", metadata, summary.Metadata)
+	}
+	if summary.Chunks != 2 || summary.Duration != 40*time.Millisecond || summary.Dropped != 0 {
+		t.Errorf("Expected 2 chunks over 40ms, got %+v", summary)
+	}
+}
+
+func TestVerifyCountsDroppedSets(t *testing.T) {
+	capture := record(t, Metadata{StartedAt: startedAt}, sequencedSet(7), sequencedSet(8), sequencedSet(12), []byte{1}, sequencedSet(13))
+	summary, err := Verify(strings.NewReader(capture))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if summary.Dropped != 3 {
+		t.Errorf("Expected 3 dropped sets, got %d", summary.Dropped)
+	}
+}
+
+func TestVerifyRejectsInvalidCaptures(t *testing.T) {
+	valid := record(t, Metadata{Source: "ws://localhost:8382/senso", StartedAt: startedAt}, []byte{1, 2}, []byte{3})
+	lines := strings.SplitAfter(valid, "\n")
+
+	for name, capture := range map[string]string{
+		"raw recording":       "0, AQI=\n",
+		"missing metadata":    lines[0] + lines[2],
+		"invalid metadata":    lines[0] + "#META {\n" + strings.Join(lines[2:], ""),
+		"truncated":           strings.Join(lines[:3], ""),
+		"truncated checksum":  strings.TrimSuffix(valid, "\n"),
+		"modified chunk":      strings.Replace(valid, "AQI=", "AQM=", 1),
+		"malformed chunk":     strings.Join(lines[:2], "") + "AQI=\n" + strings.Join(lines[3:], ""),
+		"malformed data":      strings.Replace(valid, "AQI=", "A!I=", 1),
+		"data after checksum": valid + "0, AQI=\n",
+		"empty":               "",
+	} {
+		if _, err := Verify(strings.NewReader(capture)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package catalog
+
+/* Catalog of messages shown to end users.
+
+Messages that clients may display, such as firmware update progress and
+hints about failing devices, are identified by a stable ID and parameters
+instead of being formatted in English right away. Each WebSocket connection
+renders them in the language its client asked for, with the `lang` query
+parameter or the Accept-Language header, falling back to English. Clients
+may also render them themselves from ID and parameters.
+
+Parameters are filled into templates by name, e.g. `{serial}`. Details such as
+error descriptions from the operating system stay in English.
+
+*/
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Languages messages are available in, the first is the fallback
+var Languages = []string{"en", "de", "fr", "it"}
+
+// Text is a message to be rendered in the language of the client
+type Text struct {
+	ID     string            `json:"id"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// New returns the message with given ID, parameters given as name-value pairs
+func New(id string, params ...string) Text {
+	text := Text{ID: id}
+	if len(params) > 1 {
+		text.Params = map[string]string{}
+		for i := 0; i+1 < len(params); i += 2 {
+			text.Params[params[i]] = params[i+1]
+		}
+	}
+	return text
+}
+
+// In renders the message in the language, in English if it is not available
+func (text Text) In(language string) string {
+	translations, ok := templates[text.ID]
+	if !ok {
+		return text.ID
+	}
+	template, ok := translations[language]
+	if !ok {
+		template = translations[Languages[0]]
+	}
+	replacements := []string{}
+	for name, value := range text.Params {
+		replacements = append(replacements, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(replacements...).Replace(template)
+}
+
+// String renders the message in English
+func (text Text) String() string {
+	return text.In(Languages[0])
+}
+
+// Language returns the language a client asked for with the `lang` query
+// parameter or the Accept-Language header, English if none is available
+func Language(r *http.Request) string {
+	if language := supported(r.URL.Query().Get("lang")); language != "" {
+		return language
+	}
+	// Preferences are assumed to be listed in order, as browsers do
+	for _, preference := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag := strings.SplitN(preference, ";", 2)[0]
+		if language := supported(tag); language != "" {
+			return language
+		}
+	}
+	return Languages[0]
+}
+
+// Language of a tag like "de-CH", empty if not available
+func supported(tag string) string {
+	language := strings.ToLower(strings.TrimSpace(strings.SplitN(tag, "-", 2)[0]))
+	for _, available := range Languages {
+		if language == available {
+			return language
+		}
+	}
+	return ""
+}
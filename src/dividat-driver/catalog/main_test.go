@@ -0,0 +1,69 @@
+package catalog
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var placeholder = regexp.MustCompile(`\{[a-zA-Z]+\}`)
+
+func placeholders(template string) string {
+	found := placeholder.FindAllString(template, -1)
+	sort.Strings(found)
+	return strings.Join(found, ",")
+}
+
+func TestTemplatesAreComplete(t *testing.T) {
+	for id, translations := range templates {
+		expected := placeholders(translations[Languages[0]])
+		for _, language := range Languages {
+			template, ok := translations[language]
+			if !ok {
+				t.Errorf("%s is missing in %s", id, language)
+				continue
+			}
+			if placeholders(template) != expected {
+				t.Errorf("%s in %s has parameters %q, expected %q", id, language, placeholders(template), expected)
+			}
+		}
+	}
+}
+
+func TestRendering(t *testing.T) {
+	text := New("senso.discovered", "serial", "SN123", "address", "10.0.0.2")
+	if rendered := text.In("de"); rendered != "Senso gefunden: SN123 (10.0.0.2)" {
+		t.Errorf("unexpected rendering %q", rendered)
+	}
+	if text.In("es") != text.String() {
+		t.Errorf("expected unavailable language to fall back to English")
+	}
+	if rendered := New("unknown.id").In("fr"); rendered != "unknown.id" {
+		t.Errorf("expected unknown message to render as its ID, got %q", rendered)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	cases := []struct {
+		query          string
+		acceptLanguage string
+		expected       string
+	}{
+		{"", "", "en"},
+		{"?lang=fr", "de-CH", "fr"},
+		{"?lang=es", "de-CH,de;q=0.9", "de"},
+		{"", "es-ES, it;q=0.8, en;q=0.5", "it"},
+		{"", "es", "en"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/"+c.query, nil)
+		if c.acceptLanguage != "" {
+			r.Header.Set("Accept-Language", c.acceptLanguage)
+		}
+		if language := Language(r); language != c.expected {
+			t.Errorf("query %q, Accept-Language %q: got %s, expected %s", c.query, c.acceptLanguage, language, c.expected)
+		}
+	}
+}
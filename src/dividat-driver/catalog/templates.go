@@ -0,0 +1,207 @@
+package catalog
+
+// Templates of all messages by ID and language. IDs are stable, clients may
+// rely on them. Templates may change.
+var templates = map[string]map[string]string{
+
+	// Firmware updates
+
+	"firmware.decodeFailed": {
+		"en": "Error decoding base64 string: {error}",
+		"de": "Fehler beim Dekodieren der Base64-Zeichenkette: {error}",
+		"fr": "Erreur lors du décodage de la chaîne base64 : {error}",
+		"it": "Errore durante la decodifica della stringa base64: {error}",
+	},
+	"firmware.updateFailed": {
+		"en": "Failed to update firmware: {error}",
+		"de": "Firmware-Aktualisierung fehlgeschlagen: {error}",
+		"fr": "Échec de la mise à jour du firmware : {error}",
+		"it": "Aggiornamento del firmware non riuscito: {error}",
+	},
+
+	// Senso
+
+	"senso.disconnecting": {
+		"en": "Disconnecting from the Senso",
+		"de": "Verbindung zur Senso wird getrennt",
+		"fr": "Déconnexion du Senso",
+		"it": "Disconnessione dal Senso",
+	},
+	"senso.searching": {
+		"en": "Looking for Senso with specified serial {serial}",
+		"de": "Suche nach Senso mit Seriennummer {serial}",
+		"fr": "Recherche du Senso avec le numéro de série {serial}",
+		"it": "Ricerca del Senso con numero di serie {serial}",
+	},
+	"senso.found": {
+		"en": "Found Senso at {address}",
+		"de": "Senso gefunden unter {address}",
+		"fr": "Senso trouvé à {address}",
+		"it": "Senso trovato a {address}",
+	},
+	"senso.discovering": {
+		"en": "Discovering Sensos",
+		"de": "Suche nach Sensos",
+		"fr": "Recherche des Sensos",
+		"it": "Ricerca dei Senso",
+	},
+	"senso.discovered": {
+		"en": "Discovered Senso: {serial} ({address})",
+		"de": "Senso gefunden: {serial} ({address})",
+		"fr": "Senso trouvé : {serial} ({address})",
+		"it": "Senso trovato: {serial} ({address})",
+	},
+	"senso.sentDfu": {
+		"en": "Sent DFU command to {address}",
+		"de": "DFU-Befehl an {address} gesendet",
+		"fr": "Commande DFU envoyée à {address}",
+		"it": "Comando DFU inviato a {address}",
+	},
+	"senso.retrying": {
+		"en": "{error}\nRetrying in {delay}",
+		"de": "{error}\nNeuer Versuch in {delay}",
+		"fr": "{error}\nNouvelle tentative dans {delay}",
+		"it": "{error}\nNuovo tentativo tra {delay}",
+	},
+	"senso.searchingBootloader": {
+		"en": "Looking for Senso in bootloader mode",
+		"de": "Suche nach Senso im Bootloader-Modus",
+		"fr": "Recherche du Senso en mode bootloader",
+		"it": "Ricerca del Senso in modalità bootloader",
+	},
+	"senso.foundBootloaderAt": {
+		"en": "Found Senso in bootloader mode at {address}",
+		"de": "Senso im Bootloader-Modus gefunden unter {address}",
+		"fr": "Senso en mode bootloader trouvé à {address}",
+		"it": "Senso in modalità bootloader trovato a {address}",
+	},
+	"senso.foundBootloader": {
+		"en": "Found Senso in bootloader mode",
+		"de": "Senso im Bootloader-Modus gefunden",
+		"fr": "Senso en mode bootloader trouvé",
+		"it": "Senso in modalità bootloader trovato",
+	},
+	"senso.waitingForTftp": {
+		"en": "Waiting 10 seconds to ensure proper TFTP startup",
+		"de": "10 Sekunden warten, bis TFTP bereit ist",
+		"fr": "Attente de 10 secondes pour le démarrage de TFTP",
+		"it": "Attesa di 10 secondi per l'avvio di TFTP",
+	},
+	"senso.creatingTftpClient": {
+		"en": "Creating TFTP client",
+		"de": "TFTP-Client wird erstellt",
+		"fr": "Création du client TFTP",
+		"it": "Creazione del client TFTP",
+	},
+	"senso.attemptFailed": {
+		"en": "Failed on attempt {attempt}, retrying in {delay}",
+		"de": "Versuch {attempt} fehlgeschlagen, neuer Versuch in {delay}",
+		"fr": "Échec de la tentative {attempt}, nouvelle tentative dans {delay}",
+		"it": "Tentativo {attempt} non riuscito, nuovo tentativo tra {delay}",
+	},
+	"senso.preparingTransmission": {
+		"en": "Preparing transmission",
+		"de": "Übertragung wird vorbereitet",
+		"fr": "Préparation de la transmission",
+		"it": "Preparazione della trasmissione",
+	},
+	"senso.transmitting": {
+		"en": "Transmitting...",
+		"de": "Übertragung läuft...",
+		"fr": "Transmission en cours...",
+		"it": "Trasmissione in corso...",
+	},
+	"senso.transmitted": {
+		"en": "Transmitted {sent} of {total} KiB ({percent}%) at {rate} KiB/s",
+		"de": "{sent} von {total} KiB übertragen ({percent} %) mit {rate} KiB/s",
+		"fr": "{sent} sur {total} Kio transmis ({percent} %) à {rate} Kio/s",
+		"it": "Trasmessi {sent} di {total} KiB ({percent}%) a {rate} KiB/s",
+	},
+	"senso.transmissionRestarted": {
+		"en": "Transmission failed: {error}\nRestarting transmission (attempt {attempt} of {attempts})",
+		"de": "Übertragung fehlgeschlagen: {error}\nÜbertragung wird neu gestartet (Versuch {attempt} von {attempts})",
+		"fr": "Échec de la transmission : {error}\nRedémarrage de la transmission (tentative {attempt} sur {attempts})",
+		"it": "Trasmissione non riuscita: {error}\nRiavvio della trasmissione (tentativo {attempt} di {attempts})",
+	},
+	"senso.bytesSent": {
+		"en": "{bytes} bytes sent",
+		"de": "{bytes} Bytes gesendet",
+		"fr": "{bytes} octets envoyés",
+		"it": "{bytes} byte inviati",
+	},
+	"senso.firmwareTransmitted": {
+		"en": "Firmware successfully transmitted",
+		"de": "Firmware erfolgreich übertragen",
+		"fr": "Firmware transmis avec succès",
+		"it": "Firmware trasmesso correttamente",
+	},
+
+	// Flex
+
+	"flex.disconnecting": {
+		"en": "Disconnecting from the Flex device",
+		"de": "Verbindung zum Flex-Gerät wird getrennt",
+		"fr": "Déconnexion de l'appareil Flex",
+		"it": "Disconnessione dal dispositivo Flex",
+	},
+	"flex.rebootingIntoBootloader": {
+		"en": "Rebooting {path} into bootloader",
+		"de": "{path} wird in den Bootloader neu gestartet",
+		"fr": "Redémarrage de {path} en mode bootloader",
+		"it": "Riavvio di {path} in modalità bootloader",
+	},
+	"flex.foundBootloader": {
+		"en": "Found bootloader of {board}",
+		"de": "Bootloader von {board} gefunden",
+		"fr": "Bootloader de {board} trouvé",
+		"it": "Bootloader di {board} trovato",
+	},
+	"flex.writingFirmware": {
+		"en": "Writing firmware: {percent}%",
+		"de": "Firmware wird geschrieben: {percent} %",
+		"fr": "Écriture du firmware : {percent} %",
+		"it": "Scrittura del firmware: {percent}%",
+	},
+	"flex.rebootingIntoFirmware": {
+		"en": "Rebooting into new firmware",
+		"de": "Neustart mit neuer Firmware",
+		"fr": "Redémarrage avec le nouveau firmware",
+		"it": "Riavvio con il nuovo firmware",
+	},
+	"flex.waitingForReturn": {
+		"en": "Waiting for device to return",
+		"de": "Warten, bis das Gerät wieder verfügbar ist",
+		"fr": "Attente du retour de l'appareil",
+		"it": "In attesa che il dispositivo torni disponibile",
+	},
+	"flex.firmwareFlashed": {
+		"en": "Firmware successfully flashed",
+		"de": "Firmware erfolgreich geschrieben",
+		"fr": "Firmware écrit avec succès",
+		"it": "Firmware scritto correttamente",
+	},
+	"flex.powerCycling": {
+		"en": "Power cycling port {port} of USB hub {hub}",
+		"de": "Port {port} des USB-Hubs {hub} wird aus- und wieder eingeschaltet",
+		"fr": "Redémarrage de l'alimentation du port {port} du hub USB {hub}",
+		"it": "Riavvio dell'alimentazione della porta {port} dell'hub USB {hub}",
+	},
+	"flex.powerCycleFailed": {
+		"en": "Failed to power cycle device: {error}",
+		"de": "Gerät konnte nicht aus- und wieder eingeschaltet werden: {error}",
+		"fr": "Échec du redémarrage de l'alimentation de l'appareil : {error}",
+		"it": "Riavvio dell'alimentazione del dispositivo non riuscito: {error}",
+	},
+	"flex.powerCycled": {
+		"en": "Device power cycled",
+		"de": "Gerät aus- und wieder eingeschaltet",
+		"fr": "Alimentation de l'appareil redémarrée",
+		"it": "Alimentazione del dispositivo riavviata",
+	},
+	"flex.unresponsive": {
+		"en": "No data received for {timeout} after {restarts} restarts, reopening serial port.",
+		"de": "Seit {timeout} keine Daten nach {restarts} Neustarts erhalten, serieller Port wird neu geöffnet.",
+		"fr": "Aucune donnée reçue pendant {timeout} après {restarts} redémarrages, réouverture du port série.",
+		"it": "Nessun dato ricevuto per {timeout} dopo {restarts} riavvii, riapertura della porta seriale.",
+	},
+}
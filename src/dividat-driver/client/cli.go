@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Command lists the drivers on the local network and checks whether each is
+// responding, exiting with status 1 if any is not
+func Command(flags []string) {
+	listFlags := flag.NewFlagSet("list-drivers", flag.ExitOnError)
+	timeout := listFlags.Duration("timeout", 5*time.Second, "How long to browse for drivers")
+	caPath := listFlags.String("ca", "", "PEM file with the authority issuing the drivers' TLS certificates (optional, system authorities are used otherwise)")
+	listFlags.Parse(flags)
+
+	tlsConfig := &tls.Config{}
+	if *caPath != "" {
+		pem, err := ioutil.ReadFile(*caPath)
+		if err != nil {
+			fmt.Printf("Could not read authority: %v\n", err)
+			os.Exit(1)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			fmt.Printf("No certificate found in %s\n", *caPath)
+			os.Exit(1)
+		}
+	}
+	httpClient := &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+
+	drivers, err := ListDrivers(context.Background(), *timeout)
+	if err != nil {
+		fmt.Printf("Could not browse for drivers: %v\n", err)
+		os.Exit(1)
+	}
+	if len(drivers) == 0 {
+		fmt.Println("No drivers found.")
+		return
+	}
+
+	if report(context.Background(), os.Stdout, httpClient, drivers) > 0 {
+		os.Exit(1)
+	}
+}
+
+// Print a line per driver with the result of its check, returning the number
+// of drivers not responding
+func report(ctx context.Context, out io.Writer, httpClient *http.Client, drivers []Driver) int {
+	failures := 0
+	for _, driver := range drivers {
+		info, err := Check(ctx, httpClient, driver)
+		if err != nil {
+			failures++
+			fmt.Fprintf(out, "%s\t%s\tnot responding: %v\n", driver.Instance, driver.URL(), err)
+			continue
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s (%s/%s)\n", driver.Instance, driver.URL(), info.Version, info.Os, info.Arch)
+	}
+	return failures
+}
@@ -0,0 +1,171 @@
+package client
+
+/* Helpers for applications talking to drivers over the network.
+
+Drivers accepting remote connections announce themselves via mDNS, so central
+dashboards can enumerate all drivers on a site and check their health.
+
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/zeroconf/v2"
+)
+
+// ServiceType of the mDNS announcement of drivers
+const ServiceType = "_dividat-driver._tcp"
+
+// Driver is a driver discovered on the network
+type Driver struct {
+	// Instance name, host name and label of the installation
+	Instance  string
+	Hostname  string
+	Label     string
+	Version   string
+	MachineId string
+
+	Addresses []net.IP
+	Port      int
+}
+
+// InstanceName combines host name and optional label into a name that is
+// unique per installation and readable in dashboards
+func InstanceName(hostname string, label string) string {
+	if label == "" {
+		return hostname
+	}
+	return fmt.Sprintf("%s (%s)", hostname, label)
+}
+
+// TXT records of the announcement
+func Text(hostname string, label string, version string, machineId string) []string {
+	return []string{
+		"hostname=" + hostname,
+		"label=" + label,
+		"version=" + version,
+		"machineId=" + machineId,
+	}
+}
+
+// ListDrivers browses for drivers on the local network during the timeout
+func ListDrivers(ctx context.Context, timeout time.Duration) ([]Driver, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	entries := make(chan *zeroconf.ServiceEntry)
+	drivers := []Driver{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for entry := range entries {
+			if entry != nil {
+				drivers = append(drivers, fromEntry(*entry))
+			}
+		}
+	}()
+
+	err := zeroconf.Browse(ctx, ServiceType, "local.", entries)
+	if err != nil {
+		return nil, err
+	}
+	<-ctx.Done()
+	<-done
+
+	return drivers, nil
+}
+
+func fromEntry(entry zeroconf.ServiceEntry) Driver {
+	driver := Driver{
+		Instance:  unescape(entry.Instance),
+		Addresses: append(entry.AddrIPv4, entry.AddrIPv6...),
+		Port:      entry.Port,
+	}
+	for _, field := range entry.Text {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "hostname":
+			driver.Hostname = parts[1]
+		case "label":
+			driver.Label = parts[1]
+		case "version":
+			driver.Version = parts[1]
+		case "machineId":
+			driver.MachineId = parts[1]
+		}
+	}
+	return driver
+}
+
+// Instance names are received with DNS escaping of spaces and punctuation
+func unescape(name string) string {
+	var unescaped strings.Builder
+	escaped := false
+	for _, r := range name {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		unescaped.WriteRune(r)
+	}
+	return unescaped.String()
+}
+
+// URL of the driver's remote endpoint, empty if no address is known
+func (driver Driver) URL() string {
+	if len(driver.Addresses) == 0 {
+		return ""
+	}
+	return "https://" + net.JoinHostPort(driver.Addresses[0].String(), strconv.Itoa(driver.Port))
+}
+
+// Info is what a driver reports at its root endpoint
+type Info struct {
+	Message   string          `json:"message"`
+	Version   string          `json:"version"`
+	MachineId string          `json:"machineId"`
+	Os        string          `json:"os"`
+	Arch      string          `json:"arch"`
+	Features  map[string]bool `json:"features"`
+}
+
+// Check requests the driver's root endpoint to verify it is responding.
+// The HTTP client must trust the driver's TLS certificate.
+func Check(ctx context.Context, httpClient *http.Client, driver Driver) (*Info, error) {
+	url := driver.URL()
+	if url == "" {
+		return nil, fmt.Errorf("no address known for %s", driver.Instance)
+	}
+
+	request, err := http.NewRequest("GET", url+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := httpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	var info Info
+	err = json.NewDecoder(response.Body).Decode(&info)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	return &info, nil
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/zeroconf/v2"
+)
+
+func TestFromEntry(t *testing.T) {
+	entry := zeroconf.ServiceEntry{
+		ServiceRecord: zeroconf.ServiceRecord{Instance: `kiosk\ 1\ \(Room\ A\)`},
+		Text:          append(Text("kiosk-1", "Room A", "2.6.0", "abc"), "malformed"),
+		AddrIPv4:      []net.IP{net.IPv4(192, 168, 1, 10)},
+		Port:          8383,
+	}
+	driver := fromEntry(entry)
+
+	expected := Driver{
+		Instance:  "kiosk 1 (Room A)",
+		Hostname:  "kiosk-1",
+		Label:     "Room A",
+		Version:   "2.6.0",
+		MachineId: "abc",
+		Port:      8383,
+	}
+	if driver.Instance != expected.Instance || driver.Hostname != expected.Hostname || driver.Label != expected.Label ||
+		driver.Version != expected.Version || driver.MachineId != expected.MachineId || driver.Port != expected.Port {
+		t.Errorf("expected %+v, got %+v", expected, driver)
+	}
+	if driver.URL() != "https://192.168.1.10:8383" {
+		t.Errorf("unexpected URL %s", driver.URL())
+	}
+}
+
+// Driver pointing to the address of a test server
+func driverAt(t *testing.T, server *httptest.Server) Driver {
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, _ := strconv.Atoi(port)
+	return Driver{Instance: "kiosk", Addresses: []net.IP{net.ParseIP(host)}, Port: portNumber}
+}
+
+func TestCheck(t *testing.T) {
+	var tests = []struct {
+		name    string
+		handler http.HandlerFunc
+		version string
+		err     string
+	}{
+		{
+			name: "responding",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"message":"Dividat Driver","version":"2.6.0","os":"linux","arch":"amd64"}`))
+			},
+			version: "2.6.0",
+		},
+		{
+			name: "failing",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			err: "unexpected status 500",
+		},
+		{
+			name: "not a driver",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("<html></html>"))
+			},
+			err: "invalid response",
+		},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewTLSServer(test.handler)
+		info, err := Check(context.Background(), server.Client(), driverAt(t, server))
+		server.Close()
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		} else if info.Version != test.version {
+			t.Errorf("%s: expected version %s, got %s", test.name, test.version, info.Version)
+		}
+	}
+
+	if _, err := Check(context.Background(), http.DefaultClient, Driver{Instance: "kiosk"}); err == nil {
+		t.Error("expected error for driver without address")
+	}
+}
+
+func TestReportCountsDriversNotResponding(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version":"2.6.0","os":"linux","arch":"amd64"}`))
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	failures := report(context.Background(), &out, server.Client(), []Driver{driverAt(t, server), {Instance: "unreachable"}})
+	if failures != 1 {
+		t.Errorf("expected 1 failure, got %d", failures)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "2.6.0 (linux/amd64)") || !strings.Contains(lines[1], "not responding") {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+}
@@ -0,0 +1,184 @@
+package clock
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Fake is a source whose time only passes when advanced. Timers and tickers
+// due in the meantime fire in order, with the time they were due.
+type Fake struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFake returns a fake source starting at the given time
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+type fakeTimer struct {
+	fake *Fake
+	at   time.Time
+	// Zero unless the timer is a ticker
+	period time.Duration
+	c      chan time.Time
+	// Called instead of sending on c, if set
+	f func()
+}
+
+// Now returns the fake time
+func (fake *Fake) Now() time.Time {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return fake.now
+}
+
+// Waiting returns the number of timers and tickers that have not fired or
+// were not stopped, e.g. so tests can wait until a goroutine is blocked
+func (fake *Fake) Waiting() int {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return len(fake.timers)
+}
+
+// Advance moves time forward, firing timers that become due
+func (fake *Fake) Advance(d time.Duration) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	end := fake.now.Add(d)
+	for {
+		var next *fakeTimer
+		for _, timer := range fake.timers {
+			if !timer.at.After(end) && (next == nil || timer.at.Before(next.at)) {
+				next = timer
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		fake.now = next.at
+		if next.f != nil {
+			go next.f()
+		} else {
+			// Like system timers, ticks are dropped if not received in time
+			select {
+			case next.c <- fake.now:
+			default:
+			}
+		}
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+		} else {
+			fake.remove(next)
+		}
+	}
+	fake.now = end
+}
+
+func (fake *Fake) schedule(timer *fakeTimer, d time.Duration) bool {
+	active := fake.remove(timer)
+	timer.at = fake.now.Add(d)
+	fake.timers = append(fake.timers, timer)
+	return active
+}
+
+// Remove a timer, returning whether it was waiting
+func (fake *Fake) remove(timer *fakeTimer) bool {
+	for i, t := range fake.timers {
+		if t == timer {
+			fake.timers = append(fake.timers[:i], fake.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (fake *Fake) newTimer(d time.Duration, period time.Duration, f func()) *fakeTimer {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	timer := &fakeTimer{fake: fake, period: period, f: f}
+	if f == nil {
+		timer.c = make(chan time.Time, 1)
+	}
+	fake.schedule(timer, d)
+	return timer
+}
+
+// After returns a channel receiving the time once the duration elapsed
+func (fake *Fake) After(d time.Duration) <-chan time.Time {
+	return fake.newTimer(d, 0, nil).c
+}
+
+// Sleep blocks until the duration elapsed
+func (fake *Fake) Sleep(d time.Duration) {
+	<-fake.After(d)
+}
+
+// NewTimer returns a timer firing once the duration elapsed
+func (fake *Fake) NewTimer(d time.Duration) Timer {
+	return fake.newTimer(d, 0, nil)
+}
+
+// NewTicker returns a ticker firing with the given period
+func (fake *Fake) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{fake.newTimer(d, d, nil)}
+}
+
+// AfterFunc calls f in its own goroutine once the duration elapsed
+func (fake *Fake) AfterFunc(d time.Duration, f func()) Timer {
+	return fake.newTimer(d, 0, f)
+}
+
+func (timer *fakeTimer) C() <-chan time.Time {
+	return timer.c
+}
+
+func (timer *fakeTimer) Stop() bool {
+	timer.fake.mutex.Lock()
+	defer timer.fake.mutex.Unlock()
+	return timer.fake.remove(timer)
+}
+
+func (timer *fakeTimer) Reset(d time.Duration) bool {
+	timer.fake.mutex.Lock()
+	defer timer.fake.mutex.Unlock()
+	return timer.fake.schedule(timer, d)
+}
+
+type fakeTicker struct {
+	timer *fakeTimer
+}
+
+func (ticker fakeTicker) C() <-chan time.Time {
+	return ticker.timer.c
+}
+
+func (ticker fakeTicker) Stop() {
+	ticker.timer.Stop()
+}
+
+// ServeHTTP reports the fake time and the number of waiting timers as JSON,
+// after advancing by the duration given in the `advance` query parameter of
+// POST requests (e.g. "5s")
+func (fake *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		d, err := time.ParseDuration(r.URL.Query().Get("advance"))
+		if err != nil || d < 0 {
+			http.Error(w, "Invalid duration to advance by", http.StatusBadRequest)
+			return
+		}
+		fake.Advance(d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Now     time.Time `json:"now"`
+		Waiting int       `json:"waiting"`
+	}{fake.Now(), fake.Waiting()})
+}
@@ -0,0 +1,68 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeFiresTimersInOrder(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := NewFake(start)
+
+	late := fake.After(3 * time.Second)
+	early := fake.NewTimer(time.Second)
+	ticker := fake.NewTicker(2 * time.Second)
+	called := make(chan struct{})
+	fake.AfterFunc(time.Second, func() { close(called) })
+
+	fake.Advance(999 * time.Millisecond)
+	select {
+	case <-early.C():
+		t.Fatalf("timer fired early")
+	default:
+	}
+
+	fake.Advance(time.Millisecond)
+	if at := <-early.C(); !at.Equal(start.Add(time.Second)) {
+		t.Errorf("timer fired at %v", at)
+	}
+	<-called
+
+	fake.Advance(2 * time.Second)
+	if at := <-late; !at.Equal(start.Add(3 * time.Second)) {
+		t.Errorf("After fired at %v", at)
+	}
+	if at := <-ticker.C(); !at.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("ticker fired at %v", at)
+	}
+
+	if waiting := fake.Waiting(); waiting != 1 {
+		t.Errorf("expected only the ticker to be waiting, %d are", waiting)
+	}
+	ticker.Stop()
+	if fake.Waiting() != 0 {
+		t.Errorf("expected stopped ticker not to be waiting")
+	}
+}
+
+func TestFakeTimerReset(t *testing.T) {
+	fake := NewFake(time.Now())
+	timer := fake.NewTimer(time.Second)
+
+	fake.Advance(500 * time.Millisecond)
+	if !timer.Reset(time.Second) {
+		t.Errorf("expected reset of waiting timer to report it was active")
+	}
+	fake.Advance(900 * time.Millisecond)
+	select {
+	case <-timer.C():
+		t.Fatalf("reset timer fired early")
+	default:
+	}
+	fake.Advance(100 * time.Millisecond)
+	<-timer.C()
+
+	if timer.Stop() {
+		t.Errorf("expected fired timer not to be active")
+	}
+}
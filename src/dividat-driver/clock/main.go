@@ -0,0 +1,75 @@
+package clock
+
+/* Timestamps that stay consistent when the system clock is adjusted.
+
+Timestamps are taken in UTC from the monotonic clock, relative to a reference
+read from the system clock when a session starts. NTP corrections or manual
+changes of the system clock during a session therefore do not produce
+impossible intervals between timestamps. Such jumps are detected by comparing
+both clocks, so they can be reported, and are taken into account from the next
+session on.
+
+*/
+
+import (
+	"sync"
+	"time"
+)
+
+// Smallest change of the system clock relative to the monotonic clock reported as a jump
+const JumpThreshold = 100 * time.Millisecond
+
+// Jump of the system clock
+type Jump struct {
+	// Time of detection, on the clock's timeline
+	At time.Time `json:"at"`
+	// Seconds the system clock moved, negative if it was set back
+	Offset float64 `json:"offset"`
+}
+
+// Clock derives UTC timestamps from the monotonic clock
+type Clock struct {
+	mutex     sync.Mutex
+	reference time.Time
+	// Difference between system and monotonic clock when last checked
+	skew time.Duration
+}
+
+// New returns a clock referenced to the current system time
+func New() *Clock {
+	return &Clock{reference: time.Now()}
+}
+
+// Reset takes a new reference from the system clock, e.g. between sessions
+func (clock *Clock) Reset() {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.reference = time.Now()
+	clock.skew = 0
+}
+
+// Now returns the current time in UTC. The result carries a monotonic reading,
+// so it may be compared with time.Now().
+func (clock *Clock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.reference.Add(time.Since(clock.reference)).UTC()
+}
+
+// Check reports whether the system clock jumped since the last check
+func (clock *Clock) Check() (Jump, bool) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(clock.reference)
+	// Without monotonic readings, times are compared by the system clock only
+	skew := now.Round(0).Sub(clock.reference.Round(0)) - elapsed
+
+	change := skew - clock.skew
+	if change < JumpThreshold && change > -JumpThreshold {
+		return Jump{}, false
+	}
+	clock.skew = skew
+	return Jump{At: clock.reference.Add(elapsed).UTC(), Offset: change.Seconds()}, true
+}
@@ -0,0 +1,83 @@
+package clock
+
+// Time source of background logic such as scans, watchdogs, debouncing and
+// backoff. It is the system clock, unless a fake clock is substituted so that
+// tests can advance time instead of sleeping.
+
+import (
+	"time"
+)
+
+// Source tells the time and waits for it
+type Source interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	// Call f in its own goroutine once the duration elapsed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer fires once, like time.Timer
+type Timer interface {
+	// Channel the time is sent on, nil for timers calling a function
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker fires periodically, like time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// System is the source backed by the system clock
+var System Source = system{}
+
+// Default is the source used by background logic. Must only be replaced
+// before the driver starts.
+var Default = System
+
+type system struct{}
+
+func (system) Now() time.Time {
+	return time.Now()
+}
+
+func (system) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (system) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (system) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (system) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+func (system) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (timer systemTimer) C() <-chan time.Time {
+	return timer.Timer.C
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (ticker systemTicker) C() <-chan time.Time {
+	return ticker.Ticker.C
+}
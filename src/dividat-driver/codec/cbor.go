@@ -0,0 +1,26 @@
+package codec
+
+import (
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Times are encoded as RFC 3339 strings and floats in their shortest exact
+// form, so that CBOR messages carry the same values as JSON messages
+var cborEncoding, _ = cbor.EncOptions{
+	ShortestFloat: cbor.ShortestFloat16,
+	Time:          cbor.TimeRFC3339Nano,
+}.EncMode()
+
+var cborDecoding, _ = cbor.DecOptions{}.DecMode()
+
+// MarshalCBOR encodes a value as CBOR, following its `json` struct tags. To be
+// used by messages implementing cbor.Marshaler.
+func MarshalCBOR(v interface{}) ([]byte, error) {
+	return cborEncoding.Marshal(v)
+}
+
+// UnmarshalCBOR decodes CBOR into a value, following its `json` struct tags. To
+// be used by commands implementing cbor.Unmarshaler.
+func UnmarshalCBOR(data []byte, v interface{}) error {
+	return cborDecoding.Unmarshal(data, v)
+}
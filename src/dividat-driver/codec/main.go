@@ -0,0 +1,104 @@
+package codec
+
+/* Encodings of the messages and commands exchanged with WebSocket clients.
+
+A codec encodes messages and decodes commands in the encoding the client
+negotiated when connecting with the query parameter `encoding`:
+
+- `json` (default): text frames holding JSON.
+- `cbor`: binary frames holding CBOR (RFC 8949), which is smaller and cheaper
+  to parse for embedded clients. Each frame starts with the self-described CBOR
+  tag (0xD9 0xD9 0xF7), so that messages can be told apart from binary frames
+  of device data. Clients must start commands with the same tag.
+
+Messages and commands define their fields once with `json` struct tags, which
+both encodings follow. Types customizing their JSON encoding must implement
+cbor.Marshaler or cbor.Unmarshaler alike, see MarshalCBOR and UnmarshalCBOR.
+
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// Codec translates messages to WebSocket frames and frames to commands
+type Codec interface {
+	// Name as given in the `encoding` query parameter
+	Name() string
+
+	// Encode a message, returning the WebSocket message type and payload
+	Encode(message interface{}) (int, []byte, error)
+
+	// Decode a frame received from the client into the command. Returns false
+	// if the frame is not a command in this encoding, e.g. binary device data.
+	Decode(messageType int, payload []byte, command interface{}) (bool, error)
+}
+
+// JSON is the default encoding
+var JSON Codec = jsonCodec{}
+
+// CBOR encodes messages as CBOR in binary frames
+var CBOR Codec = cborCodec{}
+
+var codecs = []Codec{JSON, CBOR}
+
+// Negotiate returns the codec requested by the client, JSON if none
+func Negotiate(r *http.Request) (Codec, error) {
+	name := r.URL.Query().Get("encoding")
+	if name == "" {
+		return JSON, nil
+	}
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown encoding %q", name)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Encode(message interface{}) (int, []byte, error) {
+	text, err := json.Marshal(message)
+	return websocket.TextMessage, text, err
+}
+
+func (jsonCodec) Decode(messageType int, payload []byte, command interface{}) (bool, error) {
+	if messageType != websocket.TextMessage {
+		return false, nil
+	}
+	return true, json.Unmarshal(payload, command)
+}
+
+// Self-described CBOR tag 55799, marking frames as CBOR
+var cborMagic = []byte{0xd9, 0xd9, 0xf7}
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string {
+	return "cbor"
+}
+
+func (cborCodec) Encode(message interface{}) (int, []byte, error) {
+	encoded, err := MarshalCBOR(message)
+	if err != nil {
+		return 0, nil, err
+	}
+	return websocket.BinaryMessage, append(append([]byte{}, cborMagic...), encoded...), nil
+}
+
+func (cborCodec) Decode(messageType int, payload []byte, command interface{}) (bool, error) {
+	if messageType != websocket.BinaryMessage || !bytes.HasPrefix(payload, cborMagic) {
+		return false, nil
+	}
+	return true, UnmarshalCBOR(payload[len(cborMagic):], command)
+}
@@ -0,0 +1,113 @@
+package codec
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type testMessage struct {
+	Type     string            `json:"type"`
+	Address  *string           `json:"address"`
+	Health   *testHealth       `json:"health,omitempty"`
+	Total    uint64            `json:"total"`
+	Sequence int               `json:"sequence"`
+	At       time.Time         `json:"at"`
+	Values   map[string]string `json:"values,omitempty"`
+}
+
+type testHealth struct {
+	LastFrameAge float64 `json:"lastFrameAge"`
+}
+
+type testCommand struct {
+	Type     string  `json:"type"`
+	Duration float64 `json:"duration"`
+}
+
+func TestEncode(t *testing.T) {
+	message := testMessage{Type: "Status", Health: &testHealth{0.25}, Total: 4294967296, Sequence: -3, At: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+
+	messageType, text, err := JSON.Encode(&message)
+	if err != nil || messageType != websocket.TextMessage {
+		t.Fatalf("could not encode as JSON: %v", err)
+	}
+	expected := `{"type":"Status","address":null,"health":{"lastFrameAge":0.25},"total":4294967296,"sequence":-3,"at":"2024-01-01T12:00:00Z"}`
+	if string(text) != expected {
+		t.Errorf("encoded as %s, expected %s", text, expected)
+	}
+
+	messageType, payload, err := CBOR.Encode(&message)
+	if err != nil || messageType != websocket.BinaryMessage {
+		t.Fatalf("could not encode as CBOR: %v", err)
+	}
+	var decoded testMessage
+	if ok, err := CBOR.Decode(messageType, payload, &decoded); !ok || err != nil {
+		t.Fatalf("could not decode %x: %v", payload, err)
+	}
+	if !reflect.DeepEqual(decoded, message) {
+		t.Errorf("round trip of %+v gave %+v", message, decoded)
+	}
+}
+
+func TestCBOREncoding(t *testing.T) {
+	_, payload, _ := CBOR.Encode(&struct {
+		Type     string  `json:"type"`
+		Duration float64 `json:"duration"`
+		Health   *string `json:"health,omitempty"`
+	}{Type: "GetStatus", Duration: 1.5})
+	// Tagged map of two entries, with the duration as half float
+	expected := append([]byte{0xd9, 0xd9, 0xf7, 0xa2, 0x64}, []byte("type")...)
+	expected = append(append(expected, 0x69), []byte("GetStatus")...)
+	expected = append(append(expected, 0x68), []byte("duration")...)
+	expected = append(expected, 0xf9, 0x3e, 0x00)
+	if !bytes.Equal(payload, expected) {
+		t.Errorf("encoded as %x, expected %x", payload, expected)
+	}
+}
+
+func TestCBORDecodesIndefiniteLengthsAndHalfFloats(t *testing.T) {
+	// {_ "type": "Discover", "duration": 1.5 as half float}
+	payload := []byte{0xd9, 0xd9, 0xf7, 0xbf, 0x64, 't', 'y', 'p', 'e', 0x7f, 0x64, 'D', 'i', 's', 'c', 0x64, 'o', 'v', 'e', 'r', 0xff, 0x68, 'd', 'u', 'r', 'a', 't', 'i', 'o', 'n', 0xf9, 0x3e, 0x00, 0xff}
+	var command testCommand
+	ok, err := CBOR.Decode(websocket.BinaryMessage, payload, &command)
+	if !ok || err != nil {
+		t.Fatalf("could not decode: %v", err)
+	}
+	if command != (testCommand{Type: "Discover", Duration: 1.5}) {
+		t.Errorf("decoded %+v", command)
+	}
+}
+
+func TestCBORLeavesDeviceDataAlone(t *testing.T) {
+	var command testCommand
+	if ok, _ := CBOR.Decode(websocket.BinaryMessage, []byte{0x01, 0x02}, &command); ok {
+		t.Errorf("expected binary frame without tag not to be a command")
+	}
+	if ok, _ := CBOR.Decode(websocket.TextMessage, []byte(`{}`), &command); ok {
+		t.Errorf("expected text frame not to be a command")
+	}
+	if ok, err := CBOR.Decode(websocket.BinaryMessage, []byte{0xd9, 0xd9, 0xf7, 0x9a, 0xff, 0xff, 0xff, 0xff}, &command); !ok || err == nil {
+		t.Errorf("expected truncated command to fail")
+	}
+	if ok, _ := JSON.Decode(websocket.BinaryMessage, []byte{0x01, 0x02}, &command); ok {
+		t.Errorf("expected binary frame not to be a JSON command")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]Codec{"/senso": JSON, "/senso?encoding=json": JSON, "/senso?encoding=cbor": CBOR}
+	for target, expected := range cases {
+		codec, err := Negotiate(httptest.NewRequest("GET", target, nil))
+		if err != nil || codec != expected {
+			t.Errorf("negotiated %v (%v) for %s", codec, err, target)
+		}
+	}
+	if _, err := Negotiate(httptest.NewRequest("GET", "/senso?encoding=xml", nil)); err == nil {
+		t.Errorf("expected unknown encoding to be refused")
+	}
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/dividat/driver/src/dividat-driver/auth"
+)
+
+// Authentication admits clients of an instance in addition to its tokens,
+// granting roles (observer, operator, maintenance) by name
+type Authentication struct {
+	// Local users connecting through the driver's Unix socket, by user name
+	Users map[string]string `json:"users"`
+
+	// Remote clients presenting a certificate issued by the client CA, by
+	// common name of the certificate
+	Certificates map[string]string `json:"certificates"`
+
+	// URL asked to decide on requests not admitted otherwise
+	Webhook string `json:"webhook"`
+}
+
+// Configured returns whether any authentication besides tokens is set up
+func (authentication Authentication) Configured() bool {
+	return len(authentication.Users) > 0 || len(authentication.Certificates) > 0 || authentication.Webhook != ""
+}
+
+func validateAuthentication(authentication Authentication) error {
+	for user, role := range authentication.Users {
+		if _, err := auth.ParseRole(role); err != nil {
+			return fmt.Errorf("user %q: %v", user, err)
+		}
+	}
+	for name, role := range authentication.Certificates {
+		if _, err := auth.ParseRole(role); err != nil {
+			return fmt.Errorf("certificate %q: %v", name, err)
+		}
+	}
+	if authentication.Webhook != "" {
+		parsed, err := url.Parse(authentication.Webhook)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid webhook URL %q", authentication.Webhook)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+)
+
+// FlexProfile describes how to drive a Flex firmware revision unknown to the
+// driver. Example:
+//
+//	{
+//	  "device": "16C0",
+//	  "bcdDevice": "0600-06FF",
+//	  "revision": "v6",
+//	  "streaming": true,
+//	  "bitdepths": [8, 12],
+//	  "commands": { "start": "S\n", "stop": "X\n", "version": "V\n" }
+//	}
+type FlexProfile struct {
+	// USB identification as "VID:PID" or "VID", any device if empty
+	Device string `json:"device"`
+
+	// Release number as "MIN-MAX" or single value in hexadecimal
+	BcdDevice string `json:"bcdDevice"`
+
+	Revision  string `json:"revision"`
+	Streaming bool   `json:"streaming"`
+	// Sets per second to poll devices that do not stream at, flexPollRate if zero
+	PollRate  float64 `json:"pollRate"`
+	Bitdepths []int   `json:"bitdepths"`
+
+	Commands FlexCommands `json:"commands"`
+}
+
+// FlexCommands are the text commands understood by the firmware, omitted if not supported
+type FlexCommands struct {
+	Start   string `json:"start"`
+	Poll    string `json:"poll"`
+	Stop    string `json:"stop"`
+	Sleep   string `json:"sleep"`
+	Version string `json:"version"`
+}
+
+// USB returns vendor and product ID, zero if matching any
+func (profile FlexProfile) USB() (vid uint16, pid uint16, err error) {
+	if profile.Device == "" {
+		return 0, 0, nil
+	}
+	return ParseUSBID(profile.Device)
+}
+
+// BcdRange returns the inclusive range of release numbers
+func (profile FlexProfile) BcdRange() (min uint16, max uint16, err error) {
+	parts := strings.SplitN(profile.BcdDevice, "-", 2)
+	min, err = enumerator.ParseID(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid bcdDevice %q: %v", profile.BcdDevice, err)
+	}
+	max = min
+	if len(parts) == 2 {
+		max, err = enumerator.ParseID(parts[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid bcdDevice %q: %v", profile.BcdDevice, err)
+		}
+	}
+	if min > max {
+		return 0, 0, fmt.Errorf("empty bcdDevice range %q", profile.BcdDevice)
+	}
+	return min, max, nil
+}
+
+func validateFlexProfiles(profiles []FlexProfile) error {
+	for _, profile := range profiles {
+		if profile.Revision == "" {
+			return fmt.Errorf("profile without revision")
+		}
+		if _, _, err := profile.USB(); err != nil {
+			return fmt.Errorf("revision %q: %v", profile.Revision, err)
+		}
+		if _, _, err := profile.BcdRange(); err != nil {
+			return fmt.Errorf("revision %q: %v", profile.Revision, err)
+		}
+		if profile.Commands.Start == "" {
+			return fmt.Errorf("revision %q has no start command", profile.Revision)
+		}
+		if !profile.Streaming && profile.Commands.Poll == "" {
+			return fmt.Errorf("revision %q does not stream and has no poll command", profile.Revision)
+		}
+		if profile.PollRate < 0 {
+			return fmt.Errorf("revision %q has negative poll rate %v", profile.Revision, profile.PollRate)
+		}
+	}
+	return nil
+}
+
+// FlexSerialSettings are line settings of Flex controllers not running at
+// 115200 baud 8N1, omitted fields keep the default. Example:
+//
+//	{ "device": "1209:F1E8", "baudRate": 57600, "parity": "even", "stopBits": 2 }
+type FlexSerialSettings struct {
+	// USB identification as "VID:PID" or "VID"
+	Device string `json:"device"`
+
+	BaudRate int `json:"baudRate"`
+	// "none", "odd", "even", "mark" or "space"
+	Parity   string  `json:"parity"`
+	DataBits int     `json:"dataBits"`
+	StopBits float64 `json:"stopBits"`
+}
+
+func validateFlexSerialSettings(settings []FlexSerialSettings) error {
+	for _, entry := range settings {
+		if _, _, err := ParseUSBID(entry.Device); err != nil {
+			return err
+		}
+		if entry.BaudRate < 0 {
+			return fmt.Errorf("device %q: invalid baud rate %d", entry.Device, entry.BaudRate)
+		}
+		switch entry.Parity {
+		case "", "none", "odd", "even", "mark", "space":
+		default:
+			return fmt.Errorf("device %q: unknown parity %q", entry.Device, entry.Parity)
+		}
+		if entry.DataBits != 0 && (entry.DataBits < 5 || entry.DataBits > 8) {
+			return fmt.Errorf("device %q: invalid data bits %d", entry.Device, entry.DataBits)
+		}
+		switch entry.StopBits {
+		case 0, 1, 1.5, 2:
+		default:
+			return fmt.Errorf("device %q: invalid stop bits %v", entry.Device, entry.StopBits)
+		}
+	}
+	return nil
+}
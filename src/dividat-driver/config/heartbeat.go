@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Heartbeat configures periodic reports to a central endpoint, so operators of
+// a fleet know which machines have working hardware
+type Heartbeat struct {
+	// HTTPS URL reports are posted to. Disabled if empty.
+	URL string `json:"url"`
+
+	// Time between reports, e.g. "1m". Defaults to a minute.
+	Interval string `json:"interval"`
+
+	// Sent as bearer token in the Authorization header, if set
+	Token string `json:"token"`
+
+	// Client certificate and key (PEM) identifying the machine to the
+	// endpoint, if set
+	CertFile string `json:"tlsCert"`
+	KeyFile  string `json:"tlsKey"`
+}
+
+// Shortest time between heartbeats, so a fleet does not flood the endpoint
+const minHeartbeatInterval = 10 * time.Second
+
+const defaultHeartbeatInterval = time.Minute
+
+// Enabled returns whether heartbeats should be sent
+func (heartbeat Heartbeat) Enabled() bool {
+	return heartbeat.URL != ""
+}
+
+// Every returns the time between heartbeats
+func (heartbeat Heartbeat) Every() time.Duration {
+	interval, err := time.ParseDuration(heartbeat.Interval)
+	if err != nil {
+		return defaultHeartbeatInterval
+	}
+	return interval
+}
+
+func validateHeartbeat(heartbeat Heartbeat) error {
+	if !heartbeat.Enabled() {
+		return nil
+	}
+	parsed, err := url.Parse(heartbeat.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("invalid URL %q, expected https://host/path", heartbeat.URL)
+	}
+	if heartbeat.Interval != "" {
+		interval, err := time.ParseDuration(heartbeat.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid interval: %v", err)
+		}
+		if interval < minHeartbeatInterval {
+			return fmt.Errorf("interval must be at least %v", minHeartbeatInterval)
+		}
+	}
+	if (heartbeat.CertFile == "") != (heartbeat.KeyFile == "") {
+		return fmt.Errorf("client certificate and key must be given together")
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Limits caps resources used by the driver, so it degrades predictably on
+// constrained hardware. Each limit is unlimited if zero. Disk usage is limited
+// with the storage settings.
+type Limits struct {
+	// WebSocket clients connected at once, further clients are refused
+	MaxClients int `json:"maxClients"`
+
+	// Flex serial ports open at once, further devices are not connected
+	MaxSerialPorts int `json:"maxSerialPorts"`
+
+	// Estimated memory use, new clients are refused while it is exceeded
+	MaxMemoryMegabytes int64 `json:"maxMemoryMegabytes"`
+}
+
+// MaxMemoryBytes returns the memory limit in bytes, zero if unlimited
+func (limits Limits) MaxMemoryBytes() int64 {
+	return limits.MaxMemoryMegabytes * Megabyte
+}
+
+func validateLimits(limits Limits) error {
+	if limits.MaxClients < 0 {
+		return fmt.Errorf("negative client limit")
+	}
+	if limits.MaxSerialPorts < 0 {
+		return fmt.Errorf("negative serial port limit")
+	}
+	if limits.MaxMemoryMegabytes < 0 {
+		return fmt.Errorf("negative memory limit")
+	}
+	return nil
+}
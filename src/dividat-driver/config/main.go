@@ -0,0 +1,618 @@
+package config
+
+/* Configuration of the driver.
+
+Configuration is read from an optional JSON file, given with the `-config`
+command-line flag. Command-line flags take precedence over values from the
+file. Example:
+
+    {
+      "logLevel": "info",
+      "permissibleOrigins": ["https://play.dividat.com"],
+      "remote": {
+        "address": "0.0.0.0:8383",
+        "tlsCert": "/etc/dividat-driver/cert.pem",
+        "tlsKey": "/etc/dividat-driver/key.pem",
+        "clientCA": "/etc/dividat-driver/client-ca.pem"
+      },
+      "socket": "/run/dividat-driver/driver.sock",
+      "indicatorSocket": "/run/dividat-driver/indicator.sock",
+      "sensoUDPBridge": "127.0.0.1:55570",
+      "features": {
+        "recorder": true
+      },
+      "requirePairing": true,
+      "strictProtocol": true,
+      "sensoReconnect": true,
+      "sensoTCP": { "dialTimeout": "5s", "keepAlive": "15s", "readTimeout": "3s" },
+      "sensoProtocol": {
+        "default": "auto",
+        "addresses": { "192.168.1.20": "legacy" },
+        "legacyPorts": { "data": 55566, "control": 55565 }
+      },
+      "sensoHealth": { "degradedAfter": "1s", "stalledAfter": "5s", "reconnect": true },
+      "dataDirectory": "/var/lib/dividat-driver",
+      "label": "Room 1",
+      "flexDevices": ["1209:F1E8"],
+      "flexProfiles": [
+        {
+          "device": "16C0",
+          "bcdDevice": "0600-06FF",
+          "revision": "v6",
+          "streaming": true,
+          "bitdepths": [8, 12],
+          "commands": { "start": "S\n", "stop": "X\n" }
+        }
+      ],
+      "flexSerialSettings": [{ "device": "1209:F1E8", "baudRate": 57600 }],
+      "flexDeviceProfiles": "/etc/dividat-driver/flex-devices.json",
+      "idleTimeout": "15m",
+      "flexDataTimeout": "5s",
+      "flexPollRate": 60,
+      "flexScanInterval": "5s",
+      "incidentWindow": "30s",
+      "accessLog": "/var/log/dividat-driver/access.log",
+      "firmwareUpdateWhenBusy": "queue",
+      "outbound": {
+        "proxy": "http://proxy.example.com:3128",
+        "caBundle": "/etc/dividat-driver/proxy-ca.pem",
+        "destinations": [{ "host": ".dividat.com", "proxy": "direct" }]
+      },
+      "storage": {
+        "maxMegabytes": 2000,
+        "quotas": { "recordings": 500 }
+      },
+      "limits": {
+        "maxClients": 20,
+        "maxSerialPorts": 4,
+        "maxMemoryMegabytes": 256
+      },
+      "profile": {
+        "url": "https://fleet.example.com/driver/profile",
+        "tlsCert": "/etc/dividat-driver/device.pem",
+        "tlsKey": "/etc/dividat-driver/device-key.pem"
+      },
+      "heartbeat": {
+        "url": "https://fleet.example.com/driver/heartbeat",
+        "interval": "1m",
+        "token": "secret"
+      },
+      "instances": [
+        {
+          "name": "room-1",
+          "token": "secret-1",
+          "tokens": [{ "token": "secret-1-observer", "role": "observer" }],
+          "sensoAddresses": ["192.168.1.10"],
+          "flexSerialNumbers": ["FLX0001"],
+          "authentication": {
+            "users": { "therapist": "operator" },
+            "certificates": { "kiosk-1": "operator" },
+            "webhook": "https://auth.example.com/driver"
+          }
+        }
+      ]
+    }
+
+
+Changes to the file are picked up while the driver is running. Settings that
+can not be applied live take effect on the next start.
+
+If a profile is configured, settings fetched from it at startup take
+precedence over the file (see profile.go).
+
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/auth"
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+	"github.com/dividat/driver/src/dividat-driver/sessions"
+)
+
+// Config holds all settings of the driver
+type Config struct {
+	LogLevel           string       `json:"logLevel"`
+	PermissibleOrigins []string     `json:"permissibleOrigins"`
+	Remote             RemoteAccess `json:"remote"`
+	Features           Features     `json:"features"`
+
+	// Unix socket serving the same endpoints as the local port, identifying
+	// local users for authentication (Linux only). Disabled if empty.
+	Socket string `json:"socket"`
+
+	// Unix socket sending device state changes as lines of text, for tray
+	// applications and status indicators. Disabled if empty.
+	IndicatorSocket string `json:"indicatorSocket"`
+
+	// Local UDP address data packets of the Senso are re-emitted to, for
+	// native applications running alongside clients. Disabled if empty.
+	SensoUDPBridge string `json:"sensoUDPBridge"`
+
+	// Require operator confirmation before streaming data from a new device
+	RequirePairing bool `json:"requirePairing"`
+
+	// Warn clients about deprecated protocol fields and behaviors they rely on
+	StrictProtocol bool `json:"strictProtocol"`
+
+	// Connect to the Sensos last connected to on startup
+	SensoReconnect bool `json:"sensoReconnect"`
+
+	// Timeouts of connections to Sensos
+	SensoTCP SensoTCP `json:"sensoTCP"`
+
+	// Protocol spoken with Sensos, for fleets with old firmware
+	SensoProtocol SensoProtocol `json:"sensoProtocol"`
+
+	// When Sensos not sending data are degraded or stalled, and reconnected
+	SensoHealth SensoHealth `json:"sensoHealth"`
+
+	// Directory for state persisted across restarts
+	DataDirectory string `json:"dataDirectory"`
+
+	// Name of the installation (e.g. a room), announced to the network with
+	// the host name if remote access is enabled
+	Label string `json:"label"`
+
+	// Additional USB devices to treat as Flex devices, as "VID:PID" in
+	// hexadecimal (e.g. "1209:F1E8"), or "VID" to match any product
+	FlexDevices []string `json:"flexDevices"`
+
+	// Firmware revisions of Flex devices, driven by polling unless a profile
+	// matches (see flexprofiles.go)
+	FlexProfiles []FlexProfile `json:"flexProfiles"`
+
+	// Line settings of Flex devices not running at 115200 baud 8N1 (see
+	// flexprofiles.go)
+	FlexSerialSettings []FlexSerialSettings `json:"flexSerialSettings"`
+
+	// JSON file declaring further kinds of Flex devices, with handler, serial
+	// settings, commands and frame format (see flex.DeviceProfile)
+	FlexDeviceProfiles string `json:"flexDeviceProfiles"`
+
+	// Additional logical drivers sharing this machine
+	Instances []Instance `json:"instances"`
+
+	// Exit after no connection has been open for this long (e.g. "15m"), only
+	// if started by systemd socket activation. Disabled if empty.
+	IdleTimeout string `json:"idleTimeout"`
+
+	// Restart acquisition if a Flex device sends no sets for this long (e.g.
+	// "5s"), reopen the port if that does not help. Disabled if empty.
+	FlexDataTimeout string `json:"flexDataTimeout"`
+
+	// Sets per second to poll Flex devices that do not stream at, unless their
+	// profile sets a rate. As fast as sets complete if zero.
+	FlexPollRate float64 `json:"flexPollRate"`
+
+	// Scan for Flex devices this often (e.g. "5s") where hotplug events are
+	// unavailable. Every 2 seconds if empty.
+	FlexScanInterval string `json:"flexScanInterval"`
+
+	// Keep log entries of all levels for this long (e.g. "30s") and write them
+	// to a file in the data directory when an error is logged. Disabled if
+	// empty.
+	IncidentWindow string `json:"incidentWindow"`
+
+	// Append a JSON line summarizing each WebSocket connection to this file.
+	// Disabled if empty.
+	AccessLog string `json:"accessLog"`
+
+	// Whether firmware updates requested while other clients stream from the
+	// device are refused ("refuse", default) or wait for them ("queue")
+	FirmwareUpdateWhenBusy string `json:"firmwareUpdateWhenBusy"`
+
+	// Proxy and certificate authorities for outbound HTTP requests
+	Outbound Outbound `json:"outbound"`
+
+	// Limits on disk usage of the data directory
+	Storage Storage `json:"storage"`
+
+	// Caps on clients, serial ports and memory
+	Limits Limits `json:"limits"`
+
+	// Central endpoint serving settings for this machine
+	Profile Profile `json:"profile"`
+
+	// Central endpoint periodically sent the state of this machine
+	Heartbeat Heartbeat `json:"heartbeat"`
+
+	// File the configuration was loaded from, empty for default configuration
+	Path string `json:"-"`
+
+	// Profile applied on top of the file, nil if none
+	profile []byte
+
+	overrides Overrides
+}
+
+// Overrides are values given on the command line, taking precedence over the file
+type Overrides struct {
+	PermissibleOrigins []string
+	Remote             RemoteAccess
+	// Added to the devices from the file
+	FlexDevices []string
+}
+
+// Apply command-line values and fill in defaults
+func (config *Config) Apply(overrides Overrides) {
+	config.overrides = overrides
+
+	if len(overrides.PermissibleOrigins) > 0 {
+		config.PermissibleOrigins = overrides.PermissibleOrigins
+	}
+	if len(config.PermissibleOrigins) == 0 {
+		config.PermissibleOrigins = DefaultOrigins
+	}
+	if overrides.Remote.Address != "" {
+		config.Remote.Address = overrides.Remote.Address
+	}
+	if overrides.Remote.CertFile != "" {
+		config.Remote.CertFile = overrides.Remote.CertFile
+	}
+	if overrides.Remote.KeyFile != "" {
+		config.Remote.KeyFile = overrides.Remote.KeyFile
+	}
+	if config.DataDirectory == "" {
+		config.DataDirectory = DefaultDataDirectory()
+	}
+	config.FlexDevices = append(config.FlexDevices, overrides.FlexDevices...)
+}
+
+// DefaultDataDirectory is in the per-user configuration directory of the OS,
+// or the working directory if unknown
+func DefaultDataDirectory() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "dividat-driver"
+	}
+	return filepath.Join(dir, "dividat-driver")
+}
+
+// Level returns the configured log level
+func (config *Config) Level() logrus.Level {
+	level, err := logrus.ParseLevel(config.LogLevel)
+	if err != nil {
+		return logrus.DebugLevel
+	}
+	return level
+}
+
+// FlexData returns the configured Flex data timeout, zero if disabled
+func (config *Config) FlexData() time.Duration {
+	timeout, err := time.ParseDuration(config.FlexDataTimeout)
+	if err != nil {
+		return 0
+	}
+	return timeout
+}
+
+// FlexScan returns the configured Flex scan interval, zero if the default
+func (config *Config) FlexScan() time.Duration {
+	interval, err := time.ParseDuration(config.FlexScanInterval)
+	if err != nil {
+		return 0
+	}
+	return interval
+}
+
+// Incidents returns the configured incident window, zero if disabled
+func (config *Config) Incidents() time.Duration {
+	window, err := time.ParseDuration(config.IncidentWindow)
+	if err != nil {
+		return 0
+	}
+	return window
+}
+
+// Idle returns the configured idle timeout, zero if disabled
+func (config *Config) Idle() time.Duration {
+	timeout, err := time.ParseDuration(config.IdleTimeout)
+	if err != nil {
+		return 0
+	}
+	return timeout
+}
+
+// RemoteAccess configures an optional listener for connections from other
+// machines (remote Play, tele-rehabilitation).
+type RemoteAccess struct {
+	// Address to listen on (host:port), remote access is disabled if empty
+	Address string `json:"address"`
+
+	CertFile string `json:"tlsCert"`
+	KeyFile  string `json:"tlsKey"`
+
+	// PEM file with authorities issuing client certificates, which are then
+	// requested from clients (optional for them)
+	ClientCA string `json:"clientCA"`
+}
+
+// Enabled returns whether a remote listener should be started
+func (remote RemoteAccess) Enabled() bool {
+	return remote.Address != ""
+}
+
+// Instance is a logical driver with its own endpoints and devices, e.g. for
+// two therapy rooms sharing one machine. Its endpoints are served below
+// `/<name>/`, next to the endpoints of the default driver.
+type Instance struct {
+	Name string `json:"name"`
+
+	// Clients must present a token as `Authorization: Bearer <token>` or as
+	// `token` query parameter. This token grants all permissions.
+	Token string `json:"token"`
+
+	// Further tokens with restricted roles
+	Tokens []RoleToken `json:"tokens"`
+
+	// Senso addresses the instance may connect to, any if empty
+	SensoAddresses []string `json:"sensoAddresses"`
+
+	// Serial numbers of Flex devices the instance may use, any if empty
+	FlexSerialNumbers []string `json:"flexSerialNumbers"`
+
+	// Admission of clients without token
+	Authentication Authentication `json:"authentication"`
+}
+
+// RoleToken grants a role (observer, operator, maintenance) to clients presenting the token
+type RoleToken struct {
+	Token string `json:"token"`
+	Role  string `json:"role"`
+}
+
+// Names that would shadow endpoints of the default driver or its name in logs
+var reservedInstanceNames = []string{"default", "senso", "flex", "rfid", "log", "debug"}
+
+var instanceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+func validateInstances(instances []Instance) error {
+	seen := map[string]bool{}
+	for _, instance := range instances {
+		if !instanceNamePattern.MatchString(instance.Name) {
+			return fmt.Errorf("invalid instance name %q, use lowercase letters, digits, '-' and '_'", instance.Name)
+		}
+		for _, reserved := range reservedInstanceNames {
+			if instance.Name == reserved {
+				return fmt.Errorf("instance name %q is reserved", instance.Name)
+			}
+		}
+		if seen[instance.Name] {
+			return fmt.Errorf("duplicate instance name %q", instance.Name)
+		}
+		seen[instance.Name] = true
+
+		if instance.Token == "" && len(instance.Tokens) == 0 && !instance.Authentication.Configured() {
+			return fmt.Errorf("instance %q has no token", instance.Name)
+		}
+		if err := validateAuthentication(instance.Authentication); err != nil {
+			return fmt.Errorf("instance %q: %v", instance.Name, err)
+		}
+		for _, token := range instance.Tokens {
+			if token.Token == "" {
+				return fmt.Errorf("instance %q has an empty token", instance.Name)
+			}
+			if _, err := auth.ParseRole(token.Role); err != nil {
+				return fmt.Errorf("instance %q: %v", instance.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseUSBID parses a device identification of the form "VID:PID" or "VID",
+// in which case the product ID is zero
+func ParseUSBID(id string) (vid uint16, pid uint16, err error) {
+	parts := strings.SplitN(id, ":", 2)
+	vid, err = enumerator.ParseID(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid vendor ID in %q: %v", id, err)
+	}
+	if len(parts) == 2 {
+		pid, err = enumerator.ParseID(parts[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid product ID in %q: %v", id, err)
+		}
+	}
+	return vid, pid, nil
+}
+
+// Features are flags to enable experimental subsystems per installation.
+// Features not mentioned in the configuration are disabled.
+type Features map[string]bool
+
+// Enabled returns whether the named feature has been switched on
+func (features Features) Enabled(name string) bool {
+	return features[name]
+}
+
+// Default returns the configuration used if no file is given
+func Default() *Config {
+	return &Config{
+		LogLevel: "debug",
+		Features: Features{},
+	}
+}
+
+// Load reads configuration from a JSON file
+func Load(path string) (*Config, error) {
+	config := Default()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open configuration file: %v", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse configuration file: %v", err)
+	}
+
+	if config.Features == nil {
+		config.Features = Features{}
+	}
+
+	err = validate(config)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Path = path
+
+	return config, nil
+}
+
+// Check settings that JSON decoding does not
+func validate(config *Config) error {
+	_, err := logrus.ParseLevel(config.LogLevel)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %v", err)
+	}
+
+	if config.IdleTimeout != "" {
+		_, err = time.ParseDuration(config.IdleTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid idle timeout: %v", err)
+		}
+	}
+
+	if config.FlexDataTimeout != "" {
+		_, err = time.ParseDuration(config.FlexDataTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid Flex data timeout: %v", err)
+		}
+	}
+
+	if config.FlexPollRate < 0 {
+		return fmt.Errorf("invalid Flex poll rate: %v", config.FlexPollRate)
+	}
+
+	if config.FlexScanInterval != "" {
+		interval, err := time.ParseDuration(config.FlexScanInterval)
+		if err != nil {
+			return fmt.Errorf("invalid Flex scan interval: %v", err)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("invalid Flex scan interval: %v", interval)
+		}
+	}
+
+	if config.IncidentWindow != "" {
+		_, err = time.ParseDuration(config.IncidentWindow)
+		if err != nil {
+			return fmt.Errorf("invalid incident window: %v", err)
+		}
+	}
+
+	err = validateSensoTCP(config.SensoTCP)
+	if err != nil {
+		return fmt.Errorf("invalid Senso TCP settings: %v", err)
+	}
+
+	err = validateSensoProtocol(config.SensoProtocol)
+	if err != nil {
+		return fmt.Errorf("invalid Senso protocol: %v", err)
+	}
+
+	err = validateSensoHealth(config.SensoHealth)
+	if err != nil {
+		return fmt.Errorf("invalid Senso health settings: %v", err)
+	}
+
+	if config.SensoUDPBridge != "" {
+		bridge, err := net.ResolveUDPAddr("udp", config.SensoUDPBridge)
+		if err != nil {
+			return fmt.Errorf("invalid Senso UDP bridge: %v", err)
+		}
+		if !bridge.IP.IsLoopback() {
+			return fmt.Errorf("invalid Senso UDP bridge %q, expected a local address", config.SensoUDPBridge)
+		}
+	}
+
+	_, err = sessions.ParsePolicy(config.FirmwareUpdateWhenBusy)
+	if err != nil {
+		return fmt.Errorf("invalid firmware update policy: %v", err)
+	}
+
+	for _, id := range config.FlexDevices {
+		_, _, err = ParseUSBID(id)
+		if err != nil {
+			return fmt.Errorf("invalid Flex device: %v", err)
+		}
+	}
+
+	err = validateFlexProfiles(config.FlexProfiles)
+	if err != nil {
+		return fmt.Errorf("invalid Flex profiles: %v", err)
+	}
+
+	err = validateFlexSerialSettings(config.FlexSerialSettings)
+	if err != nil {
+		return fmt.Errorf("invalid Flex serial settings: %v", err)
+	}
+
+	err = validateInstances(config.Instances)
+	if err != nil {
+		return fmt.Errorf("invalid instances: %v", err)
+	}
+
+	for _, instance := range config.Instances {
+		if len(instance.Authentication.Certificates) > 0 && config.Remote.ClientCA == "" {
+			return fmt.Errorf("instance %q accepts client certificates, but no client CA is configured", instance.Name)
+		}
+	}
+
+	err = validateOutbound(config.Outbound)
+	if err != nil {
+		return fmt.Errorf("invalid outbound settings: %v", err)
+	}
+
+	err = validateStorage(config.Storage)
+	if err != nil {
+		return fmt.Errorf("invalid storage settings: %v", err)
+	}
+
+	err = validateLimits(config.Limits)
+	if err != nil {
+		return fmt.Errorf("invalid limits: %v", err)
+	}
+
+	err = validateProfile(config.Profile)
+	if err != nil {
+		return fmt.Errorf("invalid profile settings: %v", err)
+	}
+
+	err = validateHeartbeat(config.Heartbeat)
+	if err != nil {
+		return fmt.Errorf("invalid heartbeat settings: %v", err)
+	}
+
+	return nil
+}
+
+var DefaultOrigins []string = []string{
+	"http://localhost:8080",
+	"https://play.dividat.ch",
+	"https://play.dividat.com",
+	"https://val-play.dividat.ch",
+	"https://val-play.dividat.com",
+	"https://dev-play.dividat.ch",
+	"https://dev-play.dividat.com",
+	"https://lab.dividat.ch",
+	"https://lab.dividat.com",
+	"https://shed.dividat.ch",
+	"https://shed.dividat.com",
+}
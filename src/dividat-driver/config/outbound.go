@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Outbound configures HTTP requests the driver makes to other services
+type Outbound struct {
+	// Proxy URL for all requests, e.g. "http://proxy.example.com:3128". If
+	// empty the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are
+	// honored.
+	Proxy string `json:"proxy"`
+
+	// PEM file with additional certificate authorities, e.g. of a proxy
+	// inspecting TLS traffic. System authorities remain trusted.
+	CABundle string `json:"caBundle"`
+
+	// Settings for specific destinations, taking precedence over the above
+	Destinations []Destination `json:"destinations"`
+}
+
+// Destination overrides outbound settings for requests to a host
+type Destination struct {
+	// Host name, or domain starting with "." matching all its subdomains
+	Host string `json:"host"`
+
+	// Proxy URL, or "direct" to connect without proxy. Inherited if empty.
+	Proxy string `json:"proxy"`
+
+	// Additional certificate authorities. Inherited if empty.
+	CABundle string `json:"caBundle"`
+}
+
+// ProxyDirect disables the proxy for a destination
+const ProxyDirect = "direct"
+
+// Matches returns whether requests to the host are subject to the destination's
+// settings. Host names are compared ignoring case and a port given with the host.
+func (destination Destination) Matches(host string) bool {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+	pattern := strings.ToLower(destination.Host)
+	if strings.HasPrefix(pattern, ".") {
+		return strings.HasSuffix(host, pattern)
+	}
+	return host == pattern
+}
+
+func validateOutbound(outbound Outbound) error {
+	if err := validateProxy(outbound.Proxy); err != nil {
+		return err
+	}
+	for _, destination := range outbound.Destinations {
+		if destination.Host == "" {
+			return fmt.Errorf("destination without host")
+		}
+		if destination.Proxy == ProxyDirect {
+			continue
+		}
+		if err := validateProxy(destination.Proxy); err != nil {
+			return fmt.Errorf("destination %q: %v", destination.Host, err)
+		}
+	}
+	return nil
+}
+
+func validateProxy(proxy string) error {
+	if proxy == "" {
+		return nil
+	}
+	parsed, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("invalid proxy: %v", err)
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return fmt.Errorf("invalid proxy %q, expected a URL like http://host:port", proxy)
+	}
+	return nil
+}
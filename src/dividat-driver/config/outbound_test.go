@@ -0,0 +1,37 @@
+package config
+
+import "testing"
+
+func TestDestinationMatches(t *testing.T) {
+	var tests = []struct {
+		destination string
+		host        string
+		matches     bool
+	}{
+		// Exact host
+		{"api.dividat.com", "api.dividat.com", true},
+		{"api.dividat.com", "dividat.com", false},
+		{"api.dividat.com", "other.api.dividat.com", false},
+		// Domain suffix
+		{".dividat.com", "api.dividat.com", true},
+		{".dividat.com", "a.b.dividat.com", true},
+		{".dividat.com", "notdividat.com", false},
+		{".dividat.com", "dividat.com.example.org", false},
+		// Case
+		{"API.Dividat.com", "api.dividat.COM", true},
+		{".Dividat.COM", "Api.dividat.com", true},
+		{".dividat.com", "API.DIVIDAT.COM", true},
+		// Port
+		{"api.dividat.com", "api.dividat.com:443", true},
+		{".dividat.com", "api.dividat.com:8443", true},
+		{"api.dividat.com", "other.com:443", false},
+		{"::1", "[::1]:8080", true},
+	}
+
+	for _, test := range tests {
+		matches := Destination{Host: test.destination}.Matches(test.host)
+		if matches != test.matches {
+			t.Errorf("expected %q matching %q to be %v", test.destination, test.host, test.matches)
+		}
+	}
+}
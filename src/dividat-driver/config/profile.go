@@ -0,0 +1,81 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Profile configures fetching settings from a central endpoint at startup,
+// so fleets of machines can be reconfigured without editing their files
+type Profile struct {
+	// HTTPS URL serving the profile, a JSON document with the same settings
+	// as the file. Disabled if empty.
+	URL string `json:"url"`
+
+	// Client certificate and key (PEM) identifying the machine to the
+	// endpoint. The machine ID is sent in any case.
+	CertFile string `json:"tlsCert"`
+	KeyFile  string `json:"tlsKey"`
+}
+
+// Enabled returns whether a profile should be fetched
+func (profile Profile) Enabled() bool {
+	return profile.URL != ""
+}
+
+func validateProfile(profile Profile) error {
+	if !profile.Enabled() {
+		return nil
+	}
+	parsed, err := url.Parse(profile.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("invalid URL %q, expected https://host/path", profile.URL)
+	}
+	if (profile.CertFile == "") != (profile.KeyFile == "") {
+		return fmt.Errorf("client certificate and key must be given together")
+	}
+	return nil
+}
+
+// WithProfile returns the configuration with the settings of a profile
+// applied on top. Settings missing from the profile keep their value, the
+// profile settings themselves can not be changed by a profile.
+func (config *Config) WithProfile(profile []byte) (*Config, error) {
+	base, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := Default()
+	err = json.Unmarshal(base, merged)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(profile))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(merged)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse profile: %v", err)
+	}
+
+	if merged.Features == nil {
+		merged.Features = Features{}
+	}
+	merged.Profile = config.Profile
+
+	err = validate(merged)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile: %v", err)
+	}
+
+	merged.Path = config.Path
+	merged.overrides = config.overrides
+	merged.profile = profile
+	return merged, nil
+}
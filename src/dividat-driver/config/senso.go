@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SensoTCP tunes the connections to Sensos, with durations like "5s". Unset
+// values keep their defaults.
+type SensoTCP struct {
+	// Give up connection attempts after this long (default "5s")
+	DialTimeout string `json:"dialTimeout"`
+
+	// Interval of TCP keep-alive probes (default "15s"), the connection is
+	// re-established when they are not answered
+	KeepAlive string `json:"keepAlive"`
+
+	// Reconnect if no data is received for this long, disabled by default
+	ReadTimeout string `json:"readTimeout"`
+}
+
+// Durations returns the dial timeout, keep-alive interval and read timeout,
+// zero where unset
+func (settings SensoTCP) Durations() (time.Duration, time.Duration, time.Duration) {
+	parse := func(value string) time.Duration {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return 0
+		}
+		return duration
+	}
+	return parse(settings.DialTimeout), parse(settings.KeepAlive), parse(settings.ReadTimeout)
+}
+
+func validateSensoTCP(settings SensoTCP) error {
+	for name, value := range map[string]string{"dial timeout": settings.DialTimeout, "keep-alive": settings.KeepAlive, "read timeout": settings.ReadTimeout} {
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		if duration <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+	return nil
+}
+
+// SensoHealth decides when connected Sensos not sending data are reported as
+// degraded or stalled, with durations like "5s". Unset values keep their
+// defaults.
+type SensoHealth struct {
+	// Report as degraded after no data frame was received for this long
+	// (default "1s")
+	DegradedAfter string `json:"degradedAfter"`
+
+	// Report as stalled after no data frame was received for this long
+	// (default "5s")
+	StalledAfter string `json:"stalledAfter"`
+
+	// Reconnect once stalled
+	Reconnect bool `json:"reconnect"`
+}
+
+// Durations returns the thresholds to be degraded and stalled, zero where
+// unset
+func (settings SensoHealth) Durations() (time.Duration, time.Duration) {
+	degradedAfter, _ := time.ParseDuration(settings.DegradedAfter)
+	stalledAfter, _ := time.ParseDuration(settings.StalledAfter)
+	return degradedAfter, stalledAfter
+}
+
+func validateSensoHealth(settings SensoHealth) error {
+	for name, value := range map[string]string{"degraded threshold": settings.DegradedAfter, "stalled threshold": settings.StalledAfter} {
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		if duration <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+	degradedAfter, stalledAfter := settings.Durations()
+	if degradedAfter > 0 && stalledAfter > 0 && degradedAfter >= stalledAfter {
+		return fmt.Errorf("degraded threshold must be shorter than the stalled threshold")
+	}
+	return nil
+}
+
+// SensoProtocol selects the protocol spoken with Sensos: "current", "legacy"
+// for old firmware with other ports and framing, or "auto" to detect it when
+// connecting
+type SensoProtocol struct {
+	// Protocol of Sensos not listed by address, "current" if empty
+	Default string `json:"default"`
+
+	// Protocol by Senso address
+	Addresses map[string]string `json:"addresses"`
+
+	// Ports of Sensos speaking the legacy protocol, required if the legacy
+	// or auto protocol is used
+	LegacyPorts SensoLegacyPorts `json:"legacyPorts"`
+}
+
+// SensoLegacyPorts are the ports of the data and control channel of Sensos
+// speaking the legacy protocol
+type SensoLegacyPorts struct {
+	Data    int `json:"data"`
+	Control int `json:"control"`
+}
+
+// Strings returns the data and control port
+func (ports SensoLegacyPorts) Strings() (string, string) {
+	return strconv.Itoa(ports.Data), strconv.Itoa(ports.Control)
+}
+
+var sensoProtocols = []string{"current", "legacy", "auto"}
+
+func validateSensoProtocol(settings SensoProtocol) error {
+	valid := func(protocol string) bool {
+		for _, known := range sensoProtocols {
+			if protocol == known {
+				return true
+			}
+		}
+		return false
+	}
+	if settings.Default != "" && !valid(settings.Default) {
+		return fmt.Errorf("unknown protocol %q, expected current, legacy or auto", settings.Default)
+	}
+	legacy := settings.Default == "legacy" || settings.Default == "auto"
+	for address, protocol := range settings.Addresses {
+		if !valid(protocol) {
+			return fmt.Errorf("unknown protocol %q for %s, expected current, legacy or auto", protocol, address)
+		}
+		legacy = legacy || protocol == "legacy" || protocol == "auto"
+	}
+	if !legacy {
+		return nil
+	}
+	for name, port := range map[string]int{"data": settings.LegacyPorts.Data, "control": settings.LegacyPorts.Control} {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("legacy %s port must be configured for the legacy and auto protocols", name)
+		}
+	}
+	return nil
+}
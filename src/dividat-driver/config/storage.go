@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Storage limits disk usage of the data directory, in megabytes
+type Storage struct {
+	// Limit for all files, unlimited if zero
+	MaxMegabytes int64 `json:"maxMegabytes"`
+
+	// Limits for files of features, by the name of their subdirectory (e.g.
+	// "recordings")
+	Quotas map[string]int64 `json:"quotas"`
+}
+
+// Megabyte as used for storage limits
+const Megabyte = 1000 * 1000
+
+// MaxBytes returns the overall limit in bytes, zero if unlimited
+func (storage Storage) MaxBytes() int64 {
+	return storage.MaxMegabytes * Megabyte
+}
+
+// QuotaBytes returns the quotas of features in bytes
+func (storage Storage) QuotaBytes() map[string]int64 {
+	quotas := map[string]int64{}
+	for feature, megabytes := range storage.Quotas {
+		quotas[feature] = megabytes * Megabyte
+	}
+	return quotas
+}
+
+func validateStorage(storage Storage) error {
+	if storage.MaxMegabytes < 0 {
+		return fmt.Errorf("negative limit")
+	}
+	for feature, megabytes := range storage.Quotas {
+		if feature == "" || feature == "." || feature == ".." || strings.ContainsAny(feature, `/\`) {
+			return fmt.Errorf("invalid feature %q", feature)
+		}
+		if megabytes < 0 {
+			return fmt.Errorf("negative quota for %q", feature)
+		}
+	}
+	return nil
+}
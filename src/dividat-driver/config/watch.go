@@ -0,0 +1,198 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/clock"
+)
+
+// How often to check the configuration file for modifications
+const watchInterval = 2 * time.Second
+
+// Watch reloads the configuration whenever the file changes on disk or the
+// process receives SIGHUP, until the context is cancelled. Remote management
+// tools can push files but can not easily signal processes on Windows, hence
+// the file watching.
+func (current *Config) Watch(ctx context.Context, onReload func(*Config), onError func(error)) {
+	path := current.Path
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := clock.Default.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	lastModified := modificationTime(path)
+
+	reload := func() {
+		config, err := Load(path)
+		if err != nil {
+			onError(err)
+			return
+		}
+		// The profile is only fetched on startup
+		if current.profile != nil {
+			config, err = config.WithProfile(current.profile)
+			if err != nil {
+				onError(err)
+				return
+			}
+		}
+		config.Apply(current.overrides)
+		onReload(config)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hangup:
+			lastModified = modificationTime(path)
+			reload()
+
+		case <-ticker.C():
+			modified := modificationTime(path)
+			if !modified.Equal(lastModified) {
+				lastModified = modified
+				reload()
+			}
+		}
+	}
+}
+
+func modificationTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// Changes between two configurations, split by whether they can be applied to
+// the running driver
+type Changes struct {
+	Applied         []string
+	RestartRequired []string
+}
+
+// IsEmpty returns whether no setting changed
+func (changes Changes) IsEmpty() bool {
+	return len(changes.Applied) == 0 && len(changes.RestartRequired) == 0
+}
+
+// Diff lists the settings that differ between old and new
+func Diff(old *Config, new *Config) Changes {
+	changes := Changes{
+		Applied:         []string{},
+		RestartRequired: []string{},
+	}
+
+	// Safe to change on a running driver
+	if old.LogLevel != new.LogLevel {
+		changes.Applied = append(changes.Applied, "logLevel")
+	}
+	if !reflect.DeepEqual(old.PermissibleOrigins, new.PermissibleOrigins) {
+		changes.Applied = append(changes.Applied, "permissibleOrigins")
+	}
+	if !reflect.DeepEqual(old.FlexDevices, new.FlexDevices) {
+		changes.Applied = append(changes.Applied, "flexDevices")
+	}
+	if old.FlexScanInterval != new.FlexScanInterval {
+		changes.Applied = append(changes.Applied, "flexScanInterval")
+	}
+
+	// Read once on startup
+	if old.Remote != new.Remote {
+		changes.RestartRequired = append(changes.RestartRequired, "remote")
+	}
+	if !reflect.DeepEqual(old.Features, new.Features) {
+		changes.RestartRequired = append(changes.RestartRequired, "features")
+	}
+	if old.RequirePairing != new.RequirePairing {
+		changes.RestartRequired = append(changes.RestartRequired, "requirePairing")
+	}
+	if old.StrictProtocol != new.StrictProtocol {
+		changes.RestartRequired = append(changes.RestartRequired, "strictProtocol")
+	}
+	if old.SensoReconnect != new.SensoReconnect {
+		changes.RestartRequired = append(changes.RestartRequired, "sensoReconnect")
+	}
+	if old.SensoTCP != new.SensoTCP {
+		changes.RestartRequired = append(changes.RestartRequired, "sensoTCP")
+	}
+	if !reflect.DeepEqual(old.SensoProtocol, new.SensoProtocol) {
+		changes.RestartRequired = append(changes.RestartRequired, "sensoProtocol")
+	}
+	if old.SensoHealth != new.SensoHealth {
+		changes.RestartRequired = append(changes.RestartRequired, "sensoHealth")
+	}
+	if old.DataDirectory != new.DataDirectory {
+		changes.RestartRequired = append(changes.RestartRequired, "dataDirectory")
+	}
+	if old.Label != new.Label {
+		changes.RestartRequired = append(changes.RestartRequired, "label")
+	}
+	if !reflect.DeepEqual(old.FlexProfiles, new.FlexProfiles) {
+		changes.RestartRequired = append(changes.RestartRequired, "flexProfiles")
+	}
+	if !reflect.DeepEqual(old.FlexSerialSettings, new.FlexSerialSettings) {
+		changes.RestartRequired = append(changes.RestartRequired, "flexSerialSettings")
+	}
+	if old.FlexDeviceProfiles != new.FlexDeviceProfiles {
+		changes.RestartRequired = append(changes.RestartRequired, "flexDeviceProfiles")
+	}
+	if old.IdleTimeout != new.IdleTimeout {
+		changes.RestartRequired = append(changes.RestartRequired, "idleTimeout")
+	}
+	if old.FlexDataTimeout != new.FlexDataTimeout {
+		changes.RestartRequired = append(changes.RestartRequired, "flexDataTimeout")
+	}
+	if old.FlexPollRate != new.FlexPollRate {
+		changes.RestartRequired = append(changes.RestartRequired, "flexPollRate")
+	}
+	if !reflect.DeepEqual(old.Instances, new.Instances) {
+		changes.RestartRequired = append(changes.RestartRequired, "instances")
+	}
+	if old.IncidentWindow != new.IncidentWindow {
+		changes.RestartRequired = append(changes.RestartRequired, "incidentWindow")
+	}
+	if old.AccessLog != new.AccessLog {
+		changes.RestartRequired = append(changes.RestartRequired, "accessLog")
+	}
+	if old.FirmwareUpdateWhenBusy != new.FirmwareUpdateWhenBusy {
+		changes.RestartRequired = append(changes.RestartRequired, "firmwareUpdateWhenBusy")
+	}
+	if !reflect.DeepEqual(old.Outbound, new.Outbound) {
+		changes.RestartRequired = append(changes.RestartRequired, "outbound")
+	}
+	if old.Socket != new.Socket {
+		changes.RestartRequired = append(changes.RestartRequired, "socket")
+	}
+	if old.IndicatorSocket != new.IndicatorSocket {
+		changes.RestartRequired = append(changes.RestartRequired, "indicatorSocket")
+	}
+	if old.SensoUDPBridge != new.SensoUDPBridge {
+		changes.RestartRequired = append(changes.RestartRequired, "sensoUDPBridge")
+	}
+	if !reflect.DeepEqual(old.Storage, new.Storage) {
+		changes.RestartRequired = append(changes.RestartRequired, "storage")
+	}
+	if old.Limits != new.Limits {
+		changes.RestartRequired = append(changes.RestartRequired, "limits")
+	}
+	if old.Profile != new.Profile {
+		changes.RestartRequired = append(changes.RestartRequired, "profile")
+	}
+	if old.Heartbeat != new.Heartbeat {
+		changes.RestartRequired = append(changes.RestartRequired, "heartbeat")
+	}
+
+	return changes
+}
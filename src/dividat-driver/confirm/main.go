@@ -0,0 +1,105 @@
+package confirm
+
+/* Two-step confirmation of destructive commands.
+
+Commands like firmware updates are not run when received. The client is sent
+a token with a description of exactly what will happen, and the command only
+runs once the client echoes the token before it expires. A UI bug sending a
+command by accident therefore can not flash or reset a device on its own.
+
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Timeout is how long a client has to confirm
+const Timeout = 30 * time.Second
+
+// Request describes an operation awaiting confirmation
+type Request struct {
+	Token   string `json:"token"`
+	Command string `json:"command"`
+	// What will happen, in words
+	Description string    `json:"description"`
+	Expires     time.Time `json:"expires"`
+}
+
+type pending struct {
+	session int
+	request Request
+	run     func()
+}
+
+// Pending holds operations awaiting confirmation, by token
+type Pending struct {
+	mutex      sync.Mutex
+	operations map[string]pending
+}
+
+// New returns an empty set of pending operations
+func New() *Pending {
+	return &Pending{operations: map[string]pending{}}
+}
+
+// Hold back an operation requested by the session until it is confirmed
+func (p *Pending) Hold(session int, command string, description string, run func()) Request {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.expire(time.Now())
+	request := Request{Token: newToken(), Command: command, Description: description, Expires: time.Now().Add(Timeout).UTC()}
+	p.operations[request.Token] = pending{session: session, request: request, run: run}
+	return request
+}
+
+// Confirm runs the operation held back under the token, returns false if the
+// token is unknown, expired or was issued to another session. Each operation
+// runs at most once.
+func (p *Pending) Confirm(session int, token string) bool {
+	p.mutex.Lock()
+	p.expire(time.Now())
+	operation, ok := p.operations[token]
+	if ok && operation.session == session {
+		delete(p.operations, token)
+	}
+	p.mutex.Unlock()
+
+	if !ok || operation.session != session {
+		return false
+	}
+	operation.run()
+	return true
+}
+
+// Drop discards the operations of a closed session
+func (p *Pending) Drop(session int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for token, operation := range p.operations {
+		if operation.session == session {
+			delete(p.operations, token)
+		}
+	}
+}
+
+// Must be called with the mutex held
+func (p *Pending) expire(now time.Time) {
+	for token, operation := range p.operations {
+		if now.After(operation.request.Expires) {
+			delete(p.operations, token)
+		}
+	}
+}
+
+func newToken() string {
+	buffer := make([]byte, 16)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buffer)
+}
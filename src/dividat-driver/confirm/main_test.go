@@ -0,0 +1,50 @@
+package confirm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfirmRunsOnce(t *testing.T) {
+	pending := New()
+	runs := 0
+	request := pending.Hold(1, "UpdateFirmware", "Flash firmware", func() { runs++ })
+
+	if !pending.Confirm(1, request.Token) {
+		t.Errorf("expected confirmation to be accepted")
+	}
+	if pending.Confirm(1, request.Token) {
+		t.Errorf("expected second confirmation to be refused")
+	}
+	if runs != 1 {
+		t.Errorf("expected operation to run once, ran %d times", runs)
+	}
+}
+
+func TestConfirmRequiresSameSession(t *testing.T) {
+	pending := New()
+	runs := 0
+	request := pending.Hold(1, "PowerCycleDevice", "Power cycle", func() { runs++ })
+
+	if pending.Confirm(2, request.Token) {
+		t.Errorf("expected confirmation of other session to be refused")
+	}
+	if !pending.Confirm(1, request.Token) || runs != 1 {
+		t.Errorf("expected operation to remain confirmable by its session")
+	}
+}
+
+func TestExpiredAndDroppedAreRefused(t *testing.T) {
+	pending := New()
+	expired := pending.Hold(1, "UpdateFirmware", "Flash firmware", func() { t.Errorf("expired operation ran") })
+	pending.expire(time.Now().Add(Timeout + time.Second))
+	if pending.Confirm(1, expired.Token) {
+		t.Errorf("expected expired confirmation to be refused")
+	}
+
+	dropped := pending.Hold(1, "UpdateFirmware", "Flash firmware", func() { t.Errorf("dropped operation ran") })
+	pending.Drop(1)
+	if pending.Confirm(1, dropped.Token) {
+		t.Errorf("expected confirmation of closed session to be refused")
+	}
+}
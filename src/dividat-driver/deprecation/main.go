@@ -0,0 +1,65 @@
+package deprecation
+
+/* Warnings about deprecated protocol fields and behaviors.
+
+Clients are warned in strict protocol mode only, so that client teams can
+migrate before the legacy paths are removed in the next major version. Each
+warning is sent to a client once per connection, in a `Deprecated` message, and
+logged.
+
+*/
+
+import (
+	"sync"
+)
+
+// Major version removing the deprecated paths
+const RemovedIn = "3.0.0"
+
+// Warning tells a client that it relies on a deprecated field or behavior
+type Warning struct {
+	// Identifier of the deprecated field or behavior
+	Feature string `json:"feature"`
+	Message string `json:"message"`
+	// What clients should do instead
+	Replacement string `json:"replacement"`
+	RemovedIn   string `json:"removedIn"`
+}
+
+// LegacyStatus is the Senso Status reporting the default device in top-level
+// fields
+var LegacyStatus = Warning{
+	Feature:     "legacyStatus",
+	Message:     "The default Senso is reported in the top-level fields address, alternatives, connection, health, serialNumber and firmwareVersion of Status.",
+	Replacement: "Read the default Senso from devices, where it is listed with an empty device identifier.",
+	RemovedIn:   RemovedIn,
+}
+
+// ImplicitConnect is the Flex endpoint connecting to any Flex device while
+// none is selected
+var ImplicitConnect = Warning{
+	Feature:     "implicitConnect",
+	Message:     "The driver connects to any Flex device while no device is selected.",
+	Replacement: "Select the device with the Connect command, by USB serial number.",
+	RemovedIn:   RemovedIn,
+}
+
+// Once passes each warning once, e.g. per connection
+type Once struct {
+	mutex sync.Mutex
+	sent  map[string]bool
+}
+
+// First returns whether the warning is passed for the first time
+func (once *Once) First(warning Warning) bool {
+	once.mutex.Lock()
+	defer once.mutex.Unlock()
+	if once.sent == nil {
+		once.sent = map[string]bool{}
+	}
+	if once.sent[warning.Feature] {
+		return false
+	}
+	once.sent[warning.Feature] = true
+	return true
+}
@@ -0,0 +1,18 @@
+package deprecation
+
+import (
+	"testing"
+)
+
+func TestWarningsArePassedOnce(t *testing.T) {
+	once := Once{}
+	if !once.First(LegacyStatus) {
+		t.Error("expected first warning to be passed")
+	}
+	if once.First(LegacyStatus) {
+		t.Error("expected repeated warning not to be passed")
+	}
+	if !once.First(ImplicitConnect) {
+		t.Error("expected other warning to be passed")
+	}
+}
@@ -0,0 +1,95 @@
+//go:build linux
+// +build linux
+
+package doctor
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/dividat/driver/src/dividat-driver/config"
+)
+
+const udevRulePath = "/etc/udev/rules.d/50-dividat-flex.rules"
+
+// Grants access to the serial port of Teensy-based Flex controllers (Teensy
+// USB Serial, 16C0:0483) to members of the dialout group, and to the user
+// logged in at the machine
+const udevRule = `SUBSYSTEM=="tty", ATTRS{idVendor}=="16c0", ATTRS{idProduct}=="0483", GROUP="dialout", MODE="0660", TAG+="uaccess"` + "\n"
+
+const pcscdSocket = "/run/pcscd/pcscd.comm"
+
+func platformChecks(cfg *config.Config) []check {
+	return []check{
+		{name: "Smart card", run: checkPcscd},
+		{name: "Firewall", run: func() result { return checkFirewall(remotePort(cfg)) }},
+	}
+}
+
+func checkDeviceAccess(path string) result {
+	file, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err == nil {
+		file.Close()
+		return pass("")
+	}
+	if os.IsPermission(err) {
+		return fail("no permission to open %s", path).withFix("install udev rule "+udevRulePath+", the driver's user must be in the dialout group", installUdevRule)
+	}
+	// Opened exclusively, e.g. by a running driver
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EBUSY {
+		return pass("")
+	}
+	return fail("could not open %s: %v", path, err)
+}
+
+func installUdevRule() error {
+	err := ioutil.WriteFile(udevRulePath, []byte(udevRule), 0644)
+	if err != nil {
+		return err
+	}
+	err = run("udevadm", "control", "--reload-rules")
+	if err != nil {
+		return err
+	}
+	return run("udevadm", "trigger", "--subsystem-match=tty")
+}
+
+// RFID readers are accessed through pcscd
+func checkPcscd() result {
+	if _, err := os.Stat(pcscdSocket); err == nil {
+		return pass("pcscd is available")
+	}
+	return warn("pcscd is not running, RFID readers are unavailable").withFix("enable pcscd socket", func() error {
+		return run("systemctl", "enable", "--now", "pcscd.socket")
+	})
+}
+
+// Remote connections must pass an active ufw firewall
+func checkFirewall(port string) result {
+	if port == "" {
+		return pass("remote access disabled")
+	}
+	if _, err := exec.LookPath("ufw"); err != nil {
+		return pass("no ufw firewall found")
+	}
+	output, err := exec.Command("ufw", "status").CombinedOutput()
+	if err != nil {
+		return warn("could not query ufw, may require root")
+	}
+	status := string(output)
+	if !strings.Contains(status, "Status: active") {
+		return pass("ufw inactive")
+	}
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && (fields[0] == port || fields[0] == port+"/tcp") && fields[1] == "ALLOW" {
+			return pass("port %s allowed by ufw", port)
+		}
+	}
+	return fail("port %s for remote access is blocked by ufw", port).withFix("allow port "+port+" in ufw", func() error {
+		return run("ufw", "allow", port+"/tcp")
+	})
+}
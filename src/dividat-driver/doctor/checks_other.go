@@ -0,0 +1,17 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package doctor
+
+import (
+	"github.com/dividat/driver/src/dividat-driver/config"
+)
+
+// No platform-specific setup is known
+func platformChecks(cfg *config.Config) []check {
+	return []check{}
+}
+
+func checkDeviceAccess(path string) result {
+	return pass("")
+}
@@ -0,0 +1,49 @@
+//go:build windows
+// +build windows
+
+package doctor
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/dividat/driver/src/dividat-driver/config"
+)
+
+const firewallRule = "Dividat Driver"
+
+func platformChecks(cfg *config.Config) []check {
+	return []check{
+		{name: "Smart card", run: checkSmartCardService},
+		{name: "Firewall", run: func() result { return checkFirewall(remotePort(cfg)) }},
+	}
+}
+
+// Serial ports need no permissions on Windows
+func checkDeviceAccess(path string) result {
+	return pass("")
+}
+
+// RFID readers are accessed through the Smart Card service
+func checkSmartCardService() result {
+	output, err := exec.Command("sc", "query", "SCardSvr").CombinedOutput()
+	if err == nil && strings.Contains(string(output), "RUNNING") {
+		return pass("Smart Card service is running")
+	}
+	return warn("Smart Card service is not running, RFID readers are unavailable").withFix("start Smart Card service", func() error {
+		return run("sc", "start", "SCardSvr")
+	})
+}
+
+// Remote connections must pass Windows Defender Firewall
+func checkFirewall(port string) result {
+	if port == "" {
+		return pass("remote access disabled")
+	}
+	if exec.Command("netsh", "advfirewall", "firewall", "show", "rule", "name="+firewallRule).Run() == nil {
+		return pass("firewall rule %q exists", firewallRule)
+	}
+	return fail("no firewall rule for remote access on port %s", port).withFix("add firewall rule "+firewallRule, func() error {
+		return run("netsh", "advfirewall", "firewall", "add", "rule", "name="+firewallRule, "dir=in", "action=allow", "protocol=TCP", "localport="+port)
+	})
+}
@@ -0,0 +1,271 @@
+package doctor
+
+/* Checks of the environment the driver runs in.
+
+`dividat-driver doctor` checks the configuration, data directory, local port,
+connected Flex devices and the platform's setup (service installation, device
+permissions, smart card service, firewall) and prints a report. With `-fix`,
+known remediations are applied to failed checks where the driver has the
+privileges to do so, and the checks are repeated.
+
+*/
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kardianos/service"
+
+	"github.com/dividat/driver/src/dividat-driver/config"
+	"github.com/dividat/driver/src/dividat-driver/flex"
+	"github.com/dividat/driver/src/dividat-driver/server"
+)
+
+type status int
+
+const (
+	passed status = iota
+	warning
+	failed
+)
+
+type result struct {
+	status status
+	detail string
+	// Remediation if the check did not pass, nil if none is known
+	fix *fix
+}
+
+type fix struct {
+	description string
+	apply       func() error
+}
+
+type check struct {
+	name string
+	run  func() result
+}
+
+func pass(format string, args ...interface{}) result {
+	return result{status: passed, detail: fmt.Sprintf(format, args...)}
+}
+
+func warn(format string, args ...interface{}) result {
+	return result{status: warning, detail: fmt.Sprintf(format, args...)}
+}
+
+func fail(format string, args ...interface{}) result {
+	return result{status: failed, detail: fmt.Sprintf(format, args...)}
+}
+
+func (r result) withFix(description string, apply func() error) result {
+	r.fix = &fix{description: description, apply: apply}
+	return r
+}
+
+// Command runs the checks and prints a report, exiting with status 1 if any
+// check failed. The service is the driver's system service.
+func Command(flags []string, svc service.Service) {
+	doctorFlags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configPath := doctorFlags.String("config", "", "Path to the JSON configuration file of the driver")
+	applyFixes := doctorFlags.Bool("fix", false, "Apply known remediations to failed checks")
+	noColor := doctorFlags.Bool("no-color", os.Getenv("NO_COLOR") != "", "Print the report without colors")
+	doctorFlags.Parse(flags)
+
+	out := report{color: !*noColor}
+
+	cfg := config.Default()
+	configResult := pass("default configuration")
+	if *configPath != "" {
+		loaded, err := config.Load(*configPath)
+		if err != nil {
+			configResult = fail("%v", err)
+		} else {
+			cfg = loaded
+			configResult = pass("loaded %s", *configPath)
+		}
+	}
+	cfg.Apply(config.Overrides{})
+	out.print("Configuration", configResult)
+
+	checks := append(commonChecks(cfg, svc), platformChecks(cfg)...)
+
+	failures := 0
+	for _, c := range checks {
+		r := c.run()
+		out.print(c.name, r)
+		if r.status == passed || r.fix == nil {
+			if r.status == failed {
+				failures++
+			}
+			continue
+		}
+
+		if !*applyFixes {
+			out.hint(r.fix.description)
+			if r.status == failed {
+				failures++
+			}
+			continue
+		}
+
+		out.hint("Fixing: " + r.fix.description)
+		if err := r.fix.apply(); err != nil {
+			out.hint(fmt.Sprintf("Could not fix: %v", err))
+		}
+		r = c.run()
+		out.print(c.name, r)
+		if r.status == failed {
+			failures++
+		}
+	}
+
+	if configResult.status == failed {
+		failures++
+	}
+	if failures > 0 {
+		fmt.Printf("\n%d check(s) failed.\n", failures)
+		if !*applyFixes {
+			fmt.Println("Run with -fix to apply known remediations, possibly as administrator.")
+		}
+		os.Exit(1)
+	}
+	fmt.Println("\nNo check failed.")
+}
+
+// Checks applying to all platforms
+func commonChecks(cfg *config.Config, svc service.Service) []check {
+	return []check{
+		{name: "Service", run: func() result { return checkService(svc) }},
+		{name: "Local port", run: func() result { return checkPort(server.LocalAddress) }},
+		{name: "Data directory", run: func() result { return checkDataDirectory(cfg.DataDirectory) }},
+		{name: "Flex devices", run: checkFlexDevices},
+	}
+}
+
+func checkService(svc service.Service) result {
+	state, err := svc.Status()
+	if err == service.ErrNotInstalled {
+		return warn("not installed as system service").withFix("install and start the service", func() error {
+			if err := svc.Install(); err != nil {
+				return err
+			}
+			return svc.Start()
+		})
+	} else if err != nil {
+		return warn("could not determine service status: %v", err)
+	}
+	if state != service.StatusRunning {
+		return warn("installed, but not running").withFix("start the service", svc.Start)
+	}
+	return pass("installed and running")
+}
+
+// The port must be free, or used by a running driver
+func checkPort(address string) result {
+	listener, err := net.Listen("tcp", address)
+	if err == nil {
+		listener.Close()
+		return pass("%s is available", address)
+	}
+
+	client := http.Client{Timeout: 2 * time.Second}
+	response, err := client.Get("http://" + address + "/")
+	if err != nil {
+		return fail("%s is used by another program", address)
+	}
+	defer response.Body.Close()
+	var root struct {
+		Message string `json:"message"`
+		Version string `json:"version"`
+	}
+	if json.NewDecoder(response.Body).Decode(&root) != nil || root.Message != "Dividat Driver" {
+		return fail("%s is used by another program", address)
+	}
+	return pass("%s is served by driver %s", address, root.Version)
+}
+
+func checkDataDirectory(dir string) result {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return warn("%s does not exist", dir).withFix("create "+dir, func() error {
+			return os.MkdirAll(dir, 0755)
+		})
+	} else if err != nil {
+		return fail("%v", err)
+	} else if !info.IsDir() {
+		return fail("%s is not a directory", dir)
+	}
+
+	probe := filepath.Join(dir, ".doctor")
+	if err := ioutil.WriteFile(probe, []byte{}, 0644); err != nil {
+		return fail("%s is not writable: %v", dir, err)
+	}
+	os.Remove(probe)
+	return pass("%s is writable", dir)
+}
+
+func checkFlexDevices() result {
+	devices, err := flex.ListDevices()
+	if err != nil {
+		return fail("could not list serial devices: %v", err)
+	}
+	if len(devices) == 0 {
+		return warn("no Flex device connected")
+	}
+	for _, device := range devices {
+		if result := checkDeviceAccess(device.Path); result.status != passed {
+			return result
+		}
+	}
+	return pass("%d device(s) connected and accessible", len(devices))
+}
+
+// Colored report on the terminal
+type report struct {
+	color bool
+}
+
+func (out report) print(name string, r result) {
+	labels := map[status]string{passed: " OK ", warning: "WARN", failed: "FAIL"}
+	colors := map[status]string{passed: "\033[32m", warning: "\033[33m", failed: "\033[31m"}
+	label := labels[r.status]
+	if out.color {
+		label = colors[r.status] + label + "\033[0m"
+	}
+	fmt.Printf("[%s] %-16s %s\n", label, name, r.detail)
+}
+
+func (out report) hint(text string) {
+	fmt.Printf("       %s\n", text)
+}
+
+// Run a system command, the error includes its output
+func run(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Port of the remote address, empty if remote access is disabled
+func remotePort(cfg *config.Config) string {
+	if !cfg.Remote.Enabled() {
+		return ""
+	}
+	_, port, err := net.SplitHostPort(cfg.Remote.Address)
+	if err != nil {
+		return ""
+	}
+	return port
+}
@@ -0,0 +1,162 @@
+package drops
+
+/* Statistics of messages dropped by the broker.
+
+The broker publishes to subscribers without blocking, messages for subscribers
+that fall behind are dropped silently. Publishers number their messages, and
+subscribers report the numbers they receive, so that gaps reveal drops.
+
+Besides cumulative counts, the share of deliveries dropped during the last
+window is kept, to warn about subscribers that currently fall behind.
+
+*/
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Length of the window over which recent drops are counted
+const Window = 30 * time.Second
+
+// Share of deliveries dropped within a window above which a warning is due
+const WarningShare = 0.01
+
+// Topic counts messages published to a topic and missed by its subscribers
+type Topic struct {
+	name string
+
+	mutex       sync.Mutex
+	published   uint64
+	dropped     uint64
+	delivered   uint64
+	subscribers map[*Subscriber]bool
+
+	// Counts at the start of the current window
+	windowStart     time.Time
+	windowDropped   uint64
+	windowDelivered uint64
+	// Drops of the last complete window
+	recent Recent
+}
+
+// Recent drops during the last complete window
+type Recent struct {
+	// Dropped messages per second
+	Rate float64 `json:"rate"`
+	// Share of deliveries to subscribers that were dropped
+	Share float64 `json:"share"`
+}
+
+// NewTopic returns statistics for the named topic
+func NewTopic(name string) *Topic {
+	return &Topic{name: name, subscribers: map[*Subscriber]bool{}, windowStart: time.Now()}
+}
+
+// Publish counts a message and returns its sequence number
+func (topic *Topic) Publish() uint64 {
+	topic.mutex.Lock()
+	defer topic.mutex.Unlock()
+	topic.published++
+	topic.roll(time.Now())
+	return topic.published
+}
+
+// Subscribe starts counting drops for a subscriber, e.g. a WebSocket client
+func (topic *Topic) Subscribe(name string) *Subscriber {
+	topic.mutex.Lock()
+	defer topic.mutex.Unlock()
+	// Messages published before subscribing were not missed
+	subscriber := &Subscriber{topic: topic, name: name, last: topic.published}
+	topic.subscribers[subscriber] = true
+	return subscriber
+}
+
+// Complete the window once it has passed
+func (topic *Topic) roll(now time.Time) {
+	elapsed := now.Sub(topic.windowStart)
+	if elapsed < Window {
+		return
+	}
+	dropped := topic.dropped - topic.windowDropped
+	delivered := topic.delivered - topic.windowDelivered
+	topic.recent = Recent{Rate: float64(dropped) / elapsed.Seconds()}
+	if dropped+delivered > 0 {
+		topic.recent.Share = float64(dropped) / float64(dropped+delivered)
+	}
+	topic.windowStart = now
+	topic.windowDropped = topic.dropped
+	topic.windowDelivered = topic.delivered
+}
+
+// Snapshot of the statistics of a topic
+type Snapshot struct {
+	Topic     string `json:"topic"`
+	Published uint64 `json:"published"`
+	// Messages missed by all subscribers, including ones that left
+	Dropped     uint64               `json:"dropped"`
+	Recent      Recent               `json:"recent"`
+	Subscribers []SubscriberSnapshot `json:"subscribers"`
+}
+
+// SubscriberSnapshot counts the messages missed by a current subscriber
+type SubscriberSnapshot struct {
+	Name    string `json:"name"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// Snapshot returns the current statistics
+func (topic *Topic) Snapshot() Snapshot {
+	topic.mutex.Lock()
+	defer topic.mutex.Unlock()
+	topic.roll(time.Now())
+
+	snapshot := Snapshot{
+		Topic:       topic.name,
+		Published:   topic.published,
+		Dropped:     topic.dropped,
+		Recent:      topic.recent,
+		Subscribers: []SubscriberSnapshot{},
+	}
+	for subscriber := range topic.subscribers {
+		snapshot.Subscribers = append(snapshot.Subscribers, SubscriberSnapshot{Name: subscriber.name, Dropped: subscriber.dropped})
+	}
+	sort.Slice(snapshot.Subscribers, func(i, j int) bool { return snapshot.Subscribers[i].Name < snapshot.Subscribers[j].Name })
+	return snapshot
+}
+
+// Subscriber counts the messages a subscriber missed
+type Subscriber struct {
+	topic *Topic
+	name  string
+
+	// Guarded by the topic's mutex
+	last    uint64
+	dropped uint64
+}
+
+// Received counts a message received by the subscriber, and the messages
+// missed before it
+func (subscriber *Subscriber) Received(sequence uint64) {
+	topic := subscriber.topic
+	topic.mutex.Lock()
+	defer topic.mutex.Unlock()
+	if sequence > subscriber.last+1 {
+		missed := sequence - subscriber.last - 1
+		subscriber.dropped += missed
+		topic.dropped += missed
+	}
+	if sequence > subscriber.last {
+		subscriber.last = sequence
+	}
+	topic.delivered++
+}
+
+// Close stops counting for the subscriber, its drops remain counted for the topic
+func (subscriber *Subscriber) Close() {
+	topic := subscriber.topic
+	topic.mutex.Lock()
+	defer topic.mutex.Unlock()
+	delete(topic.subscribers, subscriber)
+}
@@ -0,0 +1,42 @@
+package drops
+
+import "testing"
+
+func TestReceivedCountsGaps(t *testing.T) {
+	topic := NewTopic("test")
+	subscriber := topic.Subscribe("client")
+
+	for i := 0; i < 5; i++ {
+		topic.Publish()
+	}
+	subscriber.Received(1)
+	subscriber.Received(2)
+	subscriber.Received(5)
+
+	snapshot := topic.Snapshot()
+	if snapshot.Published != 5 || snapshot.Dropped != 2 {
+		t.Errorf("expected 5 published and 2 dropped, got %d and %d", snapshot.Published, snapshot.Dropped)
+	}
+	if len(snapshot.Subscribers) != 1 || snapshot.Subscribers[0].Dropped != 2 {
+		t.Errorf("expected subscriber with 2 dropped, got %+v", snapshot.Subscribers)
+	}
+
+	subscriber.Close()
+	snapshot = topic.Snapshot()
+	if len(snapshot.Subscribers) != 0 || snapshot.Dropped != 2 {
+		t.Errorf("expected drops to remain counted after closing, got %+v", snapshot)
+	}
+}
+
+func TestLateSubscriberMissesNothing(t *testing.T) {
+	topic := NewTopic("test")
+	topic.Publish()
+	topic.Publish()
+
+	subscriber := topic.Subscribe("client")
+	subscriber.Received(topic.Publish())
+
+	if dropped := topic.Snapshot().Dropped; dropped != 0 {
+		t.Errorf("expected no drops, got %d", dropped)
+	}
+}
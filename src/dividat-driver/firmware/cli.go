@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 
+	"github.com/dividat/driver/src/dividat-driver/catalog"
 	"github.com/dividat/driver/src/dividat-driver/service"
 )
 
@@ -27,8 +28,8 @@ func Command(flags []string) {
 		os.Exit(1)
 	}
 
-	onProgress := func(progressMsg string) {
-		fmt.Println(progressMsg)
+	onProgress := func(text catalog.Text) {
+		fmt.Println(text)
 	}
 
 	tryPowerCycling := "Try turning the Senso off and on, waiting for 30 seconds and then running this update tool again."
@@ -56,11 +57,11 @@ func Command(flags []string) {
 }
 
 func updateByDiscovery(ctx context.Context, image io.Reader, onProgress OnProgress) (err error, suggestPowerCycling bool) {
-	onProgress("Discovering Sensos")
+	onProgress(catalog.New("senso.discovering"))
 	services := service.List(ctx, discoveryTimeout)
 	if len(services) == 1 {
 		target := services[0]
-		onProgress(fmt.Sprintf("Discovered Senso: %s (%s)", target.Text.Serial, target.Address))
+		onProgress(catalog.New("senso.discovered", "serial", target.Text.Serial, "address", target.Address))
 		err = update(ctx, target, image, onProgress)
 		if err != nil {
 			suggestPowerCycling = true
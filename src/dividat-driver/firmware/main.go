@@ -17,14 +17,17 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
 	"github.com/pin/tftp"
 
+	"github.com/dividat/driver/src/dividat-driver/catalog"
 	"github.com/dividat/driver/src/dividat-driver/service"
 )
 
@@ -32,16 +35,17 @@ const tftpPort = "69"
 const controllerPort = "55567"
 const discoveryTimeout = 120 * time.Second
 
-type OnProgress func(msg string)
+// OnProgress reports progress to the user
+type OnProgress func(text catalog.Text)
 
 func UpdateBySerial(ctx context.Context, deviceSerial string, image io.Reader, onProgress OnProgress) error {
-	onProgress(fmt.Sprintf("Looking for Senso with specified serial %s", deviceSerial))
+	onProgress(catalog.New("senso.searching", "serial", deviceSerial))
 	match := service.Find(ctx, discoveryTimeout, service.SerialNumberFilter(deviceSerial))
 	if match == nil {
 		return fmt.Errorf("Failed to find Senso with serial number %s", deviceSerial)
 	}
 
-	onProgress(fmt.Sprintf("Found Senso at %s", match.Address))
+	onProgress(catalog.New("senso.found", "address", match.Address))
 	return update(ctx, *match, image, onProgress)
 }
 
@@ -56,14 +60,14 @@ func update(parentCtx context.Context, target service.Service, image io.Reader,
 		backoffStrategy.MaxElapsedTime = 30 * time.Second
 		backoffStrategy.MaxInterval = 10 * time.Second
 		err := backoff.RetryNotify(trySendDfu, backoffStrategy, func(e error, d time.Duration) {
-			onProgress(fmt.Sprintf("%v\nRetrying in %v", e, d))
+			onProgress(catalog.New("senso.retrying", "error", e.Error(), "delay", d.String()))
 		})
 
 		if err != nil {
 			return fmt.Errorf("Could not send DFU command to Senso at %s: %s", target.Address, err)
 		}
 
-		onProgress("Looking for Senso in bootloader mode")
+		onProgress(catalog.New("senso.searchingBootloader"))
 		dfuService := service.Find(parentCtx, discoveryTimeout, func(discovered service.Service) bool {
 			return service.SerialNumberFilter(target.Text.Serial)(discovered) && service.IsDfuService(discovered)
 		})
@@ -73,12 +77,12 @@ func update(parentCtx context.Context, target service.Service, image io.Reader,
 		}
 
 		target = *dfuService
-		onProgress(fmt.Sprintf("Found Senso in bootloader mode at %s", target.Address))
-		onProgress("Waiting 10 seconds to ensure proper TFTP startup")
+		onProgress(catalog.New("senso.foundBootloaderAt", "address", target.Address))
+		onProgress(catalog.New("senso.waitingForTftp"))
 		// Wait to ensure proper TFTP startup
 		time.Sleep(10 * time.Second)
 	} else {
-		onProgress("Found Senso in bootloader mode")
+		onProgress(catalog.New("senso.foundBootloader"))
 	}
 
 	err := putTFTP(target.Address, tftpPort, image, onProgress)
@@ -107,7 +111,7 @@ func sendDfuCommand(host string, port string, onProgress OnProgress) error {
 
 	command := append(header, body...)
 
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%s", host, port))
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, port))
 	if err != nil {
 		return fmt.Errorf("Could not dial connection to Senso controller at %s:%s: %v", host, port, err)
 	}
@@ -119,19 +123,39 @@ func sendDfuCommand(host string, port string, onProgress OnProgress) error {
 		return fmt.Errorf("Could not send DFU command: %v", err)
 	}
 
-	onProgress(fmt.Sprintf("Sent DFU command to %s:%s", host, port))
+	onProgress(catalog.New("senso.sentDfu", "address", net.JoinHostPort(host, port)))
 
 	return nil
 }
 
+// Failed transfers are restarted as a whole, as TFTP can not resume part way
+const transferAttempts = 3
+
 func putTFTP(host string, port string, image io.Reader, onProgress OnProgress) error {
-	onProgress("Creating TFTP client")
-	client, err := tftp.NewClient(fmt.Sprintf("%s:%s", host, port))
+	// Keep the image in memory to be able to retry and report progress
+	data, err := ioutil.ReadAll(image)
+	if err != nil {
+		return fmt.Errorf("Could not read firmware image: %v", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = sendTFTP(host, port, data, onProgress)
+		if err == nil || attempt == transferAttempts {
+			return err
+		}
+		onProgress(catalog.New("senso.transmissionRestarted", "error", err.Error(), "attempt", strconv.Itoa(attempt+1), "attempts", strconv.Itoa(transferAttempts)))
+	}
+}
+
+func sendTFTP(host string, port string, data []byte, onProgress OnProgress) error {
+	onProgress(catalog.New("senso.creatingTftpClient"))
+	client, err := tftp.NewClient(net.JoinHostPort(host, port))
 	if err != nil {
 		return fmt.Errorf("Could not create tftp client: %v", err)
 	}
 
 	maxRetries := 5
+	// Every block is acknowledged by the Senso and resent if the acknowledgement is missing
 	client.SetRetries(maxRetries)
 	// It can take a while for the Senso to respond to the TFTP write request.
 	// Setting timeout to 10 seconds prevents unnecessary messages about failed
@@ -146,25 +170,64 @@ func putTFTP(host string, port string, image io.Reader, onProgress OnProgress) e
 
 	client.SetBackoff(func(attempt int) time.Duration {
 		delay := expDelay(attempt)
-		msg := fmt.Sprintf("Failed on attempt %d, retrying in %v", attempt+1, delay)
-		onProgress(msg)
+		onProgress(catalog.New("senso.attemptFailed", "attempt", strconv.Itoa(attempt+1), "delay", delay.String()))
 		return delay
 	})
 
-	onProgress("Preparing transmission")
+	onProgress(catalog.New("senso.preparingTransmission"))
 	rf, err := client.Send("controller-app.bin", "octet")
 	if err != nil {
 		return fmt.Errorf("Could not create send connection: %v", err)
 	}
-	onProgress("Transmitting...")
-	n, err := rf.ReadFrom(image)
+	onProgress(catalog.New("senso.transmitting"))
+	n, err := rf.ReadFrom(newProgressReader(data, onProgress))
 	if err != nil {
 		return fmt.Errorf("Could not read from file: %v", err)
 	}
-	onProgress(fmt.Sprintf("%d bytes sent", n))
+	onProgress(catalog.New("senso.bytesSent", "bytes", strconv.FormatInt(n, 10)))
 	return nil
 }
 
+// Reader reporting the share of the image read and the effective transfer
+// rate in steps of 10%. The TFTP client reads the next block only after the
+// previous one was acknowledged, so reading progress is transfer progress.
+type progressReader struct {
+	reader     *bytes.Reader
+	total      int
+	started    time.Time
+	reported   int
+	onProgress OnProgress
+}
+
+func newProgressReader(data []byte, onProgress OnProgress) *progressReader {
+	return &progressReader{
+		reader:     bytes.NewReader(data),
+		total:      len(data),
+		started:    time.Now(),
+		onProgress: onProgress,
+	}
+}
+
+func (progress *progressReader) Read(p []byte) (int, error) {
+	n, err := progress.reader.Read(p)
+
+	read := progress.total - progress.reader.Len()
+	if progress.total > 0 {
+		percent := 100 * read / progress.total
+		if percent/10 > progress.reported/10 {
+			progress.reported = percent
+			rate := float64(read) / 1024 / time.Since(progress.started).Seconds()
+			progress.onProgress(catalog.New("senso.transmitted",
+				"sent", strconv.Itoa(read/1024),
+				"total", strconv.Itoa(progress.total/1024),
+				"percent", strconv.Itoa(percent),
+				"rate", strconv.FormatFloat(rate, 'f', 1, 64)))
+		}
+	}
+
+	return n, err
+}
+
 // State to keep track of when an update is in progress.
 // This is used by the senso module, but is kept here to
 // ensure privacy of internals.
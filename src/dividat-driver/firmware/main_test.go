@@ -0,0 +1,82 @@
+package firmware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/pin/tftp"
+
+	"github.com/dividat/driver/src/dividat-driver/catalog"
+)
+
+// Writer failing once a number of bytes was written
+type failingWriter struct {
+	limit   int
+	written int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.written += len(p)
+	if w.written > w.limit {
+		return 0, errors.New("connection dropped")
+	}
+	return len(p), nil
+}
+
+func TestFailedTransferIsRestarted(t *testing.T) {
+	image := make([]byte, 10*512+100)
+	for i := range image {
+		image[i] = byte(i)
+	}
+
+	var mutex sync.Mutex
+	writes := 0
+	var received bytes.Buffer
+	server := tftp.NewServer(nil, func(filename string, wt io.WriterTo) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		writes++
+		// The first transfer fails part way
+		if writes == 1 {
+			_, err := wt.WriteTo(&failingWriter{limit: 4 * 512})
+			return err
+		}
+		_, err := wt.WriteTo(&received)
+		return err
+	})
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(conn)
+	defer server.Shutdown()
+
+	var restarts []catalog.Text
+	onProgress := func(text catalog.Text) {
+		if text.ID == "senso.transmissionRestarted" {
+			restarts = append(restarts, text)
+		}
+	}
+	port := strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+	err = putTFTP("127.0.0.1", port, bytes.NewReader(image), onProgress)
+	if err != nil {
+		t.Fatalf("expected transfer to succeed, got %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if writes != 2 {
+		t.Errorf("expected 2 transfers, got %d", writes)
+	}
+	if len(restarts) != 1 || restarts[0].Params["attempt"] != "2" {
+		t.Errorf("expected one restart reported as attempt 2, got %v", restarts)
+	}
+	if !bytes.Equal(received.Bytes(), image) {
+		t.Errorf("expected the whole image to be received by the restarted transfer, got %d of %d bytes", received.Len(), len(image))
+	}
+}
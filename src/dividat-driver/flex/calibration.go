@@ -0,0 +1,121 @@
+package flex
+
+import (
+	"sync"
+	"time"
+)
+
+// Collection time if the client does not specify one
+const defaultCalibrationDuration = 3 * time.Second
+
+// Longest collection time, the mat must stay idle meanwhile
+const maxCalibrationDuration = time.Minute
+
+// Baseline of each cell of the idle mat, subtracted from sets while active.
+// Shared by all clients of a device.
+type calibration struct {
+	mutex sync.Mutex
+
+	// Sums of values while collecting, by cell
+	collecting bool
+	sums       map[uint16]int
+	sets       int
+
+	// Mean values of the idle mat by cell, nil if not calibrated
+	baselines map[uint16]int
+	format    sampleFormat
+}
+
+// CalibrationState is reported when calibration completes or is cleared
+type CalibrationState struct {
+	Active bool `json:"active"`
+	// Cells with a baseline
+	Cells int `json:"cells"`
+	// Sets the baselines were computed from
+	Sets int `json:"sets"`
+}
+
+func cellOf(samples []byte, offset int) uint16 {
+	return uint16(samples[offset])<<8 | uint16(samples[offset+1])
+}
+
+func (c *calibration) start() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.collecting = true
+	c.sums = map[uint16]int{}
+	c.sets = 0
+}
+
+// Accumulate a raw set if collecting
+func (c *calibration) observe(set measurementSet) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.collecting {
+		return
+	}
+	// Sets of another format can not be compared, start over
+	if c.sets > 0 && c.format.bitdepth != set.format.bitdepth {
+		c.sums = map[uint16]int{}
+		c.sets = 0
+	}
+	c.format = set.format
+	step := set.format.bytesPerSample
+	for i := 0; i+step <= len(set.samples); i += step {
+		c.sums[cellOf(set.samples, i)] += set.format.value(set.samples, i)
+	}
+	c.sets++
+}
+
+// Compute baselines from the collected sets. Cells missing from a set count
+// as zero. Previous baselines are kept if no set was collected.
+func (c *calibration) finish() CalibrationState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.collecting = false
+	if c.sets > 0 {
+		c.baselines = map[uint16]int{}
+		for cell, sum := range c.sums {
+			if mean := sum / c.sets; mean > 0 {
+				c.baselines[cell] = mean
+			}
+		}
+	}
+	state := CalibrationState{Active: c.baselines != nil, Cells: len(c.baselines), Sets: c.sets}
+	c.sums = nil
+	return state
+}
+
+func (c *calibration) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.collecting = false
+	c.sums = nil
+	c.baselines = nil
+}
+
+// Subtract baselines from a set, values do not drop below zero
+func (c *calibration) apply(set measurementSet) measurementSet {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.baselines == nil || c.format.bitdepth != set.format.bitdepth {
+		return set
+	}
+
+	samples := make([]byte, len(set.samples))
+	copy(samples, set.samples)
+	step := set.format.bytesPerSample
+	for i := 0; i+step <= len(samples); i += step {
+		baseline, ok := c.baselines[cellOf(samples, i)]
+		if !ok {
+			continue
+		}
+		value := set.format.value(samples, i) - baseline
+		if value < 0 {
+			value = 0
+		}
+		set.format.setValue(samples, i, value)
+	}
+	set.samples = samples
+	return set
+}
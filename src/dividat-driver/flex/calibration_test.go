@@ -0,0 +1,109 @@
+package flex
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCalibration(t *testing.T) {
+	var tests = []struct {
+		name     string
+		idle     []measurementSet
+		set      measurementSet
+		state    CalibrationState
+		expected []byte
+	}{
+		{
+			name:     "baseline is subtracted",
+			idle:     []measurementSet{set8([3]byte{0, 0, 4}), set8([3]byte{0, 0, 6})},
+			set:      set8([3]byte{0, 0, 20}, [3]byte{0, 1, 20}),
+			state:    CalibrationState{Active: true, Cells: 1, Sets: 2},
+			expected: []byte{0, 0, 15, 0, 1, 20},
+		},
+		{
+			name:     "values do not drop below zero",
+			idle:     []measurementSet{set8([3]byte{1, 1, 10})},
+			set:      set8([3]byte{1, 1, 3}),
+			state:    CalibrationState{Active: true, Cells: 1, Sets: 1},
+			expected: []byte{1, 1, 0},
+		},
+		{
+			name:     "cells missing from idle sets count as zero",
+			idle:     []measurementSet{set8([3]byte{2, 2, 9}), set8(), set8()},
+			set:      set8([3]byte{2, 2, 9}),
+			state:    CalibrationState{Active: true, Cells: 1, Sets: 3},
+			expected: []byte{2, 2, 6},
+		},
+		{
+			name:     "cells with a mean below one have no baseline",
+			idle:     []measurementSet{set8([3]byte{2, 2, 1}), set8()},
+			set:      set8([3]byte{2, 2, 1}),
+			state:    CalibrationState{Active: true, Cells: 0, Sets: 2},
+			expected: []byte{2, 2, 1},
+		},
+		{
+			name:     "12-bit values",
+			idle:     []measurementSet{{format: format12Bit, samples: []byte{0, 0, 0x01, 0x00}}},
+			set:      measurementSet{format: format12Bit, samples: []byte{0, 0, 0x0F, 0xFF}},
+			state:    CalibrationState{Active: true, Cells: 1, Sets: 1},
+			expected: []byte{0, 0, 0x0E, 0xFF},
+		},
+		{
+			name:     "sets of another bitdepth are not calibrated",
+			idle:     []measurementSet{set8([3]byte{0, 0, 4})},
+			set:      measurementSet{format: format12Bit, samples: []byte{0, 0, 0x00, 0x10}},
+			state:    CalibrationState{Active: true, Cells: 1, Sets: 1},
+			expected: []byte{0, 0, 0x00, 0x10},
+		},
+		{
+			name:     "format changing while collecting starts over",
+			idle:     []measurementSet{{format: format12Bit, samples: []byte{0, 0, 0x0F, 0x00}}, set8([3]byte{0, 0, 2})},
+			set:      set8([3]byte{0, 0, 5}),
+			state:    CalibrationState{Active: true, Cells: 1, Sets: 1},
+			expected: []byte{0, 0, 3},
+		},
+	}
+
+	for _, test := range tests {
+		c := calibration{}
+		c.start()
+		for _, set := range test.idle {
+			c.observe(set)
+		}
+		state := c.finish()
+		if state != test.state {
+			t.Errorf("%s: expected state %+v, got %+v", test.name, test.state, state)
+		}
+
+		original := append([]byte{}, test.set.samples...)
+		calibrated := c.apply(test.set)
+		if !bytes.Equal(calibrated.samples, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, calibrated.samples)
+		}
+		if !bytes.Equal(test.set.samples, original) {
+			t.Errorf("%s: expected the original set to be left unchanged", test.name)
+		}
+	}
+}
+
+func TestCalibrationKeepsBaselinesWithoutSets(t *testing.T) {
+	c := calibration{}
+	c.start()
+	c.observe(set8([3]byte{0, 0, 4}))
+	c.finish()
+
+	// Sets are not observed unless collecting
+	c.observe(set8([3]byte{0, 0, 100}))
+	c.start()
+	if state := c.finish(); state != (CalibrationState{Active: true, Cells: 1, Sets: 0}) {
+		t.Errorf("expected previous baselines to be kept, got %+v", state)
+	}
+	if calibrated := c.apply(set8([3]byte{0, 0, 10})); !bytes.Equal(calibrated.samples, []byte{0, 0, 6}) {
+		t.Errorf("expected previous baseline to be subtracted, got %v", calibrated.samples)
+	}
+
+	c.clear()
+	if calibrated := c.apply(set8([3]byte{0, 0, 10})); !bytes.Equal(calibrated.samples, []byte{0, 0, 10}) {
+		t.Errorf("expected no baseline after clearing, got %v", calibrated.samples)
+	}
+}
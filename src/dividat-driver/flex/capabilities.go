@@ -0,0 +1,210 @@
+package flex
+
+import (
+	"fmt"
+	"sync"
+
+	flexdevice "github.com/dividat/driver/src/dividat-driver/flex/device"
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+)
+
+// Capabilities describe how a Flex firmware revision must be driven
+type Capabilities = flexdevice.Capabilities
+
+// Commands understood by a firmware revision
+type Commands = flexdevice.Commands
+
+// Commands of SensingTex firmware, which polls with the start command
+var sensingTexCommands = Commands{
+	Start: []byte{'S', '\n'},
+	Poll:  []byte{'S', '\n'},
+}
+
+// Profile assigns capabilities to devices by USB identification
+type Profile struct {
+	// Zero matches any vendor or product
+	VID uint16
+	PID uint16
+	// Range of USB device release numbers (bcdDevice), inclusive
+	MinBcdDevice uint16
+	MaxBcdDevice uint16
+
+	Capabilities Capabilities
+}
+
+// Profiles of firmware revisions, the first matching profile is used. No
+// release numbers of firmware revisions are documented, so there are none
+// built in, profiles are registered from the configuration.
+var profiles = []Profile{}
+
+// Assumed if no profile matches. Polling works with all firmware revisions, if
+// not optimally, and clients may select any bitdepth, as they could with raw
+// commands.
+var defaultCapabilities = Capabilities{Revision: "unknown", Streaming: false, Bitdepths: []int{8, 12}, Commands: sensingTexCommands}
+
+// RegisterProfile adds a firmware revision, taking precedence over earlier
+// ones, so firmware can be driven without changes to the driver.
+// Must be called before devices are scanned.
+func RegisterProfile(profile Profile) error {
+	if profile.MinBcdDevice > profile.MaxBcdDevice {
+		return fmt.Errorf("empty bcdDevice range %04X-%04X", profile.MinBcdDevice, profile.MaxBcdDevice)
+	}
+	err := validateCapabilities(profile.Capabilities)
+	if err != nil {
+		return err
+	}
+	profiles = append([]Profile{profile}, profiles...)
+	return nil
+}
+
+func validateCapabilities(capabilities Capabilities) error {
+	if len(capabilities.Commands.Start) == 0 {
+		return fmt.Errorf("no start command")
+	}
+	if !capabilities.Streaming && len(capabilities.Commands.Poll) == 0 {
+		return fmt.Errorf("no poll command for device that does not stream")
+	}
+	if capabilities.PollRate < 0 {
+		return fmt.Errorf("negative poll rate %v", capabilities.PollRate)
+	}
+	if len(capabilities.Bitdepths) == 0 {
+		return fmt.Errorf("no bitdepths")
+	}
+	for _, bitdepth := range capabilities.Bitdepths {
+		if _, ok := sampleFormats[bitdepth]; !ok {
+			return fmt.Errorf("unsupported bitdepth %d", bitdepth)
+		}
+	}
+	return nil
+}
+
+// A profile covering all release numbers also matches devices not reporting theirs
+func (profile Profile) matches(device enumerator.Device) bool {
+	if profile.VID != 0 && device.VID != profile.VID {
+		return false
+	}
+	if profile.PID != 0 && device.PID != profile.PID {
+		return false
+	}
+	if device.BcdDevice == nil {
+		return profile.MinBcdDevice == 0x0000 && profile.MaxBcdDevice == 0xFFFF
+	}
+	return *device.BcdDevice >= profile.MinBcdDevice && *device.BcdDevice <= profile.MaxBcdDevice
+}
+
+func capabilitiesOf(device enumerator.Device) Capabilities {
+	for _, profile := range profiles {
+		if profile.matches(device) {
+			return profile.Capabilities
+		}
+	}
+	return defaultCapabilities
+}
+
+// DeviceInfo describes the connected device
+type DeviceInfo struct {
+	Path         string  `json:"path"`
+	VID          string  `json:"vid"`
+	PID          string  `json:"pid"`
+	SerialNumber string  `json:"serialNumber"`
+	BcdDevice    *string `json:"bcdDevice"`
+	// Firmware version encoded in bcdDevice, e.g. "5.02"
+	FirmwareVersion *string `json:"firmwareVersion"`
+	Revision        string  `json:"revision"`
+	Bitdepths       []int   `json:"bitdepths"`
+	// "streaming" or "polled"
+	Acquisition string `json:"acquisition"`
+	// Sets per second requested from polled devices, nil if as fast as sets complete
+	PollRate *float64 `json:"pollRate,omitempty"`
+	// Protocol handler selected for the device
+	Handler string `json:"handler"`
+	// Sample format configured on the device, determines how binary frames are laid out
+	Bitdepth       int `json:"bitdepth"`
+	BytesPerSample int `json:"bytesPerSample"`
+	// Index of the mat if the controller multiplexes several, nil otherwise
+	Mat *int `json:"mat,omitempty"`
+}
+
+func newDeviceInfo(device enumerator.Device, capabilities Capabilities) DeviceInfo {
+	info := DeviceInfo{
+		Path:         device.Path,
+		VID:          fmt.Sprintf("%04X", device.VID),
+		PID:          fmt.Sprintf("%04X", device.PID),
+		SerialNumber: device.SerialNumber,
+		Revision:     capabilities.Revision,
+		Bitdepths:    capabilities.Bitdepths,
+		Acquisition:  "polled",
+	}
+	if capabilities.Streaming {
+		info.Acquisition = "streaming"
+	} else if capabilities.PollRate > 0 {
+		rate := capabilities.PollRate
+		info.PollRate = &rate
+	}
+	if device.BcdDevice != nil {
+		bcdDevice := fmt.Sprintf("%04X", *device.BcdDevice)
+		info.BcdDevice = &bcdDevice
+		version := fmt.Sprintf("%x.%02x", *device.BcdDevice>>8, *device.BcdDevice&0xFF)
+		info.FirmwareVersion = &version
+	}
+	return info
+}
+
+// Dimensions of the sensor matrix, as seen in sets since the device connected.
+// SensingTex firmware can not be asked for them.
+type matrixSize struct {
+	mutex   sync.Mutex
+	rows    int
+	columns int
+}
+
+func (size *matrixSize) observe(set measurementSet) {
+	rows, columns := 0, 0
+	for i := 0; i+set.format.bytesPerSample <= len(set.samples); i += set.format.bytesPerSample {
+		if int(set.samples[i]) >= rows {
+			rows = int(set.samples[i]) + 1
+		}
+		if int(set.samples[i+1]) >= columns {
+			columns = int(set.samples[i+1]) + 1
+		}
+	}
+
+	size.mutex.Lock()
+	defer size.mutex.Unlock()
+	if rows > size.rows {
+		size.rows = rows
+	}
+	if columns > size.columns {
+		size.columns = columns
+	}
+}
+
+func (size *matrixSize) reset() {
+	size.mutex.Lock()
+	defer size.mutex.Unlock()
+	size.rows, size.columns = 0, 0
+}
+
+// Rows and columns, nil if no set has been seen
+func (size *matrixSize) get() (*int, *int) {
+	size.mutex.Lock()
+	defer size.mutex.Unlock()
+	if size.rows == 0 || size.columns == 0 {
+		return nil, nil
+	}
+	rows, columns := size.rows, size.columns
+	return &rows, &columns
+}
+
+// Identify the device for pairing, by serial number if available. Mats of
+// multiplexing controllers are told apart by their index.
+func (info DeviceInfo) pairingID() string {
+	id := "flex:" + info.Path
+	if info.SerialNumber != "" {
+		id = "flex:" + info.SerialNumber
+	}
+	if info.Mat != nil {
+		id += fmt.Sprintf("#mat-%d", *info.Mat)
+	}
+	return id
+}
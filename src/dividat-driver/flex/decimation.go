@@ -0,0 +1,57 @@
+package flex
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Highest rate a client may request sets at, per second
+const maxSetRate = 1000
+
+// Sets forwarded to a single client at a limited rate, all if no rate is set.
+// Surplus sets are dropped rather than averaged, so forwarded sets are as
+// received from the device.
+type decimation struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	last     time.Time
+}
+
+// set limits forwarding to the given rate per second, zero forwards all sets
+func (d *decimation) set(rate float64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if rate <= 0 {
+		d.interval = 0
+		return
+	}
+	if rate > maxSetRate {
+		rate = maxSetRate
+	}
+	d.interval = time.Duration(float64(time.Second) / rate)
+}
+
+// keep decides whether the set is forwarded
+func (d *decimation) keep(set measurementSet) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.interval == 0 {
+		return true
+	}
+	// Allow for jitter of the device, so that a rate matching the device's does not halve it
+	if !d.last.IsZero() && set.receivedAt.Sub(d.last) < d.interval*9/10 {
+		return false
+	}
+	d.last = set.receivedAt
+	return true
+}
+
+// Rate requested with the `rate` query parameter, zero if absent or invalid
+func parseRate(value string) float64 {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 {
+		return 0
+	}
+	return rate
+}
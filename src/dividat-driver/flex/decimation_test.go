@@ -0,0 +1,77 @@
+package flex
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecimation(t *testing.T) {
+	// Offsets in milliseconds at which sets are received
+	var tests = []struct {
+		name     string
+		rate     float64
+		received []int
+		kept     []int
+	}{
+		{
+			name:     "all sets without rate",
+			rate:     0,
+			received: []int{0, 1, 2, 3},
+			kept:     []int{0, 1, 2, 3},
+		},
+		{
+			name:     "halved",
+			rate:     50,
+			received: []int{0, 10, 20, 30, 40, 50},
+			kept:     []int{0, 20, 40},
+		},
+		{
+			name:     "jitter at the device's rate",
+			rate:     100,
+			received: []int{0, 9, 20, 29, 40},
+			kept:     []int{0, 9, 20, 29, 40},
+		},
+		{
+			name:     "interval counts from the last kept set",
+			rate:     10,
+			received: []int{0, 50, 95, 140, 200},
+			kept:     []int{0, 95, 200},
+		},
+		{
+			name:     "rate is capped",
+			rate:     5000,
+			received: []int{0, 0, 1, 2},
+			kept:     []int{0, 1, 2},
+		},
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		d := decimation{}
+		d.set(test.rate)
+		kept := []int{}
+		for _, offset := range test.received {
+			if d.keep(measurementSet{receivedAt: start.Add(time.Duration(offset) * time.Millisecond)}) {
+				kept = append(kept, offset)
+			}
+		}
+		if !reflect.DeepEqual(kept, test.kept) {
+			t.Errorf("%s: expected sets at %v ms, got %v", test.name, test.kept, kept)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for value, expected := range map[string]float64{
+		"":     0,
+		"30":   30,
+		"12.5": 12.5,
+		"-1":   0,
+		"fast": 0,
+	} {
+		if rate := parseRate(value); rate != expected {
+			t.Errorf("expected rate %q to be %v, got %v", value, expected, rate)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package flex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Layouts in which sets are forwarded to a client
+const (
+	// Samples as sent by the device, listing row, column and value of cells with a value
+	LayoutSparse = "sparse"
+	// Values of all cells of the matrix. Layout (big-endian):
+	//
+	//	bytes 0-1   rows
+	//	bytes 2-3   columns
+	//	bytes 4-    values row by row, 1 byte each for 8 bit, 2 bytes for 12 bit
+	//
+	// Missing cells are zero, cells outside the matrix are dropped.
+	LayoutDense = "dense"
+)
+
+const denseHeaderSize = 4
+
+func validateLayout(layout string) error {
+	if layout != LayoutSparse && layout != LayoutDense {
+		return fmt.Errorf("unknown layout %q", layout)
+	}
+	return nil
+}
+
+// Assemble the samples into a dense matrix of the given dimensions
+func densify(samples []byte, format sampleFormat, rows int, columns int) []byte {
+	width := format.bytesPerSample - 2
+	frame := make([]byte, denseHeaderSize+rows*columns*width)
+	binary.BigEndian.PutUint16(frame[0:2], uint16(rows))
+	binary.BigEndian.PutUint16(frame[2:4], uint16(columns))
+	for i := 0; i+format.bytesPerSample <= len(samples); i += format.bytesPerSample {
+		row, column := int(samples[i]), int(samples[i+1])
+		if row >= rows || column >= columns {
+			continue
+		}
+		offset := denseHeaderSize + (row*columns+column)*width
+		copy(frame[offset:offset+width], samples[i+2:i+format.bytesPerSample])
+	}
+	return frame
+}
+
+// Layout of sets for a single client. Dense matrices have the given dimensions,
+// or those of the device if zero.
+type outputLayout struct {
+	mutex   sync.Mutex
+	dense   bool
+	rows    int
+	columns int
+}
+
+func (layout *outputLayout) set(name string, rows int, columns int) {
+	layout.mutex.Lock()
+	defer layout.mutex.Unlock()
+	layout.dense = name == LayoutDense
+	layout.rows, layout.columns = rows, columns
+}
+
+func (layout *outputLayout) apply(samples []byte, format sampleFormat, matrix *matrixSize) []byte {
+	layout.mutex.Lock()
+	dense, rows, columns := layout.dense, layout.rows, layout.columns
+	layout.mutex.Unlock()
+	if !dense {
+		return samples
+	}
+
+	if rows == 0 || columns == 0 {
+		deviceRows, deviceColumns := matrix.get()
+		if deviceRows == nil {
+			return densify(samples, format, 0, 0)
+		}
+		if rows == 0 {
+			rows = *deviceRows
+		}
+		if columns == 0 {
+			columns = *deviceColumns
+		}
+	}
+	return densify(samples, format, rows, columns)
+}
@@ -0,0 +1,161 @@
+package device
+
+/* Registry of protocol handlers for Flex devices.
+
+Each kind of device is driven by a handler speaking its protocol on the opened
+serial port. Handlers are registered under a name, with a matcher selecting
+the devices they drive. Packages for further kinds of devices register their
+handlers from an init function, so the driver does not need to import them
+by name.
+
+*/
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/catalog"
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+)
+
+// Capabilities describe how a Flex firmware revision must be driven
+type Capabilities struct {
+	Revision string
+
+	// Streaming devices keep sending measurement sets after a single start
+	// command, others send a single set per start command and must be polled.
+	Streaming bool
+	// Sets per second requested from devices that do not stream, as fast as
+	// sets complete if zero
+	PollRate float64
+
+	Bitdepths []int
+
+	Commands Commands
+}
+
+// SupportsBitdepth returns whether the firmware can send samples of the bitdepth
+func (capabilities Capabilities) SupportsBitdepth(bitdepth int) bool {
+	for _, supported := range capabilities.Bitdepths {
+		if supported == bitdepth {
+			return true
+		}
+	}
+	return false
+}
+
+// Commands understood by a firmware revision, nil if not supported
+type Commands struct {
+	// Start acquisition, also sent to restart it if the device stops sending sets
+	Start []byte
+	// Request the next set from devices that do not stream
+	Poll []byte
+	// Stop acquisition when the connection is closed
+	Stop []byte
+	// Put the device into low-power mode after stopping acquisition
+	Sleep []byte
+	// Ask the device to report its firmware version as a text line
+	Version []byte
+}
+
+// Format of the samples the device is to be configured for
+type Format struct {
+	Bitdepth int
+	// Command configuring the bitdepth
+	Command []byte
+	// Row, column and big-endian sample value
+	BytesPerSample int
+}
+
+// Untagged is the mat index of sets from devices not multiplexing mats
+const Untagged = -1
+
+// Conn is an opened device, as handed to its handler
+type Conn struct {
+	Port   io.ReadWriter
+	Logger *logrus.Entry
+
+	Capabilities Capabilities
+	Format       Format
+
+	// Restart acquisition if the device sends no set for this long, zero if disabled
+	DataTimeout time.Duration
+
+	// Whether a complete set of the given number of samples is plausible
+	Valid func(set []byte, samples int) bool
+	// Counting sets for statistics
+	Received func()
+	Dropped  func()
+	Resynced func()
+	// Round trip from a poll to the complete set it asked for
+	Answered func(roundTrip time.Duration)
+
+	// Pass on a valid set, with the index of its mat or Untagged
+	Receive func(set []byte, mat int)
+	// Pass on a text line sent by the device
+	Line func(line string)
+	// Inform clients that the device stopped sending data
+	Unresponsive func(message catalog.Text)
+}
+
+// Handler speaks a protocol on an opened device until the connection ends or
+// the context is cancelled
+type Handler func(ctx context.Context, conn Conn)
+
+// Matcher selects the devices a handler drives
+type Matcher func(enumerator.Device) bool
+
+// MatchUSB matches devices with the given vendor and product, any product if zero
+func MatchUSB(vid uint16, pid uint16) Matcher {
+	return func(device enumerator.Device) bool {
+		return device.VID == vid && (pid == 0 || device.PID == pid)
+	}
+}
+
+type registration struct {
+	name    string
+	matcher Matcher
+	handler Handler
+}
+
+var (
+	mutex         sync.Mutex
+	registrations []registration
+)
+
+// Register adds a handler for the devices selected by the matcher. Later
+// registrations take precedence, so they may refine earlier, broader ones.
+// Must be called before devices are scanned, e.g. from an init function.
+func Register(name string, matcher Matcher, handler Handler) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	registrations = append([]registration{{name: name, matcher: matcher, handler: handler}}, registrations...)
+}
+
+// For returns name and handler driving the device, false if there is none
+func For(device enumerator.Device) (string, Handler, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, registration := range registrations {
+		if registration.matcher(device) {
+			return registration.name, registration.handler, true
+		}
+	}
+	return "", nil, false
+}
+
+// Named returns the handler registered under the name, false if there is none
+func Named(name string) (Handler, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, registration := range registrations {
+		if registration.name == name {
+			return registration.handler, true
+		}
+	}
+	return nil, false
+}
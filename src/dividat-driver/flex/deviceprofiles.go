@@ -0,0 +1,131 @@
+package flex
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/dividat/driver/src/dividat-driver/config"
+	flexdevice "github.com/dividat/driver/src/dividat-driver/flex/device"
+)
+
+// DeviceProfile declares how to recognize and drive a kind of Flex device, so
+// controllers of further vendors can be supported by configuration. Profiles
+// are read from a JSON file holding a list of them, e.g.:
+//
+//	[{
+//	  "name": "acme-mat",
+//	  "device": "1209:F1E8",
+//	  "handler": "sensing-tex",
+//	  "serial": { "baudRate": 57600 },
+//	  "streaming": false,
+//	  "pollRate": 50,
+//	  "bitdepths": [8],
+//	  "commands": { "start": "S\n", "poll": "S\n" }
+//	}]
+type DeviceProfile struct {
+	Name string `json:"name"`
+	// USB identification as "VID:PID" or "VID"
+	Device string `json:"device"`
+	// Name of a registered protocol handler, e.g. "sensing-tex"
+	Handler string         `json:"handler"`
+	Serial  SerialSettings `json:"serial"`
+
+	// Frame format and acquisition, as in Capabilities
+	Streaming bool         `json:"streaming"`
+	PollRate  float64      `json:"pollRate"`
+	Bitdepths []int        `json:"bitdepths"`
+	Commands  TextCommands `json:"commands"`
+}
+
+// TextCommands are Commands as text, omitted if not supported
+type TextCommands struct {
+	Start   string `json:"start"`
+	Poll    string `json:"poll"`
+	Stop    string `json:"stop"`
+	Sleep   string `json:"sleep"`
+	Version string `json:"version"`
+}
+
+func (commands TextCommands) bytes() Commands {
+	command := func(text string) []byte {
+		if text == "" {
+			return nil
+		}
+		return []byte(text)
+	}
+	return Commands{
+		Start:   command(commands.Start),
+		Poll:    command(commands.Poll),
+		Stop:    command(commands.Stop),
+		Sleep:   command(commands.Sleep),
+		Version: command(commands.Version),
+	}
+}
+
+// LoadDeviceProfiles reads device profiles from a JSON file and registers them.
+// No profile is registered if any is invalid. Must be called before devices
+// are scanned.
+func LoadDeviceProfiles(path string) ([]DeviceProfile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var profiles []DeviceProfile
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&profiles)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse device profiles: %v", err)
+	}
+
+	for _, profile := range profiles {
+		err = validateDeviceProfile(profile)
+		if err != nil {
+			return nil, fmt.Errorf("device profile %q: %v", profile.Name, err)
+		}
+	}
+	for _, profile := range profiles {
+		registerDeviceProfile(profile)
+	}
+	return profiles, nil
+}
+
+func validateDeviceProfile(profile DeviceProfile) error {
+	if profile.Name == "" {
+		return fmt.Errorf("no name")
+	}
+	if _, _, err := config.ParseUSBID(profile.Device); err != nil {
+		return err
+	}
+	if _, ok := flexdevice.Named(profile.Handler); !ok {
+		return fmt.Errorf("unknown handler %q", profile.Handler)
+	}
+	if _, err := serialMode(profile.Serial); err != nil {
+		return err
+	}
+	return validateCapabilities(profile.capabilities())
+}
+
+func (profile DeviceProfile) capabilities() Capabilities {
+	return Capabilities{
+		Revision:  profile.Name,
+		Streaming: profile.Streaming,
+		PollRate:  profile.PollRate,
+		Bitdepths: profile.Bitdepths,
+		Commands:  profile.Commands.bytes(),
+	}
+}
+
+// Register handler, serial settings and capabilities of a validated profile
+func registerDeviceProfile(profile DeviceProfile) {
+	vid, pid, _ := config.ParseUSBID(profile.Device)
+	mode, _ := serialMode(profile.Serial)
+	handler, _ := flexdevice.Named(profile.Handler)
+
+	flexdevice.Register(profile.Handler, flexdevice.MatchUSB(vid, pid), handler)
+	serialRegistry = append(serialRegistry, serialEntry{vid: vid, pid: pid, mode: mode})
+	profiles = append([]Profile{{VID: vid, PID: pid, MinBcdDevice: 0x0000, MaxBcdDevice: 0xFFFF, Capabilities: profile.capabilities()}}, profiles...)
+}
@@ -0,0 +1,33 @@
+package enumerator
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Read the bcdDevice of the USB device a tty belongs to from sysfs.
+//
+// The tty's `device` link points to the USB interface (ACM) or to a child of
+// it (USB-to-serial converters), so we walk up until the USB device
+// directory is reached.
+func readBcdDevice(portName string) *uint16 {
+	dir, err := filepath.EvalSymlinks(filepath.Join("/sys/class/tty", filepath.Base(portName), "device"))
+	if err != nil {
+		return nil
+	}
+
+	for i := 0; i < 3; i++ {
+		contents, err := ioutil.ReadFile(filepath.Join(dir, "bcdDevice"))
+		if err == nil {
+			bcdDevice, err := ParseID(strings.TrimSpace(string(contents)))
+			if err != nil {
+				return nil
+			}
+			return &bcdDevice
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	return nil
+}
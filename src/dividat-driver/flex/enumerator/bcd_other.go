@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package enumerator
+
+// The serial enumerator does not report bcdDevice on this platform
+func readBcdDevice(portName string) *uint16 {
+	return nil
+}
@@ -0,0 +1,59 @@
+package enumerator
+
+// Filtered lists the devices of another enumerator, restricted by serial number or path
+type Filtered struct {
+	Base Enumerator
+
+	// Only list the device with this path or serial number, all devices if empty
+	Address string
+
+	// Only list devices with one of these serial numbers, all devices if empty
+	Include []string
+	// Never list devices with one of these serial numbers
+	Exclude []string
+}
+
+// ListDevices implements the Enumerator interface
+func (filtered Filtered) ListDevices() ([]Device, error) {
+	devices, err := filtered.Base.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	kept := []Device{}
+	for _, device := range devices {
+		if filtered.Address != "" && device.Path != filtered.Address && device.SerialNumber != filtered.Address {
+			continue
+		}
+		if len(filtered.Include) > 0 && !contains(filtered.Include, device.SerialNumber) {
+			continue
+		}
+		if contains(filtered.Exclude, device.SerialNumber) {
+			continue
+		}
+		kept = append(kept, device)
+	}
+
+	return kept, nil
+}
+
+// ListsSystemDevices returns whether the enumerator only lists devices connected to this machine
+func ListsSystemDevices(devices Enumerator) bool {
+	switch enumerator := devices.(type) {
+	case System:
+		return true
+	case Filtered:
+		return ListsSystemDevices(enumerator.Base)
+	default:
+		return false
+	}
+}
+
+func contains(slice []string, candidate string) bool {
+	for _, member := range slice {
+		if member == candidate {
+			return true
+		}
+	}
+	return false
+}
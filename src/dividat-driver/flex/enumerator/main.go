@@ -0,0 +1,117 @@
+package enumerator
+
+/* Lists serial devices together with their USB identification.
+
+The serial enumerators of the various OS report USB vendor and product IDs as
+strings of differing format. This package normalizes them into numbers, so
+devices can be matched reliably.
+
+Devices are listed through the `Enumerator` interface. Builds with the `debug`
+tag include a registry of mock devices, which are listed in addition to the
+devices connected to the machine (see `mock.go`).
+
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	serialEnumerator "go.bug.st/serial/enumerator"
+)
+
+// Device is a USB serial device
+type Device struct {
+	// Path to open the serial port, e.g. /dev/ttyACM0 or COM3
+	Path string
+
+	VID uint16
+	// Zero if not reported
+	PID uint16
+
+	SerialNumber string
+
+	// Release number of the device (bcdDevice), nil if not reported by the platform
+	BcdDevice *uint16
+}
+
+// Enumerator lists serial devices
+type Enumerator interface {
+	ListDevices() ([]Device, error)
+}
+
+// Default enumerator, lists devices connected to this machine
+var Default Enumerator = System{}
+
+// System lists serial devices connected to this machine
+type System struct{}
+
+// ListDevices implements the Enumerator interface
+func (System) ListDevices() ([]Device, error) {
+	ports, err := serialEnumerator.GetDetailedPortsList()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := []Device{}
+	for _, port := range ports {
+		device, err := fromPortDetails(*port, readBcdDevice(port.Name))
+		if err != nil {
+			continue
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+// Convert details from the serial enumerator, only USB devices with a known vendor are kept
+func fromPortDetails(port serialEnumerator.PortDetails, bcdDevice *uint16) (Device, error) {
+	if !port.IsUSB {
+		return Device{}, fmt.Errorf("%s is not a USB device", port.Name)
+	}
+
+	vid, err := ParseID(port.VID)
+	if err != nil {
+		return Device{}, fmt.Errorf("invalid vendor ID of %s: %v", port.Name, err)
+	}
+
+	// Some platforms omit the product ID
+	pid, err := ParseID(port.PID)
+	if err != nil {
+		pid = 0
+	}
+
+	return Device{
+		Path:         port.Name,
+		VID:          vid,
+		PID:          pid,
+		SerialNumber: port.SerialNumber,
+		BcdDevice:    bcdDevice,
+	}, nil
+}
+
+// ParseID parses a hexadecimal USB identifier like "16C0", "16c0" or "0x16C0"
+func ParseID(id string) (uint16, error) {
+	normalized := strings.ToLower(strings.TrimSpace(id))
+	normalized = strings.TrimPrefix(normalized, "0x")
+	if normalized == "" {
+		return 0, fmt.Errorf("empty identifier")
+	}
+
+	value, err := strconv.ParseUint(normalized, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid identifier %q", id)
+	}
+
+	return uint16(value), nil
+}
+
+// String returns a description of the device for logging
+func (device Device) String() string {
+	bcdDevice := "unknown"
+	if device.BcdDevice != nil {
+		bcdDevice = fmt.Sprintf("%04X", *device.BcdDevice)
+	}
+	return fmt.Sprintf("%s (%04X:%04X, serial %q, bcdDevice %s)", device.Path, device.VID, device.PID, device.SerialNumber, bcdDevice)
+}
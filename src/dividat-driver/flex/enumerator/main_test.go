@@ -0,0 +1,139 @@
+package enumerator
+
+import (
+	"testing"
+
+	serialEnumerator "go.bug.st/serial/enumerator"
+)
+
+func TestParseID(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected uint16
+		valid    bool
+	}{
+		{"16C0", 0x16C0, true},
+		{"16c0", 0x16C0, true},
+		{"0x16c0", 0x16C0, true},
+		{" 0483 ", 0x0483, true},
+		{"", 0, false},
+		{"0x", 0, false},
+		{"16C0F", 0, false},
+		{"XYZ", 0, false},
+	}
+
+	for _, c := range cases {
+		value, err := ParseID(c.input)
+		if c.valid && (err != nil || value != c.expected) {
+			t.Errorf("ParseID(%q) = %04X, %v, expected %04X", c.input, value, err, c.expected)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("ParseID(%q) = %04X, expected error", c.input, value)
+		}
+	}
+}
+
+func TestFromPortDetails(t *testing.T) {
+	bcdDevice := uint16(0x0280)
+
+	device, err := fromPortDetails(serialEnumerator.PortDetails{
+		Name:  "/dev/ttyACM0",
+		IsUSB: true,
+		VID:   "16c0",
+		PID:   "0483",
+	}, &bcdDevice)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if device.VID != 0x16C0 || device.PID != 0x0483 || *device.BcdDevice != 0x0280 {
+		t.Errorf("Unexpected device: %v", device)
+	}
+}
+
+func TestFromPortDetailsWithoutPID(t *testing.T) {
+	device, err := fromPortDetails(serialEnumerator.PortDetails{
+		Name:  "COM3",
+		IsUSB: true,
+		VID:   "16C0",
+		PID:   "",
+	}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if device.VID != 0x16C0 || device.PID != 0 {
+		t.Errorf("Unexpected device: %v", device)
+	}
+}
+
+func TestFromPortDetailsWithoutBcdDevice(t *testing.T) {
+	device, err := fromPortDetails(serialEnumerator.PortDetails{
+		Name:  "/dev/ttyACM0",
+		IsUSB: true,
+		VID:   "16C0",
+		PID:   "0483",
+	}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if device.BcdDevice != nil {
+		t.Errorf("Expected unknown bcdDevice, got %04X", *device.BcdDevice)
+	}
+}
+
+func TestFromPortDetailsRejectsInvalidVID(t *testing.T) {
+	for _, port := range []serialEnumerator.PortDetails{
+		{Name: "/dev/ttyS0", IsUSB: false},
+		{Name: "/dev/ttyACM0", IsUSB: true, VID: ""},
+		{Name: "/dev/ttyACM0", IsUSB: true, VID: "not hex"},
+	} {
+		_, err := fromPortDetails(port, nil)
+		if err == nil {
+			t.Errorf("Expected error for %v", port)
+		}
+	}
+}
+
+type staticEnumerator []Device
+
+func (devices staticEnumerator) ListDevices() ([]Device, error) {
+	return devices, nil
+}
+
+func TestFilteredByAddress(t *testing.T) {
+	devices := staticEnumerator{
+		{Path: "/dev/ttyACM0", VID: 0x16C0, SerialNumber: "FLX0001"},
+		{Path: "/dev/ttyACM1", VID: 0x16C0, SerialNumber: "FLX0002"},
+	}
+
+	cases := []struct {
+		filtered Filtered
+		expected []string
+	}{
+		{Filtered{Base: devices}, []string{"/dev/ttyACM0", "/dev/ttyACM1"}},
+		{Filtered{Base: devices, Address: "/dev/ttyACM1"}, []string{"/dev/ttyACM1"}},
+		{Filtered{Base: devices, Address: "FLX0001"}, []string{"/dev/ttyACM0"}},
+		{Filtered{Base: devices, Address: "FLX0003"}, []string{}},
+		{Filtered{Base: devices, Include: []string{"FLX0002"}}, []string{"/dev/ttyACM1"}},
+		{Filtered{Base: devices, Exclude: []string{"FLX0002"}}, []string{"/dev/ttyACM0"}},
+	}
+
+	for _, c := range cases {
+		listed, err := c.filtered.ListDevices()
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths := []string{}
+		for _, device := range listed {
+			paths = append(paths, device.Path)
+		}
+		if len(paths) != len(c.expected) {
+			t.Errorf("%+v listed %v, expected %v", c.filtered, paths, c.expected)
+			continue
+		}
+		for i := range paths {
+			if paths[i] != c.expected[i] {
+				t.Errorf("%+v listed %v, expected %v", c.filtered, paths, c.expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+//go:build debug
+// +build debug
+
+package enumerator
+
+/* Registry of mock devices for testing.
+
+Test suites create a pseudo terminal that speaks the device protocol and
+register it over HTTP, together with the USB identification it should be
+listed with:
+
+    POST /debug/mock-devices
+    {"path": "/dev/pts/3", "vid": "16C0", "pid": "0483", "serialNumber": "F1", "bcdDevice": "0280"}
+
+Registered devices are listed (GET) and removed (DELETE with `path` query
+parameter, or all of the session without) through the same resource.
+
+Test runs sharing a machine keep their registrations apart with a session
+token in the `X-Mock-Session` header or the `session` query parameter: a
+session only lists and removes its own devices, and can not replace devices
+of other sessions. The driver lists the devices of all sessions. Registrations
+expire after `ttl` seconds given on registration, 10 minutes by default, so
+devices of aborted runs do not linger. Registering again renews them.
+
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Lifetime of registrations that do not specify one
+const defaultMockTTL = 10 * time.Minute
+
+// Mock is the registry of mock devices, listed in addition to system devices
+var Mock = &MockRegistry{
+	system:                System{},
+	registeredMockDevices: map[string]mockRegistration{},
+	now:                   time.Now,
+}
+
+func init() {
+	Default = Mock
+}
+
+// MockRegistry lists registered mock devices in addition to another enumerator's devices
+type MockRegistry struct {
+	system Enumerator
+
+	mutex sync.Mutex
+	// Registrations by path
+	registeredMockDevices map[string]mockRegistration
+
+	now func() time.Time
+}
+
+// Registration of a mock device by a test session
+type mockRegistration struct {
+	device  Device
+	session string
+	expires time.Time
+}
+
+// Remove expired registrations, the mutex must be held
+func (registry *MockRegistry) expire() {
+	now := registry.now()
+	for path, registration := range registry.registeredMockDevices {
+		if !now.Before(registration.expires) {
+			delete(registry.registeredMockDevices, path)
+		}
+	}
+}
+
+// ListDevices implements the Enumerator interface
+func (registry *MockRegistry) ListDevices() ([]Device, error) {
+	devices, err := registry.system.ListDevices()
+	if err != nil {
+		devices = []Device{}
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.expire()
+	for _, registration := range registry.registeredMockDevices {
+		devices = append(devices, registration.device)
+	}
+
+	return devices, nil
+}
+
+// Register adds or renews a mock device of the session for the given
+// lifetime. Returns false if the path is registered by another session.
+func (registry *MockRegistry) Register(session string, device Device, ttl time.Duration) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.expire()
+	if existing, ok := registry.registeredMockDevices[device.Path]; ok && existing.session != session {
+		return false
+	}
+	registry.registeredMockDevices[device.Path] = mockRegistration{
+		device:  device,
+		session: session,
+		expires: registry.now().Add(ttl),
+	}
+	return true
+}
+
+// Unregister removes a mock device of the session, or all its devices if the
+// path is empty
+func (registry *MockRegistry) Unregister(session string, path string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	for registered, registration := range registry.registeredMockDevices {
+		if registration.session == session && (path == "" || path == registered) {
+			delete(registry.registeredMockDevices, registered)
+		}
+	}
+}
+
+// Paths of the session's mock devices
+func (registry *MockRegistry) paths(session string) []string {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.expire()
+	paths := []string{}
+	for path, registration := range registry.registeredMockDevices {
+		if registration.session == session {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Representation of mock devices in the HTTP API
+type mockDevice struct {
+	Path         string `json:"path"`
+	VID          string `json:"vid"`
+	PID          string `json:"pid"`
+	SerialNumber string `json:"serialNumber"`
+	BcdDevice    string `json:"bcdDevice"`
+	// Lifetime of the registration in seconds, defaultMockTTL if 0
+	TTL float64 `json:"ttl"`
+}
+
+func (mock mockDevice) toDevice() (Device, error) {
+	vid, err := ParseID(mock.VID)
+	if err != nil {
+		return Device{}, err
+	}
+	pid, err := ParseID(mock.PID)
+	if err != nil {
+		pid = 0
+	}
+	device := Device{
+		Path:         mock.Path,
+		VID:          vid,
+		PID:          pid,
+		SerialNumber: mock.SerialNumber,
+	}
+	if bcdDevice, err := ParseID(mock.BcdDevice); err == nil {
+		device.BcdDevice = &bcdDevice
+	}
+	return device, nil
+}
+
+// Implement net/http Handler interface
+func (registry *MockRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session := r.Header.Get("X-Mock-Session")
+	if session == "" {
+		session = r.URL.Query().Get("session")
+	}
+
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(registry.paths(session))
+
+	case "POST":
+		var mock mockDevice
+		err := json.NewDecoder(r.Body).Decode(&mock)
+		if err != nil || mock.Path == "" || mock.TTL < 0 {
+			http.Error(w, "Invalid mock device", http.StatusBadRequest)
+			return
+		}
+		device, err := mock.toDevice()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ttl := defaultMockTTL
+		if mock.TTL > 0 {
+			ttl = time.Duration(mock.TTL * float64(time.Second))
+		}
+		if !registry.Register(session, device, ttl) {
+			http.Error(w, "Path is registered by another session", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	case "DELETE":
+		registry.Unregister(session, r.URL.Query().Get("path"))
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
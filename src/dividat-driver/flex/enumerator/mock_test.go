@@ -0,0 +1,69 @@
+//go:build debug
+// +build debug
+
+package enumerator
+
+import (
+	"testing"
+	"time"
+)
+
+type noDevices struct{}
+
+func (noDevices) ListDevices() ([]Device, error) {
+	return []Device{}, nil
+}
+
+func newTestRegistry(now *time.Time) *MockRegistry {
+	return &MockRegistry{
+		system:                noDevices{},
+		registeredMockDevices: map[string]mockRegistration{},
+		now:                   func() time.Time { return *now },
+	}
+}
+
+func TestSessionsAreKeptApart(t *testing.T) {
+	now := time.Now()
+	registry := newTestRegistry(&now)
+
+	if !registry.Register("a", Device{Path: "/dev/pts/3"}, time.Minute) {
+		t.Fatalf("expected registration to succeed")
+	}
+	if registry.Register("b", Device{Path: "/dev/pts/3"}, time.Minute) {
+		t.Errorf("expected registration of path held by other session to be refused")
+	}
+	registry.Register("b", Device{Path: "/dev/pts/4"}, time.Minute)
+
+	if paths := registry.paths("a"); len(paths) != 1 || paths[0] != "/dev/pts/3" {
+		t.Errorf("session a lists %v, expected only its own device", paths)
+	}
+
+	registry.Unregister("a", "/dev/pts/4")
+	registry.Unregister("b", "")
+	devices, _ := registry.ListDevices()
+	if len(devices) != 1 || devices[0].Path != "/dev/pts/3" {
+		t.Errorf("listed %v, expected only the device of session a", devices)
+	}
+}
+
+func TestRegistrationsExpire(t *testing.T) {
+	now := time.Now()
+	registry := newTestRegistry(&now)
+
+	registry.Register("a", Device{Path: "/dev/pts/3"}, time.Minute)
+	now = now.Add(30 * time.Second)
+	registry.Register("a", Device{Path: "/dev/pts/3"}, time.Minute)
+	now = now.Add(45 * time.Second)
+
+	if devices, _ := registry.ListDevices(); len(devices) != 1 {
+		t.Fatalf("expected renewed registration to be listed")
+	}
+
+	now = now.Add(time.Minute)
+	if devices, _ := registry.ListDevices(); len(devices) != 0 {
+		t.Errorf("expected expired registration to be removed, listed %v", devices)
+	}
+	if !registry.Register("b", Device{Path: "/dev/pts/3"}, time.Minute) {
+		t.Errorf("expected path of expired registration to be available")
+	}
+}
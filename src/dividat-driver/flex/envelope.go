@@ -0,0 +1,78 @@
+package flex
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Version of the envelope prepended to measurement sets for clients that
+// enabled timestamps. Layout (big-endian):
+//
+//	byte  0     envelope version
+//	bytes 1-8   monotonic time since driver start (nanoseconds)
+//	bytes 9-16  wall-clock time (microseconds since Unix epoch), follows the
+//	            monotonic clock while clients are connected, see ClockJump
+//	bytes 17-   samples
+const DRIVER_PROTOCOL_VERSION = 1
+
+// Version of the envelope additionally holding the sequence number of the set,
+// for clients asking for it. Layout (big-endian):
+//
+//	bytes 0-16  as in version 1
+//	bytes 17-24 sequence number, increasing by one with every set received
+//	            from devices, so gaps reveal sets not forwarded to the client
+//	bytes 25-   samples
+const SEQUENCED_PROTOCOL_VERSION = 2
+
+const envelopeHeaderSize = 17
+
+const sequencedHeaderSize = 25
+
+// Reference for monotonic timestamps, comparable across devices of this driver
+var driverStart = time.Now()
+
+// Measurement set as published to clients, stamped when completely read
+type measurementSet struct {
+	samples    []byte
+	receivedAt time.Time
+	format     sampleFormat
+	sequence   uint64
+}
+
+func envelope(set measurementSet, samples []byte, version byte) []byte {
+	headerSize := envelopeHeaderSize
+	if version == SEQUENCED_PROTOCOL_VERSION {
+		headerSize = sequencedHeaderSize
+	}
+	frame := make([]byte, headerSize+len(samples))
+	frame[0] = version
+	binary.BigEndian.PutUint64(frame[1:9], uint64(set.receivedAt.Sub(driverStart).Nanoseconds()))
+	binary.BigEndian.PutUint64(frame[9:17], uint64(set.receivedAt.UnixNano()/int64(time.Microsecond)))
+	if version == SEQUENCED_PROTOCOL_VERSION {
+		binary.BigEndian.PutUint64(frame[17:25], set.sequence)
+	}
+	copy(frame[headerSize:], samples)
+	return frame
+}
+
+// Envelope version a single client receives sets in, zero for bare sets
+type timestamps struct {
+	mutex   sync.Mutex
+	version byte
+}
+
+func (stamps *timestamps) set(version byte) {
+	stamps.mutex.Lock()
+	defer stamps.mutex.Unlock()
+	stamps.version = version
+}
+
+func (stamps *timestamps) apply(set measurementSet, samples []byte) []byte {
+	stamps.mutex.Lock()
+	defer stamps.mutex.Unlock()
+	if stamps.version == 0 {
+		return samples
+	}
+	return envelope(set, samples, stamps.version)
+}
@@ -0,0 +1,69 @@
+package flex
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/latency"
+)
+
+// FrameStats counts measurement sets read from devices
+type FrameStats struct {
+	// Complete sets forwarded to clients
+	Received uint64 `json:"received"`
+	// Sets abandoned because of unexpected bytes or failing validation
+	Dropped uint64 `json:"dropped"`
+	// Recoveries from unexpected bytes at the start of a following set
+	Resynced uint64 `json:"resynced"`
+}
+
+// Validation, counting and watching of sets, shared by all connections of a handler
+type frameCheck struct {
+	// Whether sets are validated before being forwarded
+	validate bool
+
+	// Acquisition is restarted if no set is received for this long, zero if disabled
+	dataTimeout time.Duration
+
+	// Sets per second requested from polled devices whose profile sets no rate,
+	// as fast as sets complete if zero
+	pollRate float64
+
+	received uint64
+	dropped  uint64
+	resynced uint64
+
+	// Round trips of recent polls of the connected device
+	polls *latency.Window
+}
+
+func (check *frameCheck) stats() FrameStats {
+	return FrameStats{
+		Received: atomic.LoadUint64(&check.received),
+		Dropped:  atomic.LoadUint64(&check.dropped),
+		Resynced: atomic.LoadUint64(&check.resynced),
+	}
+}
+
+func (check *frameCheck) countReceived() { atomic.AddUint64(&check.received, 1) }
+func (check *frameCheck) countDropped()  { atomic.AddUint64(&check.dropped, 1) }
+func (check *frameCheck) countResynced() { atomic.AddUint64(&check.resynced, 1) }
+
+// Whether a complete set is plausible, if validation is enabled. The
+// SensingTex protocol carries no checksum, but a set lists every point of the
+// matrix at most once.
+func (check *frameCheck) valid(set []byte, samples int, format sampleFormat) bool {
+	if !check.validate {
+		return true
+	}
+
+	seen := make(map[uint16]bool, samples)
+	for i := 0; i+1 < len(set); i += format.bytesPerSample {
+		point := cellOf(set, i)
+		if seen[point] {
+			return false
+		}
+		seen[point] = true
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package halfkay
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Flash of Teensy 4 is mapped at this address in firmware images
+const teensy4FlashBase = 0x60000000
+
+// Image is a firmware image, indexed from the start of the flash
+type Image struct {
+	data []byte
+	// Whether a byte has been set by the image, unset bytes need not be written
+	used []bool
+}
+
+// ParseHex reads a firmware image in Intel HEX format, as produced for
+// Teensy boards. Checksums of all records are verified.
+func ParseHex(reader io.Reader, codeSize int) (*Image, error) {
+	image := &Image{
+		data: make([]byte, codeSize),
+		used: make([]bool, codeSize),
+	}
+
+	scanner := bufio.NewScanner(reader)
+	var baseAddress int
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, ":") {
+			return nil, fmt.Errorf("line %d: missing start code", lineNumber)
+		}
+		record, err := hex.DecodeString(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		if len(record) < 5 || len(record) != int(record[0])+5 {
+			return nil, fmt.Errorf("line %d: invalid record length", lineNumber)
+		}
+
+		var checksum byte
+		for _, b := range record {
+			checksum += b
+		}
+		if checksum != 0 {
+			return nil, fmt.Errorf("line %d: checksum mismatch", lineNumber)
+		}
+
+		offset := int(record[1])<<8 | int(record[2])
+		payload := record[4 : len(record)-1]
+
+		switch recordType := record[3]; recordType {
+		case 0x00: // Data
+			for i, b := range payload {
+				address := baseAddress + offset + i
+				if address >= teensy4FlashBase {
+					address -= teensy4FlashBase
+				}
+				if address < 0 || address >= codeSize {
+					return nil, fmt.Errorf("line %d: address %#x beyond flash of %d bytes", lineNumber, address, codeSize)
+				}
+				image.data[address] = b
+				image.used[address] = true
+			}
+		case 0x01: // End of file
+			return image, nil
+		case 0x02: // Extended segment address
+			if len(payload) != 2 {
+				return nil, fmt.Errorf("line %d: invalid segment address", lineNumber)
+			}
+			baseAddress = (int(payload[0])<<8 | int(payload[1])) << 4
+		case 0x04: // Extended linear address
+			if len(payload) != 2 {
+				return nil, fmt.Errorf("line %d: invalid linear address", lineNumber)
+			}
+			baseAddress = (int(payload[0])<<8 | int(payload[1])) << 16
+		case 0x03, 0x05: // Start address, irrelevant for flashing
+		default:
+			return nil, fmt.Errorf("line %d: unknown record type %#x", lineNumber, recordType)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("missing end of file record")
+}
+
+// Whether any byte within the block has been set by the image
+func (image *Image) usesBlock(address int, size int) bool {
+	for i := address; i < address+size && i < len(image.used); i++ {
+		if image.used[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// Block of data starting at address, padded with erased flash (0xFF)
+func (image *Image) block(address int, size int) []byte {
+	block := make([]byte, size)
+	for i := range block {
+		if address+i < len(image.data) && image.used[address+i] {
+			block[i] = image.data[address+i]
+		} else {
+			block[i] = 0xFF
+		}
+	}
+	return block
+}
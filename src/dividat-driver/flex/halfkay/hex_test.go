@@ -0,0 +1,95 @@
+package halfkay
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Intel HEX record with a valid checksum
+func record(recordType byte, offset uint16, payload ...byte) string {
+	data := append([]byte{byte(len(payload)), byte(offset >> 8), byte(offset), recordType}, payload...)
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	data = append(data, -sum)
+	return fmt.Sprintf(":%X", data)
+}
+
+func hexImage(records ...string) *strings.Reader {
+	return strings.NewReader(strings.Join(records, "\n") + "\n")
+}
+
+func TestParseHex(t *testing.T) {
+	image, err := ParseHex(hexImage(
+		record(0x00, 0x0000, 0x01, 0x02),
+		record(0x02, 0x0000, 0x00, 0x10), // Segment 0x100
+		record(0x00, 0x0004, 0x03),
+		record(0x04, 0x0000, 0x00, 0x01), // Linear 0x10000
+		record(0x00, 0x0010, 0x04),
+		record(0x05, 0x0000, 0x00, 0x00, 0x00, 0x00),
+		record(0x01, 0x0000),
+	), 0x20000)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for address, expected := range map[int]byte{0x0000: 0x01, 0x0001: 0x02, 0x0104: 0x03, 0x10010: 0x04} {
+		if !image.used[address] || image.data[address] != expected {
+			t.Errorf("Expected %#x at %#x, got %#x (used %v)", expected, address, image.data[address], image.used[address])
+		}
+	}
+	if !image.usesBlock(0x10000, 1024) || image.usesBlock(0x400, 1024) {
+		t.Error("Expected only blocks holding data to be used")
+	}
+	if block := image.block(0, 4); !bytes.Equal(block, []byte{0x01, 0x02, 0xFF, 0xFF}) {
+		t.Errorf("Expected unset bytes to be erased, got % x", block)
+	}
+}
+
+func TestParseHexMapsTeensy4Flash(t *testing.T) {
+	image, err := ParseHex(hexImage(
+		record(0x04, 0x0000, 0x60, 0x00),
+		record(0x00, 0x1000, 0xAB),
+		record(0x01, 0x0000),
+	), 2031616)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !image.used[0x1000] || image.data[0x1000] != 0xAB {
+		t.Errorf("Expected data at the start of the flash, got %#x", image.data[0x1000])
+	}
+}
+
+func TestParseHexRejectsInvalidImages(t *testing.T) {
+	valid := record(0x00, 0x0000, 0x01, 0x02)
+	corrupted := valid[:len(valid)-2] + "00"
+
+	for name, image := range map[string]*strings.Reader{
+		"missing start code":  hexImage(valid[1:], record(0x01, 0)),
+		"invalid hex":         hexImage(":0G", record(0x01, 0)),
+		"invalid length":      hexImage(":0300000001FF", record(0x01, 0)),
+		"checksum mismatch":   hexImage(corrupted, record(0x01, 0)),
+		"beyond flash":        hexImage(record(0x00, 0x0400, 0x01), record(0x01, 0)),
+		"unknown record type": hexImage(record(0x06, 0x0000), record(0x01, 0)),
+		"invalid address":     hexImage(record(0x04, 0x0000, 0x01), record(0x01, 0)),
+		"missing end of file": hexImage(valid),
+	} {
+		if _, err := ParseHex(image, 1024); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestBoards(t *testing.T) {
+	for bcdDevice, expected := range map[uint16]board{
+		0x0274: {"Teensy 3.0", 128 * 1024, 1024},
+		0x0275: {"Teensy 3.1/3.2", 256 * 1024, 1024},
+	} {
+		if target, ok := boards[bcdDevice]; !ok || target != expected {
+			t.Errorf("Expected %+v for release %04X, got %+v", expected, bcdDevice, target)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package halfkay
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Bootloader opened through the hidraw interface of the kernel
+type hidraw struct {
+	file *os.File
+}
+
+func (device *hidraw) write(report []byte) error {
+	// Reports are prefixed with the report ID, HalfKay uses none
+	_, err := device.file.Write(append([]byte{0}, report...))
+	return err
+}
+
+func (device *hidraw) close() error {
+	return device.file.Close()
+}
+
+// Find the HalfKay bootloader among hidraw devices
+func openBootloader() (bootloader, uint16, error) {
+	entries, err := filepath.Glob("/sys/class/hidraw/hidraw*")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hidID := fmt.Sprintf("HID_ID=0003:%08X:%08X", vendorID, productID)
+	for _, entry := range entries {
+		uevent, err := ioutil.ReadFile(filepath.Join(entry, "device", "uevent"))
+		if err != nil || !strings.Contains(string(uevent), hidID) {
+			continue
+		}
+
+		// The USB device is two levels above the HID device (interface, device)
+		hidDevice, err := filepath.EvalSymlinks(filepath.Join(entry, "device"))
+		if err != nil {
+			return nil, 0, err
+		}
+		bcdDevice, err := readHexFile(filepath.Join(filepath.Dir(filepath.Dir(hidDevice)), "bcdDevice"))
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not read bootloader release: %v", err)
+		}
+
+		file, err := os.OpenFile(filepath.Join("/dev", filepath.Base(entry)), os.O_WRONLY, 0)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return &hidraw{file: file}, bcdDevice, nil
+	}
+
+	return nil, 0, fmt.Errorf("no HalfKay bootloader found")
+}
+
+func readHexFile(path string) (uint16, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 16, 16)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(value), nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package halfkay
+
+func openBootloader() (bootloader, uint16, error) {
+	return nil, 0, ErrUnsupported
+}
@@ -0,0 +1,218 @@
+package halfkay
+
+/* Flashes firmware onto Teensy-based Flex controllers.
+
+The procedure mirrors the Teensy Loader:
+
+1. Ask the running firmware to reboot into the HalfKay bootloader, by
+   briefly opening its serial port at 134 baud.
+
+2. Wait for the bootloader to appear as a USB HID device and identify the
+   board from its release number.
+
+3. Write the image block by block with HID output reports. The first block
+   erases the flash, blocks not covered by the image are skipped.
+
+4. Send the reboot command and verify that the device returns with the same
+   serial number.
+
+HalfKay can not read back flash, a successful write of all blocks and the
+device returning are the best available verification.
+
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"go.bug.st/serial"
+
+	"github.com/dividat/driver/src/dividat-driver/catalog"
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+)
+
+// ErrUnsupported is returned if HID access is not implemented for the platform
+var ErrUnsupported = errors.New("flashing Flex firmware is not supported on this platform")
+
+// USB identification of the HalfKay bootloader
+const (
+	vendorID  = 0x16C0
+	productID = 0x0478
+)
+
+// Baud rate that makes Teensy firmware reboot into the bootloader
+const rebootBaudRate = 134
+
+const bootloaderTimeout = 10 * time.Second
+const returnTimeout = 15 * time.Second
+
+// First write erases the whole flash and takes longer
+const eraseTimeout = 5 * time.Second
+const writeTimeout = 500 * time.Millisecond
+
+// Board flashed through HalfKay, identified by the bootloader's bcdDevice
+type board struct {
+	name      string
+	codeSize  int
+	blockSize int
+}
+
+// Reports start with a header holding the address, data follows at this offset
+const headerSize = 64
+
+// Release numbers as listed by the Teensy Loader (teensy_loader_cli)
+var boards = map[uint16]board{
+	0x0274: {"Teensy 3.0", 131072, 1024},
+	0x0275: {"Teensy 3.1/3.2", 262144, 1024},
+	0x0276: {"Teensy 3.5", 524288, 1024},
+	0x0277: {"Teensy 3.6", 1048576, 1024},
+	0x0279: {"Teensy 4.0", 2031616, 1024},
+	0x0280: {"Teensy 4.1", 8126464, 1024},
+}
+
+// Open bootloader, accepting HID output reports
+type bootloader interface {
+	write(report []byte) error
+	close() error
+}
+
+// OnProgress reports progress to the user
+type OnProgress func(text catalog.Text)
+
+// Flash writes the Intel HEX image onto the Flex device and waits for it to
+// return. The device's serial port must not be in use.
+func Flash(ctx context.Context, device enumerator.Device, hexImage io.Reader, devices enumerator.Enumerator, onProgress OnProgress) error {
+	onProgress(catalog.New("flex.rebootingIntoBootloader", "path", device.Path))
+	err := rebootIntoBootloader(device.Path)
+	if err != nil {
+		return fmt.Errorf("could not reboot into bootloader: %v", err)
+	}
+
+	loader, bcdDevice, err := waitForBootloader(ctx)
+	if err != nil {
+		return err
+	}
+	defer loader.close()
+
+	target, ok := boards[bcdDevice]
+	if !ok {
+		return fmt.Errorf("unknown board with bootloader release %04X", bcdDevice)
+	}
+	onProgress(catalog.New("flex.foundBootloader", "board", target.name))
+
+	image, err := ParseHex(hexImage, target.codeSize)
+	if err != nil {
+		return fmt.Errorf("invalid firmware image: %v", err)
+	}
+
+	blocks := target.codeSize / target.blockSize
+	for i := 0; i < blocks; i++ {
+		address := i * target.blockSize
+
+		// The first block is always written, as it erases the flash
+		if address > 0 && !image.usesBlock(address, target.blockSize) {
+			continue
+		}
+
+		timeout := writeTimeout
+		if address == 0 {
+			timeout = eraseTimeout
+		}
+
+		report := make([]byte, headerSize+target.blockSize)
+		putAddress(report, address)
+		copy(report[headerSize:], image.block(address, target.blockSize))
+
+		err = writeWithRetry(ctx, loader, report, timeout)
+		if err != nil {
+			return fmt.Errorf("could not write block at %#x: %v", address, err)
+		}
+
+		if i%64 == 0 {
+			onProgress(catalog.New("flex.writingFirmware", "percent", strconv.Itoa(100*i/blocks)))
+		}
+	}
+
+	onProgress(catalog.New("flex.rebootingIntoFirmware"))
+	report := make([]byte, headerSize+target.blockSize)
+	report[0], report[1], report[2] = 0xFF, 0xFF, 0xFF
+	// The bootloader disappears while handling the command, which may fail the write
+	loader.write(report)
+
+	return waitForReturn(ctx, device.SerialNumber, devices, onProgress)
+}
+
+func putAddress(report []byte, address int) {
+	report[0] = byte(address)
+	report[1] = byte(address >> 8)
+	report[2] = byte(address >> 16)
+}
+
+// Opening the port at the magic baud rate is enough, the device resets on its own
+func rebootIntoBootloader(path string) error {
+	port, err := serial.Open(path, &serial.Mode{BaudRate: rebootBaudRate})
+	if err != nil {
+		return err
+	}
+	return port.Close()
+}
+
+func waitForBootloader(ctx context.Context) (bootloader, uint16, error) {
+	deadline := time.Now().Add(bootloaderTimeout)
+	for {
+		loader, bcdDevice, err := openBootloader()
+		if err == nil || err == ErrUnsupported {
+			return loader, bcdDevice, err
+		}
+		if time.Now().After(deadline) {
+			return nil, 0, fmt.Errorf("bootloader did not appear: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// The bootloader rejects writes while busy with the previous block
+func writeWithRetry(ctx context.Context, loader bootloader, report []byte, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := loader.write(report)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) || ctx.Err() != nil {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitForReturn(ctx context.Context, serialNumber string, devices enumerator.Enumerator, onProgress OnProgress) error {
+	onProgress(catalog.New("flex.waitingForReturn"))
+	deadline := time.Now().Add(returnTimeout)
+	for time.Now().Before(deadline) {
+		listed, err := devices.ListDevices()
+		if err == nil {
+			for _, device := range listed {
+				if device.VID == vendorID && device.PID != productID && device.SerialNumber == serialNumber {
+					return nil
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("device with serial number %q did not return after flashing", serialNumber)
+}
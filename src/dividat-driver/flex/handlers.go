@@ -0,0 +1,69 @@
+package flex
+
+import (
+	"sync"
+
+	flexdevice "github.com/dividat/driver/src/dividat-driver/flex/device"
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+)
+
+// Name of the SensingTex protocol handler, referred to by device profiles
+const sensingTexHandler = "sensing-tex"
+
+// Controllers are recognized by vendor ID, unless registered otherwise.
+//
+// Vendor IDs:
+//
+//	16C0 - Van Ooijen Technische Informatica (Teensy)
+func init() {
+	flexdevice.Register(sensingTexHandler, flexdevice.MatchUSB(0x16C0, 0), runSensingTex)
+	flexdevice.Register(sensingTexHandler, isAdditionalDevice, runSensingTex)
+}
+
+// USBID identifies USB devices by vendor and product, any product if zero
+type USBID struct {
+	VID uint16
+	PID uint16
+}
+
+// Devices treated as Flex devices in addition to the known controllers
+var additionalDevices = struct {
+	sync.Mutex
+	ids []USBID
+}{}
+
+// SetAdditionalDevices treats devices with the given IDs as Flex devices
+// speaking the SensingTex protocol, e.g. rebadged controllers, instead of the
+// previously set ones. Takes effect on the next scan, devices already
+// connected stay connected.
+func SetAdditionalDevices(ids []USBID) {
+	additionalDevices.Lock()
+	defer additionalDevices.Unlock()
+	additionalDevices.ids = ids
+}
+
+func isAdditionalDevice(device enumerator.Device) bool {
+	additionalDevices.Lock()
+	defer additionalDevices.Unlock()
+	for _, id := range additionalDevices.ids {
+		if flexdevice.MatchUSB(id.VID, id.PID)(device) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListDevices returns the serial devices connected to this machine that look like Flex devices
+func ListDevices() ([]enumerator.Device, error) {
+	devices, err := enumerator.Default.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	flexLike := []enumerator.Device{}
+	for _, device := range devices {
+		if isFlexLike(device) {
+			flexLike = append(flexLike, device)
+		}
+	}
+	return flexLike, nil
+}
@@ -0,0 +1,25 @@
+package flex
+
+import (
+	"testing"
+
+	flexdevice "github.com/dividat/driver/src/dividat-driver/flex/device"
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+)
+
+func TestSetAdditionalDevices(t *testing.T) {
+	defer SetAdditionalDevices(nil)
+	rebadged := enumerator.Device{VID: 0x1209, PID: 0xF1E8}
+
+	if _, _, ok := flexdevice.For(rebadged); ok {
+		t.Fatal("Expected unknown device not to be driven")
+	}
+	SetAdditionalDevices([]USBID{{VID: 0x1209}})
+	if name, _, ok := flexdevice.For(rebadged); !ok || name != sensingTexHandler {
+		t.Errorf("Expected additional device to be driven by %s, got %s", sensingTexHandler, name)
+	}
+	SetAdditionalDevices([]USBID{{VID: 0x1209, PID: 0x0001}})
+	if _, _, ok := flexdevice.For(rebadged); ok {
+		t.Error("Expected device of another product not to be driven")
+	}
+}
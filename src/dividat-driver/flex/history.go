@@ -0,0 +1,50 @@
+package flex
+
+import (
+	"sync"
+	"time"
+)
+
+// How long received sets are kept for clients to backfill gaps in their data
+const historyRetention = 10 * time.Second
+
+// Bound on the sets kept, regardless of the rate at which the device sends
+const historyCapacity = 2048
+
+// Ring buffer of the sets received most recently
+type history struct {
+	mutex sync.Mutex
+	sets  []measurementSet
+	// Index of the oldest set once the buffer is full
+	next int
+}
+
+func (h *history) add(set measurementSet) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if len(h.sets) < historyCapacity {
+		h.sets = append(h.sets, set)
+		return
+	}
+	h.sets[h.next] = set
+	h.next = (h.next + 1) % historyCapacity
+}
+
+// since returns the retained sets received after the given time, oldest first
+func (h *history) since(t time.Time) []measurementSet {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if limit := time.Now().Add(-historyRetention); t.Before(limit) {
+		t = limit
+	}
+
+	recent := []measurementSet{}
+	for i := range h.sets {
+		set := h.sets[(h.next+i)%len(h.sets)]
+		if set.receivedAt.After(t) {
+			recent = append(recent, set)
+		}
+	}
+	return recent
+}
@@ -0,0 +1,107 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package hotplug
+
+// #cgo LDFLAGS: -framework CoreFoundation -framework IOKit
+// #include <IOKit/IOKitLib.h>
+// #include <IOKit/serial/IOSerialKeys.h>
+// #include <CoreFoundation/CoreFoundation.h>
+//
+// extern void hotplugChanged(uintptr_t subscription);
+//
+// // Iterators must be emptied to arm the notification again
+// static void drain(io_iterator_t iterator) {
+// 	io_object_t service;
+// 	while ((service = IOIteratorNext(iterator))) {
+// 		IOObjectRelease(service);
+// 	}
+// }
+//
+// static void onServices(void *subscription, io_iterator_t iterator) {
+// 	drain(iterator);
+// 	hotplugChanged((uintptr_t)subscription);
+// }
+//
+// static void unsubscribe(IONotificationPortRef port, io_iterator_t *iterators) {
+// 	for (int i = 0; i < 2; i++) {
+// 		if (iterators[i]) {
+// 			IOObjectRelease(iterators[i]);
+// 		}
+// 	}
+// 	IONotificationPortDestroy(port);
+// }
+//
+// // Notify about serial devices being published or terminated, on the run
+// // loop of the calling thread
+// static kern_return_t subscribe(uintptr_t subscription, IONotificationPortRef *port, io_iterator_t *iterators) {
+// 	*port = IONotificationPortCreate(MACH_PORT_NULL);
+// 	if (*port == NULL) {
+// 		return KERN_FAILURE;
+// 	}
+// 	CFRunLoopAddSource(CFRunLoopGetCurrent(), IONotificationPortGetRunLoopSource(*port), kCFRunLoopDefaultMode);
+//
+// 	iterators[0] = 0;
+// 	iterators[1] = 0;
+// 	const char *types[2] = {kIOFirstMatchNotification, kIOTerminatedNotification};
+// 	for (int i = 0; i < 2; i++) {
+// 		// Consumed by the registration
+// 		CFMutableDictionaryRef matching = IOServiceMatching(kIOSerialBSDServiceValue);
+// 		kern_return_t result = IOServiceAddMatchingNotification(*port, types[i], matching, onServices, (void *)subscription, &iterators[i]);
+// 		if (result != KERN_SUCCESS) {
+// 			unsubscribe(*port, iterators);
+// 			return result;
+// 		}
+// 		drain(iterators[i]);
+// 	}
+// 	return KERN_SUCCESS;
+// }
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// How long the run loop runs before checking for cancellation
+const runTimeout = 1.0
+
+//export hotplugChanged
+func hotplugChanged(subscription C.uintptr_t) {
+	dispatch(uintptr(subscription))
+}
+
+// Subscribe to serial devices being attached or detached via IOKit
+// notifications
+func Subscribe(ctx context.Context) (<-chan struct{}, error) {
+	events := make(chan struct{}, 1)
+	id := register(events)
+
+	ready := make(chan error, 1)
+	go func() {
+		// Notifications are delivered on the run loop of the registering thread
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		defer unregister(id)
+
+		var port C.IONotificationPortRef
+		var iterators [2]C.io_iterator_t
+		result := C.subscribe(C.uintptr_t(id), &port, &iterators[0])
+		if result != C.KERN_SUCCESS {
+			ready <- fmt.Errorf("could not register for IOKit notifications, error %#x", int(result))
+			return
+		}
+		ready <- nil
+
+		for ctx.Err() == nil {
+			C.CFRunLoopRunInMode(C.kCFRunLoopDefaultMode, runTimeout, 0)
+		}
+		C.unsubscribe(port, &iterators[0])
+	}()
+
+	if err := <-ready; err != nil {
+		return nil, err
+	}
+	return coalesce(ctx, events), nil
+}
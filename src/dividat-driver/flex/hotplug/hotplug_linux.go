@@ -0,0 +1,79 @@
+package hotplug
+
+import (
+	"bytes"
+	"context"
+	"syscall"
+	"time"
+)
+
+// Netlink multicast groups for device events, as sent by the kernel and
+// after processing by udev. Once udev has processed an event, the device node
+// is ready to be opened. Listening to kernel events as well covers systems
+// without udev daemon.
+const (
+	kernelEvents = 1
+	udevEvents   = 2
+)
+
+// How long a read on the netlink socket may block before checking for cancellation
+const readTimeout = 1 * time.Second
+
+// Subscribe to additions and removals of serial devices via netlink uevents
+func Subscribe(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: kernelEvents | udevEvents,
+	})
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	timeout := syscall.NsecToTimeval(readTimeout.Nanoseconds())
+	err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	events := make(chan struct{}, 1)
+
+	// Read events
+	go func() {
+		defer syscall.Close(fd)
+		buffer := make([]byte, 8192)
+		for ctx.Err() == nil {
+			n, _, err := syscall.Recvfrom(fd, buffer, 0)
+			if err != nil {
+				continue
+			}
+			if isSerialDeviceEvent(buffer[:n]) {
+				notify(events)
+			}
+		}
+	}()
+
+	return coalesce(ctx, events), nil
+}
+
+// Both kernel and udev messages carry the event's properties as
+// NUL-terminated KEY=VALUE strings.
+func isSerialDeviceEvent(message []byte) bool {
+	isTTY := false
+	isAddOrRemove := false
+	for _, field := range bytes.Split(message, []byte{0}) {
+		switch string(field) {
+		case "SUBSYSTEM=tty":
+			isTTY = true
+		case "ACTION=add", "ACTION=remove":
+			isAddOrRemove = true
+		}
+	}
+	return isTTY && isAddOrRemove
+}
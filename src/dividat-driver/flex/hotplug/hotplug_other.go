@@ -0,0 +1,15 @@
+//go:build !linux && !windows && !(darwin && cgo)
+// +build !linux
+// +build !windows
+// +build !darwin !cgo
+
+package hotplug
+
+import (
+	"context"
+)
+
+// Subscribe is not supported on this platform, use periodic scanning instead
+func Subscribe(ctx context.Context) (<-chan struct{}, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,101 @@
+package hotplug
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	cfgmgr32                     = syscall.NewLazyDLL("cfgmgr32.dll")
+	procCMRegisterNotification   = cfgmgr32.NewProc("CM_Register_Notification")
+	procCMUnregisterNotification = cfgmgr32.NewProc("CM_Unregister_Notification")
+)
+
+// Serial ports, including USB CDC devices like Flex controllers, register
+// this device interface class
+var comPortInterface = syscall.GUID{
+	Data1: 0x86E0D1E0,
+	Data2: 0x8089,
+	Data3: 0x11D0,
+	Data4: [8]byte{0x9C, 0xE4, 0x08, 0x00, 0x3E, 0x30, 0x1F, 0x73},
+}
+
+const (
+	cmNotifyFilterTypeDeviceInterface = 0
+
+	cmNotifyActionDeviceInterfaceArrival = 0
+	cmNotifyActionDeviceInterfaceRemoval = 1
+
+	crSuccess = 0
+)
+
+// CM_NOTIFY_FILTER, the union is sized by its largest member, an instance ID
+// of 200 UTF-16 characters
+type cmNotifyFilter struct {
+	size       uint32
+	flags      uint32
+	filterType uint32
+	reserved   uint32
+	classGUID  syscall.GUID
+	_          [400 - unsafe.Sizeof(syscall.GUID{})]byte
+}
+
+// Callbacks can not be released, so all subscriptions share one, dispatching
+// by the context passed at registration
+var (
+	callbackOnce sync.Once
+	callback     uintptr
+)
+
+func onNotification(notification uintptr, context uintptr, action uintptr, eventData uintptr, eventDataSize uintptr) uintptr {
+	if action != cmNotifyActionDeviceInterfaceArrival && action != cmNotifyActionDeviceInterfaceRemoval {
+		return 0
+	}
+	dispatch(context)
+	return 0
+}
+
+// Subscribe to arrivals and removals of serial ports via Configuration
+// Manager notifications (Windows 8 and later), so that ports are only listed
+// when they change
+func Subscribe(ctx context.Context) (<-chan struct{}, error) {
+	if procCMRegisterNotification.Find() != nil || procCMUnregisterNotification.Find() != nil {
+		return nil, ErrUnsupported
+	}
+	callbackOnce.Do(func() {
+		callback = syscall.NewCallback(onNotification)
+	})
+
+	events := make(chan struct{}, 1)
+	id := register(events)
+
+	filter := cmNotifyFilter{
+		filterType: cmNotifyFilterTypeDeviceInterface,
+		classGUID:  comPortInterface,
+	}
+	filter.size = uint32(unsafe.Sizeof(filter))
+
+	var notification uintptr
+	result, _, _ := procCMRegisterNotification.Call(
+		uintptr(unsafe.Pointer(&filter)),
+		id,
+		callback,
+		uintptr(unsafe.Pointer(&notification)),
+	)
+	if result != crSuccess {
+		unregister(id)
+		return nil, fmt.Errorf("could not register for device notifications, CONFIGRET %d", result)
+	}
+
+	go func() {
+		<-ctx.Done()
+		// Blocks until running callbacks have returned
+		procCMUnregisterNotification.Call(notification)
+		unregister(id)
+	}()
+
+	return coalesce(ctx, events), nil
+}
@@ -0,0 +1,82 @@
+package hotplug
+
+/* Notifications about serial devices being plugged in or removed.
+
+Listing serial devices is slow on some platforms and may interfere with other
+software using serial ports. Where the platform offers notifications about
+device changes, scans for Flex devices are only triggered by them.
+
+*/
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrUnsupported is returned if the platform offers no notifications
+var ErrUnsupported = errors.New("hotplug notifications are not supported on this platform")
+
+// Devices may take a moment to be ready for use after the first notification,
+// further notifications within this time are coalesced.
+const settleTime = 500 * time.Millisecond
+
+// Send a notification without blocking, a pending notification covers subsequent changes
+func notify(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
+
+// Coalesce events, notifying once devices have settled
+func coalesce(ctx context.Context, events <-chan struct{}) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-events:
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(settleTime):
+					notify(changes)
+				}
+			}
+		}
+	}()
+	return changes
+}
+
+// Subscriptions by ID, for platform callbacks that can only pass an integer
+var (
+	subscriptionsMutex sync.Mutex
+	subscriptions      = map[uintptr]chan<- struct{}{}
+	lastSubscription   uintptr
+)
+
+func register(events chan<- struct{}) uintptr {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+	lastSubscription++
+	subscriptions[lastSubscription] = events
+	return lastSubscription
+}
+
+func unregister(id uintptr) {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+	delete(subscriptions, id)
+}
+
+// Notify the subscription with the ID, if it still exists
+func dispatch(id uintptr) {
+	subscriptionsMutex.Lock()
+	defer subscriptionsMutex.Unlock()
+	if events, ok := subscriptions[id]; ok {
+		notify(events)
+	}
+}
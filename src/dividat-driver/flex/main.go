@@ -14,36 +14,127 @@ The functionality of this module is as follows:
 */
 
 import (
-	"bufio"
 	"context"
-	"encoding/binary"
+	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/cskr/pubsub"
+	"github.com/cenkalti/backoff"
 	"github.com/sirupsen/logrus"
 	"go.bug.st/serial"
-	"go.bug.st/serial/enumerator"
+
+	"github.com/dividat/driver/src/dividat-driver/broker"
+	"github.com/dividat/driver/src/dividat-driver/catalog"
+	"github.com/dividat/driver/src/dividat-driver/clock"
+	"github.com/dividat/driver/src/dividat-driver/confirm"
+	"github.com/dividat/driver/src/dividat-driver/drops"
+	"github.com/dividat/driver/src/dividat-driver/firmware"
+	flexdevice "github.com/dividat/driver/src/dividat-driver/flex/device"
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+	"github.com/dividat/driver/src/dividat-driver/flex/hotplug"
+	"github.com/dividat/driver/src/dividat-driver/hooks"
+	"github.com/dividat/driver/src/dividat-driver/latency"
+	"github.com/dividat/driver/src/dividat-driver/logging"
+	"github.com/dividat/driver/src/dividat-driver/pairing"
+	"github.com/dividat/driver/src/dividat-driver/sessions"
 )
 
 // Handle for managing SensingTex connection
 type Handle struct {
-	broker *pubsub.PubSub
+	broker *broker.Broker
 
 	ctx context.Context
 
+	// Guards cancelCurrentConnection and subscriberCount, which firmware
+	// updates and power cycles change from their own goroutine
+	connectionMutex         *sync.Mutex
 	cancelCurrentConnection context.CancelFunc
 	subscriberCount         int
 
+	// Lists serial devices, may be substituted for testing
+	enumerator enumerator.Enumerator
+
+	// Currently connected controller, nil if none
+	device      *DeviceInfo
+	deviceMutex *sync.Mutex
+
+	// Path or serial number of the device selected by a client, any device if empty
+	selectedAddress string
+
+	// Sample format selected by a client, used if the device supports it
+	format sampleFormat
+
+	firmwareUpdate *firmware.Update
+
+	// Counts sets read from devices
+	frameCheck *frameCheck
+
+	// Timestamps sets, referenced to the system clock when the first client connects
+	clock *clock.Clock
+
+	// Logical devices by mat index, a single one unless the controller
+	// multiplexes several mats
+	mats []*mat
+
+	// Dead cells of mats, persisted if set
+	masks *MaskStore
+
+	// Serial ports held by other programs
+	portsInUse *portsInUse
+
+	// Paired devices, nil if pairing is not required
+	pairing        *pairing.Store
+	pendingPairing *pairing.Pending
+
+	// Callbacks observing WebSocket traffic, set before serving clients
+	Hooks hooks.Hooks
+
+	// Connected clients, firmware updates must not disrupt them
+	sessions   *sessions.Registry
+	busyPolicy sessions.Policy
+
+	// Destructive commands awaiting confirmation by their client
+	confirmations *confirm.Pending
+
+	// Hex dumps of sets read on request of support, nil if not available
+	frameTrace *logging.FrameTrace
+
+	// Whether clients are warned about deprecated behaviors
+	strict bool
+
+	// Called with "connected" or "disconnected" when a controller connects or
+	// is lost, nil if not watched
+	onState func(state string)
+
+	// Commands of clients, taken while a device is connected
+	commands chan clientCommand
+
 	log *logrus.Entry
 }
 
 // New returns an initialized handler
-func New(ctx context.Context, log *logrus.Entry) *Handle {
+func New(ctx context.Context, log *logrus.Entry, pairingStore *pairing.Store) *Handle {
 	handle := Handle{
-		broker: pubsub.New(32),
-		ctx:    ctx,
-		log:    log,
+		broker:          broker.New(32),
+		ctx:             ctx,
+		enumerator:      enumerator.Default,
+		deviceMutex:     &sync.Mutex{},
+		connectionMutex: &sync.Mutex{},
+		format:          defaultSampleFormat,
+		firmwareUpdate:  firmware.InitialUpdateState(),
+		frameCheck:      &frameCheck{polls: latency.NewWindow(pollWindow)},
+		clock:           clock.New(),
+		mats:            newMats(),
+		portsInUse:      &portsInUse{},
+		sessions:        sessions.NewRegistry(),
+		busyPolicy:      sessions.Refuse,
+		confirmations:   confirm.New(),
+		pairing:         pairingStore,
+		pendingPairing:  &pairing.Pending{},
+		commands:        make(chan clientCommand),
+		log:             log,
 	}
 
 	// Clean up
@@ -57,232 +148,753 @@ func New(ctx context.Context, log *logrus.Entry) *Handle {
 
 // Connect to device
 func (handle *Handle) Connect() {
+	handle.connectionMutex.Lock()
+	defer handle.connectionMutex.Unlock()
+
 	handle.subscriberCount++
+	if handle.subscriberCount == 1 {
+		handle.clock.Reset()
+	}
+
+	// If there is no existing connection, create it. During a firmware update
+	// scanning is resumed once done.
+	if handle.cancelCurrentConnection == nil && !handle.firmwareUpdate.IsUpdating() {
+		handle.startListening()
+	}
+}
+
+// SelectDevice restricts connections to the device with given path (e.g.
+// /dev/ttyACM0) or USB serial number, which unlike paths does not change
+// across reboots. An empty address allows any device again.
+func (handle *Handle) SelectDevice(address string) {
+	handle.deviceMutex.Lock()
+	handle.selectedAddress = address
+	handle.deviceMutex.Unlock()
+
+	handle.log.WithField("address", address).Info("Selected Flex device.")
+
+	handle.reconnect()
+}
+
+// SelectBitdepth configures devices to send samples with the given bitdepth,
+// which must be one of sampleFormats. Devices not supporting it keep using the
+// default bitdepth.
+func (handle *Handle) SelectBitdepth(bitdepth int) {
+	handle.deviceMutex.Lock()
+	handle.format = sampleFormats[bitdepth]
+	handle.deviceMutex.Unlock()
+
+	handle.log.WithField("bitdepth", bitdepth).Info("Selected Flex bitdepth.")
+
+	handle.reconnect()
+}
+
+// Reconnect with current settings if already connected
+func (handle *Handle) reconnect() {
+	handle.connectionMutex.Lock()
+	defer handle.connectionMutex.Unlock()
+
+	if handle.cancelCurrentConnection != nil && !handle.firmwareUpdate.IsUpdating() {
+		handle.cancelCurrentConnection()
+		handle.setDevice(nil)
+		handle.startListening()
+	}
+}
 
-	// If there is no existing connection, create it
-	if handle.cancelCurrentConnection == nil {
-		ctx, cancel := context.WithCancel(handle.ctx)
+// Must be called with connectionMutex held
+func (handle *Handle) startListening() {
+	ctx, cancel := context.WithCancel(handle.ctx)
 
-		onReceive := func(data []byte) {
-			handle.broker.TryPub(data, "flex-rx")
+	onReceive := func(data []byte, format sampleFormat, index int) {
+		// Hold back data from devices that have not been confirmed by an operator
+		if handle.pendingPairing.Device() != nil {
+			return
+		}
+		if index == untaggedMat {
+			handle.frameTrace.Record("flex", data)
+		} else {
+			handle.frameTrace.Record("flex/mat"+strconv.Itoa(index), data)
 		}
+		m := handle.mat(index)
+		set := measurementSet{samples: data, receivedAt: handle.clock.Now(), format: format, sequence: m.rxDrops.Publish()}
+		if jump, ok := handle.clock.Check(); ok {
+			handle.log.WithField("offset", jump.Offset).Warn("System clock jumped, timestamps follow the monotonic clock until all clients disconnect.")
+			handle.Broadcast(Message{ClockJump: &jump})
+		}
+		m.matrix.observe(set)
+		m.calibration.observe(set)
+		m.detection.observe(set)
+		set = m.calibration.apply(set)
+		set = m.mask.apply(set)
+		m.history.add(set)
+		handle.broker.TryPub(set, m.dataTopic())
+	}
 
-		go listeningLoop(ctx, handle.log, handle.broker.Sub("flex-tx"), onReceive)
+	handle.deviceMutex.Lock()
+	devices := enumerator.Filtered{Base: handle.enumerator, Address: handle.selectedAddress}
+	format := handle.format
+	handle.deviceMutex.Unlock()
 
-		handle.cancelCurrentConnection = cancel
+	// Ignore a loop that is still winding down after being replaced. Devices
+	// whose port vanished are given a moment to re-enumerate before they are
+	// reported lost.
+	var reenumerating reenumeration
+	onDevice := func(device *DeviceInfo) {
+		if ctx.Err() != nil {
+			return
+		}
+		if device == nil {
+			current := handle.Device()
+			if current != nil && current.SerialNumber != "" && vanished(devices, current.Path) {
+				interruption := StreamInterruption{SerialNumber: current.SerialNumber, Path: current.Path}
+				handle.log.WithField("name", current.Path).Info("Serial port vanished, waiting for device to re-enumerate.")
+				handle.Broadcast(Message{StreamInterrupted: &interruption})
+				reenumerating.expect(current.SerialNumber, func() {
+					if ctx.Err() != nil {
+						return
+					}
+					handle.log.WithField("name", current.Path).Info("Device did not re-enumerate, disconnected.")
+					handle.setDevice(nil)
+				})
+				return
+			}
+		} else if device.Mat == nil && reenumerating.resume(device.SerialNumber) {
+			interruption := StreamInterruption{SerialNumber: device.SerialNumber, Path: device.Path}
+			handle.log.WithField("name", device.Path).Info("Device re-enumerated, resuming stream.")
+			handle.Broadcast(Message{StreamResumed: &interruption})
+		}
+		handle.setDevice(device)
+	}
+
+	go listeningLoop(ctx, handle.log, devices, handle.frameCheck, handle.commands, format, onReceive, onDevice, handle.onDeviceMessage)
+
+	handle.cancelCurrentConnection = cancel
+}
+
+// RestrictDevices limits the devices used to the given serial numbers (all if
+// empty) and never uses the excluded ones. Must be called before clients connect.
+func (handle *Handle) RestrictDevices(include []string, exclude []string) {
+	handle.enumerator = enumerator.Filtered{
+		Base:    handle.enumerator,
+		Include: include,
+		Exclude: exclude,
 	}
 }
 
+// UseMasks loads and persists masks of dead cells in the given store. Must be
+// called before clients connect.
+func (handle *Handle) UseMasks(store *MaskStore) {
+	handle.masks = store
+}
+
+// SetBusyPolicy decides whether firmware updates requested while other clients
+// stream from the device are refused or queued. Must be called before clients connect.
+func (handle *Handle) SetBusyPolicy(policy sessions.Policy) {
+	handle.busyPolicy = policy
+}
+
+// ValidateFrames drops sets that are implausible instead of forwarding them.
+// Must be called before clients connect.
+func (handle *Handle) ValidateFrames() {
+	handle.frameCheck.validate = true
+}
+
+// WatchData restarts acquisition if a device sends no sets for the given
+// duration, and reopens the port if restarting does not help. Must be called
+// before clients connect.
+func (handle *Handle) WatchData(timeout time.Duration) {
+	handle.frameCheck.dataTimeout = timeout
+}
+
+// TraceFramesTo enables the TraceFrames command, writing traces to dir. Must be
+// called before clients connect.
+func (handle *Handle) TraceFramesTo(dir string) {
+	handle.frameTrace = logging.NewFrameTrace(dir)
+}
+
+// WarnDeprecations makes clients be warned about deprecated behaviors they rely
+// on. Must be called before clients connect.
+func (handle *Handle) WarnDeprecations() {
+	handle.strict = true
+}
+
+// States reported to watchers
+const (
+	connected    = "connected"
+	disconnected = "disconnected"
+)
+
+// WatchState calls onState with "connected" or "disconnected" whenever a
+// controller connects or is lost. Must be called before clients connect.
+func (handle *Handle) WatchState(onState func(state string)) {
+	handle.onState = onState
+	onState(disconnected)
+}
+
+// PollAt limits the rate at which devices that do not stream are polled, unless
+// their profile sets a rate. Zero polls as soon as a set is complete. Must be
+// called before clients connect.
+func (handle *Handle) PollAt(rate float64) {
+	handle.frameCheck.pollRate = rate
+}
+
+// Drops returns statistics of sets missed by clients, of the first mat and
+// further mats sets have been received from
+func (handle *Handle) Drops() []drops.Snapshot {
+	snapshots := []drops.Snapshot{}
+	for _, m := range handle.mats {
+		snapshot := m.rxDrops.Snapshot()
+		if m.index == 0 || snapshot.Published > 0 {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots
+}
+
+// FrameStats returns counters of sets read from devices
+func (handle *Handle) FrameStats() FrameStats {
+	return handle.frameCheck.stats()
+}
+
+// Device returns information on the connected controller, nil if not connected
+func (handle *Handle) Device() *DeviceInfo {
+	handle.deviceMutex.Lock()
+	defer handle.deviceMutex.Unlock()
+	return handle.device
+}
+
+// SelectedAddress returns the path or serial number of the selected device, empty if any device may be used
+func (handle *Handle) SelectedAddress() string {
+	handle.deviceMutex.Lock()
+	defer handle.deviceMutex.Unlock()
+	return handle.selectedAddress
+}
+
+// Set the connected controller, or the logical device of one of its mats
+func (handle *Handle) setDevice(device *DeviceInfo) {
+	if device != nil && device.Mat == nil {
+		handle.portsInUse.set(device.Path, false)
+	}
+
+	if device != nil && device.Mat != nil {
+		handle.setMatDevice(handle.mats[*device.Mat], device)
+		return
+	}
+
+	handle.deviceMutex.Lock()
+	handle.device = device
+	handle.deviceMutex.Unlock()
+
+	if handle.onState != nil {
+		if device == nil {
+			handle.onState(disconnected)
+		} else {
+			handle.onState(connected)
+		}
+	}
+
+	// Sets go to the first mat until the controller tags them
+	for _, m := range handle.mats {
+		if m.index == 0 {
+			handle.setMatDevice(m, device)
+		} else {
+			handle.setMatDevice(m, nil)
+		}
+	}
+
+	if device == nil {
+		handle.pendingPairing.Set(nil)
+		return
+	}
+
+	// Controllers are paired as a whole, with all their mats
+	id := device.pairingID()
+	if handle.pairing != nil && !handle.pairing.IsPaired(id) {
+		handle.log.WithField("device", id).Info("Waiting for confirmation to pair with Flex device.")
+		handle.pendingPairing.Set(&id)
+		handle.Broadcast(Message{PairingRequired: &id})
+	}
+}
+
+// Assign the logical device of a mat and load its dead cells
+func (handle *Handle) setMatDevice(m *mat, device *DeviceInfo) {
+	m.setDevice(device)
+	if device == nil || handle.masks == nil {
+		m.mask.set(Mask{})
+		return
+	}
+
+	id := device.pairingID()
+	mask := handle.masks.get(id)
+	m.mask.set(mask)
+	if len(mask.Cells) > 0 {
+		handle.log.WithField("device", id).WithField("cells", len(mask.Cells)).Info("Masking dead cells of Flex device.")
+	}
+}
+
+// Calibrate collects sets of the idle mat with the given index for the given
+// duration and then subtracts the mean value of each cell from all following
+// sets. Clients of the mat are informed when done.
+func (handle *Handle) Calibrate(index int, duration time.Duration) {
+	m := handle.mats[index]
+	if duration <= 0 {
+		duration = defaultCalibrationDuration
+	}
+	if duration > maxCalibrationDuration {
+		duration = maxCalibrationDuration
+	}
+
+	handle.log.WithField("mat", index).WithField("duration", duration).Info("Calibrating Flex baselines.")
+	m.calibration.start()
+
+	time.AfterFunc(duration, func() {
+		state := m.calibration.finish()
+		handle.log.WithField("mat", index).WithField("cells", state.Cells).WithField("sets", state.Sets).Info("Calibrated Flex baselines.")
+		handle.broadcastMat(m, Message{Calibration: &state})
+	})
+}
+
+// ClearCalibration forwards sets of the mat without subtracting baselines again
+func (handle *Handle) ClearCalibration(index int) {
+	m := handle.mats[index]
+	m.calibration.clear()
+	handle.log.WithField("mat", index).Info("Cleared Flex baselines.")
+	handle.broadcastMat(m, Message{Calibration: &CalibrationState{}})
+}
+
+// SetMask replaces the dead cells of the mat, an empty mask forwards all
+// cells again. Masks are persisted if a store is used.
+func (handle *Handle) SetMask(index int, mask Mask) {
+	m := handle.mats[index]
+	m.mask.set(mask)
+
+	device := m.getDevice()
+	if device != nil && handle.masks != nil {
+		err := handle.masks.set(device.pairingID(), mask)
+		if err != nil {
+			handle.log.WithError(err).Error("Could not persist dead cells.")
+		}
+	}
+
+	handle.log.WithField("mat", index).WithField("cells", len(mask.Cells)).Info("Updated dead cells of Flex device.")
+	handle.broadcastMat(m, Message{DeadCells: &mask})
+}
+
+// GetMask returns the dead cells of the mat
+func (handle *Handle) GetMask(index int) Mask {
+	return handle.mats[index].mask.get()
+}
+
+// DetectDeadCells collects sets of the idle mat for the given duration and
+// adds cells reporting values in most of them to its mask.
+func (handle *Handle) DetectDeadCells(index int, duration time.Duration) {
+	m := handle.mats[index]
+	if duration <= 0 {
+		duration = defaultDetectionDuration
+	}
+	if duration > maxCalibrationDuration {
+		duration = maxCalibrationDuration
+	}
+
+	handle.log.WithField("mat", index).WithField("duration", duration).Info("Detecting dead cells of Flex device.")
+	m.detection.start()
+
+	time.AfterFunc(duration, func() {
+		mask := m.mask.get()
+		known := map[uint16]bool{}
+		for _, cell := range mask.Cells {
+			known[cell.key()] = true
+		}
+		for _, cell := range m.detection.finish() {
+			if !known[cell.key()] {
+				mask.Cells = append(mask.Cells, cell)
+			}
+		}
+		handle.SetMask(index, mask)
+	})
+}
+
+// ConfirmPairing remembers the device awaiting confirmation and starts
+// forwarding its data, if it is the given device announced in PairingRequired
+func (handle *Handle) ConfirmPairing(pending string) {
+	device := handle.pendingPairing.Device()
+	if device == nil || *device != pending {
+		handle.log.WithField("device", pending).Warn("Refusing to pair with Flex device not awaiting confirmation.")
+		return
+	}
+
+	err := handle.pairing.Pair(*device)
+	if err != nil {
+		handle.log.WithError(err).Error("Could not persist paired device.")
+	}
+
+	handle.log.WithField("device", *device).Info("Paired with Flex device.")
+	handle.pendingPairing.Set(nil)
+	handle.Broadcast(Message{Paired: device})
+}
+
+// Forward messages about devices to clients. Ports in use are only reported
+// once, as they are tried on every scan.
+func (handle *Handle) onDeviceMessage(message Message) {
+	if message.PortInUse != nil {
+		if !handle.portsInUse.set(*message.PortInUse, true) {
+			return
+		}
+		handle.log.WithField("name", *message.PortInUse).Warn("Serial port is in use by another program, not connecting.")
+	}
+	handle.Broadcast(message)
+}
+
+// Broadcast sends a message to all connected clients
+func (handle *Handle) Broadcast(message Message) {
+	// Unlike sets, broadcasts are never dropped. Publishing waits for slow
+	// clients, whose writes time out.
+	handle.broker.Pub(message, "flex-broadcast")
+}
+
+// Send a message to the clients of a mat
+func (handle *Handle) broadcastMat(m *mat, message Message) {
+	handle.broker.Pub(message, m.broadcastTopic())
+}
+
 // Deregister subscribers and disconnect when none left
 func (handle *Handle) DeregisterSubscriber() {
+	handle.connectionMutex.Lock()
 	handle.subscriberCount--
-
-	if handle.subscriberCount == 0 && handle.cancelCurrentConnection != nil {
-		handle.cancelCurrentConnection()
+	var cancel context.CancelFunc
+	if handle.subscriberCount == 0 {
+		cancel = handle.cancelCurrentConnection
 		handle.cancelCurrentConnection = nil
 	}
+	handle.connectionMutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+		handle.setDevice(nil)
+	}
+}
+
+// Disconnect from the current device, e.g. to free its serial port. Returns
+// whether a connection was stopped.
+func (handle *Handle) stopListening() bool {
+	handle.connectionMutex.Lock()
+	cancel := handle.cancelCurrentConnection
+	handle.cancelCurrentConnection = nil
+	handle.connectionMutex.Unlock()
+
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	handle.setDevice(nil)
+	return true
+}
+
+// Resume looking for devices if clients are connected
+func (handle *Handle) resumeListening() {
+	handle.connectionMutex.Lock()
+	defer handle.connectionMutex.Unlock()
+
+	if handle.subscriberCount > 0 && handle.cancelCurrentConnection == nil {
+		handle.startListening()
+	}
+}
+
+// Default interval of scans for devices while hotplug events are unavailable
+const defaultScanInterval = 2 * time.Second
+
+// Interval of scans in case a hotplug event was missed
+const hotplugRescanInterval = 30 * time.Second
+
+// Interval of scans for devices across all handles, the default if zero
+var scanInterval = struct {
+	sync.Mutex
+	interval time.Duration
+}{}
+
+// SetScanInterval sets how often devices are scanned for while hotplug events
+// are unavailable, the default if zero. Takes effect after the current wait.
+func SetScanInterval(interval time.Duration) {
+	scanInterval.Lock()
+	defer scanInterval.Unlock()
+	scanInterval.interval = interval
+}
+
+// How often devices are scanned for while hotplug events are unavailable
+func currentScanInterval() time.Duration {
+	scanInterval.Lock()
+	defer scanInterval.Unlock()
+	if scanInterval.interval == 0 {
+		return defaultScanInterval
+	}
+	return scanInterval.interval
 }
 
 // Keep looking for serial devices and connect to them when found, sending signals into the
 // callback.
-func listeningLoop(ctx context.Context, logger *logrus.Entry, tx chan interface{}, onReceive func([]byte)) {
+func listeningLoop(ctx context.Context, logger *logrus.Entry, devices enumerator.Enumerator, check *frameCheck, commands <-chan clientCommand, format sampleFormat, onReceive func([]byte, sampleFormat, int), onDevice func(*DeviceInfo), onMessage func(Message)) {
+	// Hotplug events only reflect real devices, mock devices are picked up by polling
+	var changes <-chan struct{}
+	if enumerator.ListsSystemDevices(devices) {
+		var err error
+		changes, err = hotplug.Subscribe(ctx)
+		if err != nil {
+			logger.WithField("error", err).Debug("Hotplug detection unavailable, polling for serial devices.")
+		}
+	}
+
 	for {
-		scanAndConnectSerial(ctx, logger, tx, onReceive)
+		lost := scanAndConnectSerial(ctx, logger, devices, check, commands, format, onReceive, onDevice, onMessage)
+		if lost != nil {
+			reconnectSerial(ctx, logger, devices, *lost, check, commands, format, onReceive, onDevice, onMessage)
+		}
 
 		// Terminate if we were cancelled
 		if ctx.Err() != nil {
 			return
 		}
 
-		time.Sleep(2 * time.Second)
+		if changes == nil {
+			clock.Default.Sleep(currentScanInterval())
+			continue
+		}
+
+		// Wait for a device to be added or removed, rescanning occasionally in case an event was missed
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+		case <-clock.Default.After(hotplugRescanInterval):
+		}
 	}
 }
 
 // One pass of browsing for serial devices and trying to connect to them turn by turn, first
-// successful connection wins.
-func scanAndConnectSerial(ctx context.Context, logger *logrus.Entry, tx chan interface{}, onReceive func([]byte)) {
-	ports, err := enumerator.GetDetailedPortsList()
+// successful connection wins. Returns the device last connected to, nil if none.
+func scanAndConnectSerial(ctx context.Context, logger *logrus.Entry, devices enumerator.Enumerator, check *frameCheck, commands <-chan clientCommand, format sampleFormat, onReceive func([]byte, sampleFormat, int), onDevice func(*DeviceInfo), onMessage func(Message)) *enumerator.Device {
+	ports, err := devices.ListDevices()
 	if err != nil {
 		logger.WithField("error", err).Info("Could not list serial devices.")
-		return
+		return nil
 	}
 
+	var lost *enumerator.Device
 	for _, port := range ports {
 		// Terminate if we have been cancelled
 		if ctx.Err() != nil {
-			return
+			return nil
 		}
 
-		logger.WithField("name", port.Name).WithField("vendor", port.VID).Debug("Considering serial port.")
+		logger.WithField("name", port.Path).WithField("vendor", fmt.Sprintf("%04X", port.VID)).Debug("Considering serial port.")
 
 		if isFlexLike(port) {
-			connectSerial(ctx, logger, port.Name, tx, onReceive)
+			if connectSerial(ctx, logger, port, check, commands, format, onReceive, onDevice, onMessage) {
+				device := port
+				lost = &device
+			}
 		}
 	}
+	return lost
 }
 
-// Check whether a port looks like a potential Flex device.
-//
-// Vendor IDs:
-//
-//	16C0 - Van Ooijen Technische Informatica (Teensy)
-func isFlexLike(port *enumerator.PortDetails) bool {
-	vendorId := strings.ToUpper(port.VID)
-
-	return vendorId == "16C0"
-}
+// Connections that lasted this long were healthy, reconnecting restarts with the shortest delay
+const healthyConnection = 10 * time.Second
 
-// Serial communication
+// Try to get back to a device after the connection was lost, e.g. because of a read error. The
+// first attempt is immediate, further attempts back off exponentially with jitter. Gives up
+// once the device has been unreachable for a while, scanning takes over from there.
+func reconnectSerial(ctx context.Context, logger *logrus.Entry, devices enumerator.Enumerator, device enumerator.Device, check *frameCheck, commands <-chan clientCommand, format sampleFormat, onReceive func([]byte, sampleFormat, int), onDevice func(*DeviceInfo), onMessage func(Message)) {
+	policy := backoff.NewExponentialBackOff()
+	policy.InitialInterval = 100 * time.Millisecond
+	policy.MaxInterval = 5 * time.Second
+	policy.MaxElapsedTime = 30 * time.Second
+	policy.RandomizationFactor = 0.5
+	policy.Clock = clock.Default
 
-type ReaderState int
+	for {
+		// The path may change if the device enumerates again
+		device = currentPath(devices, device)
+
+		logger.WithField("name", device.Path).Info("Reconnecting to serial port.")
+		started := clock.Default.Now()
+		if connectSerial(ctx, logger, device, check, commands, format, onReceive, onDevice, onMessage) && clock.Default.Now().Sub(started) > healthyConnection {
+			policy.Reset()
+			continue
+		}
 
-const (
-	WAITING_FOR_HEADER ReaderState = iota
-	HEADER_START
-	HEADER_READ_LENGTH_MSB
-	WAITING_FOR_BODY
-	BODY_START
-	BODY_READ_SAMPLE
-	UNEXPECTED_BYTE
-)
+		delay := policy.NextBackOff()
+		if delay == backoff.Stop {
+			logger.WithField("name", device.Path).Info("Giving up reconnecting to serial port.")
+			return
+		}
 
-const (
-	HEADER_START_MARKER = 'N'
-	BODY_START_MARKER   = 'P'
-)
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.Default.After(delay):
+		}
+	}
+}
 
-// Actually attempt to connect to an individual serial port and pipe its signal into the callback, summarizing
-// package units into a buffer.
-func connectSerial(ctx context.Context, logger *logrus.Entry, serialName string, tx chan interface{}, onReceive func([]byte)) {
-	mode := &serial.Mode{
-		BaudRate: 115200,
-		Parity:   serial.NoParity,
-		DataBits: 8,
-		StopBits: serial.OneStopBit,
+// Device as currently listed, found by serial number
+func currentPath(devices enumerator.Enumerator, device enumerator.Device) enumerator.Device {
+	if device.SerialNumber == "" {
+		return device
+	}
+	listed, err := devices.ListDevices()
+	if err != nil {
+		return device
+	}
+	for _, candidate := range listed {
+		if candidate.SerialNumber == device.SerialNumber && isFlexLike(candidate) {
+			return candidate
+		}
 	}
+	return device
+}
+
+// Check whether a port looks like a potential Flex device, i.e. a handler is registered for it
+func isFlexLike(device enumerator.Device) bool {
+	_, _, ok := flexdevice.For(device)
+	return ok
+}
 
-	START_MEASUREMENT_CMD := []byte{'S', '\n'}
+// Lock and open the serial port of a device with its line settings. Ports
+// held by other programs are reported to onMessage.
+func openSerial(logger *logrus.Entry, device enumerator.Device, onMessage func(Message)) (serial.Port, func(), bool) {
+	serialName := device.Path
+	mode := serialModeOf(device)
 
 	logger.WithField("name", serialName).Info("Attempting to connect with serial port.")
-	port, err := serial.Open(serialName, mode)
-	if err != nil {
+	if !acquirePort() {
+		logger.WithField("name", serialName).Warn("Not opening serial port, the limit of open serial ports is reached.")
+		return nil, nil, false
+	}
+	lock, err := lockPort(serialName)
+	if err == errPortInUse {
+		releasePort()
+		onMessage(Message{PortInUse: &serialName})
+		return nil, nil, false
+	} else if err != nil {
+		releasePort()
+		logger.WithField("error", err).Info("Failed to lock serial port.")
+		return nil, nil, false
+	}
+	unlock := func() {
+		lock()
+		releasePort()
+	}
+	port, err := serial.Open(serialName, &mode)
+	if portErr, ok := err.(*serial.PortError); ok && portErr.Code() == serial.PortBusy {
+		unlock()
+		onMessage(Message{PortInUse: &serialName})
+		return nil, nil, false
+	} else if err != nil {
+		unlock()
 		logger.WithField("config", mode).WithField("error", err).Info("Failed to open connection to serial port.")
-		return
+		return nil, nil, false
+	}
+	return port, unlock, true
+}
+
+// Actually attempt to connect to an individual serial port and hand it to the handler registered for the
+// device, which pipes its signal into the callback. Text lines sent by the device are passed to onMessage.
+// Returns whether the port could be opened.
+func connectSerial(ctx context.Context, logger *logrus.Entry, device enumerator.Device, check *frameCheck, commands <-chan clientCommand, format sampleFormat, onReceive func([]byte, sampleFormat, int), onDevice func(*DeviceInfo), onMessage func(Message)) bool {
+	serialName := device.Path
+	capabilities := capabilitiesOf(device)
+	if capabilities.PollRate == 0 {
+		capabilities.PollRate = check.pollRate
+	}
+	handlerName, handler, ok := flexdevice.For(device)
+	if !ok {
+		return false
+	}
+	logger = logger.WithField("revision", capabilities.Revision).WithField("handler", handlerName)
+
+	port, unlock, ok := openSerial(logger, device, onMessage)
+	if !ok {
+		return false
 	}
 	portCtx, portCtxCancel := context.WithCancel(ctx)
 	defer func() {
 		logger.WithField("name", serialName).Info("Disconnecting from serial port.")
+		onDevice(nil)
 		port.Close()
+		unlock()
 		portCtxCancel()
 	}()
 
-	// We hardcode a bitdepth of 8 for sample acquisition.
-	// In principle this could be made configurable and left to the client.
-	// However, parsing of the byte stream requires knowing the bitdepth,
-	// so in order to assemble frame packages the driver would need to
-	// intercept client-to-device commands and configure the parser
-	// accordingly. As we don't need acquisition at other than 8 bits it
-	// seems more robust to fix the mode in the driver right now.
-	BYTES_PER_SAMPLE := 3 // Row, column and sample value of 8 bit
-	BITDEPTH_8_CMD := []byte{'U', 'L', '\n'}
-	_, err = port.Write(BITDEPTH_8_CMD)
-	if err != nil {
-		logger.WithField("error", err).Info("Failed to set bitdepth of 8.")
-		return
+	if !capabilities.SupportsBitdepth(format.bitdepth) {
+		logger.WithField("bitdepth", format.bitdepth).Info("Device does not support selected bitdepth, using default.")
+		format = defaultSampleFormat
 	}
 
-	_, err = port.Write(START_MEASUREMENT_CMD)
-	if err != nil {
-		logger.WithField("error", err).Info("Failed to write start message to serial port.")
-		return
+	check.polls.Reset()
+	deviceInfo := newDeviceInfo(device, capabilities)
+	deviceInfo.Handler = handlerName
+	deviceInfo.Bitdepth = format.bitdepth
+	deviceInfo.BytesPerSample = format.bytesPerSample
+	onDevice(&deviceInfo)
+
+	// Mats of multiplexing controllers become logical devices once their first set arrives
+	announced := map[int]bool{}
+	announce := func(index int) {
+		if index == untaggedMat || announced[index] {
+			return
+		}
+		announced[index] = true
+		matInfo := deviceInfo
+		matInfo.Mat = &index
+		logger.WithField("mat", index).Info("Controller multiplexes mats, adding logical device.")
+		onDevice(&matInfo)
 	}
 
-	reader := bufio.NewReader(port)
-	state := WAITING_FOR_HEADER
-	var samplesLeftInSet int
-	var bytesLeftInSample int
+	// Commands of clients and of the handler are written one at a time. The
+	// writer outlives the handler's context, so that the handler can leave the
+	// device idle once cancelled.
+	writerCtx, cancelWriter := context.WithCancel(context.Background())
+	defer cancelWriter()
+	writer := newCommandWriter(writerCtx, port)
 
 	// Spawn routine to forward WebSocket commands to device
-	go func() {
-		for {
-			select {
-
-			case <-portCtx.Done():
-				return
-
-			case i := <-tx:
-				data, _ := i.([]byte)
-				_, err = port.Write(data)
-				logger.WithField("bytes", data).Debug("Wrote binary command to serial out.")
-			}
-		}
-	}()
-
-	// Start signal acquisition
-	var buff []byte
-	for {
-		// Terminate if we were cancelled
-		if ctx.Err() != nil {
-			return
-		}
-
-		input, err := reader.ReadByte()
-		if err != nil {
-			return
-		}
+	go forwardCommands(portCtx, logger, commands, writer)
 
-		// Finite State Machine for parsing byte stream
-		switch {
-		case state == WAITING_FOR_HEADER && input == HEADER_START_MARKER:
-			state = HEADER_START
-		case state == HEADER_START && input == '\n':
-			state = HEADER_READ_LENGTH_MSB
-		case state == HEADER_READ_LENGTH_MSB:
-			// The number of measurements in each set may vary and is
-			// given as two consecutive bytes (big-endian).
-			msb := input
-			lsb, err := reader.ReadByte()
-			if err != nil {
+	// Reopen the port after the machine resumed from suspend, which configures
+	// and starts the device again
+	go watchSuspend(portCtx, func(suspended time.Duration) {
+		logger.WithField("suspended", suspended).Info("Resumed from suspend, reopening serial port.")
+		port.Close()
+	})
+
+	handler(portCtx, flexdevice.Conn{
+		Port:         serializedPort{ReadWriteCloser: port, writer: writer},
+		Logger:       logger,
+		Capabilities: capabilities,
+		Format:       flexdevice.Format{Bitdepth: format.bitdepth, Command: format.command, BytesPerSample: format.bytesPerSample},
+		DataTimeout:  check.dataTimeout,
+		Valid: func(set []byte, samples int) bool {
+			return check.valid(set, samples, format)
+		},
+		Received: check.countReceived,
+		Dropped:  check.countDropped,
+		Resynced: check.countResynced,
+		Answered: check.polls.Add,
+		Receive: func(set []byte, index int) {
+			if index != untaggedMat && (index < 0 || index >= maxMats) {
+				logger.WithField("mat", index).Debug("Dropped set of unknown mat.")
 				return
 			}
-			samplesLeftInSet = int(binary.BigEndian.Uint16([]byte{msb, lsb}))
-			state = WAITING_FOR_BODY
-		case state == WAITING_FOR_BODY && input == BODY_START_MARKER:
-			state = BODY_START
-		case state == BODY_START && input == '\n':
-			state = BODY_READ_SAMPLE
-			buff = []byte{}
-			bytesLeftInSample = BYTES_PER_SAMPLE
-		case state == BODY_READ_SAMPLE:
-			buff = append(buff, input)
-			bytesLeftInSample = bytesLeftInSample - 1
-
-			if bytesLeftInSample <= 0 {
-				samplesLeftInSet = samplesLeftInSet - 1
-
-				if samplesLeftInSet <= 0 {
-					// Finish and send set
-					onReceive(buff)
-
-					// Get ready for next set and request it
-					state = WAITING_FOR_HEADER
-					_, err = port.Write(START_MEASUREMENT_CMD)
-					if err != nil {
-						logger.WithField("error", err).Info("Failed to write poll message to serial port.")
-						return
-					}
-				} else {
-					// Start next point
-					bytesLeftInSample = BYTES_PER_SAMPLE
-				}
-			}
-		case state == UNEXPECTED_BYTE && input == HEADER_START_MARKER:
-			// Recover from error state when a new header is seen
-			state = HEADER_START
-		default:
-			state = UNEXPECTED_BYTE
-		}
-
-	}
-
+			announce(index)
+			onReceive(set, format, index)
+		},
+		// The format of text lines is not documented, so they are only logged
+		Line: func(line string) {
+			logger.WithField("line", strings.TrimSpace(line)).Info("Received text from device.")
+		},
+		Unresponsive: func(message catalog.Text) {
+			onMessage(Message{DeviceUnresponsive: &message})
+		},
+	})
+	return true
 }
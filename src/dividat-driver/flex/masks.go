@@ -0,0 +1,222 @@
+package flex
+
+/* Masking of dead or noisy cells.
+
+Cells can be marked by clients or detected on the idle mat. Values of masked
+cells are replaced by the mean of their unmasked neighbours in the same set, or
+zero. Masks are stored by device, so they survive restarts.
+
+Sets only list cells with a value, so masked cells missing from a set stay
+missing.
+
+*/
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cell of the sensor matrix
+type Cell struct {
+	Row    byte `json:"row"`
+	Column byte `json:"column"`
+}
+
+func (cell Cell) key() uint16 {
+	return uint16(cell.Row)<<8 | uint16(cell.Column)
+}
+
+// Mask of a device
+type Mask struct {
+	Cells []Cell `json:"cells"`
+	// Replace values by the mean of neighbouring cells instead of zero
+	Interpolate bool `json:"interpolate"`
+}
+
+// MaskStore persists masks by device
+type MaskStore struct {
+	path string
+
+	mutex sync.Mutex
+	masks map[string]Mask
+}
+
+// OpenMasks opens the store persisted at path, a missing file is an empty store
+func OpenMasks(path string) (*MaskStore, error) {
+	store := MaskStore{path: path, masks: map[string]Mask{}}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(contents, &store.masks)
+	if err != nil {
+		return nil, err
+	}
+	return &store, nil
+}
+
+func (store *MaskStore) get(device string) Mask {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.masks[device]
+}
+
+func (store *MaskStore) set(device string, mask Mask) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if len(mask.Cells) == 0 {
+		delete(store.masks, device)
+	} else {
+		store.masks[device] = mask
+	}
+
+	contents, err := json.MarshalIndent(store.masks, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(store.path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(store.path, contents, 0644)
+}
+
+// Mask applied to sets of the connected device
+type cellMask struct {
+	mutex       sync.Mutex
+	cells       map[uint16]bool
+	interpolate bool
+}
+
+func (mask *cellMask) set(m Mask) {
+	mask.mutex.Lock()
+	defer mask.mutex.Unlock()
+	mask.cells = map[uint16]bool{}
+	for _, cell := range m.Cells {
+		mask.cells[cell.key()] = true
+	}
+	mask.interpolate = m.Interpolate
+}
+
+func (mask *cellMask) get() Mask {
+	mask.mutex.Lock()
+	defer mask.mutex.Unlock()
+	m := Mask{Cells: []Cell{}, Interpolate: mask.interpolate}
+	for key := range mask.cells {
+		m.Cells = append(m.Cells, Cell{Row: byte(key >> 8), Column: byte(key)})
+	}
+	return m
+}
+
+func (mask *cellMask) apply(set measurementSet) measurementSet {
+	mask.mutex.Lock()
+	defer mask.mutex.Unlock()
+	if len(mask.cells) == 0 {
+		return set
+	}
+
+	step := set.format.bytesPerSample
+	values := map[uint16]int{}
+	if mask.interpolate {
+		for i := 0; i+step <= len(set.samples); i += step {
+			values[cellOf(set.samples, i)] = set.format.value(set.samples, i)
+		}
+	}
+
+	samples := make([]byte, len(set.samples))
+	copy(samples, set.samples)
+	for i := 0; i+step <= len(samples); i += step {
+		if !mask.cells[cellOf(samples, i)] {
+			continue
+		}
+		value := 0
+		if mask.interpolate {
+			value = mask.neighbourMean(samples[i], samples[i+1], values)
+		}
+		set.format.setValue(samples, i, value)
+	}
+	set.samples = samples
+	return set
+}
+
+// Mean of the unmasked direct neighbours, cells missing from the set count as zero
+func (mask *cellMask) neighbourMean(row byte, column byte, values map[uint16]int) int {
+	sum, count := 0, 0
+	for _, offset := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+		r, c := int(row)+offset[0], int(column)+offset[1]
+		if r < 0 || c < 0 || r > 255 || c > 255 {
+			continue
+		}
+		neighbour := Cell{Row: byte(r), Column: byte(c)}.key()
+		if mask.cells[neighbour] {
+			continue
+		}
+		sum += values[neighbour]
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / count
+}
+
+// Collection time for detection if the client does not specify one
+const defaultDetectionDuration = 3 * time.Second
+
+// Share of idle sets in which a cell must have a value to be considered noisy
+const noisyCellShare = 0.5
+
+// Detection of cells reporting values while the mat is idle
+type cellDetection struct {
+	mutex      sync.Mutex
+	collecting bool
+	counts     map[uint16]int
+	sets       int
+}
+
+func (detection *cellDetection) start() {
+	detection.mutex.Lock()
+	defer detection.mutex.Unlock()
+	detection.collecting = true
+	detection.counts = map[uint16]int{}
+	detection.sets = 0
+}
+
+func (detection *cellDetection) observe(set measurementSet) {
+	detection.mutex.Lock()
+	defer detection.mutex.Unlock()
+	if !detection.collecting {
+		return
+	}
+	step := set.format.bytesPerSample
+	for i := 0; i+step <= len(set.samples); i += step {
+		if set.format.value(set.samples, i) > 0 {
+			detection.counts[cellOf(set.samples, i)]++
+		}
+	}
+	detection.sets++
+}
+
+// Cells with values in most of the collected sets
+func (detection *cellDetection) finish() []Cell {
+	detection.mutex.Lock()
+	defer detection.mutex.Unlock()
+	detection.collecting = false
+	noisy := []Cell{}
+	for key, count := range detection.counts {
+		if detection.sets > 0 && float64(count)/float64(detection.sets) >= noisyCellShare {
+			noisy = append(noisy, Cell{Row: byte(key >> 8), Column: byte(key)})
+		}
+	}
+	detection.counts = nil
+	return noisy
+}
@@ -0,0 +1,126 @@
+package flex
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCellMask(t *testing.T) {
+	var tests = []struct {
+		name     string
+		mask     Mask
+		set      measurementSet
+		expected []byte
+	}{
+		{
+			name:     "no cells masked",
+			mask:     Mask{},
+			set:      set8([3]byte{1, 1, 50}),
+			expected: []byte{1, 1, 50},
+		},
+		{
+			name:     "masked cell is zeroed",
+			mask:     Mask{Cells: []Cell{{1, 1}}},
+			set:      set8([3]byte{1, 0, 10}, [3]byte{1, 1, 200}, [3]byte{1, 2, 30}),
+			expected: []byte{1, 0, 10, 1, 1, 0, 1, 2, 30},
+		},
+		{
+			name:     "interpolated from direct neighbours",
+			mask:     Mask{Cells: []Cell{{1, 1}}, Interpolate: true},
+			set:      set8([3]byte{0, 1, 10}, [3]byte{1, 0, 20}, [3]byte{1, 1, 200}, [3]byte{1, 2, 30}, [3]byte{2, 1, 40}, [3]byte{2, 2, 90}),
+			expected: []byte{0, 1, 10, 1, 0, 20, 1, 1, 25, 1, 2, 30, 2, 1, 40, 2, 2, 90},
+		},
+		{
+			name:     "neighbours missing from the set count as zero",
+			mask:     Mask{Cells: []Cell{{1, 1}}, Interpolate: true},
+			set:      set8([3]byte{1, 1, 200}, [3]byte{1, 2, 40}),
+			expected: []byte{1, 1, 10, 1, 2, 40},
+		},
+		{
+			name:     "masked neighbours are left out",
+			mask:     Mask{Cells: []Cell{{1, 1}, {1, 2}}, Interpolate: true},
+			set:      set8([3]byte{0, 1, 12}, [3]byte{1, 1, 200}, [3]byte{1, 2, 200}),
+			expected: []byte{0, 1, 12, 1, 1, 4, 1, 2, 0},
+		},
+		{
+			name:     "neighbours outside the matrix are left out",
+			mask:     Mask{Cells: []Cell{{0, 0}}, Interpolate: true},
+			set:      set8([3]byte{0, 0, 200}, [3]byte{0, 1, 30}, [3]byte{1, 0, 10}),
+			expected: []byte{0, 0, 20, 0, 1, 30, 1, 0, 10},
+		},
+		{
+			name:     "12-bit values",
+			mask:     Mask{Cells: []Cell{{0, 0}}, Interpolate: true},
+			set:      measurementSet{format: format12Bit, samples: []byte{0, 0, 0x0F, 0xFF, 0, 1, 0x02, 0x00, 1, 0, 0x04, 0x00}},
+			expected: []byte{0, 0, 0x03, 0x00, 0, 1, 0x02, 0x00, 1, 0, 0x04, 0x00},
+		},
+	}
+
+	for _, test := range tests {
+		mask := cellMask{}
+		mask.set(test.mask)
+		original := append([]byte{}, test.set.samples...)
+		masked := mask.apply(test.set)
+		if !bytes.Equal(masked.samples, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, masked.samples)
+		}
+		if !bytes.Equal(test.set.samples, original) {
+			t.Errorf("%s: expected the original set to be left unchanged", test.name)
+		}
+	}
+}
+
+func TestNoisyCellDetection(t *testing.T) {
+	detection := cellDetection{}
+	detection.start()
+	detection.observe(set8([3]byte{0, 0, 3}, [3]byte{4, 4, 1}, [3]byte{7, 7, 0}))
+	detection.observe(set8([3]byte{0, 0, 2}, [3]byte{7, 7, 0}))
+	detection.observe(set8([3]byte{0, 0, 5}, [3]byte{4, 4, 2}))
+	detection.observe(set8([3]byte{9, 9, 1}))
+
+	noisy := detection.finish()
+	sort.Slice(noisy, func(i, j int) bool { return noisy[i].key() < noisy[j].key() })
+	if !reflect.DeepEqual(noisy, []Cell{{0, 0}, {4, 4}}) {
+		t.Errorf("expected cells 0:0 and 4:4 to be detected, got %v", noisy)
+	}
+}
+
+func TestMasksArePersistedByDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "masks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "flex", "masks.json")
+
+	store, err := OpenMasks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mask := Mask{Cells: []Cell{{3, 4}}, Interpolate: true}
+	if err := store.set("A", mask); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.set("B", Mask{Cells: []Cell{{1, 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.set("B", Mask{}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenMasks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reopened.get("A"), mask) {
+		t.Errorf("expected mask of A to be persisted, got %+v", reopened.get("A"))
+	}
+	if len(reopened.get("B").Cells) != 0 {
+		t.Errorf("expected empty mask to remove B, got %+v", reopened.get("B"))
+	}
+}
@@ -0,0 +1,120 @@
+package flex
+
+/* Controllers multiplexing several mats.
+
+Upcoming controllers connect several daisy-chained mats through one serial
+port. Their handlers pass each set with the index of its mat, and the stream is
+split into logical devices, one per mat, each with its own device information,
+sets and processing state (calibration, dead cells, history). The framing that
+tags sets with their mat is not documented yet, so the SensingTex handler
+passes all sets untagged.
+
+Clients select a mat with the `mat` query parameter. The first mat is used by
+default, which is also where sets of controllers with a single mat go, so
+clients unaware of multiplexing work as before.
+
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/dividat/driver/src/dividat-driver/drops"
+	flexdevice "github.com/dividat/driver/src/dividat-driver/flex/device"
+)
+
+// Number of mats a controller may multiplex
+const maxMats = 16
+
+// Index passed with sets of controllers that do not multiplex
+const untaggedMat = flexdevice.Untagged
+
+// Logical device for one mat of the connected controller
+type mat struct {
+	index int
+
+	// Device information, nil until the controller connected or, for further
+	// mats, a set of the mat has been received
+	mutex  sync.Mutex
+	device *DeviceInfo
+
+	// Sets missed by clients falling behind, numbers the sets
+	rxDrops *drops.Topic
+
+	// Sets received recently, for clients to backfill
+	history *history
+
+	// Matrix dimensions of the mat
+	matrix *matrixSize
+
+	// Baselines of the idle mat, subtracted from sets
+	calibration *calibration
+
+	// Dead or noisy cells of the mat
+	mask      *cellMask
+	detection *cellDetection
+}
+
+func newMats() []*mat {
+	mats := make([]*mat, maxMats)
+	for index := range mats {
+		m := &mat{
+			index:       index,
+			history:     &history{},
+			matrix:      &matrixSize{},
+			calibration: &calibration{},
+			mask:        &cellMask{},
+			detection:   &cellDetection{},
+		}
+		m.rxDrops = drops.NewTopic(m.dataTopic())
+		mats[index] = m
+	}
+	return mats
+}
+
+// Topic sets of the mat are published on
+func (m *mat) dataTopic() string {
+	if m.index == 0 {
+		return "flex-rx"
+	}
+	return fmt.Sprintf("flex-rx-%d", m.index)
+}
+
+// Topic of messages only concerning clients of the mat
+func (m *mat) broadcastTopic() string {
+	return fmt.Sprintf("flex-broadcast-%d", m.index)
+}
+
+func (m *mat) getDevice() *DeviceInfo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.device
+}
+
+func (m *mat) setDevice(device *DeviceInfo) {
+	m.mutex.Lock()
+	m.device = device
+	m.mutex.Unlock()
+	m.matrix.reset()
+}
+
+// Mat the sets with the given index belong to
+func (handle *Handle) mat(index int) *mat {
+	if index == untaggedMat {
+		return handle.mats[0]
+	}
+	return handle.mats[index]
+}
+
+// Parse the mat selected by a client, the first if empty
+func parseMat(param string) (int, error) {
+	if param == "" {
+		return 0, nil
+	}
+	index, err := strconv.Atoi(param)
+	if err != nil || index < 0 || index >= maxMats {
+		return 0, fmt.Errorf("invalid mat %q, expected 0 to %d", param, maxMats-1)
+	}
+	return index, nil
+}
@@ -0,0 +1,319 @@
+package flex
+
+/* Metrics derived from measurement sets.
+
+Clients may subscribe to a selection of metrics, which are computed from the
+sets they receive and sent at the requested rate. Each metric is computed by a
+processor, which may use the values of processors it depends on:
+
+- totalLoad: sum of all sample values
+- centerOfPressure: load-weighted mean row and column, nil without load
+- swayPathLength: distance travelled by the center of pressure since subscribing, in cells
+- cadence: steps per minute over the last 10 seconds
+
+Computing and sending run in their own goroutines. If computing falls behind,
+sets are skipped rather than holding up the stream of sets.
+
+*/
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Rate at which metrics are sent if the client does not specify one, per second
+const defaultMetricsRate = 10
+
+// Highest rate at which metrics are sent, per second
+const maxMetricsRate = 60
+
+// Values derived from one set, processors add their value under their name
+type derivedSet struct {
+	set    measurementSet
+	values map[string]interface{}
+}
+
+// Computes one metric, with state kept per subscription
+type processor interface {
+	process(frame *derivedSet)
+}
+
+type metricDefinition struct {
+	// Metrics that must be computed first
+	dependsOn []string
+	create    func() processor
+}
+
+var metricDefinitions = map[string]metricDefinition{
+	"totalLoad":        {create: func() processor { return totalLoad{} }},
+	"centerOfPressure": {create: func() processor { return centerOfPressure{} }},
+	"swayPathLength":   {dependsOn: []string{"centerOfPressure"}, create: func() processor { return &swayPathLength{} }},
+	"cadence":          {create: func() processor { return &cadence{} }},
+}
+
+// Check that all metrics are known
+func validateMetrics(names []string) error {
+	for _, name := range names {
+		if _, ok := metricDefinitions[name]; !ok {
+			return fmt.Errorf("unknown metric %q", name)
+		}
+	}
+	return nil
+}
+
+// Processors for the selected metrics and their dependencies, dependencies first
+func buildProcessors(names []string) []processor {
+	processors := []processor{}
+	added := map[string]bool{}
+	var add func(name string)
+	add = func(name string) {
+		if added[name] {
+			return
+		}
+		added[name] = true
+		definition := metricDefinitions[name]
+		for _, dependency := range definition.dependsOn {
+			add(dependency)
+		}
+		processors = append(processors, definition.create())
+	}
+	for _, name := range names {
+		add(name)
+	}
+	return processors
+}
+
+// Metrics of a single client, computed and sent while subscribed
+type metricsPipeline struct {
+	input  chan measurementSet
+	cancel context.CancelFunc
+
+	mutex    sync.Mutex
+	latest   map[string]interface{}
+	latestAt time.Time
+	fresh    bool
+}
+
+func startMetrics(names []string, rate float64, sendMessage func(Message) error) *metricsPipeline {
+	if rate <= 0 {
+		rate = defaultMetricsRate
+	}
+	if rate > maxMetricsRate {
+		rate = maxMetricsRate
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pipeline := &metricsPipeline{input: make(chan measurementSet, 1), cancel: cancel}
+
+	go pipeline.compute(ctx, names, buildProcessors(names))
+	go pipeline.send(ctx, time.Duration(float64(time.Second)/rate), sendMessage)
+
+	return pipeline
+}
+
+// Hand a set to the pipeline without blocking, replacing a set not yet picked up
+func (pipeline *metricsPipeline) offer(set measurementSet) {
+	select {
+	case pipeline.input <- set:
+		return
+	default:
+	}
+	select {
+	case <-pipeline.input:
+	default:
+	}
+	select {
+	case pipeline.input <- set:
+	default:
+	}
+}
+
+func (pipeline *metricsPipeline) stop() {
+	pipeline.cancel()
+}
+
+func (pipeline *metricsPipeline) compute(ctx context.Context, names []string, processors []processor) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case set := <-pipeline.input:
+			frame := derivedSet{set: set, values: map[string]interface{}{}}
+			for _, p := range processors {
+				p.process(&frame)
+			}
+
+			// Only selected metrics are sent, not dependencies
+			selected := make(map[string]interface{}, len(names))
+			for _, name := range names {
+				selected[name] = frame.values[name]
+			}
+
+			pipeline.mutex.Lock()
+			pipeline.latest = selected
+			pipeline.latestAt = set.receivedAt
+			pipeline.fresh = true
+			pipeline.mutex.Unlock()
+		}
+	}
+}
+
+func (pipeline *metricsPipeline) send(ctx context.Context, interval time.Duration, sendMessage func(Message) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pipeline.mutex.Lock()
+			fresh := pipeline.fresh
+			metrics := Metrics{ReceivedAt: pipeline.latestAt, Values: pipeline.latest}
+			pipeline.fresh = false
+			pipeline.mutex.Unlock()
+
+			// Nothing new since the last time
+			if !fresh {
+				continue
+			}
+			if sendMessage(Message{Metrics: &metrics}) != nil {
+				return
+			}
+		}
+	}
+}
+
+// Metrics subscription of a single client, nil pipeline if not subscribed
+type metricsSubscription struct {
+	mutex    sync.Mutex
+	pipeline *metricsPipeline
+}
+
+func (subscription *metricsSubscription) set(pipeline *metricsPipeline) {
+	subscription.mutex.Lock()
+	defer subscription.mutex.Unlock()
+	if subscription.pipeline != nil {
+		subscription.pipeline.stop()
+	}
+	subscription.pipeline = pipeline
+}
+
+func (subscription *metricsSubscription) offer(set measurementSet) {
+	subscription.mutex.Lock()
+	defer subscription.mutex.Unlock()
+	if subscription.pipeline != nil {
+		subscription.pipeline.offer(set)
+	}
+}
+
+// PROCESSORS
+
+type totalLoad struct{}
+
+func (totalLoad) process(frame *derivedSet) {
+	load := 0
+	samples, format := frame.set.samples, frame.set.format
+	for i := 0; i+format.bytesPerSample <= len(samples); i += format.bytesPerSample {
+		load += format.value(samples, i)
+	}
+	frame.values["totalLoad"] = load
+}
+
+// Point on the sensor matrix, in fractional cells
+type Point struct {
+	Row    float64 `json:"row"`
+	Column float64 `json:"column"`
+}
+
+type centerOfPressure struct{}
+
+func (centerOfPressure) process(frame *derivedSet) {
+	var load, row, column float64
+	samples, format := frame.set.samples, frame.set.format
+	for i := 0; i+format.bytesPerSample <= len(samples); i += format.bytesPerSample {
+		value := float64(format.value(samples, i))
+		load += value
+		row += float64(samples[i]) * value
+		column += float64(samples[i+1]) * value
+	}
+	if load == 0 {
+		frame.values["centerOfPressure"] = nil
+		return
+	}
+	frame.values["centerOfPressure"] = &Point{Row: row / load, Column: column / load}
+}
+
+type swayPathLength struct {
+	length   float64
+	previous *Point
+}
+
+func (sway *swayPathLength) process(frame *derivedSet) {
+	current, _ := frame.values["centerOfPressure"].(*Point)
+	// Do not count jumps when stepping off and on again
+	if current != nil && sway.previous != nil {
+		sway.length += math.Hypot(current.Row-sway.previous.Row, current.Column-sway.previous.Column)
+	}
+	sway.previous = current
+	frame.values["swayPathLength"] = sway.length
+}
+
+// Steps are counted when a number of cells become loaded at once, e.g. as a
+// foot lands, but not more often than a person can step.
+const (
+	contactThreshold = 16
+	stepMinCells     = 4
+	stepMinInterval  = 250 * time.Millisecond
+	cadenceWindow    = 10 * time.Second
+)
+
+type cadence struct {
+	loaded map[uint16]bool
+	steps  []time.Time
+	start  time.Time
+}
+
+func (c *cadence) process(frame *derivedSet) {
+	now := frame.set.receivedAt
+	if c.start.IsZero() {
+		c.start = now
+	}
+
+	loaded := map[uint16]bool{}
+	landed := 0
+	samples, format := frame.set.samples, frame.set.format
+	for i := 0; i+format.bytesPerSample <= len(samples); i += format.bytesPerSample {
+		// Threshold applies to values scaled to 8 bit
+		if format.value(samples, i)>>uint(format.bitdepth-8) < contactThreshold {
+			continue
+		}
+		cell := cellOf(samples, i)
+		loaded[cell] = true
+		if !c.loaded[cell] {
+			landed++
+		}
+	}
+
+	// The first set shows whoever is standing on the mat, not a step
+	if c.loaded != nil && landed >= stepMinCells && (len(c.steps) == 0 || now.Sub(c.steps[len(c.steps)-1]) >= stepMinInterval) {
+		c.steps = append(c.steps, now)
+	}
+	c.loaded = loaded
+
+	for len(c.steps) > 0 && now.Sub(c.steps[0]) > cadenceWindow {
+		c.steps = c.steps[1:]
+	}
+
+	window := now.Sub(c.start)
+	if window > cadenceWindow {
+		window = cadenceWindow
+	}
+	if window <= 0 {
+		frame.values["cadence"] = 0.0
+		return
+	}
+	frame.values["cadence"] = float64(len(c.steps)) * float64(time.Minute) / float64(window)
+}
@@ -0,0 +1,163 @@
+package flex
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Values of the metrics computed over the sets in turn, for the last set
+func computeMetrics(names []string, sets ...measurementSet) map[string]interface{} {
+	processors := buildProcessors(names)
+	var frame derivedSet
+	for _, set := range sets {
+		frame = derivedSet{set: set, values: map[string]interface{}{}}
+		for _, p := range processors {
+			p.process(&frame)
+		}
+	}
+	return frame.values
+}
+
+func at(set measurementSet, offset time.Duration) measurementSet {
+	set.receivedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(offset)
+	return set
+}
+
+func TestFrameMetrics(t *testing.T) {
+	var tests = []struct {
+		name     string
+		set      measurementSet
+		load     int
+		pressure *Point
+	}{
+		{
+			name: "empty set",
+			set:  set8(),
+		},
+		{
+			name:     "single cell",
+			set:      set8([3]byte{3, 4, 10}),
+			load:     10,
+			pressure: &Point{Row: 3, Column: 4},
+		},
+		{
+			name:     "weighted by load",
+			set:      set8([3]byte{0, 0, 10}, [3]byte{4, 8, 30}),
+			load:     40,
+			pressure: &Point{Row: 3, Column: 6},
+		},
+		{
+			name:     "cells without load",
+			set:      set8([3]byte{0, 0, 0}, [3]byte{2, 2, 5}),
+			load:     5,
+			pressure: &Point{Row: 2, Column: 2},
+		},
+		{
+			name:     "12-bit values",
+			set:      measurementSet{format: format12Bit, samples: []byte{1, 0, 0x01, 0x00, 3, 0, 0x03, 0x00}},
+			load:     1024,
+			pressure: &Point{Row: 2.5, Column: 0},
+		},
+	}
+
+	for _, test := range tests {
+		values := computeMetrics([]string{"totalLoad", "centerOfPressure"}, test.set)
+		if values["totalLoad"] != test.load {
+			t.Errorf("%s: expected total load %d, got %v", test.name, test.load, values["totalLoad"])
+		}
+		pressure, _ := values["centerOfPressure"].(*Point)
+		if !reflect.DeepEqual(pressure, test.pressure) {
+			t.Errorf("%s: expected center of pressure %+v, got %+v", test.name, test.pressure, pressure)
+		}
+	}
+}
+
+func TestSwayPathLength(t *testing.T) {
+	var tests = []struct {
+		name   string
+		sets   []measurementSet
+		length float64
+	}{
+		{
+			name:   "standing still",
+			sets:   []measurementSet{set8([3]byte{1, 1, 10}), set8([3]byte{1, 1, 20})},
+			length: 0,
+		},
+		{
+			name:   "moving",
+			sets:   []measurementSet{set8([3]byte{0, 0, 10}), set8([3]byte{3, 4, 10}), set8([3]byte{3, 0, 10})},
+			length: 9,
+		},
+		{
+			name:   "stepping off and on again",
+			sets:   []measurementSet{set8([3]byte{0, 0, 10}), set8(), set8([3]byte{6, 8, 10})},
+			length: 0,
+		},
+	}
+
+	for _, test := range tests {
+		values := computeMetrics([]string{"swayPathLength"}, test.sets...)
+		if values["swayPathLength"] != test.length {
+			t.Errorf("%s: expected sway path length %v, got %v", test.name, test.length, values["swayPathLength"])
+		}
+	}
+}
+
+func TestCadence(t *testing.T) {
+	standing := set8([3]byte{0, 0, 50})
+	foot := set8([3]byte{0, 0, 50}, [3]byte{5, 0, 50}, [3]byte{5, 1, 50}, [3]byte{6, 0, 50}, [3]byte{6, 1, 50})
+	light := set8([3]byte{0, 0, 50}, [3]byte{5, 0, 5}, [3]byte{5, 1, 5}, [3]byte{6, 0, 5}, [3]byte{6, 1, 5})
+
+	var tests = []struct {
+		name    string
+		sets    []measurementSet
+		cadence float64
+	}{
+		{
+			name:    "first set is not a step",
+			sets:    []measurementSet{at(foot, 0)},
+			cadence: 0,
+		},
+		{
+			name:    "one step in a second",
+			sets:    []measurementSet{at(standing, 0), at(foot, 500*time.Millisecond), at(standing, time.Second)},
+			cadence: 60,
+		},
+		{
+			name:    "steps closer than a person can step",
+			sets:    []measurementSet{at(standing, 0), at(foot, 100*time.Millisecond), at(standing, 200*time.Millisecond), at(foot, 300*time.Millisecond), at(standing, time.Second)},
+			cadence: 60,
+		},
+		{
+			name:    "cells below the contact threshold",
+			sets:    []measurementSet{at(standing, 0), at(light, 500*time.Millisecond), at(standing, time.Second)},
+			cadence: 0,
+		},
+		{
+			name:    "steps leave the window",
+			sets:    []measurementSet{at(standing, 0), at(foot, time.Second), at(standing, 2*time.Second), at(standing, 20*time.Second)},
+			cadence: 0,
+		},
+	}
+
+	for _, test := range tests {
+		values := computeMetrics([]string{"cadence"}, test.sets...)
+		if values["cadence"] != test.cadence {
+			t.Errorf("%s: expected cadence %v, got %v", test.name, test.cadence, values["cadence"])
+		}
+	}
+}
+
+func TestDependenciesAreComputedFirst(t *testing.T) {
+	processors := buildProcessors([]string{"swayPathLength", "centerOfPressure", "totalLoad"})
+	if len(processors) != 3 {
+		t.Fatalf("expected 3 processors, got %d", len(processors))
+	}
+	if _, ok := processors[0].(centerOfPressure); !ok {
+		t.Errorf("expected center of pressure to be computed first, got %T", processors[0])
+	}
+	if validateMetrics([]string{"totalLoad", "heartRate"}) == nil {
+		t.Error("expected unknown metric to be rejected")
+	}
+}
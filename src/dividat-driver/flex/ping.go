@@ -0,0 +1,64 @@
+package flex
+
+// Round trips to a Flex device are the time from writing the poll command to
+// reading the complete set it asked for. Polled devices are asked for every
+// set anyway, so the most recent round trips are kept instead of sending
+// additional polls. Streaming devices are not polled, their round trips are
+// unknown.
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dividat/driver/src/dividat-driver/latency"
+)
+
+// Poll round trips kept for the Ping command
+const pollWindow = 100
+
+// Ping command, measures round trips to the device and to the client
+type Ping struct {
+	// Round trips to the client to measure, latency.DefaultCount if zero
+	Count int `json:"count"`
+}
+
+// Latency answers a Ping command, with round trips in milliseconds
+type Latency struct {
+	// Nil if the round trips to the device are unknown
+	DeviceRoundTrip *latency.Summary `json:"deviceRoundTrip"`
+	// Why the round trips to the device are unknown
+	DeviceError     string           `json:"deviceError,omitempty"`
+	ClientRoundTrip *latency.Summary `json:"clientRoundTrip"`
+}
+
+// Recent round trips of polls to the connected device
+func (handle *Handle) pollRoundTrips() (latency.Summary, error) {
+	device := handle.Device()
+	if device == nil {
+		return latency.Summary{}, errors.New("no device is connected")
+	}
+	if device.Acquisition != "polled" {
+		return latency.Summary{}, errors.New("device streams without being polled")
+	}
+	summary := handle.frameCheck.polls.Summary()
+	if summary.Samples == 0 {
+		return latency.Summary{}, errors.New("device has not answered a poll yet")
+	}
+	return summary, nil
+}
+
+// Measure round trips to the device and to the client
+func (handle *Handle) ping(ctx context.Context, command Ping, client *latency.ClientPinger) Latency {
+	result := Latency{}
+	deviceRoundTrip, err := handle.pollRoundTrips()
+	if err != nil {
+		result.DeviceError = err.Error()
+	} else {
+		result.DeviceRoundTrip = &deviceRoundTrip
+	}
+	clientRoundTrip, err := client.Measure(ctx, latency.Count(command.Count))
+	if err == nil {
+		result.ClientRoundTrip = &clientRoundTrip
+	}
+	return result
+}
@@ -0,0 +1,44 @@
+package flex
+
+import (
+	"sync"
+)
+
+// Serial ports open across all handles
+var serialPorts = struct {
+	sync.Mutex
+	open int
+	max  int
+}{}
+
+// LimitSerialPorts sets the most serial ports open at once, unlimited if zero.
+// Devices found while the limit is reached are not connected.
+func LimitSerialPorts(max int) {
+	serialPorts.Lock()
+	defer serialPorts.Unlock()
+	serialPorts.max = max
+}
+
+// SerialPorts returns the number of open serial ports and the limit
+func SerialPorts() (int, int) {
+	serialPorts.Lock()
+	defer serialPorts.Unlock()
+	return serialPorts.open, serialPorts.max
+}
+
+// Count a port as open, unless the limit is reached
+func acquirePort() bool {
+	serialPorts.Lock()
+	defer serialPorts.Unlock()
+	if serialPorts.max > 0 && serialPorts.open >= serialPorts.max {
+		return false
+	}
+	serialPorts.open++
+	return true
+}
+
+func releasePort() {
+	serialPorts.Lock()
+	defer serialPorts.Unlock()
+	serialPorts.open--
+}
@@ -0,0 +1,46 @@
+package flex
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Another program, or another driver, holds the serial port. Reading from it
+// as well would interleave the reads, corrupting the data of both.
+var errPortInUse = errors.New("serial port is in use by another program")
+
+// Serial ports found in use, reported to clients once until they can be opened
+type portsInUse struct {
+	mutex sync.Mutex
+	paths map[string]bool
+}
+
+// Record whether the port is in use, returns whether that changed
+func (ports *portsInUse) set(path string, inUse bool) bool {
+	ports.mutex.Lock()
+	defer ports.mutex.Unlock()
+	if ports.paths == nil {
+		ports.paths = map[string]bool{}
+	}
+	if ports.paths[path] == inUse {
+		return false
+	}
+	if inUse {
+		ports.paths[path] = true
+	} else {
+		delete(ports.paths, path)
+	}
+	return true
+}
+
+func (ports *portsInUse) list() []string {
+	ports.mutex.Lock()
+	defer ports.mutex.Unlock()
+	paths := []string{}
+	for path := range ports.paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
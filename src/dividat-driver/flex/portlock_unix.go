@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package flex
+
+import (
+	"syscall"
+)
+
+// Take an advisory lock on the serial port, held until released. The serial
+// library additionally sets TIOCEXCL, which does not stop privileged
+// processes such as a driver running as system service.
+func lockPort(path string) (func(), error) {
+	fd, err := syscall.Open(path, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err == syscall.EBUSY {
+		return nil, errPortInUse
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		syscall.Close(fd)
+		return nil, errPortInUse
+	} else if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	return func() { syscall.Close(fd) }, nil
+}
@@ -0,0 +1,7 @@
+package flex
+
+// Serial ports are opened without sharing on Windows, opening a port in use
+// already fails
+func lockPort(path string) (func(), error) {
+	return func() {}, nil
+}
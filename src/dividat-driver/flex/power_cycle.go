@@ -0,0 +1,40 @@
+package flex
+
+import (
+	"github.com/dividat/driver/src/dividat-driver/catalog"
+	"github.com/dividat/driver/src/dividat-driver/flex/usbpower"
+)
+
+// ProcessPowerCycleRequest resets a Flex device that does not react any more by
+// switching off the power of its USB hub port, as an alternative to
+// unplugging and replugging it
+func (handle *Handle) ProcessPowerCycleRequest(command PowerCycleDevice, send SendMsg) {
+	handle.log.Info("Processing power cycle request.")
+	handle.firmwareUpdate.SetUpdating(true)
+	defer handle.firmwareUpdate.SetUpdating(false)
+
+	device, err := handle.findDevice(command.SerialNumber)
+	if err != nil {
+		failureMsg := catalog.New("flex.powerCycleFailed", "error", err.Error())
+		send.failure(failureMsg)
+		handle.log.Error(failureMsg)
+		return
+	}
+
+	// Free the serial port
+	if handle.stopListening() {
+		send.progress(catalog.New("flex.disconnecting"))
+	}
+
+	// Resume scanning for connected clients when done
+	defer handle.resumeListening()
+
+	err = usbpower.PowerCycle(handle.ctx, device, handle.enumerator, send.progress)
+	if err != nil {
+		failureMsg := catalog.New("flex.powerCycleFailed", "error", err.Error())
+		send.failure(failureMsg)
+		handle.log.Error(failureMsg)
+	} else {
+		send.success(catalog.New("flex.powerCycled"))
+	}
+}
@@ -0,0 +1,81 @@
+package flex
+
+// A firmware hiccup can make a Teensy-based controller re-enumerate: its
+// serial port vanishes and reappears, possibly under a new path, within a
+// second. Instead of reporting a disconnection, clients are told that the
+// stream was interrupted, and that it resumed once the device with the same
+// serial number is connected again. Only if it does not return in time is the
+// device reported lost.
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/clock"
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+)
+
+// How long a vanished device may take to reappear
+const reenumerationWindow = 2 * time.Second
+
+// StreamInterruption identifies a device that re-enumerates
+type StreamInterruption struct {
+	SerialNumber string `json:"serialNumber"`
+	// Path of the port that vanished, or the port it reappeared at once resumed
+	Path string `json:"path"`
+}
+
+// Device expected to reappear after its port vanished
+type reenumeration struct {
+	mutex sync.Mutex
+	// Empty if no device is expected
+	serialNumber string
+	timer        clock.Timer
+}
+
+// Wait for the device with the serial number to reappear, calling onTimeout
+// if it does not within the window
+func (r *reenumeration) expect(serialNumber string, onTimeout func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.serialNumber = serialNumber
+	r.timer = clock.Default.AfterFunc(reenumerationWindow, func() {
+		r.mutex.Lock()
+		expected := r.serialNumber == serialNumber
+		r.serialNumber = ""
+		r.mutex.Unlock()
+		if expected {
+			onTimeout()
+		}
+	})
+}
+
+// Whether the device was expected to reappear, which it no longer is
+func (r *reenumeration) resume(serialNumber string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.serialNumber == "" || r.serialNumber != serialNumber {
+		return false
+	}
+	r.serialNumber = ""
+	r.timer.Stop()
+	return true
+}
+
+// Whether the port of the device is no longer listed, i.e. the device was
+// unplugged or re-enumerates
+func vanished(devices enumerator.Enumerator, path string) bool {
+	listed, err := devices.ListDevices()
+	if err != nil {
+		return false
+	}
+	for _, device := range listed {
+		if device.Path == path {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package flex
+
+import "sync"
+
+// Region is a rectangle of the sensor matrix, bounds are inclusive
+type Region struct {
+	RowStart    byte `json:"rowStart"`
+	RowEnd      byte `json:"rowEnd"`
+	ColumnStart byte `json:"columnStart"`
+	ColumnEnd   byte `json:"columnEnd"`
+}
+
+func (region Region) contains(row byte, column byte) bool {
+	return row >= region.RowStart && row <= region.RowEnd &&
+		column >= region.ColumnStart && column <= region.ColumnEnd
+}
+
+// filter returns the samples of a measurement set that lie within the region
+func (region Region) filter(set measurementSet) []byte {
+	step := set.format.bytesPerSample
+	filtered := make([]byte, 0, len(set.samples))
+	for i := 0; i+step <= len(set.samples); i += step {
+		if region.contains(set.samples[i], set.samples[i+1]) {
+			filtered = append(filtered, set.samples[i:i+step]...)
+		}
+	}
+	return filtered
+}
+
+// Region of interest of a single client, nil if all samples are forwarded
+type regionOfInterest struct {
+	mutex  sync.Mutex
+	region *Region
+}
+
+func (roi *regionOfInterest) set(region *Region) {
+	roi.mutex.Lock()
+	defer roi.mutex.Unlock()
+	roi.region = region
+}
+
+func (roi *regionOfInterest) apply(set measurementSet) []byte {
+	roi.mutex.Lock()
+	defer roi.mutex.Unlock()
+	if roi.region == nil {
+		return set.samples
+	}
+	return roi.region.filter(set)
+}
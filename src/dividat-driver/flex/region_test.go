@@ -0,0 +1,78 @@
+package flex
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Set of 8-bit samples given as row, column and value
+func set8(samples ...[3]byte) measurementSet {
+	set := measurementSet{format: format8Bit, samples: []byte{}}
+	for _, sample := range samples {
+		set.samples = append(set.samples, sample[:]...)
+	}
+	return set
+}
+
+func TestRegionFilter(t *testing.T) {
+	var tests = []struct {
+		name     string
+		region   Region
+		set      measurementSet
+		expected []byte
+	}{
+		{
+			name:     "bounds are inclusive",
+			region:   Region{RowStart: 1, RowEnd: 2, ColumnStart: 1, ColumnEnd: 2},
+			set:      set8([3]byte{0, 1, 10}, [3]byte{1, 1, 20}, [3]byte{2, 2, 30}, [3]byte{2, 3, 40}, [3]byte{3, 2, 50}),
+			expected: []byte{1, 1, 20, 2, 2, 30},
+		},
+		{
+			name:     "single cell",
+			region:   Region{RowStart: 5, RowEnd: 5, ColumnStart: 7, ColumnEnd: 7},
+			set:      set8([3]byte{5, 6, 1}, [3]byte{5, 7, 2}, [3]byte{6, 7, 3}),
+			expected: []byte{5, 7, 2},
+		},
+		{
+			name:     "no sample within",
+			region:   Region{RowStart: 10, RowEnd: 20, ColumnStart: 10, ColumnEnd: 20},
+			set:      set8([3]byte{0, 0, 1}, [3]byte{30, 15, 2}),
+			expected: []byte{},
+		},
+		{
+			name:     "12-bit samples",
+			region:   Region{RowStart: 0, RowEnd: 0, ColumnStart: 0, ColumnEnd: 255},
+			set:      measurementSet{format: format12Bit, samples: []byte{0, 3, 0x0F, 0xFF, 1, 3, 0x01, 0x00}},
+			expected: []byte{0, 3, 0x0F, 0xFF},
+		},
+		{
+			name:     "truncated sample is dropped",
+			region:   Region{RowStart: 0, RowEnd: 255, ColumnStart: 0, ColumnEnd: 255},
+			set:      measurementSet{format: format8Bit, samples: []byte{1, 1, 5, 2, 2}},
+			expected: []byte{1, 1, 5},
+		},
+	}
+
+	for _, test := range tests {
+		filtered := test.region.filter(test.set)
+		if !bytes.Equal(filtered, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, filtered)
+		}
+	}
+}
+
+func TestRegionOfInterestForwardsAllWithoutRegion(t *testing.T) {
+	set := set8([3]byte{0, 0, 1}, [3]byte{9, 9, 2})
+	roi := regionOfInterest{}
+	if !bytes.Equal(roi.apply(set), set.samples) {
+		t.Error("expected all samples without region")
+	}
+	roi.set(&Region{RowStart: 9, RowEnd: 9, ColumnStart: 9, ColumnEnd: 9})
+	if !bytes.Equal(roi.apply(set), []byte{9, 9, 2}) {
+		t.Error("expected samples within the region")
+	}
+	roi.set(nil)
+	if !bytes.Equal(roi.apply(set), set.samples) {
+		t.Error("expected all samples after clearing the region")
+	}
+}
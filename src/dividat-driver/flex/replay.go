@@ -0,0 +1,95 @@
+package flex
+
+/* Replay of the last session for debugging clients.
+
+The most recent uninterrupted run of retained sets is streamed again to the
+requesting client only, at the original intervals. Live sets are held back from
+that client while replaying, so that the two streams do not interleave.
+
+*/
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Sets further apart than this belong to different sessions
+const sessionGap = time.Second
+
+// lastSession returns the most recent retained sets without a gap between them, oldest first
+func (h *history) lastSession() []measurementSet {
+	sets := h.since(time.Time{})
+	start := 0
+	for i := 1; i < len(sets); i++ {
+		if sets[i].receivedAt.Sub(sets[i-1].receivedAt) > sessionGap {
+			start = i
+		}
+	}
+	return sets[start:]
+}
+
+// Replay of a single client, nil cancel if not replaying
+type replay struct {
+	send func(measurementSet) error
+
+	mutex  sync.Mutex
+	cancel context.CancelFunc
+}
+
+func (r *replay) active() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.cancel != nil
+}
+
+// start streams the sets at their original intervals, replacing a running replay
+func (r *replay) start(ctx context.Context, sets []measurementSet, done func(completed bool)) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	r.mutex.Lock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.cancel = cancel
+	r.mutex.Unlock()
+
+	go func() {
+		completed := r.run(ctx, sets)
+
+		r.mutex.Lock()
+		// Leave a replay that replaced this one alone
+		if ctx.Err() == nil {
+			r.cancel = nil
+		}
+		r.mutex.Unlock()
+		cancel()
+
+		done(completed)
+	}()
+}
+
+func (r *replay) stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+}
+
+func (r *replay) run(ctx context.Context, sets []measurementSet) bool {
+	begin := time.Now()
+	for _, set := range sets {
+		due := begin.Add(set.receivedAt.Sub(sets[0].receivedAt))
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Until(due)):
+		}
+		if r.send(set) != nil {
+			return false
+		}
+	}
+	return true
+}
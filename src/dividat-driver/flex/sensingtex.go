@@ -0,0 +1,340 @@
+package flex
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/catalog"
+	"github.com/dividat/driver/src/dividat-driver/clock"
+	flexdevice "github.com/dividat/driver/src/dividat-driver/flex/device"
+)
+
+// SensingTex protocol
+
+type ReaderState int
+
+const (
+	WAITING_FOR_HEADER ReaderState = iota
+	HEADER_START
+	HEADER_READ_LENGTH_MSB
+	WAITING_FOR_BODY
+	BODY_START
+	BODY_READ_SAMPLE
+	UNEXPECTED_BYTE
+	READING_TEXT
+)
+
+const (
+	HEADER_START_MARKER = 'N'
+	BODY_START_MARKER   = 'P'
+)
+
+// Besides measurement sets, devices send text lines between sets, e.g. to echo
+// commands. Longer lines are noise, e.g. bytes of a corrupted set.
+const maxDeviceMessageLength = 256
+
+// Layout of samples, depending on the bitdepth configured on the device
+type sampleFormat struct {
+	bitdepth int
+	// Command configuring the bitdepth
+	command []byte
+	// Row, column and big-endian sample value
+	bytesPerSample int
+}
+
+var (
+	format8Bit  = sampleFormat{bitdepth: 8, command: []byte{'U', 'L', '\n'}, bytesPerSample: 3}
+	format12Bit = sampleFormat{bitdepth: 12, command: []byte{'U', 'M', '\n'}, bytesPerSample: 4}
+)
+
+// Formats clients may select, by bitdepth
+var sampleFormats = map[int]sampleFormat{8: format8Bit, 12: format12Bit}
+
+// Used unless a client selects another format
+var defaultSampleFormat = format8Bit
+
+// Format configured by a raw command, for clients that still send them
+func formatForCommand(command []byte) (sampleFormat, bool) {
+	for _, format := range sampleFormats {
+		if bytes.Equal(command, format.command) {
+			return format, true
+		}
+	}
+	return sampleFormat{}, false
+}
+
+// Value of the sample starting at the given offset
+func (format sampleFormat) value(samples []byte, offset int) int {
+	value := 0
+	for _, b := range samples[offset+2 : offset+format.bytesPerSample] {
+		value = value<<8 | int(b)
+	}
+	return value
+}
+
+// Overwrite the value of the sample starting at the given offset
+func (format sampleFormat) setValue(samples []byte, offset int, value int) {
+	for i := offset + format.bytesPerSample - 1; i >= offset+2; i-- {
+		samples[i] = byte(value)
+		value >>= 8
+	}
+}
+
+// Poll or stream measurement sets from a SensingTex controller and minimally parse the byte stream to
+// determine start and end of each set.
+func runSensingTex(ctx context.Context, conn flexdevice.Conn) {
+	logger, port, capabilities, format := conn.Logger, conn.Port, conn.Capabilities, conn.Format
+	commands := capabilities.Commands
+
+	// Parsing of the byte stream requires knowing the bitdepth, so it is
+	// configured by the driver rather than by clients sending raw commands.
+	if !capabilities.SupportsBitdepth(format.Bitdepth) {
+		logger.WithField("bitdepth", format.Bitdepth).Info("Device does not support bitdepth.")
+		return
+	}
+	_, err := port.Write(format.Command)
+	if err != nil {
+		logger.WithField("bitdepth", format.Bitdepth).WithField("error", err).Info("Failed to set bitdepth.")
+		return
+	}
+
+	if commands.Version != nil {
+		_, err = port.Write(commands.Version)
+		if err != nil {
+			logger.WithField("error", err).Info("Failed to write version query to serial port.")
+			return
+		}
+	}
+
+	_, err = port.Write(commands.Start)
+	if err != nil {
+		logger.WithField("error", err).Info("Failed to write start message to serial port.")
+		return
+	}
+	lastRequest := time.Now()
+
+	// Leave the device idle if the connection is closed by the driver
+	defer func() {
+		if ctx.Err() == nil {
+			return
+		}
+		for _, command := range [][]byte{commands.Stop, commands.Sleep} {
+			if command == nil {
+				continue
+			}
+			_, err := port.Write(command)
+			if err != nil {
+				logger.WithField("error", err).Info("Failed to write stop message to serial port.")
+				return
+			}
+		}
+	}()
+
+	// Restart acquisition if the device stops sending sets
+	received := make(chan struct{}, 1)
+	if conn.DataTimeout > 0 {
+		go watchData(ctx, logger, port, conn.DataTimeout, received, commands.Start, conn.Unresponsive)
+	}
+
+	reader := bufio.NewReader(port)
+	state := WAITING_FOR_HEADER
+	var samplesInSet int
+	var samplesLeftInSet int
+	var bytesLeftInSample int
+
+	// Polled devices need to be asked for the next set after one has been read
+	// or dropped, not sooner than the poll rate allows
+	requestSet := func() error {
+		if capabilities.Streaming {
+			return nil
+		}
+		if capabilities.PollRate > 0 {
+			due := lastRequest.Add(time.Duration(float64(time.Second) / capabilities.PollRate))
+			if wait := time.Until(due); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		lastRequest = time.Now()
+		_, err := port.Write(commands.Poll)
+		if err != nil {
+			logger.WithField("error", err).Info("Failed to write poll message to serial port.")
+		}
+		return err
+	}
+
+	// Start signal acquisition
+	var buff []byte
+	var line []byte
+	onLine := func() {
+		conn.Line(string(line))
+	}
+	for {
+		// Terminate if we were cancelled
+		if ctx.Err() != nil {
+			return
+		}
+
+		input, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+
+		// Finite State Machine for parsing byte stream
+		switch {
+		case state == WAITING_FOR_HEADER && input == HEADER_START_MARKER:
+			state = HEADER_START
+		case state == HEADER_START && input == '\n':
+			state = HEADER_READ_LENGTH_MSB
+		case state == HEADER_START && isText(input):
+			// Not a header after all, but a text line starting with the marker
+			line = []byte{HEADER_START_MARKER, input}
+			state = READING_TEXT
+		case state == WAITING_FOR_HEADER && input == '\n':
+			// Ignore empty lines
+		case state == WAITING_FOR_HEADER && isText(input):
+			line = []byte{input}
+			state = READING_TEXT
+		case state == READING_TEXT && input == '\n':
+			onLine()
+			state = WAITING_FOR_HEADER
+		case state == READING_TEXT && (input == '\r' || isText(input)) && len(line) < maxDeviceMessageLength:
+			line = append(line, input)
+		case state == READING_TEXT:
+			// Binary data, e.g. samples while resynchronizing, is not a text line
+			logger.WithField("byte", input).Debug("Dropped text line after unexpected byte.")
+			state = UNEXPECTED_BYTE
+		case state == HEADER_READ_LENGTH_MSB:
+			// The number of measurements in each set may vary and is
+			// given as two consecutive bytes (big-endian).
+			msb := input
+			lsb, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			samplesInSet = int(binary.BigEndian.Uint16([]byte{msb, lsb}))
+			samplesLeftInSet = samplesInSet
+			state = WAITING_FOR_BODY
+		case state == WAITING_FOR_BODY && input == BODY_START_MARKER:
+			state = BODY_START
+		case state == BODY_START && input == '\n':
+			state = BODY_READ_SAMPLE
+			buff = []byte{}
+			bytesLeftInSample = format.BytesPerSample
+		case state == BODY_READ_SAMPLE:
+			buff = append(buff, input)
+			bytesLeftInSample = bytesLeftInSample - 1
+
+			if bytesLeftInSample <= 0 {
+				samplesLeftInSet = samplesLeftInSet - 1
+
+				if samplesLeftInSet <= 0 {
+					// Finish and send set
+					if conn.Valid(buff, samplesInSet) {
+						conn.Received()
+						if !capabilities.Streaming {
+							conn.Answered(time.Since(lastRequest))
+						}
+						conn.Receive(buff, flexdevice.Untagged)
+						select {
+						case received <- struct{}{}:
+						default:
+						}
+					} else {
+						conn.Dropped()
+						logger.WithField("samples", samplesInSet).Debug("Dropped set failing validation.")
+					}
+
+					// Get ready for next set and request it, unless the device streams by itself
+					state = WAITING_FOR_HEADER
+					if requestSet() != nil {
+						return
+					}
+				} else {
+					// Start next point
+					bytesLeftInSample = format.BytesPerSample
+				}
+			}
+		case state == UNEXPECTED_BYTE && input == HEADER_START_MARKER:
+			// Recover from error state when a new header is seen
+			conn.Resynced()
+			state = HEADER_START
+		case state == HEADER_READ_LENGTH_MSB || state == WAITING_FOR_BODY || state == BODY_START:
+			// Set is corrupted, wait for the next one
+			conn.Dropped()
+			logger.WithField("byte", input).Debug("Dropped set after unexpected byte.")
+			state = UNEXPECTED_BYTE
+			if requestSet() != nil {
+				return
+			}
+		default:
+			state = UNEXPECTED_BYTE
+		}
+
+	}
+
+}
+
+// Whether the byte may be part of a text line sent by the device
+func isText(input byte) bool {
+	return input >= ' ' && input <= '~'
+}
+
+// Restarts after which an unresponsive device's port is reopened
+const maxDataRestarts = 3
+
+// Send the start command again whenever no set has been received for the
+// timeout. If the device stays silent, clients are informed and the port is
+// closed, ending the connection so it is reopened.
+func watchData(ctx context.Context, logger *logrus.Entry, port io.ReadWriter, timeout time.Duration, received <-chan struct{}, startCmd []byte, onUnresponsive func(catalog.Text)) {
+	timer := clock.Default.NewTimer(timeout)
+	defer timer.Stop()
+
+	restarts := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-received:
+			restarts = 0
+			if !timer.Stop() {
+				select {
+				case <-timer.C():
+				default:
+				}
+			}
+			timer.Reset(timeout)
+
+		case <-timer.C():
+			if restarts >= maxDataRestarts {
+				logger.WithField("timeout", timeout).Warn("Device sends no data after restarting acquisition, reopening serial port.")
+				onUnresponsive(catalog.New("flex.unresponsive", "timeout", timeout.String(), "restarts", strconv.Itoa(restarts)))
+				if closer, ok := port.(io.Closer); ok {
+					closer.Close()
+				}
+				return
+			}
+
+			restarts++
+			logger.WithField("timeout", timeout).WithField("restart", restarts).Info("Device sends no data, restarting acquisition.")
+			_, err := port.Write(startCmd)
+			if err != nil {
+				logger.WithField("error", err).Info("Failed to write start message to serial port.")
+			}
+			timer.Reset(timeout)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package flex
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	flexdevice "github.com/dividat/driver/src/dividat-driver/flex/device"
+)
+
+// Port reading a fixed byte stream, writes are discarded
+type streamPort struct {
+	*bytes.Reader
+}
+
+func (streamPort) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+// Set of 8-bit samples as sent by a streaming device
+func encodeSet(samples ...[3]byte) []byte {
+	set := []byte{'N', '\n', 0, 0, 'P', '\n'}
+	binary.BigEndian.PutUint16(set[2:], uint16(len(samples)))
+	for _, sample := range samples {
+		set = append(set, sample[:]...)
+	}
+	return set
+}
+
+// Parse the stream, returning the sets and text lines passed on
+func parseStream(stream []byte) ([][]byte, []string, int) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	sets := [][]byte{}
+	lines := []string{}
+	resynced := 0
+	runSensingTex(context.Background(), flexdevice.Conn{
+		Port:   streamPort{bytes.NewReader(stream)},
+		Logger: logrus.NewEntry(logger),
+		Capabilities: flexdevice.Capabilities{
+			Streaming: true,
+			Bitdepths: []int{8},
+			Commands:  flexdevice.Commands{Start: []byte("S\n")},
+		},
+		Format:   flexdevice.Format{Bitdepth: 8, Command: format8Bit.command, BytesPerSample: format8Bit.bytesPerSample},
+		Valid:    func([]byte, int) bool { return true },
+		Received: func() {},
+		Dropped:  func() {},
+		Resynced: func() { resynced++ },
+		Receive:  func(set []byte, mat int) { sets = append(sets, set) },
+		Line:     func(line string) { lines = append(lines, line) },
+	})
+	return sets, lines, resynced
+}
+
+func TestSensingTexParsesTextBetweenSets(t *testing.T) {
+	stream := encodeSet([3]byte{0, 0, 10}, [3]byte{0, 1, 20})
+	stream = append(stream, "UL\r\n"...)
+	stream = append(stream, encodeSet([3]byte{0, 0, 30})...)
+
+	sets, lines, _ := parseStream(stream)
+	if len(sets) != 2 {
+		t.Fatalf("Expected 2 sets, got %d", len(sets))
+	}
+	if !reflect.DeepEqual(lines, []string{"UL\r"}) {
+		t.Errorf("Unexpected lines: %q", lines)
+	}
+}
+
+func TestSensingTexResyncsWithoutSwallowingSets(t *testing.T) {
+	for name, noise := range map[string][]byte{
+		"printable then binary": []byte("ab\x05cd"),
+		"header marker":         []byte("N\x07xy"),
+		"text marker in noise":  []byte("\x01N?\x02"),
+	} {
+		stream := append(append([]byte{}, noise...), encodeSet([3]byte{0, 0, 10})...)
+		stream = append(stream, encodeSet([3]byte{0, 0, 20})...)
+
+		sets, lines, resynced := parseStream(stream)
+		if len(sets) != 2 {
+			t.Errorf("%s: expected 2 sets, got %d", name, len(sets))
+		}
+		if len(lines) != 0 {
+			t.Errorf("%s: expected no text lines, got %q", name, lines)
+		}
+		if resynced == 0 {
+			t.Errorf("%s: expected to resync", name)
+		}
+	}
+}
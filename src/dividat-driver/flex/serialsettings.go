@@ -0,0 +1,103 @@
+package flex
+
+import (
+	"fmt"
+
+	"go.bug.st/serial"
+
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+)
+
+// SerialSettings are the line settings of a controller, zero values take the default
+type SerialSettings struct {
+	BaudRate int `json:"baudRate"`
+	// "none", "odd", "even", "mark" or "space"
+	Parity   string `json:"parity"`
+	DataBits int    `json:"dataBits"`
+	// 1, 1.5 or 2
+	StopBits float64 `json:"stopBits"`
+}
+
+// Line settings of SensingTex controllers, used unless registered otherwise
+var defaultSerialMode = serial.Mode{
+	BaudRate: 115200,
+	Parity:   serial.NoParity,
+	DataBits: 8,
+	StopBits: serial.OneStopBit,
+}
+
+var parities = map[string]serial.Parity{
+	"none":  serial.NoParity,
+	"odd":   serial.OddParity,
+	"even":  serial.EvenParity,
+	"mark":  serial.MarkParity,
+	"space": serial.SpaceParity,
+}
+
+var stopBits = map[float64]serial.StopBits{
+	1:   serial.OneStopBit,
+	1.5: serial.OnePointFiveStopBits,
+	2:   serial.TwoStopBits,
+}
+
+// Line settings by device, the first matching entry is used
+type serialEntry struct {
+	vid uint16
+	// Zero matches any product
+	pid  uint16
+	mode serial.Mode
+}
+
+var serialRegistry = []serialEntry{}
+
+// RegisterSerialSettings opens devices with the given vendor and product (any
+// if zero) with other line settings, unless an earlier registration matches.
+// Must be called before devices are scanned.
+func RegisterSerialSettings(vid uint16, pid uint16, settings SerialSettings) error {
+	mode, err := serialMode(settings)
+	if err != nil {
+		return err
+	}
+	serialRegistry = append(serialRegistry, serialEntry{vid: vid, pid: pid, mode: mode})
+	return nil
+}
+
+func serialMode(settings SerialSettings) (serial.Mode, error) {
+	mode := defaultSerialMode
+	if settings.BaudRate < 0 {
+		return mode, fmt.Errorf("invalid baud rate %d", settings.BaudRate)
+	} else if settings.BaudRate > 0 {
+		mode.BaudRate = settings.BaudRate
+	}
+	if settings.Parity != "" {
+		parity, ok := parities[settings.Parity]
+		if !ok {
+			return mode, fmt.Errorf("unknown parity %q", settings.Parity)
+		}
+		mode.Parity = parity
+	}
+	if settings.DataBits != 0 {
+		if settings.DataBits < 5 || settings.DataBits > 8 {
+			return mode, fmt.Errorf("invalid data bits %d", settings.DataBits)
+		}
+		mode.DataBits = settings.DataBits
+	}
+	if settings.StopBits != 0 {
+		bits, ok := stopBits[settings.StopBits]
+		if !ok {
+			return mode, fmt.Errorf("invalid stop bits %v", settings.StopBits)
+		}
+		mode.StopBits = bits
+	}
+	return mode, nil
+}
+
+// Line settings to open the device with
+func serialModeOf(device enumerator.Device) serial.Mode {
+	for _, entry := range serialRegistry {
+		if device.VID == entry.vid && (entry.pid == 0 || device.PID == entry.pid) {
+			return entry.mode
+		}
+	}
+	return defaultSerialMode
+}
@@ -0,0 +1,39 @@
+package flex
+
+import (
+	"context"
+	"time"
+)
+
+// The monotonic clock does not advance while the machine is suspended, the
+// system clock does. A forward jump of the system clock beyond this is taken
+// as the machine having been suspended.
+const suspendThreshold = 5 * time.Second
+
+// Interval of comparing the clocks
+const suspendCheckInterval = 2 * time.Second
+
+// Call onResume with the time spent suspended whenever the machine resumes.
+// Serial port handles are stale after resume, without reads necessarily
+// failing.
+func watchSuspend(ctx context.Context, onResume func(time.Duration)) {
+	ticker := time.NewTicker(suspendCheckInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			now := time.Now()
+			// Stripping the monotonic reading compares by the system clock
+			suspended := now.Round(0).Sub(last.Round(0)) - now.Sub(last)
+			last = now
+			if suspended > suspendThreshold {
+				onResume(suspended)
+			}
+		}
+	}
+}
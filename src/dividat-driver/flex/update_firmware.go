@@ -0,0 +1,89 @@
+package flex
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/dividat/driver/src/dividat-driver/catalog"
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+	"github.com/dividat/driver/src/dividat-driver/flex/halfkay"
+)
+
+type SendMsg struct {
+	progress func(catalog.Text)
+	failure  func(catalog.Text)
+	success  func(catalog.Text)
+}
+
+// ProcessFirmwareUpdateRequest flashes a Teensy-based Flex controller
+func (handle *Handle) ProcessFirmwareUpdateRequest(command UpdateFirmware, send SendMsg) {
+	handle.log.Info("Processing firmware update request.")
+	handle.firmwareUpdate.SetUpdating(true)
+	defer handle.firmwareUpdate.SetUpdating(false)
+
+	// Free the serial port
+	if handle.stopListening() {
+		send.progress(catalog.New("flex.disconnecting"))
+	}
+
+	// Resume scanning for connected clients when done
+	defer handle.resumeListening()
+
+	image, err := base64.StdEncoding.DecodeString(command.Image)
+	if err != nil {
+		msg := catalog.New("firmware.decodeFailed", "error", err.Error())
+		send.failure(msg)
+		handle.log.Error(msg)
+		return
+	}
+
+	device, err := handle.findDevice(command.SerialNumber)
+	if err != nil {
+		failureMsg := catalog.New("firmware.updateFailed", "error", err.Error())
+		send.failure(failureMsg)
+		handle.log.Error(failureMsg)
+		return
+	}
+
+	err = halfkay.Flash(handle.ctx, device, bytes.NewReader(image), handle.enumerator, send.progress)
+	if err != nil {
+		failureMsg := catalog.New("firmware.updateFailed", "error", err.Error())
+		send.failure(failureMsg)
+		handle.log.Error(failureMsg)
+	} else {
+		send.success(catalog.New("flex.firmwareFlashed"))
+	}
+}
+
+// Whether the device to be updated is the one clients stream from, which is
+// assumed if no serial number is given
+func (handle *Handle) streamsFrom(serialNumber string) bool {
+	device := handle.Device()
+	if device == nil {
+		return false
+	}
+	return serialNumber == "" || device.SerialNumber == serialNumber
+}
+
+// Find the Flex device with given serial number, or the only one if no serial number is given
+func (handle *Handle) findDevice(serialNumber string) (enumerator.Device, error) {
+	devices, err := handle.enumerator.ListDevices()
+	if err != nil {
+		return enumerator.Device{}, fmt.Errorf("could not list serial devices: %v", err)
+	}
+
+	candidates := []enumerator.Device{}
+	for _, device := range devices {
+		if isFlexLike(device) && (serialNumber == "" || device.SerialNumber == serialNumber) {
+			candidates = append(candidates, device)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return enumerator.Device{}, fmt.Errorf("no Flex device with serial number %q found", serialNumber)
+	} else if len(candidates) > 1 {
+		return enumerator.Device{}, fmt.Errorf("several Flex devices found, specify a serial number")
+	}
+	return candidates[0], nil
+}
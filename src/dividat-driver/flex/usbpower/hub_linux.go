@@ -0,0 +1,36 @@
+package usbpower
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// USB device directories in sysfs are named after the bus and the ports on
+// the way from the root hub, e.g. 1-1.4 for port 4 of the hub at port 1 of
+// bus 1. Interfaces carry a configuration suffix (1-1.4:1.0) and do not match.
+var usbLocation = regexp.MustCompile(`^(\d+)-([\d.]+)$`)
+
+// Location of the hub (as understood by uhubctl) and port a tty's USB device is plugged into
+func hubPort(portName string) (string, string, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Join("/sys/class/tty", filepath.Base(portName), "device"))
+	if err != nil {
+		return "", "", fmt.Errorf("could not find USB device of %s: %v", portName, err)
+	}
+
+	// Walk up from the interface, as when reading bcdDevice
+	for i := 0; i < 3; i++ {
+		if match := usbLocation.FindStringSubmatch(filepath.Base(dir)); match != nil {
+			bus, ports := match[1], match[2]
+			if last := strings.LastIndex(ports, "."); last >= 0 {
+				return bus + "-" + ports[:last], ports[last+1:], nil
+			}
+			// Plugged into the root hub
+			return bus, ports, nil
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	return "", "", fmt.Errorf("could not find USB port of %s", portName)
+}
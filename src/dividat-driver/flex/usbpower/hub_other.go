@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package usbpower
+
+func hubPort(portName string) (string, string, error) {
+	return "", "", ErrUnsupported
+}
@@ -0,0 +1,78 @@
+package usbpower
+
+/* Power cycles Flex devices through USB hubs with per-port power switching.
+
+Hubs are switched with uhubctl (https://github.com/mvp/uhubctl), which must be
+installed and allowed to write to the hub. Cutting power resets a device that
+does not react any more, as unplugging and replugging it would.
+
+*/
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/catalog"
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+)
+
+// ErrUnsupported is returned if the hub port of a device can not be determined on the platform
+var ErrUnsupported = errors.New("power cycling USB ports is not supported on this platform")
+
+// How long the port is left without power
+const offDuration = 2 * time.Second
+
+const returnTimeout = 15 * time.Second
+
+type OnProgress func(catalog.Text)
+
+// PowerCycle switches off the port of the hub the device is plugged into,
+// switches it on again and waits for the device to return
+func PowerCycle(ctx context.Context, device enumerator.Device, devices enumerator.Enumerator, onProgress OnProgress) error {
+	hub, port, err := hubPort(device.Path)
+	if err != nil {
+		return err
+	}
+
+	tool, err := exec.LookPath("uhubctl")
+	if err != nil {
+		return fmt.Errorf("uhubctl is not installed")
+	}
+
+	onProgress(catalog.New("flex.powerCycling", "port", port, "hub", hub))
+	delay := strconv.FormatFloat(offDuration.Seconds(), 'f', -1, 64)
+	output, err := exec.CommandContext(ctx, tool, "-l", hub, "-p", port, "-a", "cycle", "-d", delay).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("could not switch port power, the hub may not support it: %v: %s", err, bytes.TrimSpace(output))
+	}
+
+	return waitForReturn(ctx, device, devices, onProgress)
+}
+
+// Devices without serial number are recognized by their path
+func waitForReturn(ctx context.Context, device enumerator.Device, devices enumerator.Enumerator, onProgress OnProgress) error {
+	onProgress(catalog.New("flex.waitingForReturn"))
+	deadline := time.Now().Add(returnTimeout)
+	for time.Now().Before(deadline) {
+		listed, err := devices.ListDevices()
+		if err == nil {
+			for _, candidate := range listed {
+				if device.SerialNumber != "" && candidate.SerialNumber == device.SerialNumber || device.SerialNumber == "" && candidate.Path == device.Path {
+					return nil
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("device %s did not return after power cycling", device.Path)
+}
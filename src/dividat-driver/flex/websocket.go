@@ -2,25 +2,897 @@ package flex
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/auth"
+	"github.com/dividat/driver/src/dividat-driver/catalog"
+	"github.com/dividat/driver/src/dividat-driver/clock"
+	"github.com/dividat/driver/src/dividat-driver/codec"
+	"github.com/dividat/driver/src/dividat-driver/config"
+	"github.com/dividat/driver/src/dividat-driver/confirm"
+	"github.com/dividat/driver/src/dividat-driver/deprecation"
+	"github.com/dividat/driver/src/dividat-driver/drops"
+	"github.com/dividat/driver/src/dividat-driver/hooks"
+	"github.com/dividat/driver/src/dividat-driver/latency"
+	"github.com/dividat/driver/src/dividat-driver/logging"
+	"github.com/dividat/driver/src/dividat-driver/sessions"
 )
 
 // WEBSOCKET PROTOCOL
 
+// Command sent by Play
+type Command struct {
+	*GetStatus
+	*GetDeviceInfo
+
+	*Connect
+
+	*SetRegionOfInterest
+	*ClearRegionOfInterest
+
+	*EnableTimestamps
+	*DisableTimestamps
+
+	*SetRate
+	*SetLayout
+
+	*SetSampleFormat
+
+	*Calibrate
+	*ClearCalibration
+
+	*GetDeadCells
+	*SetDeadCells
+	*DetectDeadCells
+
+	*ConfirmPairing
+
+	*GetRecentFrames
+	*ReplayLastSession
+	*StopReplay
+
+	*SubscribeMetrics
+	*UnsubscribeMetrics
+
+	*TraceFrames
+
+	*Ping
+
+	*UpdateFirmware
+	*PowerCycleDevice
+	*Confirm
+}
+
+func prettyPrintCommand(command Command) string {
+	if command.GetStatus != nil {
+		return "GetStatus"
+	} else if command.GetDeviceInfo != nil {
+		return "GetDeviceInfo"
+	} else if command.Connect != nil {
+		return "Connect"
+	} else if command.SetRegionOfInterest != nil {
+		return "SetRegionOfInterest"
+	} else if command.ClearRegionOfInterest != nil {
+		return "ClearRegionOfInterest"
+	} else if command.EnableTimestamps != nil {
+		return "EnableTimestamps"
+	} else if command.DisableTimestamps != nil {
+		return "DisableTimestamps"
+	} else if command.SetSampleFormat != nil {
+		return "SetSampleFormat"
+	} else if command.Calibrate != nil {
+		return "Calibrate"
+	} else if command.ClearCalibration != nil {
+		return "ClearCalibration"
+	} else if command.SetRate != nil {
+		return "SetRate"
+	} else if command.SetLayout != nil {
+		return "SetLayout"
+	} else if command.GetDeadCells != nil {
+		return "GetDeadCells"
+	} else if command.ReplayLastSession != nil {
+		return "ReplayLastSession"
+	} else if command.StopReplay != nil {
+		return "StopReplay"
+	} else if command.SetDeadCells != nil {
+		return "SetDeadCells"
+	} else if command.DetectDeadCells != nil {
+		return "DetectDeadCells"
+	} else if command.ConfirmPairing != nil {
+		return "ConfirmPairing"
+	} else if command.GetRecentFrames != nil {
+		return "GetRecentFrames"
+	} else if command.SubscribeMetrics != nil {
+		return "SubscribeMetrics"
+	} else if command.UnsubscribeMetrics != nil {
+		return "UnsubscribeMetrics"
+	} else if command.TraceFrames != nil {
+		return "TraceFrames"
+	} else if command.Ping != nil {
+		return "Ping"
+	} else if command.UpdateFirmware != nil {
+		return "UpdateFirmware"
+	} else if command.PowerCycleDevice != nil {
+		return "PowerCycleDevice"
+	} else if command.Confirm != nil {
+		return "Confirm"
+	}
+	return "Unknown"
+}
+
+// GetStatus command
+type GetStatus struct{}
+
+// GetDeviceInfo command, requests details on the connected device
+type GetDeviceInfo struct{}
+
+// Connect command, selects the device by path or USB serial number
+type Connect struct {
+	Address string `json:"address"`
+}
+
+// SetRegionOfInterest command, restricts forwarded samples to a region of the matrix
+type SetRegionOfInterest struct {
+	Region
+}
+
+// ClearRegionOfInterest command, forwards all samples again
+type ClearRegionOfInterest struct{}
+
+// EnableTimestamps command, wraps sets in an envelope with the time they were
+// received. Version 2 of the envelope also holds sequence numbers.
+type EnableTimestamps struct {
+	Version byte `json:"version"`
+}
+
+// DisableTimestamps command, forwards bare sets again
+type DisableTimestamps struct{}
+
+// SetRate command, limits the sets forwarded to this client to the given number
+// per second, zero forwards all sets
+type SetRate struct {
+	Rate float64 `json:"rate"`
+}
+
+// SetLayout command, selects whether this client receives sets as sent by the
+// device ("sparse") or as complete matrices ("dense"). Dense matrices have the
+// dimensions of the device unless given.
+type SetLayout struct {
+	Layout  string `json:"layout"`
+	Rows    int    `json:"rows"`
+	Columns int    `json:"columns"`
+}
+
+// SetSampleFormat command, selects the bitdepth of samples (8 or 12) for all
+// clients of the device
+type SetSampleFormat struct {
+	Bitdepth int `json:"bitdepth"`
+}
+
+// Calibrate command, records baselines of the idle mat during the given number
+// of seconds, to be subtracted from sets for all clients
+type Calibrate struct {
+	Duration float64 `json:"duration"`
+}
+
+// ClearCalibration command, stops subtracting baselines
+type ClearCalibration struct{}
+
+// ReplayLastSession command, streams the most recent session retained for
+// backfilling again to the requesting client, at the original timing
+type ReplayLastSession struct{}
+
+// StopReplay command, resumes the live stream before the replay is finished
+type StopReplay struct{}
+
+// TraceFrames command, writes hex dumps of all sets read from the device to a
+// file for the given number of seconds
+type TraceFrames struct {
+	Duration float64 `json:"duration"`
+}
+
+// GetDeadCells command, requests the cells masked for the connected device
+type GetDeadCells struct{}
+
+// SetDeadCells command, replaces the cells masked for the connected device
+type SetDeadCells struct {
+	Mask
+}
+
+// DetectDeadCells command, masks cells reporting values on the idle mat during
+// the given number of seconds
+type DetectDeadCells struct {
+	Duration float64 `json:"duration"`
+}
+
+// ConfirmPairing command, confirms the device awaiting pairing
+type ConfirmPairing struct {
+	// Device awaiting pairing, as announced in PairingRequired
+	Device string `json:"device"`
+}
+
+// GetRecentFrames command, requests sets received during the given number of
+// seconds (all retained sets if zero)
+type GetRecentFrames struct {
+	Seconds float64 `json:"seconds"`
+}
+
+// SubscribeMetrics command, requests derived metrics at the given rate per
+// second, replacing any previous subscription
+type SubscribeMetrics struct {
+	Metrics []string `json:"metrics"`
+	Rate    float64  `json:"rate"`
+}
+
+// UnsubscribeMetrics command, stops sending metrics
+type UnsubscribeMetrics struct{}
+
+// UpdateFirmware command, flashes a base64 encoded Intel HEX image
+type UpdateFirmware struct {
+	SerialNumber string `json:"serialNumber"`
+	Image        string `json:"image"`
+	// Update even if other clients stream from the device
+	Force bool `json:"force"`
+}
+
+// PowerCycleDevice command, switches the USB port of the device with given
+// serial number (or the only one) off and on again
+type PowerCycleDevice struct {
+	SerialNumber string `json:"serialNumber"`
+}
+
+// Confirm command, runs a destructive command held back until the client
+// echoes the token it was sent in a ConfirmationRequired message
+type Confirm struct {
+	Token string `json:"token"`
+}
+
+// UnmarshalJSON implements encoding/json Unmarshaler interface
+func (command *Command) UnmarshalJSON(data []byte) error {
+	return command.decode(data, json.Unmarshal)
+}
+
+// UnmarshalCBOR implements cbor.Unmarshaler interface
+func (command *Command) UnmarshalCBOR(data []byte) error {
+	return command.decode(data, codec.UnmarshalCBOR)
+}
+
+// Decode a command with the unmarshal function of the client's encoding
+func (command *Command) decode(data []byte, unmarshal func([]byte, interface{}) error) error {
+
+	// Helper struct to get type
+	temp := struct {
+		Type string `json:"type"`
+	}{}
+	if err := unmarshal(data, &temp); err != nil {
+		return err
+	}
+
+	if temp.Type == "GetStatus" {
+		command.GetStatus = &GetStatus{}
+
+	} else if temp.Type == "GetDeviceInfo" {
+		command.GetDeviceInfo = &GetDeviceInfo{}
+
+	} else if temp.Type == "Connect" {
+		err := unmarshal(data, &command.Connect)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "SetRegionOfInterest" {
+		err := unmarshal(data, &command.SetRegionOfInterest)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "ClearRegionOfInterest" {
+		command.ClearRegionOfInterest = &ClearRegionOfInterest{}
+
+	} else if temp.Type == "EnableTimestamps" {
+		err := unmarshal(data, &command.EnableTimestamps)
+		if err != nil {
+			return err
+		}
+		if command.EnableTimestamps.Version == 0 {
+			command.EnableTimestamps.Version = DRIVER_PROTOCOL_VERSION
+		}
+		if command.EnableTimestamps.Version != DRIVER_PROTOCOL_VERSION && command.EnableTimestamps.Version != SEQUENCED_PROTOCOL_VERSION {
+			return fmt.Errorf("unsupported envelope version %d", command.EnableTimestamps.Version)
+		}
+
+	} else if temp.Type == "DisableTimestamps" {
+		command.DisableTimestamps = &DisableTimestamps{}
+
+	} else if temp.Type == "SetSampleFormat" {
+		err := unmarshal(data, &command.SetSampleFormat)
+		if err != nil {
+			return err
+		}
+		if _, ok := sampleFormats[command.SetSampleFormat.Bitdepth]; !ok {
+			return fmt.Errorf("unsupported bitdepth %d", command.SetSampleFormat.Bitdepth)
+		}
+
+	} else if temp.Type == "Calibrate" {
+		err := unmarshal(data, &command.Calibrate)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "ClearCalibration" {
+		command.ClearCalibration = &ClearCalibration{}
+
+	} else if temp.Type == "ReplayLastSession" {
+		command.ReplayLastSession = &ReplayLastSession{}
+
+	} else if temp.Type == "StopReplay" {
+		command.StopReplay = &StopReplay{}
+
+	} else if temp.Type == "TraceFrames" {
+		err := unmarshal(data, &command.TraceFrames)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "Ping" {
+		err := unmarshal(data, &command.Ping)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "SetRate" {
+		err := unmarshal(data, &command.SetRate)
+		if err != nil {
+			return err
+		}
+		if command.SetRate.Rate < 0 {
+			return fmt.Errorf("negative rate %v", command.SetRate.Rate)
+		}
+
+	} else if temp.Type == "SetLayout" {
+		err := unmarshal(data, &command.SetLayout)
+		if err != nil {
+			return err
+		}
+		if err := validateLayout(command.SetLayout.Layout); err != nil {
+			return err
+		}
+		if command.SetLayout.Rows < 0 || command.SetLayout.Rows > 256 || command.SetLayout.Columns < 0 || command.SetLayout.Columns > 256 {
+			return fmt.Errorf("invalid dimensions %dx%d", command.SetLayout.Rows, command.SetLayout.Columns)
+		}
+
+	} else if temp.Type == "GetDeadCells" {
+		command.GetDeadCells = &GetDeadCells{}
+
+	} else if temp.Type == "SetDeadCells" {
+		err := unmarshal(data, &command.SetDeadCells)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "DetectDeadCells" {
+		err := unmarshal(data, &command.DetectDeadCells)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "ConfirmPairing" {
+		err := unmarshal(data, &command.ConfirmPairing)
+		if err != nil {
+			return err
+		}
+		if command.ConfirmPairing.Device == "" {
+			return errors.New("device awaiting pairing is required")
+		}
+
+	} else if temp.Type == "GetRecentFrames" {
+		err := unmarshal(data, &command.GetRecentFrames)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "SubscribeMetrics" {
+		err := unmarshal(data, &command.SubscribeMetrics)
+		if err != nil {
+			return err
+		}
+		err = validateMetrics(command.SubscribeMetrics.Metrics)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "UnsubscribeMetrics" {
+		command.UnsubscribeMetrics = &UnsubscribeMetrics{}
+
+	} else if temp.Type == "UpdateFirmware" {
+		err := unmarshal(data, &command.UpdateFirmware)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "PowerCycleDevice" {
+		err := unmarshal(data, &command.PowerCycleDevice)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "Confirm" {
+		err := unmarshal(data, &command.Confirm)
+		if err != nil {
+			return err
+		}
+
+	} else {
+		return errors.New("can not decode unknown command")
+	}
+
+	return nil
+}
+
+// Message that can be sent to Play
+type Message struct {
+	*Status
+	DeviceDetails   *DeviceDetails
+	ConfigReloaded  *config.Changes
+	PairingRequired *string
+	Paired          *string
+	// Sent by the driver when a device stopped sending data
+	DeviceUnresponsive *catalog.Text
+	// Sent while a device re-enumerates, and once it is back
+	StreamInterrupted *StreamInterruption
+	StreamResumed     *StreamInterruption
+	// Path of a serial port held by another program
+	PortInUse    *string
+	RecentFrames *[]RecentFrame
+	Calibration  *CalibrationState
+	DeadCells    *Mask
+	Replay       *ReplayState
+	ClockJump    *clock.Jump
+	Metrics      *Metrics
+
+	FrameTraceStarted *logging.TraceStart
+	FrameTraceStopped *logging.TraceSummary
+
+	Latency *Latency
+
+	Deprecated *deprecation.Warning
+
+	FirmwareUpdateMessage *FirmwareUpdateMessage
+	PermissionDenied      *auth.PermissionDenied
+	FirmwareUpdateBusy    *sessions.Busy
+	DeviceStateChanged    *StateChange
+	PowerCycle            *PowerCycleState
+	ConfirmationRequired  *confirm.Request
+	// Token of a confirmation that is unknown or expired
+	ConfirmationInvalid *string
+	// Why a binary command could not be written to the device
+	CommandFailed *string
+
+	// Language catalog texts are rendered in
+	language string
+}
+
+// PowerCycleState reports progress and outcome of a power cycle: "progress",
+// "success" or "failure"
+type PowerCycleState struct {
+	State   string `json:"state"`
+	Message catalog.Text
+}
+
+// StateChange attributes a change of device state to the client causing it,
+// so other clients can explain the change to their users
+type StateChange struct {
+	// Command changing the state, e.g. "SetSampleFormat"
+	Action string `json:"action"`
+	// Session of the initiating client, as reported to it in Status
+	Session int              `json:"session"`
+	Client  sessions.Session `json:"client"`
+}
+
+// FirmwareUpdateMessage reports progress and outcome of a firmware update
+type FirmwareUpdateMessage struct {
+	FirmwareUpdateProgress *catalog.Text
+	FirmwareUpdateSuccess  *catalog.Text
+	FirmwareUpdateFailure  *catalog.Text
+}
+
+// ReplayState reports start and end of a replay: "started", "finished" or
+// "stopped" if it was stopped early or nothing was retained
+type ReplayState struct {
+	State string `json:"state"`
+	Sets  int    `json:"sets"`
+	// Duration of the replayed session in seconds
+	Duration float64 `json:"duration"`
+}
+
+// RecentFrame is a set received before it was requested, samples are encoded in base64
+type RecentFrame struct {
+	ReceivedAt time.Time `json:"receivedAt"`
+	Samples    []byte    `json:"samples"`
+}
+
+// Metrics derived from the most recent set, by name
+type Metrics struct {
+	ReceivedAt time.Time
+	Values     map[string]interface{}
+}
+
+// DeviceDetails answers GetDeviceInfo
+type DeviceDetails struct {
+	// Nil if not connected
+	Device *DeviceInfo
+	// Dimensions of the sensor matrix seen in sets so far, nil before the first set
+	Rows    *int
+	Columns *int
+}
+
+// Status is a message containing status information
+type Status struct {
+	Device *DeviceInfo
+	// Path or serial number of the selected device, empty if any
+	Address string
+	// Device awaiting confirmation by an operator, nil if none
+	PairingRequired *string
+	// Sets missed by clients
+	Drops drops.Snapshot
+	// Paths of serial ports held by other programs
+	PortsInUse []string
+	// Session of the client receiving the status, to recognize its own state changes
+	Session int
+}
+
+// MarshalJSON implements JSON encoder for messages
+func (message *Message) MarshalJSON() ([]byte, error) {
+	wire, err := message.wire()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(wire)
+}
+
+// MarshalCBOR implements cbor.Marshaler interface
+func (message *Message) MarshalCBOR() ([]byte, error) {
+	wire, err := message.wire()
+	if err != nil {
+		return nil, err
+	}
+	return codec.MarshalCBOR(wire)
+}
+
+// Message as encoded for the client, with its type
+func (message *Message) wire() (interface{}, error) {
+	if message.Status != nil {
+		var address *string
+		if message.Status.Address != "" {
+			address = &message.Status.Address
+		}
+
+		return &struct {
+			Type            string         `json:"type"`
+			Device          *DeviceInfo    `json:"device"`
+			Address         *string        `json:"address"`
+			PairingRequired *string        `json:"pairingRequired,omitempty"`
+			Drops           drops.Snapshot `json:"drops"`
+			PortsInUse      []string       `json:"portsInUse"`
+			Session         int            `json:"session"`
+		}{
+			Type:            "Status",
+			Device:          message.Status.Device,
+			Address:         address,
+			PairingRequired: message.Status.PairingRequired,
+			Drops:           message.Status.Drops,
+			PortsInUse:      message.Status.PortsInUse,
+			Session:         message.Status.Session,
+		}, nil
+
+	} else if message.DeviceDetails != nil {
+		return &struct {
+			Type    string      `json:"type"`
+			Device  *DeviceInfo `json:"device"`
+			Rows    *int        `json:"rows"`
+			Columns *int        `json:"columns"`
+		}{
+			Type:    "DeviceInfo",
+			Device:  message.DeviceDetails.Device,
+			Rows:    message.DeviceDetails.Rows,
+			Columns: message.DeviceDetails.Columns,
+		}, nil
+
+	} else if message.ConfigReloaded != nil {
+		return &struct {
+			Type            string   `json:"type"`
+			Applied         []string `json:"applied"`
+			RestartRequired []string `json:"restartRequired"`
+		}{
+			Type:            "ConfigReloaded",
+			Applied:         message.ConfigReloaded.Applied,
+			RestartRequired: message.ConfigReloaded.RestartRequired,
+		}, nil
+
+	} else if message.PairingRequired != nil {
+		return &struct {
+			Type   string `json:"type"`
+			Device string `json:"device"`
+		}{
+			Type:   "PairingRequired",
+			Device: *message.PairingRequired,
+		}, nil
+
+	} else if message.Paired != nil {
+		return &struct {
+			Type   string `json:"type"`
+			Device string `json:"device"`
+		}{
+			Type:   "Paired",
+			Device: *message.Paired,
+		}, nil
+
+	} else if message.DeviceUnresponsive != nil {
+		return &struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+			catalog.Text
+		}{
+			Type:    "DeviceUnresponsive",
+			Message: message.DeviceUnresponsive.In(message.language),
+			Text:    *message.DeviceUnresponsive,
+		}, nil
+
+	} else if message.StreamInterrupted != nil {
+		return &struct {
+			Type string `json:"type"`
+			StreamInterruption
+		}{
+			Type:               "StreamInterrupted",
+			StreamInterruption: *message.StreamInterrupted,
+		}, nil
+
+	} else if message.StreamResumed != nil {
+		return &struct {
+			Type string `json:"type"`
+			StreamInterruption
+		}{
+			Type:               "StreamResumed",
+			StreamInterruption: *message.StreamResumed,
+		}, nil
+
+	} else if message.PortInUse != nil {
+		return &struct {
+			Type string `json:"type"`
+			Path string `json:"path"`
+		}{
+			Type: "PortInUse",
+			Path: *message.PortInUse,
+		}, nil
+
+	} else if message.Calibration != nil {
+		return &struct {
+			Type string `json:"type"`
+			CalibrationState
+		}{
+			Type:             "Calibration",
+			CalibrationState: *message.Calibration,
+		}, nil
+
+	} else if message.ClockJump != nil {
+		return &struct {
+			Type string `json:"type"`
+			clock.Jump
+		}{
+			Type: "ClockJump",
+			Jump: *message.ClockJump,
+		}, nil
+
+	} else if message.FrameTraceStarted != nil {
+		return &struct {
+			Type string `json:"type"`
+			logging.TraceStart
+		}{
+			Type:       "FrameTraceStarted",
+			TraceStart: *message.FrameTraceStarted,
+		}, nil
+
+	} else if message.FrameTraceStopped != nil {
+		return &struct {
+			Type string `json:"type"`
+			logging.TraceSummary
+		}{
+			Type:         "FrameTraceStopped",
+			TraceSummary: *message.FrameTraceStopped,
+		}, nil
+
+	} else if message.Latency != nil {
+		return &struct {
+			Type string `json:"type"`
+			Latency
+		}{
+			Type:    "Latency",
+			Latency: *message.Latency,
+		}, nil
+
+	} else if message.Deprecated != nil {
+		return &struct {
+			Type string `json:"type"`
+			deprecation.Warning
+		}{
+			Type:    "Deprecated",
+			Warning: *message.Deprecated,
+		}, nil
+
+	} else if message.Replay != nil {
+		return &struct {
+			Type string `json:"type"`
+			ReplayState
+		}{
+			Type:        "Replay",
+			ReplayState: *message.Replay,
+		}, nil
+
+	} else if message.DeadCells != nil {
+		return &struct {
+			Type string `json:"type"`
+			Mask
+		}{
+			Type: "DeadCells",
+			Mask: *message.DeadCells,
+		}, nil
+
+	} else if message.RecentFrames != nil {
+		return &struct {
+			Type   string        `json:"type"`
+			Frames []RecentFrame `json:"frames"`
+		}{
+			Type:   "RecentFrames",
+			Frames: *message.RecentFrames,
+		}, nil
+
+	} else if message.Metrics != nil {
+		return &struct {
+			Type       string                 `json:"type"`
+			ReceivedAt time.Time              `json:"receivedAt"`
+			Values     map[string]interface{} `json:"values"`
+		}{
+			Type:       "Metrics",
+			ReceivedAt: message.Metrics.ReceivedAt,
+			Values:     message.Metrics.Values,
+		}, nil
+
+	} else if message.FirmwareUpdateMessage != nil {
+		fwUpdate := struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+			catalog.Text
+		}{}
+
+		if message.FirmwareUpdateMessage.FirmwareUpdateProgress != nil {
+			fwUpdate.Type = "FirmwareUpdateProgress"
+			fwUpdate.Text = *message.FirmwareUpdateMessage.FirmwareUpdateProgress
+		} else if message.FirmwareUpdateMessage.FirmwareUpdateFailure != nil {
+			fwUpdate.Type = "FirmwareUpdateFailure"
+			fwUpdate.Text = *message.FirmwareUpdateMessage.FirmwareUpdateFailure
+		} else if message.FirmwareUpdateMessage.FirmwareUpdateSuccess != nil {
+			fwUpdate.Type = "FirmwareUpdateSuccess"
+			fwUpdate.Text = *message.FirmwareUpdateMessage.FirmwareUpdateSuccess
+		} else {
+			return nil, errors.New("could not marshal firmware update message")
+		}
+
+		fwUpdate.Message = fwUpdate.Text.In(message.language)
+		return fwUpdate, nil
+
+	} else if message.FirmwareUpdateBusy != nil {
+		return &struct {
+			Type     string             `json:"type"`
+			Sessions []sessions.Session `json:"sessions"`
+			Queued   bool               `json:"queued"`
+		}{
+			Type:     "FirmwareUpdateBusy",
+			Sessions: message.FirmwareUpdateBusy.Sessions,
+			Queued:   message.FirmwareUpdateBusy.Queued,
+		}, nil
+
+	} else if message.PermissionDenied != nil {
+		return &struct {
+			Type     string `json:"type"`
+			Command  string `json:"command"`
+			Role     string `json:"role"`
+			Required string `json:"required"`
+		}{
+			Type:     "PermissionDenied",
+			Command:  message.PermissionDenied.Command,
+			Role:     message.PermissionDenied.Role,
+			Required: message.PermissionDenied.Required,
+		}, nil
+
+	} else if message.ConfirmationRequired != nil {
+		return &struct {
+			Type string `json:"type"`
+			confirm.Request
+		}{
+			Type:    "ConfirmationRequired",
+			Request: *message.ConfirmationRequired,
+		}, nil
+
+	} else if message.ConfirmationInvalid != nil {
+		return &struct {
+			Type  string `json:"type"`
+			Token string `json:"token"`
+		}{
+			Type:  "ConfirmationInvalid",
+			Token: *message.ConfirmationInvalid,
+		}, nil
+
+	} else if message.CommandFailed != nil {
+		return &struct {
+			Type  string `json:"type"`
+			Error string `json:"error"`
+		}{
+			Type:  "CommandFailed",
+			Error: *message.CommandFailed,
+		}, nil
+
+	} else if message.PowerCycle != nil {
+		return &struct {
+			Type    string `json:"type"`
+			State   string `json:"state"`
+			Message string `json:"message"`
+			catalog.Text
+		}{
+			Type:    "PowerCycle",
+			State:   message.PowerCycle.State,
+			Message: message.PowerCycle.Message.In(message.language),
+			Text:    message.PowerCycle.Message,
+		}, nil
+
+	} else if message.DeviceStateChanged != nil {
+		return &struct {
+			Type string `json:"type"`
+			StateChange
+		}{
+			Type:        "DeviceStateChanged",
+			StateChange: *message.DeviceStateChanged,
+		}, nil
+	}
+
+	return nil, errors.New("could not marshal message")
+}
+
 // Implement net/http Handler interface
 func (handle *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Set up logger
+	connectionID := hooks.NewID()
 	var log = handle.log.WithFields(logrus.Fields{
+		"connectionId":  connectionID,
 		"clientAddress": r.RemoteAddr,
 		"userAgent":     r.UserAgent(),
 	})
 
+	// Mat of a multiplexing controller the client streams from
+	index, err := parseMat(r.URL.Query().Get("mat"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m := handle.mats[index]
+	log = log.WithField("mat", index)
+
+	// Encoding of messages and commands
+	encoding, err := codec.Negotiate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Update to WebSocket
 	conn, err := webSocketUpgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -31,12 +903,37 @@ func (handle *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	log.Info("WebSocket connection opened")
 
+	// Measures round trips for the Ping command
+	pinger := latency.NewClientPinger(conn)
+
+	// Role of clients authenticated with a token, unrestricted otherwise
+	role := auth.RoleFrom(r.Context())
+
+	// Language user-facing messages are rendered in
+	language := catalog.Language(r)
+
+	client := hooks.Client{ID: connectionID, Endpoint: "flex", Address: r.RemoteAddr, UserAgent: r.UserAgent()}
+	handle.Hooks.ClientConnected(client)
+	session := handle.sessions.Open(sessions.Session{Address: r.RemoteAddr, UserAgent: r.UserAgent(), Since: time.Now().UTC()})
+
 	// Create a mutex for writing to WebSocket (connection supports only one concurrent reader and one concurrent writer (https://godoc.org/github.com/gorilla/websocket#hdr-Concurrency))
 	writeMutex := sync.Mutex{}
 
 	// Create a context for this WebSocket connection
 	ctx, cancel := context.WithCancel(context.Background())
 
+	// Close connections of temporary sessions once they expire
+	if expires, ok := auth.ExpiryFrom(r.Context()); ok {
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(expires)):
+				log.Info("Closing WebSocket connection of expired session.")
+				conn.Close()
+			}
+		}()
+	}
+
 	// Send binary data up the WebSocket
 	sendBinary := func(data []byte) error {
 		writeMutex.Lock()
@@ -52,18 +949,92 @@ func (handle *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return nil
 	}
 
-	// Create channels with data received from SensingTex controller
-	rx := handle.broker.Sub("flex-rx")
+	// send message up the WebSocket
+	sendMessage := func(message Message) error {
+		message.language = language
+		messageType, payload, err := encoding.Encode(&message)
+		if err != nil {
+			log.WithError(err).Error("Could not encode message.")
+			return err
+		}
+		writeMutex.Lock()
+		conn.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+		err = conn.WriteMessage(messageType, payload)
+		writeMutex.Unlock()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.WithError(err).Error("WebSocket error")
+			}
+			return err
+		}
+		if messageType == websocket.TextMessage {
+			handle.Hooks.TextExchanged(client, false, payload)
+		}
+		return nil
+	}
+
+	// Samples outside of the client's region of interest are not forwarded,
+	// sets are decimated, assembled into matrices and timestamped if the client
+	// asked for it. Metrics are computed from all sets.
+	stream := &clientStream{role: role, session: session, mat: m, pinger: pinger, sendMessage: sendMessage}
+	stream.rate.set(parseRate(r.URL.Query().Get("rate")))
+	stream.layout.set(r.URL.Query().Get("layout"), 0, 0)
+	sendSet := func(set measurementSet) error {
+		samples := stream.roi.apply(set)
+		stream.metrics.offer(measurementSet{samples: samples, receivedAt: set.receivedAt, format: set.format})
+		if !stream.rate.keep(set) {
+			return nil
+		}
+		frame := stream.stamps.apply(set, stream.layout.apply(samples, set.format, m.matrix))
+		err := sendBinary(frame)
+		if err == nil {
+			handle.Hooks.FrameForwarded(client, frame)
+		}
+		return err
+	}
+
+	// Sets received from the device and messages meant for all clients, or the
+	// clients of the mat, share a channel, so they are sent in the order they
+	// were published
+	outbound := handle.broker.SubFor(ctx, m.dataTopic(), "flex-broadcast", m.broadcastTopic())
 
-	// send data from device
-	go rx_data_loop(ctx, rx, sendBinary)
+	// Live sets are held back while replaying
+	stream.replaying.send = sendSet
+	received := m.rxDrops.Subscribe(r.RemoteAddr)
+	sendLive := func(set measurementSet) error {
+		received.Received(set.sequence)
+		if stream.replaying.active() {
+			return nil
+		}
+		return sendSet(set)
+	}
+
+	go func() {
+		outbound_loop(ctx, outbound, sendLive, sendMessage)
+		// Clients that can not be sent to are disconnected, ending the subscription
+		conn.Close()
+	}()
+
+	// Mirrors are sent the status instead of asking for it
+	if role == auth.Mirror {
+		go status_loop(ctx, mirrorStatusInterval, func() error {
+			return sendMessage(handle.status(m, session))
+		})
+	}
 
 	// Helper function to close the connection
 	close := func() {
-		handle.broker.Unsub(rx)
+		handle.broker.Unsub(outbound)
+		received.Close()
 
+		handle.sessions.Close(session)
+		handle.confirmations.Drop(session)
 		handle.DeregisterSubscriber()
 
+		// Stop computing metrics
+		stream.metrics.set(nil)
+		stream.replaying.stop()
+
 		// Cancel the context
 		cancel()
 
@@ -71,11 +1042,20 @@ func (handle *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		conn.Close()
 
 		log.Info("Websocket connection closed")
+
+		handle.Hooks.ClientDisconnected(client)
 	}
 
 	// Start connecting to devices
 	handle.Connect()
 
+	// Clients should select the device instead of streaming from any
+	if handle.strict && handle.SelectedAddress() == "" {
+		warning := deprecation.ImplicitConnect
+		log.WithField("deprecation", warning.Feature).Warn("Client relies on connecting to any Flex device.")
+		sendMessage(Message{Deprecated: &warning})
+	}
+
 	// Main loop for the WebSocket connection
 	go func() {
 		defer close()
@@ -88,8 +1068,48 @@ func (handle *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				}
 				return
 			}
-			if messageType == websocket.BinaryMessage {
-				handle.broker.TryPub(msg, "flex-tx")
+			// Commands in the negotiated encoding
+			var command Command
+			isCommand, decodeErr := encoding.Decode(messageType, msg, &command)
+
+			if messageType == websocket.BinaryMessage && !isCommand {
+				if !role.Allows(auth.Operator) {
+					denied := auth.Deny("binary", role, auth.Operator)
+					sendMessage(Message{PermissionDenied: &denied})
+					continue
+				}
+				// Raw bitdepth commands would leave the parser behind, configure it as well
+				if format, ok := formatForCommand(msg); ok {
+					handle.SelectBitdepth(format.bitdepth)
+					handle.announceChange(nil, session, "SetSampleFormat")
+					continue
+				}
+				if err := handle.sendCommand(msg); err != nil {
+					log.WithError(err).Info("Could not write command to Flex device.")
+					failure := err.Error()
+					sendMessage(Message{CommandFailed: &failure})
+					continue
+				}
+				handle.announceChange(nil, session, "BinaryCommand")
+
+			} else if isCommand {
+				if messageType == websocket.TextMessage {
+					handle.Hooks.TextExchanged(client, true, msg)
+				}
+
+				if decodeErr != nil {
+					log.WithField("rawCommand", msg).WithError(decodeErr).Warning("Can not decode command.")
+					continue
+				}
+				// Entries caused by the command can be told apart from those of other commands
+				log := log.WithField("commandId", hooks.NewID())
+				log.WithField("command", prettyPrintCommand(command)).Debug("Received command.")
+				handle.Hooks.CommandReceived(client, prettyPrintCommand(command))
+
+				err := handle.dispatchCommand(ctx, log, command, stream)
+				if err != nil {
+					return
+				}
 			}
 		}
 	}()
@@ -98,18 +1118,297 @@ func (handle *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // HELPERS
 
-// rx_data_loop reads data from SensingTex and forwards it up the WebSocket
-func rx_data_loop(ctx context.Context, rx chan interface{}, send func([]byte) error) {
+// Interval of status messages to mirrors
+const mirrorStatusInterval = 5 * time.Second
+
+// Role a client needs to issue the command, settings of the client's own stream only need observing
+func requiredRole(command Command) auth.Role {
+	if command.UpdateFirmware != nil || command.TraceFrames != nil {
+		return auth.Maintenance
+	} else if command.Connect != nil || command.SetSampleFormat != nil || command.Calibrate != nil || command.ClearCalibration != nil || command.SetDeadCells != nil || command.DetectDeadCells != nil || command.ConfirmPairing != nil || command.PowerCycleDevice != nil {
+		return auth.Operator
+	}
+	return auth.Observer
+}
+
+// State of a single client's WebSocket connection that its commands act on
+type clientStream struct {
+	role    auth.Role
+	session int
+	mat     *mat
+	pinger  *latency.ClientPinger
+
+	roi       regionOfInterest
+	stamps    timestamps
+	rate      decimation
+	layout    outputLayout
+	metrics   metricsSubscription
+	replaying replay
+
+	sendMessage func(Message) error
+}
+
+// dispatchCommand handles incoming commands and sends responses back up the WebSocket
+func (handle *Handle) dispatchCommand(ctx context.Context, log *logrus.Entry, command Command, stream *clientStream) error {
+
+	if required := requiredRole(command); !stream.role.Allows(required) {
+		denied := auth.Deny(prettyPrintCommand(command), stream.role, required)
+		log.WithField("command", denied.Command).WithField("role", denied.Role).Info("Denying command not permitted for role.")
+		return stream.sendMessage(Message{PermissionDenied: &denied})
+	}
+
+	if command.GetStatus != nil {
+		return stream.sendMessage(handle.status(stream.mat, stream.session))
+
+	} else if command.GetDeviceInfo != nil {
+		details := DeviceDetails{Device: stream.mat.getDevice()}
+		if details.Device != nil {
+			details.Rows, details.Columns = stream.mat.matrix.get()
+		}
+		return stream.sendMessage(Message{DeviceDetails: &details})
+
+	} else if command.Connect != nil {
+		handle.SelectDevice(command.Connect.Address)
+		handle.announceChange(nil, stream.session, "Connect")
+
+	} else if command.SetRegionOfInterest != nil {
+		region := command.SetRegionOfInterest.Region
+		log.WithField("region", region).Debug("Restricting samples to region of interest.")
+		stream.roi.set(&region)
+
+	} else if command.ClearRegionOfInterest != nil {
+		stream.roi.set(nil)
+
+	} else if command.GetRecentFrames != nil {
+		since := time.Time{}
+		if seconds := command.GetRecentFrames.Seconds; seconds > 0 {
+			since = time.Now().Add(-time.Duration(seconds * float64(time.Second)))
+		}
+
+		frames := []RecentFrame{}
+		for _, set := range stream.mat.history.since(since) {
+			frames = append(frames, RecentFrame{ReceivedAt: set.receivedAt, Samples: stream.roi.apply(set)})
+		}
+		return stream.sendMessage(Message{RecentFrames: &frames})
+
+	} else if command.TraceFrames != nil {
+		if handle.frameTrace == nil {
+			log.Info("Can not trace frames, no directory is configured.")
+			return stream.sendMessage(Message{FrameTraceStopped: &logging.TraceSummary{Reason: "error"}})
+		}
+		started, err := handle.frameTrace.Start("flex", time.Duration(command.TraceFrames.Duration*float64(time.Second)), func(summary logging.TraceSummary) {
+			log.WithField("file", summary.File).WithField("frames", summary.Frames).WithField("reason", summary.Reason).Info("Stopped tracing frames.")
+			stream.sendMessage(Message{FrameTraceStopped: &summary})
+		})
+		if err != nil {
+			log.WithError(err).Warn("Could not start tracing frames.")
+			return stream.sendMessage(Message{FrameTraceStopped: &logging.TraceSummary{Reason: "error"}})
+		}
+		log.WithField("file", started.File).WithField("duration", started.Duration).Info("Tracing frames.")
+		return stream.sendMessage(Message{FrameTraceStarted: &started})
+
+	} else if command.Ping != nil {
+		// Pongs of the client are only noticed while its commands are read
+		ping := *command.Ping
+		go func() {
+			result := handle.ping(ctx, ping, stream.pinger)
+			log.WithField("device", result.DeviceRoundTrip).WithField("client", result.ClientRoundTrip).Info("Measured latency.")
+			stream.sendMessage(Message{Latency: &result})
+		}()
+		return nil
+
+	} else if command.ReplayLastSession != nil {
+		sets := stream.mat.history.lastSession()
+		if len(sets) == 0 {
+			return stream.sendMessage(Message{Replay: &ReplayState{State: "stopped"}})
+		}
+		state := ReplayState{Sets: len(sets), Duration: sets[len(sets)-1].receivedAt.Sub(sets[0].receivedAt).Seconds()}
+		log.WithField("sets", state.Sets).WithField("duration", state.Duration).Info("Replaying last session.")
+
+		started := state
+		started.State = "started"
+		err := stream.sendMessage(Message{Replay: &started})
+		if err != nil {
+			return err
+		}
+		stream.replaying.start(ctx, sets, func(completed bool) {
+			ended := state
+			ended.State = "stopped"
+			if completed {
+				ended.State = "finished"
+			}
+			stream.sendMessage(Message{Replay: &ended})
+		})
+
+	} else if command.StopReplay != nil {
+		stream.replaying.stop()
+
+	} else if command.SubscribeMetrics != nil {
+		log.WithField("metrics", command.SubscribeMetrics.Metrics).WithField("rate", command.SubscribeMetrics.Rate).Debug("Subscribing to metrics.")
+		stream.metrics.set(startMetrics(command.SubscribeMetrics.Metrics, command.SubscribeMetrics.Rate, stream.sendMessage))
+
+	} else if command.UnsubscribeMetrics != nil {
+		stream.metrics.set(nil)
+
+	} else if command.SetSampleFormat != nil {
+		handle.SelectBitdepth(command.SetSampleFormat.Bitdepth)
+		handle.announceChange(nil, stream.session, "SetSampleFormat")
+
+	} else if command.Calibrate != nil {
+		handle.Calibrate(stream.mat.index, time.Duration(command.Calibrate.Duration*float64(time.Second)))
+		handle.announceChange(stream.mat, stream.session, "Calibrate")
+
+	} else if command.ClearCalibration != nil {
+		handle.ClearCalibration(stream.mat.index)
+		handle.announceChange(stream.mat, stream.session, "ClearCalibration")
+
+	} else if command.GetDeadCells != nil {
+		mask := handle.GetMask(stream.mat.index)
+		return stream.sendMessage(Message{DeadCells: &mask})
+
+	} else if command.SetDeadCells != nil {
+		handle.SetMask(stream.mat.index, command.SetDeadCells.Mask)
+		handle.announceChange(stream.mat, stream.session, "SetDeadCells")
+
+	} else if command.DetectDeadCells != nil {
+		handle.DetectDeadCells(stream.mat.index, time.Duration(command.DetectDeadCells.Duration*float64(time.Second)))
+		handle.announceChange(stream.mat, stream.session, "DetectDeadCells")
+
+	} else if command.EnableTimestamps != nil {
+		stream.stamps.set(command.EnableTimestamps.Version)
+
+	} else if command.DisableTimestamps != nil {
+		stream.stamps.set(0)
+
+	} else if command.SetRate != nil {
+		log.WithField("rate", command.SetRate.Rate).Debug("Limiting rate of forwarded sets.")
+		stream.rate.set(command.SetRate.Rate)
+
+	} else if command.SetLayout != nil {
+		log.WithField("layout", command.SetLayout.Layout).Debug("Changing layout of forwarded sets.")
+		stream.layout.set(command.SetLayout.Layout, command.SetLayout.Rows, command.SetLayout.Columns)
+
+	} else if command.ConfirmPairing != nil {
+		handle.ConfirmPairing(command.ConfirmPairing.Device)
+		handle.announceChange(nil, stream.session, "ConfirmPairing")
+
+	} else if command.UpdateFirmware != nil {
+		request := handle.confirmations.Hold(stream.session, "UpdateFirmware", describeFirmwareUpdate(*command.UpdateFirmware), func() {
+			go func() {
+				// Do not disrupt clients streaming from the device, unless forced
+				if !command.UpdateFirmware.Force && handle.streamsFrom(command.UpdateFirmware.SerialNumber) && !handle.sessions.Guard(ctx, stream.session, handle.busyPolicy, func(busy sessions.Busy) {
+					log.WithField("sessions", len(busy.Sessions)).WithField("queued", busy.Queued).Info("Holding back firmware update while other clients stream from the device.")
+					stream.sendMessage(Message{FirmwareUpdateBusy: &busy})
+				}) {
+					return
+				}
+
+				handle.announceChange(nil, stream.session, "UpdateFirmware")
+				handle.ProcessFirmwareUpdateRequest(*command.UpdateFirmware, SendMsg{
+					progress: func(msg catalog.Text) {
+						stream.sendMessage(firmwareUpdateMessage(FirmwareUpdateMessage{FirmwareUpdateProgress: &msg}))
+					},
+					failure: func(msg catalog.Text) {
+						stream.sendMessage(firmwareUpdateMessage(FirmwareUpdateMessage{FirmwareUpdateFailure: &msg}))
+					},
+					success: func(msg catalog.Text) {
+						stream.sendMessage(firmwareUpdateMessage(FirmwareUpdateMessage{FirmwareUpdateSuccess: &msg}))
+					},
+				})
+			}()
+		})
+		return stream.sendMessage(Message{ConfirmationRequired: &request})
+
+	} else if command.PowerCycleDevice != nil {
+		request := handle.confirmations.Hold(stream.session, "PowerCycleDevice", describePowerCycle(*command.PowerCycleDevice), func() {
+			handle.announceChange(nil, stream.session, "PowerCycleDevice")
+			go handle.ProcessPowerCycleRequest(*command.PowerCycleDevice, SendMsg{
+				progress: func(msg catalog.Text) {
+					stream.sendMessage(Message{PowerCycle: &PowerCycleState{State: "progress", Message: msg}})
+				},
+				failure: func(msg catalog.Text) {
+					stream.sendMessage(Message{PowerCycle: &PowerCycleState{State: "failure", Message: msg}})
+				},
+				success: func(msg catalog.Text) {
+					stream.sendMessage(Message{PowerCycle: &PowerCycleState{State: "success", Message: msg}})
+				},
+			})
+		})
+		return stream.sendMessage(Message{ConfirmationRequired: &request})
+
+	} else if command.Confirm != nil {
+		if !handle.confirmations.Confirm(stream.session, command.Confirm.Token) {
+			log.Info("Refusing unknown or expired confirmation.")
+			return stream.sendMessage(Message{ConfirmationInvalid: &command.Confirm.Token})
+		}
+
+	}
+	return nil
+}
+
+// Inform clients of a state change caused by the given session, the clients of
+// the mat if given, all clients otherwise
+func (handle *Handle) announceChange(m *mat, session int, action string) {
+	client, _ := handle.sessions.Get(session)
+	message := Message{DeviceStateChanged: &StateChange{Action: action, Session: session, Client: client}}
+	if m != nil {
+		handle.broadcastMat(m, message)
+	} else {
+		handle.Broadcast(message)
+	}
+}
+
+// Status of the mat as seen by the given session
+func (handle *Handle) status(m *mat, session int) Message {
+	return Message{Status: &Status{Device: m.getDevice(), Address: handle.SelectedAddress(), PairingRequired: handle.pendingPairing.Device(), Drops: m.rxDrops.Snapshot(), PortsInUse: handle.portsInUse.list(), Session: session}}
+}
+
+// What a firmware update will do, for the client to confirm
+func describeFirmwareUpdate(command UpdateFirmware) string {
+	description := fmt.Sprintf("Flash a firmware image of %d bytes onto %s", base64.StdEncoding.DecodedLen(len(command.Image)), describeTarget(command.SerialNumber))
+	if command.Force {
+		description += ", even if other clients stream from it"
+	}
+	return description
+}
+
+// What a power cycle will do, for the client to confirm
+func describePowerCycle(command PowerCycleDevice) string {
+	return fmt.Sprintf("Switch off the USB port of %s and on again", describeTarget(command.SerialNumber))
+}
+
+func describeTarget(serialNumber string) string {
+	if serialNumber == "" {
+		return "the only connected Flex device"
+	}
+	return fmt.Sprintf("the Flex device with serial number %q", serialNumber)
+}
+
+func firmwareUpdateMessage(msg FirmwareUpdateMessage) Message {
+	return Message{FirmwareUpdateMessage: &msg}
+}
+
+// outbound_loop forwards sets from the device and messages meant for all
+// clients up the WebSocket in order
+func outbound_loop(ctx context.Context, outbound chan interface{}, sendSet func(measurementSet) error, sendMessage func(Message) error) {
+	// Broadcasts wait for all subscribers, so the channel is read until the
+	// subscription ends
+	defer func() {
+		for range outbound {
+		}
+	}()
 	var err error
 	for {
 		select {
 		case <-ctx.Done():
 			return
 
-		case i := <-rx:
-			data, ok := i.([]byte)
-			if ok {
-				err = send(data)
+		case i := <-outbound:
+			switch item := i.(type) {
+			case measurementSet:
+				err = sendSet(item)
+			case Message:
+				err = sendMessage(item)
 			}
 		}
 
@@ -119,6 +1418,22 @@ func rx_data_loop(ctx context.Context, rx chan interface{}, send func([]byte) er
 	}
 }
 
+// status_loop sends the status to mirrors, which may not ask for it, on connection and periodically
+func status_loop(ctx context.Context, interval time.Duration, send func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if send() != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // Helper to upgrade http to WebSocket
 var webSocketUpgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
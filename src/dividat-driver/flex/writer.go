@@ -0,0 +1,188 @@
+package flex
+
+// Commands reach a device from clients and from the driver itself, e.g. to
+// configure the bitdepth, start acquisition or poll for sets. All writes to a
+// port go through a single writer, so that multi-byte commands are never
+// interleaved. Commands of the driver take precedence over queued commands of
+// clients, each command is written as a whole.
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Priorities of writes, in the order they are served
+const (
+	driverPriority = iota
+	clientPriority
+	priorities
+)
+
+type pendingWrite struct {
+	data []byte
+	done chan error
+}
+
+type commandWriter struct {
+	ctx  context.Context
+	port io.Writer
+
+	mutex  sync.Mutex
+	queues [priorities][]pendingWrite
+	// Signalled when a write is queued
+	queued chan struct{}
+}
+
+// Serialize writes to the port until the context is done
+func newCommandWriter(ctx context.Context, port io.Writer) *commandWriter {
+	writer := &commandWriter{ctx: ctx, port: port, queued: make(chan struct{}, 1)}
+	go writer.run()
+	return writer
+}
+
+// Queue a command, returning a channel receiving the outcome of writing it
+func (writer *commandWriter) enqueue(priority int, data []byte) <-chan error {
+	write := pendingWrite{data: data, done: make(chan error, 1)}
+	writer.mutex.Lock()
+	writer.queues[priority] = append(writer.queues[priority], write)
+	writer.mutex.Unlock()
+	select {
+	case writer.queued <- struct{}{}:
+	default:
+	}
+	return write.done
+}
+
+// Write a command of the driver, waiting until it has been written
+func (writer *commandWriter) Write(data []byte) (int, error) {
+	select {
+	case err := <-writer.enqueue(driverPriority, data):
+		if err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	case <-writer.ctx.Done():
+		return 0, writer.ctx.Err()
+	}
+}
+
+// Next queued command, by priority
+func (writer *commandWriter) next() (pendingWrite, bool) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	for priority := range writer.queues {
+		if queue := writer.queues[priority]; len(queue) > 0 {
+			writer.queues[priority] = queue[1:]
+			return queue[0], true
+		}
+	}
+	return pendingWrite{}, false
+}
+
+func (writer *commandWriter) run() {
+	for {
+		select {
+		case <-writer.ctx.Done():
+			writer.abandon()
+			return
+		case <-writer.queued:
+		}
+
+		for {
+			write, ok := writer.next()
+			if !ok {
+				break
+			}
+			write.done <- writeAll(writer.port, write.data)
+		}
+	}
+}
+
+// Fail queued commands once the port is closed
+func (writer *commandWriter) abandon() {
+	for {
+		write, ok := writer.next()
+		if !ok {
+			return
+		}
+		write.done <- writer.ctx.Err()
+	}
+}
+
+// Write all of the data, ports may accept less at a time
+func writeAll(port io.Writer, data []byte) error {
+	for len(data) > 0 {
+		n, err := port.Write(data)
+		if err != nil {
+			return err
+		}
+		// A port accepting nothing would be retried forever
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// Port whose writes are serialized by a command writer
+type serializedPort struct {
+	io.ReadWriteCloser
+	writer *commandWriter
+}
+
+func (port serializedPort) Write(data []byte) (int, error) {
+	return port.writer.Write(data)
+}
+
+// Command of a client, answered with the outcome of writing it
+type clientCommand struct {
+	data []byte
+	done chan error
+}
+
+// Write commands of clients while the port is open
+func forwardCommands(ctx context.Context, logger *logrus.Entry, commands <-chan clientCommand, writer *commandWriter) {
+	for {
+		select {
+
+		case <-ctx.Done():
+			return
+
+		case command := <-commands:
+			var err error
+			select {
+			case err = <-writer.enqueue(clientPriority, command.data):
+			case <-ctx.Done():
+				command.done <- ctx.Err()
+				return
+			}
+			command.done <- err
+			if err != nil {
+				logger.WithField("error", err).Info("Failed to write binary command to serial out.")
+				continue
+			}
+			logger.WithField("bytes", command.data).Debug("Wrote binary command to serial out.")
+		}
+	}
+}
+
+// How long a command of a client waits for a connected device to take it
+const commandTimeout = 2 * time.Second
+
+// Write a command of a client to the connected device, after the commands of
+// the driver. Returns once it is written or could not be.
+func (handle *Handle) sendCommand(data []byte) error {
+	command := clientCommand{data: data, done: make(chan error, 1)}
+	select {
+	case handle.commands <- command:
+		return <-command.done
+	case <-time.After(commandTimeout):
+		return errors.New("no Flex device connected")
+	}
+}
@@ -0,0 +1,64 @@
+package flex
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Port accepting at most the given number of bytes per write
+type limitedPort struct {
+	bytes.Buffer
+	limit int
+}
+
+func (port *limitedPort) Write(data []byte) (int, error) {
+	if len(data) > port.limit {
+		data = data[:port.limit]
+	}
+	return port.Buffer.Write(data)
+}
+
+func TestWriteAll(t *testing.T) {
+	port := limitedPort{limit: 2}
+	if err := writeAll(&port, []byte{1, 2, 3, 4, 5}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(port.Bytes(), []byte{1, 2, 3, 4, 5}) {
+		t.Errorf("Expected all data written, got % x", port.Bytes())
+	}
+
+	if err := writeAll(&limitedPort{limit: 0}, []byte{1}); err != io.ErrShortWrite {
+		t.Errorf("Expected a short write when nothing is accepted, got %v", err)
+	}
+}
+
+func TestClientCommandsReportTheOutcomeOfWriting(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handle := &Handle{commands: make(chan clientCommand)}
+
+	port := limitedPort{limit: 2}
+	go forwardCommands(ctx, logrus.NewEntry(logger), handle.commands, newCommandWriter(ctx, &port))
+	if err := handle.sendCommand([]byte{'S', '\n'}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(port.Bytes(), []byte{'S', '\n'}) {
+		t.Errorf("Expected command written, got % x", port.Bytes())
+	}
+
+	// A device that accepts nothing
+	stuckCtx, cancelStuck := context.WithCancel(context.Background())
+	defer cancelStuck()
+	stuck := &Handle{commands: make(chan clientCommand)}
+	go forwardCommands(stuckCtx, logrus.NewEntry(logger), stuck.commands, newCommandWriter(stuckCtx, &limitedPort{limit: 0}))
+	if err := stuck.sendCommand([]byte{'S', '\n'}); err != io.ErrShortWrite {
+		t.Errorf("Expected the failed write to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package heartbeat
+
+/* Periodic reports of the driver's state to a central endpoint.
+
+Operators of large fleets keep an inventory of which machines have working
+hardware. Each machine posts a report with its version, uptime and connected
+devices at a fixed interval. Reports that can not be delivered are spooled in
+the data directory and sent, oldest first, once the endpoint can be reached
+again, so that gaps can be told apart from outages of the machine. Reports
+the endpoint rejects are dropped, as sending them again would not help.
+
+*/
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/clock"
+)
+
+// Header identifying the machine to the endpoint
+const MachineIDHeader = "X-Dividat-Machine-Id"
+
+// Spooled reports kept at most, older ones are dropped
+const maxSpooled = 1000
+
+// Report describes the state of the driver at a point in time
+type Report struct {
+	MachineID string    `json:"machineId"`
+	Label     string    `json:"label,omitempty"`
+	Version   string    `json:"version"`
+	SentAt    time.Time `json:"sentAt"`
+	// Seconds since the driver started
+	Uptime  float64  `json:"uptime"`
+	Devices []Device `json:"devices"`
+}
+
+// Device is a device an instance of the driver is connected to, or trying to
+type Device struct {
+	Instance string `json:"instance"`
+	// "senso" or "flex"
+	Kind string `json:"kind"`
+	// Identifier of named Senso devices
+	Device       string `json:"device,omitempty"`
+	Address      string `json:"address,omitempty"`
+	SerialNumber string `json:"serialNumber,omitempty"`
+	// Connection of the device, or its health if connected but not healthy
+	State string `json:"state"`
+}
+
+// Publisher posts reports to the endpoint
+type Publisher struct {
+	URL       string
+	MachineID string
+	Token     string
+	Client    *http.Client
+	// Directory undelivered reports are spooled in
+	Spool string
+	// Called for each report, machine ID and time are filled in
+	Collect func() Report
+}
+
+// Run posts a report at each interval until the context is done
+func (publisher *Publisher) Run(ctx context.Context, log *logrus.Entry, interval time.Duration) {
+	ticker := clock.Default.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		publisher.beat(ctx, log)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// Send a report, after any spooled ones, spooling it if it can not be sent
+func (publisher *Publisher) beat(ctx context.Context, log *logrus.Entry) {
+	report := publisher.Collect()
+	report.MachineID = publisher.MachineID
+	report.SentAt = clock.Default.Now().UTC()
+	body, err := json.Marshal(report)
+	if err != nil {
+		log.WithError(err).Error("Could not encode heartbeat.")
+		return
+	}
+
+	err = publisher.flush(ctx, log)
+	if err == nil {
+		err = publisher.post(ctx, body)
+	}
+	if isRejected(err) {
+		log.WithError(err).Warn("Heartbeat was rejected, dropping it.")
+	} else if err != nil {
+		log.WithError(err).Info("Could not send heartbeat, spooling it.")
+		err = publisher.spool(report.SentAt, body)
+		if err != nil {
+			log.WithError(err).Warn("Could not spool heartbeat.")
+		}
+	}
+}
+
+func (publisher *Publisher) post(ctx context.Context, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, publisher.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/json")
+	if publisher.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+publisher.Token)
+	}
+	request.Header.Set(MachineIDHeader, publisher.MachineID)
+
+	response, err := publisher.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		err := fmt.Errorf("endpoint answered %s", response.Status)
+		if isClientError(response.StatusCode) {
+			return rejection{err}
+		}
+		return err
+	}
+	return nil
+}
+
+// Rejection of a report by the endpoint, which would be rejected again
+type rejection struct {
+	error
+}
+
+func isRejected(err error) bool {
+	var r rejection
+	return errors.As(err, &r)
+}
+
+// Whether the endpoint refused the report itself. Timeouts and rate limits
+// pass, so reports answered with them are sent again.
+func isClientError(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+// Spooled reports, oldest first
+func (publisher *Publisher) spooled() []string {
+	paths, _ := filepath.Glob(filepath.Join(publisher.Spool, "*.json"))
+	sort.Strings(paths)
+	return paths
+}
+
+func (publisher *Publisher) spool(sentAt time.Time, body []byte) error {
+	err := os.MkdirAll(publisher.Spool, 0755)
+	if err != nil {
+		return err
+	}
+	// Names sort by the time reports were taken
+	path := filepath.Join(publisher.Spool, sentAt.Format("20060102T150405.000000000")+".json")
+	err = ioutil.WriteFile(path, body, 0644)
+	if err != nil {
+		return err
+	}
+	paths := publisher.spooled()
+	for len(paths) > maxSpooled {
+		os.Remove(paths[0])
+		paths = paths[1:]
+	}
+	return nil
+}
+
+// Send spooled reports, stopping at the first that can not be sent because
+// the endpoint can not be reached or fails. Rejected reports are dropped.
+func (publisher *Publisher) flush(ctx context.Context, log *logrus.Entry) error {
+	for _, path := range publisher.spooled() {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			os.Remove(path)
+			continue
+		}
+		err = publisher.post(ctx, body)
+		if isRejected(err) {
+			log.WithError(err).WithField("report", filepath.Base(path)).Warn("Spooled heartbeat was rejected, dropping it.")
+		} else if err != nil {
+			return err
+		}
+		os.Remove(path)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package heartbeat
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type endpoint struct {
+	mutex     sync.Mutex
+	available bool
+	// Uptime of a report answered as malformed
+	malformed float64
+	received  []Report
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if !e.available {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if r.Header.Get(MachineIDHeader) != "machine" || r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var report Report
+	json.NewDecoder(r.Body).Decode(&report)
+	if e.malformed != 0 && report.Uptime == e.malformed {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	e.received = append(e.received, report)
+}
+
+func testPublisher(t *testing.T, e *endpoint) (*Publisher, func()) {
+	spool, err := ioutil.TempDir("", "heartbeats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(e)
+
+	uptime := 0.0
+	publisher := &Publisher{
+		URL:       server.URL,
+		MachineID: "machine",
+		Token:     "secret",
+		Client:    server.Client(),
+		Spool:     spool,
+		Collect: func() Report {
+			uptime++
+			return Report{Version: "test", Uptime: uptime}
+		},
+	}
+	return publisher, func() {
+		server.Close()
+		os.RemoveAll(spool)
+	}
+}
+
+func TestUndeliveredReportsAreSentOldestFirst(t *testing.T) {
+	e := &endpoint{}
+	publisher, done := testPublisher(t, e)
+	defer done()
+	log := logrus.NewEntry(logrus.New())
+
+	publisher.beat(context.Background(), log)
+	time.Sleep(time.Millisecond)
+	publisher.beat(context.Background(), log)
+	if spooled := len(publisher.spooled()); spooled != 2 {
+		t.Fatalf("expected 2 spooled reports, got %d", spooled)
+	}
+
+	e.mutex.Lock()
+	e.available = true
+	e.mutex.Unlock()
+	publisher.beat(context.Background(), log)
+
+	if spooled := len(publisher.spooled()); spooled != 0 {
+		t.Errorf("expected spool to be empty, got %d reports", spooled)
+	}
+	if len(e.received) != 3 {
+		t.Fatalf("expected 3 reports, got %d", len(e.received))
+	}
+	for i, report := range e.received {
+		if report.Uptime != float64(i+1) {
+			t.Errorf("expected report %d to have uptime %d, got %v", i, i+1, report.Uptime)
+		}
+		if report.MachineID != "machine" {
+			t.Errorf("expected machine ID to be filled in, got %q", report.MachineID)
+		}
+	}
+}
+
+func TestRejectedReportsAreDropped(t *testing.T) {
+	e := &endpoint{}
+	publisher, done := testPublisher(t, e)
+	defer done()
+	log := logrus.NewEntry(logrus.New())
+
+	for i := 0; i < 3; i++ {
+		publisher.beat(context.Background(), log)
+		time.Sleep(time.Millisecond)
+	}
+	if spooled := len(publisher.spooled()); spooled != 3 {
+		t.Fatalf("expected 3 spooled reports, got %d", spooled)
+	}
+
+	// A report the endpoint rejects does not hold back the ones after it
+	e.mutex.Lock()
+	e.available = true
+	e.malformed = 2
+	e.mutex.Unlock()
+	publisher.beat(context.Background(), log)
+
+	if spooled := len(publisher.spooled()); spooled != 0 {
+		t.Errorf("expected spool to be empty, got %d reports", spooled)
+	}
+	var uptimes []float64
+	for _, report := range e.received {
+		uptimes = append(uptimes, report.Uptime)
+	}
+	if len(uptimes) != 3 || uptimes[0] != 1 || uptimes[1] != 3 || uptimes[2] != 4 {
+		t.Errorf("expected reports 1, 3 and 4 to be received, got %v", uptimes)
+	}
+
+	// Rejected heartbeats are not spooled either
+	publisher.Token = "wrong"
+	publisher.beat(context.Background(), log)
+	if spooled := len(publisher.spooled()); spooled != 0 {
+		t.Errorf("expected rejected heartbeat not to be spooled, got %d reports", spooled)
+	}
+}
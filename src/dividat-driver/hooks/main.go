@@ -0,0 +1,113 @@
+package hooks
+
+/* Optional callbacks to observe WebSocket traffic of device handlers.
+
+Subsystems like metrics or audit logs can register hooks on the Senso and
+Flex handlers instead of modifying their connection loops. Hooks are called
+synchronously from the connection's goroutines and must return quickly.
+
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Client identifies a WebSocket connection
+type Client struct {
+	// Unique ID of the connection, logged as connectionId
+	ID string
+	// Endpoint the client is connected to, e.g. "senso" or "flex"
+	Endpoint  string
+	Address   string
+	UserAgent string
+}
+
+// Hooks holds callbacks, any of which may be nil
+type Hooks struct {
+	OnClientConnect    func(client Client)
+	OnClientDisconnect func(client Client)
+	// Called with the name of each decoded command
+	OnCommand func(client Client, command string)
+	// Called for each frame of device data sent to the client
+	OnFrameForwarded func(client Client, frame []byte)
+	// Called for each text message received from the client (incoming) or
+	// sent to it, i.e. not for clients using CBOR
+	OnText func(client Client, incoming bool, text []byte)
+}
+
+// Join returns hooks calling the hooks of all given sets in order
+func Join(sets ...Hooks) Hooks {
+	return Hooks{
+		OnClientConnect: func(client Client) {
+			for _, hooks := range sets {
+				hooks.ClientConnected(client)
+			}
+		},
+		OnClientDisconnect: func(client Client) {
+			for _, hooks := range sets {
+				hooks.ClientDisconnected(client)
+			}
+		},
+		OnCommand: func(client Client, command string) {
+			for _, hooks := range sets {
+				hooks.CommandReceived(client, command)
+			}
+		},
+		OnFrameForwarded: func(client Client, frame []byte) {
+			for _, hooks := range sets {
+				hooks.FrameForwarded(client, frame)
+			}
+		},
+		OnText: func(client Client, incoming bool, text []byte) {
+			for _, hooks := range sets {
+				hooks.TextExchanged(client, incoming, text)
+			}
+		},
+	}
+}
+
+// ClientConnected calls the OnClientConnect hook if set
+func (hooks Hooks) ClientConnected(client Client) {
+	if hooks.OnClientConnect != nil {
+		hooks.OnClientConnect(client)
+	}
+}
+
+// ClientDisconnected calls the OnClientDisconnect hook if set
+func (hooks Hooks) ClientDisconnected(client Client) {
+	if hooks.OnClientDisconnect != nil {
+		hooks.OnClientDisconnect(client)
+	}
+}
+
+// CommandReceived calls the OnCommand hook if set
+func (hooks Hooks) CommandReceived(client Client, command string) {
+	if hooks.OnCommand != nil {
+		hooks.OnCommand(client, command)
+	}
+}
+
+// FrameForwarded calls the OnFrameForwarded hook if set
+func (hooks Hooks) FrameForwarded(client Client, frame []byte) {
+	if hooks.OnFrameForwarded != nil {
+		hooks.OnFrameForwarded(client, frame)
+	}
+}
+
+// TextExchanged calls the OnText hook if set
+func (hooks Hooks) TextExchanged(client Client, incoming bool, text []byte) {
+	if hooks.OnText != nil {
+		hooks.OnText(client, incoming, text)
+	}
+}
+
+// NewID returns a random ID to correlate log entries of a connection or command
+func NewID() string {
+	buffer := make([]byte, 8)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buffer)
+}
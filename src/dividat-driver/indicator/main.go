@@ -0,0 +1,156 @@
+package indicator
+
+/* Device state for tray applications and hardware status indicators.
+
+Companion programs connect to a local socket instead of speaking WebSocket. On
+connection they are sent the current state of each device, followed by every
+change, one line per state:
+
+	<instance> <device> <state>
+
+e.g. "default senso connected" or "room1 flex disconnected". Lines sent by
+clients are ignored.
+
+*/
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Lines a client may fall behind before it is disconnected
+const clientBuffer = 64
+
+// Hub keeps the state of devices and sends changes to connected clients
+type Hub struct {
+	mutex sync.Mutex
+	// State by instance and device, joined by a space
+	states  map[string]string
+	clients map[chan string]bool
+}
+
+// New returns a hub without known states
+func New() *Hub {
+	return &Hub{states: map[string]string{}, clients: map[chan string]bool{}}
+}
+
+// Set the state of a device of an instance, notifying clients if it changed
+func (hub *Hub) Set(instance string, device string, state string) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	key := instance + " " + device
+	if hub.states[key] == state {
+		return
+	}
+	hub.states[key] = state
+
+	line := key + " " + state + "\n"
+	for client := range hub.clients {
+		select {
+		case client <- line:
+		default:
+			// Do not hold back others for a client that stopped reading
+			delete(hub.clients, client)
+			close(client)
+		}
+	}
+}
+
+// Subscribe to changes, receiving the current states first
+func (hub *Hub) subscribe() chan string {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	keys := make([]string, 0, len(hub.states))
+	for key := range hub.states {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	client := make(chan string, len(keys)+clientBuffer)
+	for _, key := range keys {
+		client <- key + " " + hub.states[key] + "\n"
+	}
+	hub.clients[client] = true
+	return client
+}
+
+func (hub *Hub) unsubscribe(client chan string) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if hub.clients[client] {
+		delete(hub.clients, client)
+		close(client)
+	}
+}
+
+// Listen on a Unix socket, which Windows supports since Windows 10
+func Listen(path string) (net.Listener, error) {
+	// Left behind by a previous run
+	os.Remove(path)
+	return net.Listen("unix", path)
+}
+
+// Serve clients on the listener until the context is done
+func (hub *Hub) Serve(ctx context.Context, log *logrus.Entry, listener net.Listener) {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithError(err).Error("Could not accept indicator client.")
+			}
+			return
+		}
+		go hub.serveClient(ctx, log, conn)
+	}
+}
+
+func (hub *Hub) serveClient(ctx context.Context, log *logrus.Entry, conn net.Conn) {
+	defer conn.Close()
+	log.Debug("Indicator client connected.")
+
+	client := hub.subscribe()
+	defer hub.unsubscribe(client)
+
+	// Notice clients closing the connection, discarding what they send
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		buffer := make([]byte, 256)
+		for {
+			if _, err := conn.Read(buffer); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+			log.Debug("Indicator client disconnected.")
+			return
+		case line, ok := <-client:
+			if !ok {
+				log.Warn("Disconnecting indicator client that does not keep up.")
+				return
+			}
+			if _, err := fmt.Fprint(conn, line); err != nil {
+				return
+			}
+		}
+	}
+}
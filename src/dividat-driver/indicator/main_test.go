@@ -0,0 +1,51 @@
+package indicator
+
+import (
+	"testing"
+)
+
+func TestSubscribersReceiveStateThenChanges(t *testing.T) {
+	hub := New()
+	hub.Set("default", "senso", "connecting")
+	hub.Set("default", "flex", "disconnected")
+
+	client := hub.subscribe()
+	for _, expected := range []string{"default flex disconnected\n", "default senso connecting\n"} {
+		if line := <-client; line != expected {
+			t.Errorf("expected %q, got %q", expected, line)
+		}
+	}
+
+	hub.Set("default", "senso", "connecting")
+	hub.Set("default", "senso", "connected")
+	if line := <-client; line != "default senso connected\n" {
+		t.Errorf("expected only the change to be sent, got %q", line)
+	}
+	select {
+	case line := <-client:
+		t.Errorf("unexpected line %q", line)
+	default:
+	}
+}
+
+func TestClientsFallingBehindAreDropped(t *testing.T) {
+	hub := New()
+	client := hub.subscribe()
+	for i := 0; i <= clientBuffer; i++ {
+		state := "connected"
+		if i%2 == 1 {
+			state = "disconnected"
+		}
+		hub.Set("default", "flex", state)
+	}
+
+	received := 0
+	for range client {
+		received++
+	}
+	if received != clientBuffer {
+		t.Errorf("expected %d buffered lines before being dropped, got %d", clientBuffer, received)
+	}
+	// Unsubscribing a dropped client must not close its channel again
+	hub.unsubscribe(client)
+}
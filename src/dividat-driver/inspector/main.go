@@ -0,0 +1,219 @@
+package inspector
+
+/* Live trace of the WebSocket protocol, for debugging clients.
+
+The inspector observes Senso and Flex connections through their hooks and
+streams a line for each connection opened and closed and each command and
+message exchanged to everyone watching, e.g. with
+
+    curl -N http://127.0.0.1:8382/debug/traffic
+
+Browsers are served a page following the same stream. Lines look like
+
+    12:03:04.567 flex 3fa85f6457174562 > {"type":"GetStatus"}
+    12:03:04.569 flex 3fa85f6457174562 < {"type":"Status",...}
+
+with `>` for text received from the client and `<` for text sent to it.
+Binary frames of device data are not traced. Values of fields that may hold
+secrets or bulky data, such as tokens and firmware images, are redacted and
+long messages truncated.
+
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/hooks"
+)
+
+// Longest message traced in full, in bytes
+const maxPayload = 512
+
+// Lines buffered per watcher, further lines are dropped while it falls behind
+const watcherBuffer = 256
+
+// Fields whose values are not traced
+var redactedFields = map[string]bool{
+	"token":         true,
+	"image":         true,
+	"password":      true,
+	"authorization": true,
+}
+
+// Inspector distributes trace lines to watchers
+type Inspector struct {
+	mutex    sync.Mutex
+	watchers map[chan string]bool
+}
+
+// New returns an inspector without watchers
+func New() *Inspector {
+	return &Inspector{watchers: map[chan string]bool{}}
+}
+
+// Hooks to set on the handlers whose connections are traced
+func (inspector *Inspector) Hooks() hooks.Hooks {
+	return hooks.Hooks{
+		OnClientConnect: func(client hooks.Client) {
+			inspector.trace(client, "+", fmt.Sprintf("connected from %s (%s)", client.Address, client.UserAgent))
+		},
+		OnClientDisconnect: func(client hooks.Client) {
+			inspector.trace(client, "-", "closed")
+		},
+		OnText: func(client hooks.Client, incoming bool, text []byte) {
+			if !inspector.watched() {
+				return
+			}
+			direction := "<"
+			if incoming {
+				direction = ">"
+			}
+			inspector.trace(client, direction, Redact(text))
+		},
+	}
+}
+
+func (inspector *Inspector) watched() bool {
+	inspector.mutex.Lock()
+	defer inspector.mutex.Unlock()
+	return len(inspector.watchers) > 0
+}
+
+func (inspector *Inspector) trace(client hooks.Client, direction string, text string) {
+	line := fmt.Sprintf("%s %s %s %s %s", time.Now().Format("15:04:05.000"), client.Endpoint, client.ID, direction, text)
+
+	inspector.mutex.Lock()
+	defer inspector.mutex.Unlock()
+	for watcher := range inspector.watchers {
+		select {
+		case watcher <- line:
+		default:
+		}
+	}
+}
+
+func (inspector *Inspector) watch() chan string {
+	inspector.mutex.Lock()
+	defer inspector.mutex.Unlock()
+	watcher := make(chan string, watcherBuffer)
+	inspector.watchers[watcher] = true
+	return watcher
+}
+
+func (inspector *Inspector) unwatch(watcher chan string) {
+	inspector.mutex.Lock()
+	defer inspector.mutex.Unlock()
+	delete(inspector.watchers, watcher)
+}
+
+// Redact returns the text with values of sensitive fields replaced, truncated
+// to maxPayload bytes. Text that is not JSON is only truncated.
+func Redact(text []byte) string {
+	var value interface{}
+	if json.Unmarshal(text, &value) == nil {
+		if redacted, err := json.Marshal(redact(value)); err == nil {
+			text = redacted
+		}
+	}
+	if len(text) > maxPayload {
+		return fmt.Sprintf("%s... (%d bytes)", text[:maxPayload], len(text))
+	}
+	return string(text)
+}
+
+func redact(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if redactedFields[strings.ToLower(key)] {
+				value[key] = "[redacted]"
+			} else {
+				value[key] = redact(field)
+			}
+		}
+	case []interface{}:
+		for i, element := range value {
+			value[i] = redact(element)
+		}
+	}
+	return value
+}
+
+// Implement net/http Handler interface
+func (inspector *Inspector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	watcher := inspector.watch()
+	defer inspector.unwatch(watcher)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line := <-watcher:
+			_, err := fmt.Fprintln(w, line)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// Page following the stream in a browser
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Driver traffic</title>
+<style>
+body { font-family: monospace; margin: 0; }
+header { position: sticky; top: 0; background: #eee; padding: 0.5em; }
+pre { margin: 0.5em; white-space: pre-wrap; word-break: break-all; }
+</style>
+</head>
+<body>
+<header>Filter <input id="filter" size="40"> <button id="clear">Clear</button></header>
+<pre id="lines"></pre>
+<script>
+const lines = document.getElementById("lines")
+const filter = document.getElementById("filter")
+document.getElementById("clear").onclick = () => { lines.textContent = "" }
+fetch(location.href, { headers: { Accept: "text/plain" } }).then(async response => {
+  const reader = response.body.pipeThrough(new TextDecoderStream()).getReader()
+  let rest = ""
+  for (;;) {
+    const { value, done } = await reader.read()
+    if (done) break
+    const received = (rest + value).split("\n")
+    rest = received.pop()
+    for (const line of received) {
+      if (line.includes(filter.value)) lines.textContent += line + "\n"
+    }
+    window.scrollTo(0, document.body.scrollHeight)
+  }
+})
+</script>
+</body>
+</html>
+`
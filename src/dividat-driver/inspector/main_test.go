@@ -0,0 +1,31 @@
+package inspector
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{`{"type":"Confirm","token":"abc"}`, `{"token":"[redacted]","type":"Confirm"}`},
+		{`{"type":"UpdateFirmware","image":"AAAA","serialNumber":"F1"}`, `{"image":"[redacted]","serialNumber":"F1","type":"UpdateFirmware"}`},
+		{`{"devices":[{"Token":"abc"}]}`, `{"devices":[{"Token":"[redacted]"}]}`},
+		{`not json`, `not json`},
+	}
+	for _, c := range cases {
+		if redacted := Redact([]byte(c.text)); redacted != c.expected {
+			t.Errorf("Redact(%s) = %s, expected %s", c.text, redacted, c.expected)
+		}
+	}
+}
+
+func TestRedactTruncates(t *testing.T) {
+	text := strings.Repeat("x", maxPayload+10)
+	redacted := Redact([]byte(text))
+	if !strings.HasPrefix(redacted, strings.Repeat("x", maxPayload)+"...") || !strings.HasSuffix(redacted, "(522 bytes)") {
+		t.Errorf("unexpected truncation %q", redacted)
+	}
+}
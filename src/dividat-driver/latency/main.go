@@ -0,0 +1,207 @@
+package latency
+
+/* Round trip times for diagnosing laggy setups.
+
+The Ping commands of Senso and Flex measure round trips between driver and
+device and between driver and client, and answer with percentiles. Round trips
+to clients are measured with WebSocket ping control frames, which browsers
+answer by themselves, so clients need not implement anything.
+
+*/
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Round trips measured if a Ping command gives no count
+const DefaultCount = 10
+
+// Most round trips measured for a Ping command
+const MaxCount = 100
+
+// Time between probes
+const Interval = 100 * time.Millisecond
+
+// Time after which a probe is counted as lost
+const Timeout = time.Second
+
+// Count returns the number of round trips to measure for a requested count
+func Count(requested int) int {
+	if requested <= 0 {
+		return DefaultCount
+	}
+	if requested > MaxCount {
+		return MaxCount
+	}
+	return requested
+}
+
+// Summary of round trip times, in milliseconds
+type Summary struct {
+	Samples int `json:"samples"`
+	// Probes that were not answered in time
+	Lost int     `json:"lost"`
+	Min  float64 `json:"min"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// Summarize round trips, percentiles are taken by nearest rank
+func Summarize(samples []time.Duration, lost int) Summary {
+	summary := Summary{Samples: len(samples), Lost: lost}
+	if len(samples) == 0 {
+		return summary
+	}
+	sorted := append([]time.Duration{}, samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		if rank < 1 {
+			rank = 1
+		}
+		return milliseconds(sorted[rank-1])
+	}
+	summary.Min = milliseconds(sorted[0])
+	summary.P50 = percentile(50)
+	summary.P90 = percentile(90)
+	summary.P99 = percentile(99)
+	summary.Max = milliseconds(sorted[len(sorted)-1])
+	return summary
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Window keeps the most recent round trips of a continuous exchange, e.g.
+// polls of a device
+type Window struct {
+	mutex   sync.Mutex
+	samples []time.Duration
+	next    int
+	size    int
+}
+
+// NewWindow returns a window of the given number of round trips
+func NewWindow(size int) *Window {
+	return &Window{size: size}
+}
+
+// Add a round trip, replacing the oldest if the window is full
+func (window *Window) Add(roundTrip time.Duration) {
+	window.mutex.Lock()
+	defer window.mutex.Unlock()
+	if len(window.samples) < window.size {
+		window.samples = append(window.samples, roundTrip)
+		return
+	}
+	window.samples[window.next] = roundTrip
+	window.next = (window.next + 1) % window.size
+}
+
+// Reset forgets all round trips, e.g. when a new device connects
+func (window *Window) Reset() {
+	window.mutex.Lock()
+	defer window.mutex.Unlock()
+	window.samples = nil
+	window.next = 0
+}
+
+// Summary of the round trips in the window
+func (window *Window) Summary() Summary {
+	window.mutex.Lock()
+	defer window.mutex.Unlock()
+	return Summarize(window.samples, 0)
+}
+
+// Measure round trips with a probe, which sends a request and returns a
+// channel receiving once it is answered. Probes are sent one at a time.
+func Measure(ctx context.Context, count int, probe func() (<-chan struct{}, error)) (Summary, error) {
+	samples := []time.Duration{}
+	lost := 0
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return Summarize(samples, lost), nil
+			case <-time.After(Interval):
+			}
+		}
+		sent := time.Now()
+		answered, err := probe()
+		if err != nil {
+			return Summary{}, err
+		}
+		select {
+		case <-ctx.Done():
+			return Summarize(samples, lost), nil
+		case <-answered:
+			samples = append(samples, time.Since(sent))
+		case <-time.After(Timeout):
+			lost++
+		}
+	}
+	return Summarize(samples, lost), nil
+}
+
+// ClientPinger measures round trips to a WebSocket client with ping frames
+type ClientPinger struct {
+	conn *websocket.Conn
+
+	mutex   sync.Mutex
+	serial  int
+	pending map[string]chan struct{}
+}
+
+// NewClientPinger handles pong frames of the connection. Pongs are only
+// noticed while the connection is read from, so measurements must not block
+// the reading goroutine.
+func NewClientPinger(conn *websocket.Conn) *ClientPinger {
+	pinger := &ClientPinger{conn: conn, pending: map[string]chan struct{}{}}
+	conn.SetPongHandler(pinger.onPong)
+	return pinger
+}
+
+func (pinger *ClientPinger) onPong(data string) error {
+	pinger.mutex.Lock()
+	defer pinger.mutex.Unlock()
+	if answered, ok := pinger.pending[data]; ok {
+		close(answered)
+		delete(pinger.pending, data)
+	}
+	return nil
+}
+
+// Measure count round trips to the client
+func (pinger *ClientPinger) Measure(ctx context.Context, count int) (Summary, error) {
+	sent := []string{}
+	defer func() { pinger.forget(sent) }()
+	return Measure(ctx, count, func() (<-chan struct{}, error) {
+		answered := make(chan struct{})
+		pinger.mutex.Lock()
+		pinger.serial++
+		data := "ping-" + strconv.Itoa(pinger.serial)
+		pinger.pending[data] = answered
+		pinger.mutex.Unlock()
+		sent = append(sent, data)
+		return answered, pinger.conn.WriteControl(websocket.PingMessage, []byte(data), time.Now().Add(Timeout))
+	})
+}
+
+// Forget pings that were not answered
+func (pinger *ClientPinger) forget(sent []string) {
+	pinger.mutex.Lock()
+	defer pinger.mutex.Unlock()
+	for _, data := range sent {
+		delete(pinger.pending, data)
+	}
+}
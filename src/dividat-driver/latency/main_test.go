@@ -0,0 +1,65 @@
+package latency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSummarizeTakesPercentilesByNearestRank(t *testing.T) {
+	samples := []time.Duration{}
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	summary := Summarize(samples, 2)
+	expected := Summary{Samples: 100, Lost: 2, Min: 1, P50: 50, P90: 90, P99: 99, Max: 100}
+	if summary != expected {
+		t.Errorf("expected %+v, got %+v", expected, summary)
+	}
+
+	if empty := Summarize(nil, 3); empty != (Summary{Lost: 3}) {
+		t.Errorf("expected only lost probes without samples, got %+v", empty)
+	}
+}
+
+func TestWindowKeepsMostRecentRoundTrips(t *testing.T) {
+	window := NewWindow(3)
+	for i := 1; i <= 5; i++ {
+		window.Add(time.Duration(i) * time.Millisecond)
+	}
+	summary := window.Summary()
+	if summary.Samples != 3 || summary.Min != 3 || summary.Max != 5 {
+		t.Errorf("expected round trips 3 to 5 ms, got %+v", summary)
+	}
+
+	window.Reset()
+	if summary := window.Summary(); summary.Samples != 0 {
+		t.Errorf("expected no round trips after reset, got %+v", summary)
+	}
+}
+
+func TestMeasureCountsUnansweredProbesAsLost(t *testing.T) {
+	probes := 0
+	summary, err := Measure(context.Background(), 2, func() (<-chan struct{}, error) {
+		probes++
+		answered := make(chan struct{})
+		if probes == 1 {
+			close(answered)
+		}
+		return answered, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Samples != 1 || summary.Lost != 1 {
+		t.Errorf("expected one answered and one lost probe, got %+v", summary)
+	}
+
+	_, err = Measure(context.Background(), 1, func() (<-chan struct{}, error) {
+		return nil, errors.New("closed")
+	})
+	if err == nil {
+		t.Error("expected failing probe to be reported")
+	}
+}
@@ -0,0 +1,175 @@
+package limits
+
+/* Caps on resources shared by all clients, so that the driver degrades
+predictably on constrained hardware instead of being killed by the OS.
+
+WebSocket upgrades beyond the maximum number of connected clients, or while the
+estimated memory use exceeds its maximum, are refused with 503 Service
+Unavailable. Clients already connected are not affected, and other requests are
+always served.
+
+*/
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// Interval at which memory use is estimated
+const memoryInterval = 5 * time.Second
+
+// Seconds refused clients are asked to wait before connecting again
+const retryAfter = "10"
+
+// Resource is the current use of a resource and its limit, zero if unlimited
+type Resource struct {
+	Used int64 `json:"used"`
+	Max  int64 `json:"max"`
+}
+
+// Limits admits WebSocket clients while resources are within their limits
+type Limits struct {
+	maxClients int64
+	maxMemory  int64
+
+	mutex   sync.Mutex
+	clients int64
+	memory  int64
+}
+
+// New returns limits on connected clients and memory use in bytes, zero for
+// unlimited
+func New(maxClients int, maxMemory int64) *Limits {
+	return &Limits{maxClients: int64(maxClients), maxMemory: maxMemory}
+}
+
+// Clients returns the number of connected WebSocket clients
+func (limits *Limits) Clients() Resource {
+	limits.mutex.Lock()
+	defer limits.mutex.Unlock()
+	return Resource{Used: limits.clients, Max: limits.maxClients}
+}
+
+// Memory returns the last estimate of memory use in bytes
+func (limits *Limits) Memory() Resource {
+	limits.mutex.Lock()
+	defer limits.mutex.Unlock()
+	return Resource{Used: limits.memory, Max: limits.maxMemory}
+}
+
+// Reserve a client slot, returning the reason if the client must be refused
+func (limits *Limits) admit() (string, bool) {
+	limits.mutex.Lock()
+	defer limits.mutex.Unlock()
+	if limits.maxClients > 0 && limits.clients >= limits.maxClients {
+		return "Too many clients", false
+	}
+	if limits.maxMemory > 0 && limits.memory > limits.maxMemory {
+		return "Memory limit exceeded", false
+	}
+	limits.clients++
+	return "", true
+}
+
+func (limits *Limits) release() {
+	limits.mutex.Lock()
+	defer limits.mutex.Unlock()
+	limits.clients--
+}
+
+// Set the memory estimate, returning whether it newly exceeds the limit
+func (limits *Limits) setMemory(memory int64) bool {
+	limits.mutex.Lock()
+	defer limits.mutex.Unlock()
+	exceeded := limits.maxMemory > 0 && limits.memory <= limits.maxMemory && memory > limits.maxMemory
+	limits.memory = memory
+	return exceeded
+}
+
+// Run estimates memory use periodically until the context is done. The
+// estimate is the memory obtained from the OS and not yet returned to it.
+func (limits *Limits) Run(ctx context.Context, log *logrus.Entry) {
+	var m runtime.MemStats
+	ticker := time.NewTicker(memoryInterval)
+	defer ticker.Stop()
+	for {
+		runtime.ReadMemStats(&m)
+		memory := int64(m.Sys - m.HeapReleased)
+		if limits.setMemory(memory) {
+			log.WithField("memory", memory).WithField("maxMemory", limits.maxMemory).Warn("Memory limit exceeded, refusing new clients.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Middleware refusing WebSocket upgrades while limits are reached, counting
+// each upgraded connection as client until it is closed
+func (limits *Limits) Middleware(log *logrus.Entry, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reason, ok := limits.admit()
+		if !ok {
+			log.WithField("path", r.URL.Path).WithField("reason", reason).Warn("Refusing WebSocket client, resource limit reached.")
+			w.Header().Set("Retry-After", retryAfter)
+			http.Error(w, reason, http.StatusServiceUnavailable)
+			return
+		}
+
+		counted := &countedWriter{ResponseWriter: w, limits: limits}
+		next.ServeHTTP(counted, r)
+		// Handlers refusing the upgrade do not take over the connection
+		if !counted.hijacked {
+			limits.release()
+		}
+	})
+}
+
+// Response writer handing out connections that release their client slot when
+// closed
+type countedWriter struct {
+	http.ResponseWriter
+	limits   *Limits
+	hijacked bool
+}
+
+func (w *countedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	return &countedConn{Conn: conn, limits: w.limits}, rw, nil
+}
+
+type countedConn struct {
+	net.Conn
+	limits *Limits
+	once   sync.Once
+}
+
+func (conn *countedConn) Close() error {
+	conn.once.Do(conn.limits.release)
+	return conn.Conn.Close()
+}
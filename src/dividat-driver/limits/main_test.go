@@ -0,0 +1,83 @@
+package limits
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+func serve(limits *Limits) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+	})
+	return httptest.NewServer(limits.Middleware(logrus.NewEntry(logrus.New()), echo))
+}
+
+func dial(server *httptest.Server) (*websocket.Conn, int, error) {
+	conn, response, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if response != nil {
+		return conn, response.StatusCode, err
+	}
+	return conn, 0, err
+}
+
+func TestClientsAreLimited(t *testing.T) {
+	limits := New(1, 0)
+	server := serve(limits)
+	defer server.Close()
+
+	first, _, err := dial(server)
+	if err != nil {
+		t.Fatalf("expected first client to connect: %v", err)
+	}
+	if _, status, _ := dial(server); status != http.StatusServiceUnavailable {
+		t.Errorf("expected second client to be refused with 503, got %d", status)
+	}
+
+	first.Close()
+	deadline := time.Now().Add(time.Second)
+	for limits.Clients().Used != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	second, _, err := dial(server)
+	if err != nil {
+		t.Fatalf("expected client to connect once the first closed: %v", err)
+	}
+	second.Close()
+}
+
+func TestMemoryLimitRefusesClients(t *testing.T) {
+	limits := New(0, 100)
+	server := serve(limits)
+	defer server.Close()
+
+	if !limits.setMemory(200) {
+		t.Errorf("expected exceeding the limit to be reported")
+	}
+	if limits.setMemory(300) {
+		t.Errorf("expected limit to be reported exceeded only once")
+	}
+	if _, status, _ := dial(server); status != http.StatusServiceUnavailable {
+		t.Errorf("expected client to be refused with 503, got %d", status)
+	}
+	if limits.Clients().Used != 0 {
+		t.Errorf("expected refused client not to be counted")
+	}
+}
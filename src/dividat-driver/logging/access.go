@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/hooks"
+)
+
+// AccessLog appends a JSON line summarizing each WebSocket connection to a
+// file once the connection is closed. Entries of the general log carry the
+// same connection ID, so a single client's session can be traced there.
+type AccessLog struct {
+	mutex sync.Mutex
+	file  *os.File
+	open  map[string]*accessEntry
+}
+
+// Summary of a connection
+type accessEntry struct {
+	ID        string    `json:"connectionId"`
+	Endpoint  string    `json:"endpoint"`
+	Address   string    `json:"address"`
+	UserAgent string    `json:"userAgent"`
+	Opened    time.Time `json:"opened"`
+	Closed    time.Time `json:"closed"`
+	Seconds   float64   `json:"seconds"`
+	// Number of commands received, by name
+	Commands map[string]int `json:"commands"`
+	// Frames of device data sent to the client and their total size
+	Frames int `json:"frames"`
+	Bytes  int `json:"bytes"`
+}
+
+// OpenAccessLog appends to the file at path, creating it if necessary
+func OpenAccessLog(path string) (*AccessLog, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessLog{file: file, open: map[string]*accessEntry{}}, nil
+}
+
+// Hooks to set on the handlers whose connections are logged
+func (log *AccessLog) Hooks() hooks.Hooks {
+	return hooks.Hooks{
+		OnClientConnect:    log.connected,
+		OnClientDisconnect: log.disconnected,
+		OnCommand:          log.command,
+		OnFrameForwarded:   log.frame,
+	}
+}
+
+func (log *AccessLog) connected(client hooks.Client) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	log.open[client.ID] = &accessEntry{
+		ID:        client.ID,
+		Endpoint:  client.Endpoint,
+		Address:   client.Address,
+		UserAgent: client.UserAgent,
+		Opened:    time.Now().UTC(),
+		Commands:  map[string]int{},
+	}
+}
+
+func (log *AccessLog) command(client hooks.Client, command string) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	if entry, ok := log.open[client.ID]; ok {
+		entry.Commands[command]++
+	}
+}
+
+func (log *AccessLog) frame(client hooks.Client, frame []byte) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	if entry, ok := log.open[client.ID]; ok {
+		entry.Frames++
+		entry.Bytes += len(frame)
+	}
+}
+
+func (log *AccessLog) disconnected(client hooks.Client) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	entry, ok := log.open[client.ID]
+	if !ok {
+		return
+	}
+	delete(log.open, client.ID)
+
+	entry.Closed = time.Now().UTC()
+	entry.Seconds = entry.Closed.Sub(entry.Opened).Seconds()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	log.file.Write(append(line, '\n'))
+}
@@ -0,0 +1,162 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Bound on the entries kept, regardless of how much is logged
+const maxIncidentEntries = 10000
+
+// IncidentRecorder keeps entries of all levels for a while and writes them to
+// a file when an error is logged, as intermittent problems can rarely be
+// reproduced at debug level. The logger is kept at debug level, entries below
+// the configured level are only hidden from other outputs.
+type IncidentRecorder struct {
+	dir    string
+	window time.Duration
+
+	mutex   sync.Mutex
+	entries []*logrus.Entry
+	// Time of the last incident, errors within the window after it are only kept
+	lastIncident time.Time
+
+	// Level of entries passed on to the logger's output and other hooks
+	level *levelGate
+}
+
+// CaptureIncidents records entries of the last window and writes them to a
+// file in dir whenever an error is logged. The logger's current level keeps
+// applying to its output and hooks, use SetLevel to change it.
+func CaptureIncidents(logger *logrus.Logger, dir string, window time.Duration) *IncidentRecorder {
+	recorder := &IncidentRecorder{
+		dir:    dir,
+		window: window,
+		level:  &levelGate{level: logger.GetLevel()},
+	}
+
+	// Hide entries below the configured level from existing outputs
+	logger.Formatter = gatedFormatter{gate: recorder.level, formatter: logger.Formatter}
+	gated := logrus.LevelHooks{}
+	for level, hooks := range logger.ReplaceHooks(logrus.LevelHooks{}) {
+		for _, hook := range hooks {
+			gated[level] = append(gated[level], gatedHook{gate: recorder.level, hook: hook})
+		}
+	}
+	logger.ReplaceHooks(gated)
+
+	logger.AddHook(recorder)
+	logger.SetLevel(logrus.DebugLevel)
+	return recorder
+}
+
+// SetLevel changes the level of entries passed on to the logger's output and hooks
+func (recorder *IncidentRecorder) SetLevel(level logrus.Level) {
+	recorder.level.set(level)
+}
+
+// Levels implements the logrus.Hook interface
+func (recorder *IncidentRecorder) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements the logrus.Hook interface
+func (recorder *IncidentRecorder) Fire(entry *logrus.Entry) error {
+	recorder.mutex.Lock()
+
+	// Forget entries that left the window
+	cutoff := entry.Time.Add(-recorder.window)
+	first := 0
+	for first < len(recorder.entries) && recorder.entries[first].Time.Before(cutoff) {
+		first++
+	}
+	if len(recorder.entries)-first >= maxIncidentEntries {
+		first = len(recorder.entries) - maxIncidentEntries + 1
+	}
+	recorder.entries = append(recorder.entries[first:], entry)
+
+	if entry.Level > logrus.ErrorLevel || entry.Time.Sub(recorder.lastIncident) < recorder.window {
+		recorder.mutex.Unlock()
+		return nil
+	}
+	recorder.lastIncident = entry.Time
+	entries := recorder.entries
+	recorder.entries = nil
+	recorder.mutex.Unlock()
+
+	// Written right away, fatal errors end the process
+	return recorder.write(entry.Time, entries)
+}
+
+func (recorder *IncidentRecorder) write(at time.Time, entries []*logrus.Entry) error {
+	var contents bytes.Buffer
+	for _, entry := range entries {
+		encoded, err := formatter.Format(entry)
+		if err != nil {
+			continue
+		}
+		contents.Write(encoded)
+	}
+
+	err := os.MkdirAll(recorder.dir, 0755)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("incident-%s.log", at.UTC().Format("20060102T150405.000Z"))
+	return ioutil.WriteFile(filepath.Join(recorder.dir, name), contents.Bytes(), 0644)
+}
+
+// Level below which entries are hidden, changed while logging
+type levelGate struct {
+	mutex sync.RWMutex
+	level logrus.Level
+}
+
+func (gate *levelGate) set(level logrus.Level) {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	gate.level = level
+}
+
+func (gate *levelGate) allows(level logrus.Level) bool {
+	gate.mutex.RLock()
+	defer gate.mutex.RUnlock()
+	return level <= gate.level
+}
+
+// Formats nothing for hidden entries, so the logger writes nothing
+type gatedFormatter struct {
+	gate      *levelGate
+	formatter logrus.Formatter
+}
+
+func (f gatedFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if !f.gate.allows(entry.Level) {
+		return nil, nil
+	}
+	return f.formatter.Format(entry)
+}
+
+// Fires only for entries that are not hidden
+type gatedHook struct {
+	gate *levelGate
+	hook logrus.Hook
+}
+
+func (h gatedHook) Levels() []logrus.Level {
+	return h.hook.Levels()
+}
+
+func (h gatedHook) Fire(entry *logrus.Entry) error {
+	if !h.gate.allows(entry.Level) {
+		return nil
+	}
+	return h.hook.Fire(entry)
+}
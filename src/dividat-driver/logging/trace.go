@@ -0,0 +1,133 @@
+package logging
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/clock"
+)
+
+// Longest trace, longer requests are cut to it
+const MaxTraceDuration = 5 * time.Minute
+
+// Size at which a trace is stopped early
+const maxTraceBytes = 50 << 20
+
+// FrameTrace writes hex dumps of all device frames to a file for a bounded
+// time, so support can inspect the traffic of a live session without
+// permanently enabling debug logging
+type FrameTrace struct {
+	dir string
+
+	mutex   sync.Mutex
+	current *trace
+}
+
+type trace struct {
+	file   *os.File
+	timer  clock.Timer
+	onStop func(TraceSummary)
+	TraceSummary
+}
+
+// TraceSummary describes a finished trace
+type TraceSummary struct {
+	File   string `json:"file"`
+	Frames int    `json:"frames"`
+	Bytes  int64  `json:"bytes"`
+	// "elapsed", "size" (the size cap was reached), "replaced" (by a new
+	// trace) or "error" (the file could not be written)
+	Reason string `json:"reason"`
+}
+
+// TraceStart describes a started trace
+type TraceStart struct {
+	File string `json:"file"`
+	// In seconds
+	Duration float64 `json:"duration"`
+}
+
+// NewFrameTrace returns a trace writing files to dir
+func NewFrameTrace(dir string) *FrameTrace {
+	return &FrameTrace{dir: dir}
+}
+
+// Start tracing frames of the source (e.g. "senso") for the duration, at most
+// MaxTraceDuration, replacing a running trace. onStop is called once the trace
+// ends.
+func (frameTrace *FrameTrace) Start(source string, duration time.Duration, onStop func(TraceSummary)) (TraceStart, error) {
+	if duration <= 0 {
+		return TraceStart{}, errors.New("trace duration must be positive")
+	}
+	if duration > MaxTraceDuration {
+		duration = MaxTraceDuration
+	}
+
+	err := os.MkdirAll(frameTrace.dir, 0755)
+	if err != nil {
+		return TraceStart{}, err
+	}
+	path := filepath.Join(frameTrace.dir, fmt.Sprintf("%s-frames-%s.log", source, time.Now().UTC().Format("20060102T150405.000")))
+	file, err := os.Create(path)
+	if err != nil {
+		return TraceStart{}, err
+	}
+
+	frameTrace.mutex.Lock()
+	defer frameTrace.mutex.Unlock()
+	frameTrace.stop("replaced")
+	current := &trace{file: file, onStop: onStop, TraceSummary: TraceSummary{File: path}}
+	current.timer = clock.Default.AfterFunc(duration, func() {
+		frameTrace.mutex.Lock()
+		defer frameTrace.mutex.Unlock()
+		if frameTrace.current == current {
+			frameTrace.stop("elapsed")
+		}
+	})
+	frameTrace.current = current
+	return TraceStart{File: path, Duration: duration.Seconds()}, nil
+}
+
+// Record a frame received from a device if tracing. Nothing is recorded by a
+// nil trace.
+func (frameTrace *FrameTrace) Record(device string, frame []byte) {
+	if frameTrace == nil {
+		return
+	}
+	frameTrace.mutex.Lock()
+	defer frameTrace.mutex.Unlock()
+	current := frameTrace.current
+	if current == nil {
+		return
+	}
+
+	entry := fmt.Sprintf("%s %s %d bytes\n%s", time.Now().UTC().Format(time.RFC3339Nano), device, len(frame), hex.Dump(frame))
+	n, err := current.file.WriteString(entry)
+	current.Frames++
+	current.Bytes += int64(n)
+	if err != nil {
+		frameTrace.stop("error")
+	} else if current.Bytes >= maxTraceBytes {
+		frameTrace.stop("size")
+	}
+}
+
+// Stop the running trace, if any. Must be called with the mutex held.
+func (frameTrace *FrameTrace) stop(reason string) {
+	current := frameTrace.current
+	if current == nil {
+		return
+	}
+	frameTrace.current = nil
+	current.timer.Stop()
+	current.file.Close()
+	current.Reason = reason
+	if current.onStop != nil {
+		go current.onStop(current.TraceSummary)
+	}
+}
@@ -4,14 +4,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"io/ioutil"
 	"log"
 	"os"
 	"strings"
 
+	"github.com/dividat/driver/src/dividat-driver/benchmark"
+	"github.com/dividat/driver/src/dividat-driver/client"
+	"github.com/dividat/driver/src/dividat-driver/config"
+	"github.com/dividat/driver/src/dividat-driver/doctor"
 	"github.com/dividat/driver/src/dividat-driver/firmware"
 	"github.com/dividat/driver/src/dividat-driver/logging"
+	"github.com/dividat/driver/src/dividat-driver/profile"
 	"github.com/dividat/driver/src/dividat-driver/server"
 	"github.com/kardianos/service"
 	"github.com/sirupsen/logrus"
@@ -25,6 +31,14 @@ func main() {
 	// Serve command or start in daemon mode by default
 	if len(os.Args) > 1 && os.Args[1] == "update-firmware" {
 		firmware.Command(os.Args[2:])
+	} else if len(os.Args) > 1 && os.Args[1] == "support-link" {
+		server.SessionCommand(os.Args[2:])
+	} else if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		doctor.Command(os.Args[2:], newService(&program{}))
+	} else if len(os.Args) > 1 && os.Args[1] == "benchmark" {
+		benchmark.Command(os.Args[2:])
+	} else if len(os.Args) > 1 && os.Args[1] == "list-drivers" {
+		client.Command(os.Args[2:])
 	} else {
 		runDaemon()
 	}
@@ -39,18 +53,64 @@ func (p *program) Start(s service.Service) error {
 			logger.AddHook(logging.NewSystemHook(systemLogger))
 		}
 	}
-	logger.SetLevel(logrus.DebugLevel)
 
 	// Command-line flags
+	configPath := flag.String("config", "", "Path to a JSON configuration file. Command-line flags take precedence over its values.")
 	var permissibleOrigins stringList
 	flag.Var(&permissibleOrigins, "permissible-origin", "Permissible origin to make requests to the driver's HTTP endpoints, may be repeated. Default is a list of common Dividat origins.")
+	remoteAddress := flag.String("remote-address", "", "Additional address (host:port) on which to accept connections from other machines, e.g. 0.0.0.0:8383. Requires TLS certificate and key.")
+	tlsCert := flag.String("tls-cert", "", "Path to TLS certificate (PEM) used for remote connections.")
+	tlsKey := flag.String("tls-key", "", "Path to TLS private key (PEM) used for remote connections.")
+	var flexDevices stringList
+	flag.Var(&flexDevices, "flex-device", "Additional USB device (VID:PID in hexadecimal, e.g. 1209:F1E8) to treat as Flex device, may be repeated.")
 	flag.Parse()
-	if len(permissibleOrigins) == 0 {
-		permissibleOrigins = defaultOrigins
+
+	// Configuration file
+	cfg := config.Default()
+	if *configPath != "" {
+		var err error
+		cfg, err = config.Load(*configPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Settings managed centrally for fleets of machines
+	if cfg.Profile.Enabled() {
+		machineID := ""
+		if systemInfo, err := server.GetSystemInfo(); err == nil {
+			machineID = systemInfo.MachineId
+		}
+		cfg = profile.Apply(cfg, machineID, logger.WithField("package", "profile"))
+	}
+
+	cfg.Apply(config.Overrides{
+		PermissibleOrigins: permissibleOrigins,
+		Remote: config.RemoteAccess{
+			Address:  *remoteAddress,
+			CertFile: *tlsCert,
+			KeyFile:  *tlsKey,
+		},
+		FlexDevices: flexDevices,
+	})
+	logger.SetLevel(cfg.Level())
+
+	for _, id := range flexDevices {
+		if _, _, err := config.ParseUSBID(id); err != nil {
+			return err
+		}
+	}
+
+	// Device data may be health-related, never serve it in plaintext across the network
+	if cfg.Remote.Enabled() && (cfg.Remote.CertFile == "" || cfg.Remote.KeyFile == "") {
+		return errors.New("remote access requires a TLS certificate and key")
 	}
 
 	// Start server
-	p.close = server.Start(logger, permissibleOrigins)
+	p.close = server.Start(logger, cfg, func() {
+		p.close()
+		os.Exit(0)
+	})
 	return nil
 }
 
@@ -60,19 +120,22 @@ func (p *program) Stop(s service.Service) error {
 }
 
 func runDaemon() {
+	log.Fatal(newService(&program{}).Run())
+}
+
+// System service running the program
+func newService(prg *program) service.Service {
 	svcConfig := &service.Config{
 		Name:        "DividatDriver",
 		DisplayName: "Dividat Driver",
 		Description: "Dividat Driver application for hardware connectivity.",
 	}
 
-	prg := &program{}
 	s, err := service.New(prg, svcConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	log.Fatal(s.Run())
+	return s
 }
 
 // Flags
@@ -87,17 +150,3 @@ func (i *stringList) Set(value string) error {
 	*i = append(*i, value)
 	return nil
 }
-
-var defaultOrigins []string = []string{
-	"http://localhost:8080",
-	"https://play.dividat.ch",
-	"https://play.dividat.com",
-	"https://val-play.dividat.ch",
-	"https://val-play.dividat.com",
-	"https://dev-play.dividat.ch",
-	"https://dev-play.dividat.com",
-	"https://lab.dividat.ch",
-	"https://lab.dividat.com",
-	"https://shed.dividat.ch",
-	"https://shed.dividat.com",
-}
@@ -0,0 +1,144 @@
+package outbound
+
+/* HTTP clients for requests the driver makes to other services (firmware
+repository, telemetry, log shipping, webhooks).
+
+All outbound requests should use a client from this package, so that proxies
+and certificate authorities configured for the site are honored. Settings
+for specific destinations take precedence over the general settings.
+
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/config"
+)
+
+// Client returns an HTTP client honoring the outbound settings
+func Client(settings config.Outbound, timeout time.Duration) (*http.Client, error) {
+	transport, err := NewTransport(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// ClientWithCertificate returns an HTTP client like Client, presenting the
+// certificate to servers that ask for one
+func ClientWithCertificate(settings config.Outbound, certificate tls.Certificate, timeout time.Duration) (*http.Client, error) {
+	transport, err := newRouter(settings, []tls.Certificate{certificate})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// NewTransport returns a round tripper honoring the outbound settings, for
+// clients that need to be set up differently
+func NewTransport(settings config.Outbound) (http.RoundTripper, error) {
+	return newRouter(settings, nil)
+}
+
+func newRouter(settings config.Outbound, certificates []tls.Certificate) (*router, error) {
+	fallback, err := newTransport(settings.Proxy, settings.CABundle, certificates)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := []route{}
+	for _, destination := range settings.Destinations {
+		proxy := destination.Proxy
+		if proxy == "" {
+			proxy = settings.Proxy
+		}
+		caBundle := destination.CABundle
+		if caBundle == "" {
+			caBundle = settings.CABundle
+		}
+		transport, err := newTransport(proxy, caBundle, certificates)
+		if err != nil {
+			return nil, fmt.Errorf("destination %q: %v", destination.Host, err)
+		}
+		routes = append(routes, route{destination: destination, transport: transport})
+	}
+
+	return &router{routes: routes, fallback: fallback}, nil
+}
+
+// Settings of a destination, with the transport using them
+type route struct {
+	destination config.Destination
+	transport   *http.Transport
+}
+
+// Dispatches requests to the transport of the first matching destination
+type router struct {
+	routes   []route
+	fallback *http.Transport
+}
+
+func (router *router) RoundTrip(request *http.Request) (*http.Response, error) {
+	host := request.URL.Hostname()
+	for _, route := range router.routes {
+		if route.destination.Matches(host) {
+			return route.transport.RoundTrip(request)
+		}
+	}
+	return router.fallback.RoundTrip(request)
+}
+
+func newTransport(proxy string, caBundle string, certificates []tls.Certificate) (*http.Transport, error) {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	if proxy == config.ProxyDirect {
+		transport.Proxy = nil
+	} else if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if caBundle != "" || len(certificates) > 0 {
+		transport.TLSClientConfig = &tls.Config{Certificates: certificates}
+	}
+	if caBundle != "" {
+		pool, err := certPool(caBundle)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	return transport, nil
+}
+
+// System certificate authorities with the ones from the bundle added
+func certPool(caBundle string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caBundle)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA bundle: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", caBundle)
+	}
+	return pool, nil
+}
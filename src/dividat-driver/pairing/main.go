@@ -0,0 +1,100 @@
+package pairing
+
+/* Remembers devices an operator has confirmed to be the right ones.
+
+Shared facilities may have several devices reachable over the network. With
+pairing required, data from a device is only forwarded after an operator has
+confirmed the device once. Confirmed devices are stored on disk and are not
+asked for again.
+
+Devices are identified by a string chosen by the device module, e.g.
+"senso:" or "flex:" followed by the serial number. Clients confirm a device by
+echoing its identifier, so that a device swapped in meanwhile is not confirmed
+instead.
+
+*/
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Store of paired devices
+type Store struct {
+	path string
+
+	mutex   sync.Mutex
+	devices map[string]time.Time
+}
+
+// Open the store persisted at path, a missing file is an empty store
+func Open(path string) (*Store, error) {
+	store := Store{
+		path:    path,
+		devices: map[string]time.Time{},
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(contents, &store.devices)
+	if err != nil {
+		return nil, err
+	}
+
+	return &store, nil
+}
+
+// IsPaired returns whether the device has been confirmed before
+func (store *Store) IsPaired(device string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	_, paired := store.devices[device]
+	return paired
+}
+
+// Pair remembers a device as confirmed
+func (store *Store) Pair(device string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.devices[device] = time.Now().UTC()
+
+	contents, err := json.MarshalIndent(store.devices, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(store.path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(store.path, contents, 0644)
+}
+
+// Pending pairing of the device a handle is connected to
+type Pending struct {
+	mutex  sync.Mutex
+	device *string
+}
+
+// Set the device awaiting confirmation, nil if none
+func (pending *Pending) Set(device *string) {
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+	pending.device = device
+}
+
+// Device returns the device awaiting confirmation, nil if none
+func (pending *Pending) Device() *string {
+	pending.mutex.Lock()
+	defer pending.mutex.Unlock()
+	return pending.device
+}
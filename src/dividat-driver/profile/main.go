@@ -0,0 +1,143 @@
+package profile
+
+/* Configuration profiles fetched from a central endpoint.
+
+At startup the driver requests its profile from the configured HTTPS URL,
+identifying itself with its machine ID and optionally a client certificate.
+The profile is a JSON document with the same settings as the configuration
+file, taking precedence over them.
+
+Valid profiles are cached in the data directory. If the endpoint can not be
+reached or serves an invalid profile, the last cached profile is used, and
+without one the local configuration alone.
+
+*/
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/config"
+	"github.com/dividat/driver/src/dividat-driver/outbound"
+)
+
+// Header identifying the machine to the endpoint
+const MachineIDHeader = "X-Dividat-Machine-Id"
+
+// Startup should not be held up for long by an unreachable endpoint
+const fetchTimeout = 10 * time.Second
+
+// Profiles are small, refuse anything larger
+const maxProfileSize = 1 << 20
+
+// Name of the cached profile in the data directory
+const cacheFile = "profile.json"
+
+// Apply returns the configuration with the fetched or cached profile applied,
+// or the configuration itself if no profile is configured or available
+func Apply(cfg *config.Config, machineID string, log *logrus.Entry) *config.Config {
+	if !cfg.Profile.Enabled() {
+		return cfg
+	}
+	log = log.WithField("url", cfg.Profile.URL)
+
+	dataDirectory := cfg.DataDirectory
+	if dataDirectory == "" {
+		dataDirectory = config.DefaultDataDirectory()
+	}
+	cachePath := filepath.Join(dataDirectory, cacheFile)
+
+	profile, err := fetch(cfg.Profile, cfg.Outbound, machineID)
+	if err == nil {
+		var applied *config.Config
+		applied, err = cfg.WithProfile(profile)
+		if err == nil {
+			if err := writeCache(cachePath, profile); err != nil {
+				log.WithError(err).Warn("Could not cache configuration profile.")
+			}
+			log.Info("Applied configuration profile.")
+			return applied
+		}
+	}
+	log.WithError(err).Warn("Could not fetch configuration profile.")
+
+	cached, err := ioutil.ReadFile(cachePath)
+	if os.IsNotExist(err) {
+		log.Warn("No cached configuration profile, using local configuration.")
+		return cfg
+	} else if err != nil {
+		log.WithError(err).Warn("Could not read cached configuration profile, using local configuration.")
+		return cfg
+	}
+	applied, err := cfg.WithProfile(cached)
+	if err != nil {
+		log.WithError(err).Warn("Cached configuration profile is invalid, using local configuration.")
+		return cfg
+	}
+	log.Info("Applied cached configuration profile.")
+	return applied
+}
+
+func fetch(settings config.Profile, outboundSettings config.Outbound, machineID string) ([]byte, error) {
+	var client *http.Client
+	var err error
+	if settings.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		client, err = outbound.ClientWithCertificate(outboundSettings, certificate, fetchTimeout)
+	} else {
+		client, err = outbound.Client(outboundSettings, fetchTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(http.MethodGet, settings.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set(MachineIDHeader, machineID)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("endpoint answered %s", response.Status)
+	}
+
+	profile, err := ioutil.ReadAll(io.LimitReader(response.Body, maxProfileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(profile) > maxProfileSize {
+		return nil, fmt.Errorf("profile exceeds %d bytes", maxProfileSize)
+	}
+	return profile, nil
+}
+
+// Replace the cache atomically, so a crash can not leave a partial profile
+func writeCache(path string, profile []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	temporary := path + ".tmp"
+	err = ioutil.WriteFile(temporary, profile, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
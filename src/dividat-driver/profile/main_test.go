@@ -0,0 +1,68 @@
+package profile
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/config"
+)
+
+func TestApplyFallsBackToCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	profile := `{"label": "Central"}`
+	var machineID string
+	endpoint := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		machineID = r.Header.Get(MachineIDHeader)
+		w.Write([]byte(profile))
+	}))
+	defer endpoint.Close()
+
+	// Trust the test server
+	caBundle := filepath.Join(dir, "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: endpoint.Certificate().Raw})
+	if err := ioutil.WriteFile(caBundle, certificate, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.Label = "Local"
+	cfg.DataDirectory = dir
+	cfg.Outbound.CABundle = caBundle
+	cfg.Profile.URL = endpoint.URL
+	log := logrus.NewEntry(logrus.New())
+
+	applied := Apply(cfg, "machine", log)
+	if applied.Label != "Central" || machineID != "machine" {
+		t.Errorf("expected fetched profile for machine, got label %q for %q", applied.Label, machineID)
+	}
+
+	profile = `{"label": 1}`
+	applied = Apply(cfg, "machine", log)
+	if applied.Label != "Central" {
+		t.Errorf("expected cached profile after invalid profile, got label %q", applied.Label)
+	}
+
+	endpoint.Close()
+	applied = Apply(cfg, "machine", log)
+	if applied.Label != "Central" {
+		t.Errorf("expected cached profile while offline, got label %q", applied.Label)
+	}
+
+	os.Remove(filepath.Join(dir, cacheFile))
+	applied = Apply(cfg, "machine", log)
+	if applied.Label != "Local" {
+		t.Errorf("expected local configuration without cache, got label %q", applied.Label)
+	}
+}
@@ -1,18 +1,26 @@
 package main
 
 import (
-	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/dividat/driver/src/dividat-driver/capture"
 )
 
 func main() {
+	if len(os.Args) > 2 && os.Args[1] == "verify" {
+		verify(os.Args[2])
+		return
+	}
+
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 
@@ -24,36 +32,58 @@ func main() {
 	}
 	defer c.Close()
 
-	prev := time.Now()
+	metadata := capture.Metadata{
+		Source:     u.String(),
+		StartedAt:  time.Now().UTC(),
+		DeviceType: deviceType(u),
+	}
 
-	done := make(chan struct{})
+	messages := make(chan received)
 	go func() {
-		defer close(done)
+		defer close(messages)
 		for {
-			_, message, err := c.ReadMessage()
-			if err != nil {
-				break
-			}
-			encoded := base64.StdEncoding.EncodeToString(message)
-			now := time.Now()
-			d := now.Sub(prev)
-			prev = now
-			fmt.Println(fmt.Sprintf("%d, ", d.Nanoseconds()/1000000) + encoded)
+			messageType, message, err := c.ReadMessage()
 			if err != nil {
-				panic(err)
+				return
 			}
+			messages <- received{messageType, message, time.Now()}
 		}
 	}()
 
+	err = c.WriteMessage(websocket.TextMessage, []byte(`{"type": "GetStatus"}`))
+	if err != nil {
+		log.Printf("Could not request device status: %s", err)
+	}
+	early := awaitStatus(messages, &metadata)
+
+	writer, err := capture.NewWriter(os.Stdout, metadata)
+	if err != nil {
+		log.Fatalf("Could not write capture: %s", err)
+	}
+	write := func(message received) {
+		err := writer.WriteChunk(message.data, message.at)
+		if err != nil {
+			panic(err)
+		}
+	}
+	for _, message := range early {
+		write(message)
+	}
+
 	for {
 		select {
-		case <-done:
-			return
+		case message, ok := <-messages:
+			if !ok {
+				writer.Close()
+				return
+			}
+			write(message)
 		case <-interrupt:
 			c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-			select {
-			case <-done:
+			for message := range messages {
+				write(message)
 			}
+			writer.Close()
 			return
 		}
 	}
@@ -69,3 +99,96 @@ func parseUrl() url.URL {
 	}
 	return *u
 }
+
+// Endpoint recorded from, "senso" or "flex", empty if not apparent from the URL
+func deviceType(u url.URL) string {
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "senso" || segment == "flex" {
+			return segment
+		}
+	}
+	return ""
+}
+
+// Message received from the driver
+type received struct {
+	messageType int
+	data        []byte
+	at          time.Time
+}
+
+// Time to wait for the driver to answer GetStatus
+const statusTimeout = 2 * time.Second
+
+// Wait for the status of the device to fill in its serial number and firmware
+// version. Messages received in the meantime are returned to be recorded.
+// Metadata is left incomplete if the driver does not answer in time.
+func awaitStatus(messages <-chan received, metadata *capture.Metadata) []received {
+	early := []received{}
+	timeout := time.After(statusTimeout)
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				return early
+			}
+			early = append(early, message)
+
+			// Sensos report at the top level, Flex devices in `device`
+			var status struct {
+				Type            string `json:"type"`
+				SerialNumber    string `json:"serialNumber"`
+				FirmwareVersion string `json:"firmwareVersion"`
+				Device          *struct {
+					SerialNumber    string  `json:"serialNumber"`
+					FirmwareVersion *string `json:"firmwareVersion"`
+				} `json:"device"`
+			}
+			if message.messageType != websocket.TextMessage || json.Unmarshal(message.data, &status) != nil || status.Type != "Status" {
+				continue
+			}
+			metadata.SerialNumber = status.SerialNumber
+			metadata.FirmwareVersion = status.FirmwareVersion
+			if status.Device != nil {
+				metadata.SerialNumber = status.Device.SerialNumber
+				if status.Device.FirmwareVersion != nil {
+					metadata.FirmwareVersion = *status.Device.FirmwareVersion
+				}
+			}
+			return early
+		case <-timeout:
+			log.Print("No device status received, recording without device details.")
+			return early
+		}
+	}
+}
+
+// Check a capture file's integrity
+func verify(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Could not open capture: %s", err)
+	}
+	defer file.Close()
+
+	summary, err := capture.Verify(file)
+	if err != nil {
+		fmt.Printf("Invalid capture: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Valid capture from %s, started %s: %d chunks, %s\n", summary.Metadata.Source, summary.Metadata.StartedAt, summary.Chunks, summary.Duration)
+	if metadata := summary.Metadata; metadata.DeviceType != "" {
+		fmt.Printf("Recorded from %s %s with firmware %s.\n", metadata.DeviceType, orUnknown(metadata.SerialNumber), orUnknown(metadata.FirmwareVersion))
+	}
+	if summary.Dropped > 0 {
+		fmt.Printf("%d Flex sets were dropped while recording.\n", summary.Dropped)
+	}
+}
+
+func orUnknown(value string) string {
+	if value == "" {
+		return "(unknown)"
+	}
+	return value
+}
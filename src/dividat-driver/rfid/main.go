@@ -39,9 +39,10 @@ import (
 	"sync"
 	"time"
 
-	"github.com/cskr/pubsub"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/broker"
 )
 
 const Topic = "rfid-tokens"
@@ -49,7 +50,7 @@ const Topic = "rfid-tokens"
 // RFID handle
 
 type Handle struct {
-	broker *pubsub.PubSub
+	broker *broker.Broker
 
 	ctx context.Context
 
@@ -62,7 +63,7 @@ type Handle struct {
 
 func NewHandle(ctx context.Context, log *logrus.Entry) *Handle {
 	handle := Handle{
-		broker:       pubsub.New(2),
+		broker:       broker.New(2),
 		ctx:          ctx,
 		log:          log,
 		knownReaders: []string{},
@@ -196,7 +197,7 @@ func (handle *Handle) StreamEvents(w http.ResponseWriter, r *http.Request) {
 		}
 		return nil
 	}
-	rx := handle.broker.Sub(Topic)
+	rx := handle.broker.SubFor(ctx, Topic)
 	go rx_data_loop(ctx, rx, send)
 
 	// Helper function to close the connection
@@ -0,0 +1,81 @@
+package senso
+
+// A Senso is connected through two TCP channels: frames are received on the
+// data channel, commands are written to and answered on the control channel.
+// Each channel declares which commands of clients are routed to it.
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type channel struct {
+	name string
+	port string
+	// Topic of commands written to the channel for the device with the given
+	// identifier, nil if the channel takes no commands
+	commands func(id string) string
+	// Whether data arrives continually, so the read timeout applies
+	streams bool
+}
+
+var dataChannel = channel{name: "data", port: "55568", streams: true}
+
+var controlChannel = channel{name: "control", port: "55567", commands: txTopic}
+
+// Channels of a connection, in the order they are connected
+var channels = []channel{dataChannel, controlChannel}
+
+// Delay between connecting the channels of a connection
+const channelDelay = 1000 * time.Millisecond
+
+// Command of a client, answered with the outcome of writing it
+type clientCommand struct {
+	data []byte
+	done chan error
+}
+
+// How long a command of a client waits for the control channel to take it
+const commandTimeout = 2 * time.Second
+
+// Write a command of a client to the control channel of a device, returning
+// once it is written or could not be
+func (handle *Handle) sendCommand(id string, data []byte) error {
+	device := handle.device(id)
+	if device == nil {
+		return errors.New("no Senso connected")
+	}
+	command := clientCommand{data: data, done: make(chan error, 1)}
+	select {
+	case device.commands <- command:
+		return <-command.done
+	case <-time.After(commandTimeout):
+		return errors.New("Senso control channel not connected")
+	}
+}
+
+// Subscribe to the commands routed to the channel of a device, nil (never
+// receiving) if the channel takes none
+func (handle *Handle) route(ctx context.Context, ch channel, id string) chan interface{} {
+	if ch.commands == nil {
+		return nil
+	}
+	return handle.broker.SubFor(ctx, ch.commands(id))
+}
+
+// Keep the channel of a device connected until the context is done, speaking
+// the given protocol. Commands of clients are written if the channel takes
+// commands.
+func (handle *Handle) connectChannel(ctx context.Context, log *logrus.Entry, id string, address string, ch channel, protocol string, commands <-chan clientCommand, onReceive onReceive, onConnection func(bool)) {
+	settings := handle.tcp
+	if !ch.streams {
+		settings.ReadTimeout = 0
+	}
+	if ch.commands == nil {
+		commands = nil
+	}
+	connectTCP(ctx, log.WithField("channel", ch.name), address+":"+handle.protocols.port(ch, protocol), settings, handle.route(ctx, ch, id), commands, onReceive, onConnection)
+}
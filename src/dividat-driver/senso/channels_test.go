@@ -0,0 +1,60 @@
+package senso
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestClientCommandsAreWrittenToTheControlChannel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	log := logrus.NewEntry(logger)
+	handle := New(ctx, log, nil)
+
+	if err := handle.sendCommand("left", []byte{1}); err == nil {
+		t.Error("expected command to a device that is not connected to fail")
+	}
+
+	left := &device{cancel: func() {}, connection: &connectionState{}, commands: make(chan clientCommand)}
+	handle.setDevice("left", left)
+	go connectTCP(ctx, log, listener.Addr().String(), handle.tcp, nil, left.commands, func([]byte) {}, func(bool) {})
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	// Taken once the connection is established
+	if err := handle.sendCommand("left", []byte{1, 2, 3}); err != nil {
+		t.Fatalf("expected command to be written, got %v", err)
+	}
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		received := make([]byte, 3)
+		if _, err := io.ReadFull(conn, received); err != nil || !bytes.Equal(received, []byte{1, 2, 3}) {
+			t.Errorf("expected the command to be received, got %v (%v)", received, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the Senso to be connected")
+	}
+}
@@ -0,0 +1,212 @@
+package senso
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/clock"
+)
+
+// States of the connection with the Senso, as reported in Status
+const (
+	// No Senso selected
+	disconnected = "disconnected"
+	// Senso selected, but not all channels are connected, e.g. while reconnecting
+	connecting = "connecting"
+	// Data and control channels are connected
+	connected = "connected"
+)
+
+// Health of a connected Senso, judged by the age of the last frame received on
+// the data channel
+const (
+	// Frames arrive
+	healthy = "ok"
+	// No frame for degradedAfter, or none yet
+	degraded = "degraded"
+	// No frame for stalledAfter
+	stalled = "stalled"
+)
+
+// HealthSettings decide when a connected Senso is considered degraded or
+// stalled, and whether it is reconnected once stalled
+type HealthSettings struct {
+	DegradedAfter time.Duration
+	StalledAfter  time.Duration
+	// Reconnect stalled Sensos, e.g. after a firmware hang
+	Reconnect bool
+}
+
+// DefaultHealthSettings are used unless configured otherwise
+var DefaultHealthSettings = HealthSettings{
+	DegradedAfter: 1 * time.Second,
+	StalledAfter:  5 * time.Second,
+}
+
+// Interval at which the health of the connection is checked
+const healthInterval = 1 * time.Second
+
+// Health of the connection, reported in Status while connected
+type Health struct {
+	State string `json:"state"`
+	// Seconds since the last frame was received, nil if none was received yet
+	LastFrameAge *float64 `json:"lastFrameAge"`
+}
+
+// Tracks which channels of the current connection are connected
+type connectionState struct {
+	// Thresholds of the health, set when connecting
+	degradedAfter time.Duration
+	stalledAfter  time.Duration
+
+	mutex sync.Mutex
+	// Nil if no Senso is selected
+	channels map[string]bool
+	// When the data channel last connected and last received a frame
+	since     time.Time
+	lastFrame time.Time
+	// Protocol spoken with the Senso, empty until detected
+	protocol string
+}
+
+// Start tracking a new connection, none of whose channels are connected yet
+func (state *connectionState) reset() {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	state.channels = map[string]bool{}
+	state.since = time.Time{}
+	state.lastFrame = time.Time{}
+	state.protocol = ""
+}
+
+func (state *connectionState) setProtocol(protocol string) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	state.protocol = protocol
+}
+
+func (state *connectionState) getProtocol() string {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.protocol
+}
+
+// Stop tracking, no Senso is selected
+func (state *connectionState) clear() {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	state.channels = nil
+}
+
+// Record that a frame was received on the data channel
+func (state *connectionState) received(at time.Time) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	state.lastFrame = at
+}
+
+// Health of the connection at the given time, nil if not connected
+func (state *connectionState) health(now time.Time) *Health {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.describe() != connected {
+		return nil
+	}
+
+	if state.lastFrame.IsZero() || state.lastFrame.Before(state.since) {
+		// Silent since connecting
+		if now.Sub(state.since) >= state.stalledAfter {
+			return &Health{State: stalled}
+		}
+		return &Health{State: degraded}
+	}
+
+	age := now.Sub(state.lastFrame)
+	seconds := age.Seconds()
+	health := Health{State: healthy, LastFrameAge: &seconds}
+	if age >= state.stalledAfter {
+		health.State = stalled
+	} else if age >= state.degradedAfter {
+		health.State = degraded
+	}
+	return &health
+}
+
+// Record whether a channel is connected, returns whether the state changed
+func (state *connectionState) set(channel string, isConnected bool) bool {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.channels == nil {
+		return false
+	}
+	before := state.describe()
+	state.channels[channel] = isConnected
+	if channel == dataChannel.name && isConnected {
+		state.since = clock.Default.Now()
+	}
+	return state.describe() != before
+}
+
+func (state *connectionState) get() string {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.describe()
+}
+
+func (state *connectionState) describe() string {
+	if state.channels == nil {
+		return disconnected
+	}
+	for _, channel := range channels {
+		if !state.channels[channel.name] {
+			return connecting
+		}
+	}
+	return connected
+}
+
+// Check the health of a connection periodically, informing clients when it
+// changes, until the connection is replaced. Stalled connections are replaced
+// if configured.
+func (handle *Handle) watchHealth(ctx context.Context, id string, current *device) {
+	connection := current.connection
+	ticker := clock.Default.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	last := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			state := ""
+			if health := connection.health(now); health != nil {
+				state = health.State
+			}
+			if state != last {
+				if state == stalled {
+					handle.log.WithField("after", connection.stalledAfter).Warn("Senso is connected but sends no data.")
+				}
+				// Changes of connection state are broadcast on their own
+				if state != "" && last != "" {
+					handle.Broadcast(handle.status())
+				}
+				last = state
+				if state == stalled && handle.health.Reconnect {
+					go handle.reconnectStalled(id, current)
+					return
+				}
+			}
+		}
+	}
+}
+
+// Connect again to the Senso of a stalled connection, unless it was replaced
+func (handle *Handle) reconnectStalled(id string, stalledDevice *device) {
+	if handle.device(id) != stalledDevice {
+		return
+	}
+	handle.log.WithField("address", stalledDevice.address).Info("Reconnecting stalled Senso.")
+	handle.connect(id, stalledDevice.address, stalledDevice.serial, stalledDevice.alternatives)
+}
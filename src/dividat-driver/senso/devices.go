@@ -0,0 +1,240 @@
+package senso
+
+// Connections to several Sensos at once.
+//
+// Each connection belongs to a device identifier chosen by the client, e.g.
+// "left" and "right" for two plates driven from one machine. Clients that do
+// not name a device address the default device, whose identifier is empty, so
+// that they keep working unchanged.
+//
+// Clients connecting with the query parameter `envelope=device` receive frames
+// of all devices wrapped in an envelope naming the device, and send frames in
+// the same envelope: one byte giving the length of the identifier, the
+// identifier, then the frame. Other clients receive and send frames of the
+// default device only, without envelope.
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Device addressed by clients that do not name one
+const defaultDevice = ""
+
+// Longest device identifier that fits the envelope
+const maxDeviceLength = 255
+
+// Connection to one Senso
+type device struct {
+	address string
+	// Empty if connected by address
+	serial string
+	// Other known paths to the Senso, if connected by serial. Set before the
+	// device is stored and not changed afterwards.
+	alternatives []string
+	cancel       context.CancelFunc
+	connection   *connectionState
+	// Serial number and firmware version reported by the Senso
+	info deviceInfo
+	// Whether data is held back until an operator confirms the Senso
+	pairing pairingState
+	// Commands of clients, taken while the control channel is connected
+	commands chan clientCommand
+
+	// Held while publishing data, so none is published once the connection
+	// is cancelled
+	publishing sync.RWMutex
+}
+
+// Publish data received from the Senso unless the connection is cancelled
+func (device *device) publish(ctx context.Context, publish func()) {
+	device.publishing.RLock()
+	defer device.publishing.RUnlock()
+	if ctx.Err() == nil {
+		publish()
+	}
+}
+
+// Pairing of a connected Senso, each device awaits confirmation on its own
+type pairingState struct {
+	mutex sync.Mutex
+	// Identifier the Senso is paired by, empty if pairing is not required
+	paired   string
+	awaiting bool
+}
+
+// Hold back data until the Senso with given identifier is confirmed
+func (state *pairingState) await(paired string) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	state.paired = paired
+	state.awaiting = true
+}
+
+// Identifier of the Senso if it awaits confirmation, empty otherwise
+func (state *pairingState) pending() string {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if !state.awaiting {
+		return ""
+	}
+	return state.paired
+}
+
+// Release data if the Senso awaits confirmation as the given identifier,
+// returning whether it did
+func (state *pairingState) confirm(paired string) bool {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if !state.awaiting || state.paired != paired {
+		return false
+	}
+	state.awaiting = false
+	return true
+}
+
+// Cancel the connection, returning once no more data is published
+func (device *device) close() {
+	device.publishing.Lock()
+	defer device.publishing.Unlock()
+	device.cancel()
+}
+
+// DeviceStatus is the status of a named device
+type DeviceStatus struct {
+	Device       string   `json:"device"`
+	Address      string   `json:"address"`
+	Alternatives []string `json:"alternatives,omitempty"`
+	Connection   string   `json:"connection"`
+	Health       *Health  `json:"health,omitempty"`
+	// Empty until the Senso reported them
+	SerialNumber    string `json:"serialNumber,omitempty"`
+	FirmwareVersion string `json:"firmwareVersion,omitempty"`
+	// "current" or "legacy", empty until detected
+	Protocol string `json:"protocol,omitempty"`
+	// Identifier of the Senso if it awaits confirmation to pair
+	PairingRequired string `json:"pairingRequired,omitempty"`
+}
+
+// State returns the connection of the device, or its health if degraded or
+// stalled
+func (status DeviceStatus) State() string {
+	if status.Health != nil && status.Health.State != healthy {
+		return status.Health.State
+	}
+	return status.Connection
+}
+
+// Topic of frames to be sent to the control channel of a device
+func txTopic(id string) string {
+	if id == defaultDevice {
+		return "tx"
+	}
+	return "tx/" + id
+}
+
+// Wrap a frame in an envelope naming its device
+func envelope(id string, data []byte) []byte {
+	wrapped := make([]byte, 0, 1+len(id)+len(data))
+	wrapped = append(wrapped, byte(len(id)))
+	wrapped = append(wrapped, id...)
+	return append(wrapped, data...)
+}
+
+// Unwrap a frame from an envelope, returning its device
+func unwrap(wrapped []byte) (string, []byte, error) {
+	if len(wrapped) < 1 || len(wrapped) < 1+int(wrapped[0]) {
+		return "", nil, errors.New("frame is shorter than its envelope")
+	}
+	length := int(wrapped[0])
+	return string(wrapped[1 : 1+length]), wrapped[1+length:], nil
+}
+
+func validDevice(id string) error {
+	if len(id) > maxDeviceLength {
+		return errors.New("device identifier is longer than 255 bytes")
+	}
+	return nil
+}
+
+// Connection of a device, nil if not connected
+func (handle *Handle) device(id string) *device {
+	handle.devicesMutex.Lock()
+	defer handle.devicesMutex.Unlock()
+	return handle.devices[id]
+}
+
+func (handle *Handle) setDevice(id string, device *device) {
+	handle.devicesMutex.Lock()
+	defer handle.devicesMutex.Unlock()
+	if device == nil {
+		delete(handle.devices, id)
+	} else {
+		handle.devices[id] = device
+	}
+}
+
+// Release data of all devices awaiting confirmation as the given identifier,
+// returning whether there were any
+func (handle *Handle) confirmPairing(paired string) bool {
+	handle.devicesMutex.Lock()
+	defer handle.devicesMutex.Unlock()
+	confirmed := false
+	for _, device := range handle.devices {
+		if device.pairing.confirm(paired) {
+			confirmed = true
+		}
+	}
+	return confirmed
+}
+
+// Identifier of the first device by identifier awaiting confirmation, nil if
+// none
+func (handle *Handle) pendingPairing() *string {
+	handle.devicesMutex.Lock()
+	defer handle.devicesMutex.Unlock()
+	ids := []string{}
+	for id, device := range handle.devices {
+		if device.pairing.pending() != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Strings(ids)
+	pending := handle.devices[ids[0]].pairing.pending()
+	return &pending
+}
+
+// Status of all named devices, and the default device if listed, ordered by
+// identifier
+func (handle *Handle) namedDevices(now time.Time, listDefault bool) []DeviceStatus {
+	handle.devicesMutex.Lock()
+	defer handle.devicesMutex.Unlock()
+	statuses := []DeviceStatus{}
+	for id, device := range handle.devices {
+		if id == defaultDevice && !listDefault {
+			continue
+		}
+		status := DeviceStatus{
+			Device:       id,
+			Address:      device.address,
+			Alternatives: device.alternatives,
+			Connection:   device.connection.get(),
+			Health:       device.connection.health(now),
+			Protocol:     device.connection.getProtocol(),
+			// Held back data is released once confirmed
+			PairingRequired: device.pairing.pending(),
+		}
+		if info := device.info.get(); info != nil {
+			status.SerialNumber, status.FirmwareVersion = info.SerialNumber, info.FirmwareVersion
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Device < statuses[j].Device })
+	return statuses
+}
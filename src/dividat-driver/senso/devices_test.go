@@ -0,0 +1,59 @@
+package senso
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/pairing"
+)
+
+func TestPairingIsConfirmedPerDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "senso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := pairing.Open(filepath.Join(dir, "paired-devices.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handle := New(ctx, logrus.NewEntry(logrus.New()), store)
+
+	// Two unpaired Sensos connected at once
+	left := &device{address: "192.168.1.10", serial: "A", cancel: func() {}, connection: &connectionState{}}
+	left.pairing.await("senso:A")
+	handle.setDevice("left", left)
+	right := &device{address: "192.168.1.11", serial: "B", cancel: func() {}, connection: &connectionState{}}
+	right.pairing.await("senso:B")
+	handle.setDevice("right", right)
+
+	handle.ConfirmPairing("senso:B")
+	if right.pairing.pending() != "" {
+		t.Error("expected data of the confirmed Senso to be forwarded")
+	}
+	if left.pairing.pending() != "senso:A" {
+		t.Error("expected the other Senso to keep awaiting confirmation")
+	}
+	if pending := handle.pendingPairing(); pending == nil || *pending != "senso:A" {
+		t.Errorf("expected status to report senso:A awaiting confirmation, got %v", pending)
+	}
+
+	// Neither another disconnection nor a confirmation of an unknown device
+	// releases data of the Senso awaiting confirmation
+	handle.Disconnect("right")
+	handle.ConfirmPairing("senso:C")
+	if left.pairing.pending() != "senso:A" {
+		t.Error("expected the remaining Senso to keep awaiting confirmation")
+	}
+
+	if !store.IsPaired("senso:B") || store.IsPaired("senso:A") || store.IsPaired("senso:C") {
+		t.Error("expected only the confirmed Senso to be paired")
+	}
+}
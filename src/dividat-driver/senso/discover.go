@@ -0,0 +1,139 @@
+package senso
+
+// Discover streams Sensos announced via mDNS as Discovered messages. In the
+// unified mode (devices "all"), the Flex devices connected to this machine are
+// listed too, and all results are sent as DeviceInfo messages telling the
+// type of device, so that clients can present a single device picker.
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+	"github.com/dividat/driver/src/dividat-driver/service"
+)
+
+// Devices discovered by the Discover command
+const (
+	sensoDevices = "senso"
+	allDevices   = "all"
+)
+
+// Types of discovered devices
+const (
+	sensoDeviceType = "senso"
+	flexDeviceType  = "flex"
+)
+
+// DiscoveredDevice is a device found in the unified discovery
+type DiscoveredDevice struct {
+	// "senso" or "flex"
+	DeviceType   string `json:"deviceType"`
+	SerialNumber string `json:"serialNumber"`
+	// IP address of Sensos, path of the serial port of Flex devices
+	Address string `json:"address"`
+	// Whether the Senso is in bootloader mode, awaiting a firmware update
+	Bootloader bool `json:"bootloader,omitempty"`
+	// USB identification of Flex devices
+	VID string `json:"vid,omitempty"`
+	PID string `json:"pid,omitempty"`
+	// Whether the device was discovered before, at another address
+	Updated bool `json:"updated,omitempty"`
+}
+
+func discoveredSenso(entry service.Service) DiscoveredDevice {
+	return DiscoveredDevice{
+		DeviceType:   sensoDeviceType,
+		SerialNumber: entry.Text.Serial,
+		Address:      entry.Address,
+		Bootloader:   service.IsDfuService(entry),
+	}
+}
+
+func discoveredFlex(device enumerator.Device) DiscoveredDevice {
+	return DiscoveredDevice{
+		DeviceType:   flexDeviceType,
+		SerialNumber: device.SerialNumber,
+		Address:      device.Path,
+		VID:          fmt.Sprintf("%04X", device.VID),
+		PID:          fmt.Sprintf("%04X", device.PID),
+	}
+}
+
+// Check the devices to discover are known
+func (command Discover) validate() error {
+	if command.Devices != "" && command.Devices != sensoDevices && command.Devices != allDevices {
+		return fmt.Errorf("unknown devices to discover %q, expected senso or all", command.Devices)
+	}
+	return nil
+}
+
+// SetFlexDevices lists Flex devices in the unified discovery, which finds
+// Sensos only otherwise. Must be called before clients connect.
+func (handle *Handle) SetFlexDevices(list func() ([]enumerator.Device, error)) {
+	handle.listFlexDevices = list
+}
+
+// Flex devices connected to this machine, none if they can not be listed
+func (handle *Handle) discoverFlex() []DiscoveredDevice {
+	discovered := []DiscoveredDevice{}
+	if handle.listFlexDevices == nil {
+		return discovered
+	}
+	devices, err := handle.listFlexDevices()
+	if err != nil {
+		handle.log.WithError(err).Warn("Could not list Flex devices for discovery.")
+		return discovered
+	}
+	for _, device := range devices {
+		discovered = append(discovered, discoveredFlex(device))
+	}
+	return discovered
+}
+
+// CancelDiscover command, aborts the discoveries running for the client
+type CancelDiscover struct{}
+
+// Discoveries running for each session, so that clients can abort them
+type discoveries struct {
+	mutex   sync.Mutex
+	next    int
+	running map[int]map[int]context.CancelFunc
+}
+
+// Track a discovery of the session, returning a function to call once it
+// finished
+func (d *discoveries) start(session int, cancel context.CancelFunc) func() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.running == nil {
+		d.running = make(map[int]map[int]context.CancelFunc)
+	}
+	if d.running[session] == nil {
+		d.running[session] = make(map[int]context.CancelFunc)
+	}
+	id := d.next
+	d.next++
+	d.running[session][id] = cancel
+	return func() {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		delete(d.running[session], id)
+		if len(d.running[session]) == 0 {
+			delete(d.running, session)
+		}
+	}
+}
+
+// Abort the discoveries of the session, returning how many were running
+func (d *discoveries) cancel(session int) int {
+	d.mutex.Lock()
+	running := d.running[session]
+	delete(d.running, session)
+	d.mutex.Unlock()
+	for _, cancel := range running {
+		cancel()
+	}
+	return len(running)
+}
@@ -0,0 +1,71 @@
+package senso
+
+// Following a Senso whose address changes, e.g. when its DHCP lease is renewed.
+//
+// While a device is connected, the Senso is looked up via mDNS by its serial
+// number every so often. The serial number of Sensos connected by address is
+// learned from the announcement of that address. Once the Senso is no longer
+// announced at the address connected to, the connections move to the best of
+// its new addresses and clients are sent the updated status.
+
+import (
+	"context"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/clock"
+	"github.com/dividat/driver/src/dividat-driver/service"
+)
+
+// Interval of looking up the address of a connected Senso
+const followInterval = 30 * time.Second
+
+func (handle *Handle) followAddress(ctx context.Context, id string, address string, serial string) {
+	ticker := clock.Default.NewTicker(followInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		if serial == "" {
+			serial = discoverSerial(ctx, address)
+			if serial == "" {
+				continue
+			}
+		}
+
+		addresses := handle.allowed(discoverAddresses(ctx, serial))
+		if len(addresses) == 0 || contains(addresses, address) || ctx.Err() != nil {
+			continue
+		}
+
+		handle.log.WithField("serial", serial).WithField("address", address).WithField("addresses", addresses).Info("Senso changed its address, following it.")
+		// Replaces this connection, cancelling the context
+		handle.connectBest(id, serial, addresses)
+		return
+	}
+}
+
+// Serial number of the Senso announced at the address, empty if none
+func discoverSerial(ctx context.Context, address string) string {
+	ctx, cancel := context.WithTimeout(ctx, candidateDiscoveryTimeout)
+	defer cancel()
+
+	serial := ""
+	for discovered := range service.Scan(ctx) {
+		if serial != "" || service.IsDfuService(discovered) {
+			continue
+		}
+		for _, ip := range discovered.ServiceEntry.AddrIPv4 {
+			if ip.String() == address {
+				serial = discovered.Text.Serial
+				// Stop scanning, the channel is closed once done
+				cancel()
+			}
+		}
+	}
+	return serial
+}
@@ -0,0 +1,225 @@
+package senso
+
+// Clients connecting with the query parameter `frames=json` receive data of
+// the Senso decoded into Frame messages instead of binary frames, so that they
+// need not implement the binary protocol.
+//
+// Packets start with a header of 8 bytes, giving the protocol version in the
+// first and the number of blocks in the second byte. Each block has a header
+// giving its length and type (uint16 each, little-endian), followed by as many
+// bytes. The data channel mostly carries packets of 56 bytes holding a single
+// block of a data frame, a timestamp in milliseconds (uint32) followed by four
+// readings (int16) for each plate, but answers to commands may be sent on it
+// too (see rec/senso/miscalibration).
+//
+// Older firmware sends an all-zero packet header and gives a data block length
+// of 45 for 44 bytes (see rec/senso/simple.dat). Its packets are taken to hold
+// a single data block.
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Type of blocks holding a data frame
+const dataBlockType = 0x80
+
+// Version in the packet header of current firmware, as recorded in
+// rec/senso/static
+const packetVersion = 1
+
+const (
+	packetHeaderSize = 8
+	blockHeaderSize  = 4
+	readingsPerPlate = 4
+	dataBlockSize    = 4 + len(plates)*readingsPerPlate*2
+	dataPacketSize   = packetHeaderSize + blockHeaderSize + dataBlockSize
+)
+
+// Longest packet accepted, longer ones mean the framing was lost
+const maxPacketSize = 4096
+
+// Plates in the order their readings are sent
+var plates = [...]string{"center", "up", "right", "down", "left"}
+
+// Frame is a decoded data frame of a Senso
+type Frame struct {
+	// Identifier of the device that received the frame
+	Device    string  `json:"device,omitempty"`
+	Timestamp uint32  `json:"timestamp"`
+	Plates    []Plate `json:"plates"`
+	// Unit of plate values and identifier of the calibration they were
+	// converted with, empty if not converted
+	Unit        string `json:"unit,omitempty"`
+	Calibration string `json:"calibration,omitempty"`
+}
+
+// Plate holds the readings of one plate of a frame
+type Plate struct {
+	Plate  string                  `json:"plate"`
+	Forces [readingsPerPlate]int16 `json:"forces"`
+	// Converted value, nil if not converted
+	Value *float64 `json:"value,omitempty"`
+}
+
+// Packet header announcing the number of blocks following it
+func packetHeader(blocks int) []byte {
+	header := make([]byte, packetHeaderSize)
+	header[0] = packetVersion
+	header[1] = byte(blocks)
+	return header
+}
+
+var (
+	errUnknownBlock = errors.New("data channel packet without version holds no data frame")
+	errTooLong      = errors.New("data channel packet is too long")
+)
+
+// Reassembles packets, which TCP may split or join
+type packetSplitter struct {
+	buffer []byte
+}
+
+// Packets completed by the received data. Data is dropped if the packet
+// boundary is lost, i.e. if a packet is too long or a packet without version
+// holds no data frame.
+func (splitter *packetSplitter) split(data []byte) ([][]byte, error) {
+	splitter.buffer = append(splitter.buffer, data...)
+
+	var packets [][]byte
+	for {
+		length, err := packetLength(splitter.buffer)
+		if err != nil {
+			splitter.reset()
+			return packets, err
+		}
+		if length == 0 {
+			break
+		}
+		packets = append(packets, splitter.buffer[:length:length])
+		splitter.buffer = splitter.buffer[length:]
+	}
+
+	// Do not hold on to the backing array of consumed packets
+	if len(splitter.buffer) == 0 {
+		splitter.buffer = nil
+	}
+	return packets, nil
+}
+
+// Forget partial packets, e.g. when the channel reconnects
+func (splitter *packetSplitter) reset() {
+	splitter.buffer = nil
+}
+
+// Length of the packet at the start of the data, 0 if it is incomplete
+func packetLength(data []byte) (int, error) {
+	if len(data) < packetHeaderSize+blockHeaderSize {
+		return 0, nil
+	}
+	if data[0] == 0 {
+		if binary.LittleEndian.Uint16(data[packetHeaderSize+2:])&0x7fff != dataBlockType {
+			return 0, errUnknownBlock
+		}
+		if len(data) < dataPacketSize {
+			return 0, nil
+		}
+		return dataPacketSize, nil
+	}
+
+	length := packetHeaderSize
+	for i := 0; i < int(data[1]); i++ {
+		if len(data) < length+blockHeaderSize {
+			return 0, nil
+		}
+		length += blockHeaderSize + int(binary.LittleEndian.Uint16(data[length:]))
+		if length > maxPacketSize {
+			return 0, errTooLong
+		}
+	}
+	if len(data) < length {
+		return 0, nil
+	}
+	return length, nil
+}
+
+// Block of a packet
+type block struct {
+	blockType uint16
+	payload   []byte
+}
+
+// Blocks of a complete packet, as many as its header announces and fit
+func packetBlocks(packet []byte) []block {
+	if len(packet) < packetHeaderSize {
+		return nil
+	}
+	if packet[0] == 0 {
+		if len(packet) < dataPacketSize {
+			return nil
+		}
+		return []block{{
+			blockType: binary.LittleEndian.Uint16(packet[packetHeaderSize+2:]),
+			payload:   packet[packetHeaderSize+blockHeaderSize : dataPacketSize],
+		}}
+	}
+
+	var blocks []block
+	data := packet[packetHeaderSize:]
+	for i := 0; i < int(packet[1]) && len(data) >= blockHeaderSize; i++ {
+		length := int(binary.LittleEndian.Uint16(data))
+		if len(data) < blockHeaderSize+length {
+			break
+		}
+		blocks = append(blocks, block{
+			blockType: binary.LittleEndian.Uint16(data[2:]),
+			payload:   data[blockHeaderSize : blockHeaderSize+length],
+		})
+		data = data[blockHeaderSize+length:]
+	}
+	return blocks
+}
+
+// Data frames held by a complete packet
+func packetFrames(packet []byte) []Frame {
+	var frames []Frame
+	for _, block := range packetBlocks(packet) {
+		if block.blockType&0x7fff == dataBlockType && len(block.payload) >= dataBlockSize {
+			frames = append(frames, decodeFrame(block.payload))
+		}
+	}
+	return frames
+}
+
+// Decodes the frames of the data channel
+type frameDecoder struct {
+	packets packetSplitter
+}
+
+// Decode the frames completed by the received data
+func (decoder *frameDecoder) decode(data []byte) ([]Frame, error) {
+	packets, err := decoder.packets.split(data)
+	var frames []Frame
+	for _, packet := range packets {
+		frames = append(frames, packetFrames(packet)...)
+	}
+	return frames, err
+}
+
+// Forget partial packets, e.g. when the channel reconnects
+func (decoder *frameDecoder) reset() {
+	decoder.packets.reset()
+}
+
+func decodeFrame(block []byte) Frame {
+	frame := Frame{Timestamp: binary.LittleEndian.Uint32(block), Plates: make([]Plate, len(plates))}
+	readings := block[4:]
+	for i, name := range plates {
+		frame.Plates[i].Plate = name
+		for j := range frame.Plates[i].Forces {
+			offset := (i*readingsPerPlate + j) * 2
+			frame.Plates[i].Forces[j] = int16(binary.LittleEndian.Uint16(readings[offset:]))
+		}
+	}
+	return frame
+}
@@ -0,0 +1,121 @@
+package senso
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+)
+
+// Packets of the data channel, as recorded in rec/senso
+var (
+	// Current firmware, from front-step.dat
+	recordedPacket = "AQEAAAAAAAAsAIAAPHMgAAQnFiG+NwA1vQAJAAEAEwHu/6YA+gAGANX/3wAfAcb+nAFs/w3+AAM="
+	// Firmware sending an all-zero header, from simple.dat
+	recordedUnversionedPacket = "AAAAAAAAAAAtAIAAdcoJAOr/LgD1/wAAFgAhAAsAEQA2AC0A6/8RANv/BwBKAKH/DwAlALUA0P8="
+	// Answer to a command followed by a data packet, received at once, from
+	// miscalibration/full-body-center.dat
+	recordedAnswerAndPacket = "AQEAAAAAAAB4ANCAAAAAAAAAAADrDOkTxC7YLp9MCQEGAAAAAAAAAIEMHRKBLRctE0u6vgYAAAAAAAAAgQyuEo4toi00S6q+BgAAAAAAAACHDCoSUi2HLSBLqL4GAAAAAAAAAIcMnhFFLWYtIEupvgYAAAAAAAAAjwwxEoctZi0tS6u+AQEAAAAAAAAsAIAAvV0BAFfhpt3A0XDCAwArAAkAGgAVANf/dP8CAFkAkv/J/loAIP+dAG4B6v0="
+)
+
+func decodeBase64(t *testing.T, data string) []byte {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestFrameDecoderDecodesRecordedPackets(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		data      string
+		timestamp uint32
+		center    [readingsPerPlate]int16
+	}{
+		{"current firmware", recordedPacket, 2126652, [readingsPerPlate]int16{9988, 8470, 14270, 13568}},
+		{"all-zero header", recordedUnversionedPacket, 641653, [readingsPerPlate]int16{-22, 46, -11, 0}},
+		{"answer before data", recordedAnswerAndPacket, 89533, [readingsPerPlate]int16{-7849, -8794, -11840, -15760}},
+	} {
+		decoder := frameDecoder{}
+		frames, err := decoder.decode(decodeBase64(t, test.data))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if len(frames) != 1 {
+			t.Errorf("%s: expected 1 frame, got %d", test.name, len(frames))
+			continue
+		}
+		if frames[0].Timestamp != test.timestamp {
+			t.Errorf("%s: expected timestamp %d, got %d", test.name, test.timestamp, frames[0].Timestamp)
+		}
+		if center := frames[0].Plates[0]; center.Plate != "center" || center.Forces != test.center {
+			t.Errorf("%s: expected center forces %v, got %s %v", test.name, test.center, center.Plate, center.Forces)
+		}
+		if decoder.packets.buffer != nil {
+			t.Errorf("%s: expected no data left, got % x", test.name, decoder.packets.buffer)
+		}
+	}
+}
+
+func TestFrameDecoderReassemblesSplitPackets(t *testing.T) {
+	stream := decodeBase64(t, recordedAnswerAndPacket)
+	stream = append(stream, decodeBase64(t, recordedPacket)...)
+
+	decoder := frameDecoder{}
+	var timestamps []uint32
+	for i := range stream {
+		frames, err := decoder.decode(stream[i : i+1])
+		if err != nil {
+			t.Fatalf("Unexpected error at byte %d: %v", i, err)
+		}
+		for _, frame := range frames {
+			timestamps = append(timestamps, frame.Timestamp)
+		}
+	}
+	if len(timestamps) != 2 || timestamps[0] != 89533 || timestamps[1] != 2126652 {
+		t.Errorf("Expected timestamps [89533 2126652], got %v", timestamps)
+	}
+}
+
+func TestFrameDecoderDecodesPacketsOfSeveralBlocks(t *testing.T) {
+	first := decodeBase64(t, recordedPacket)[packetHeaderSize:]
+	second := decodeBase64(t, recordedAnswerAndPacket)[packetHeaderSize:]
+
+	packet := append(packetHeader(2), second[:blockHeaderSize+120]...)
+	packet = append(packet, first...)
+
+	decoder := frameDecoder{}
+	frames, err := decoder.decode(packet)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(frames) != 1 || frames[0].Timestamp != 2126652 {
+		t.Errorf("Expected the frame of the second block, got %+v", frames)
+	}
+}
+
+func TestFrameDecoderDropsDataWhenFramingIsLost(t *testing.T) {
+	unknown := decodeBase64(t, recordedUnversionedPacket)
+	binary.LittleEndian.PutUint16(unknown[packetHeaderSize+2:], 0xD0)
+
+	tooLong := append(packetHeader(1), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint16(tooLong[packetHeaderSize:], maxPacketSize)
+
+	for _, test := range []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"unknown block without version", unknown, errUnknownBlock},
+		{"too long", tooLong, errTooLong},
+	} {
+		decoder := frameDecoder{}
+		if _, err := decoder.decode(test.data); err != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+		}
+		frames, err := decoder.decode(decodeBase64(t, recordedPacket))
+		if err != nil || len(frames) != 1 {
+			t.Errorf("%s: expected to decode the next packet, got %d frames and error %v", test.name, len(frames), err)
+		}
+	}
+}
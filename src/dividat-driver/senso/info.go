@@ -0,0 +1,112 @@
+package senso
+
+// With the `sensoDeviceInfo` feature, the Senso is asked for its device
+// information once the control channel connects, so that its serial number and
+// firmware version can be reported in the Status. The answer to this request is
+// not forwarded to clients, while answers to requests of clients update the
+// information too. The request is not sent by default until it has been
+// verified against hardware.
+//
+// The layout follows the answer of the mock Senso in tools/replay/control.js:
+// an item of 32 bytes for the controller and for each plate, in the order
+// center, up, right, down, left: status and error code (uint32 each), the
+// firmware version as fix, feature, minor and major byte, the hardware version
+// (uint32) and the serial number (ASCII, zero padded). The item of the
+// controller holds the serial number of the Senso as a whole.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Type of blocks asking for device information (DATA_TYPE_GET_DEV_INFO)
+const devInfoBlockType = 0xD1
+
+// Set in the type of blocks answering a request
+const responseBit = 0x8000
+
+const devInfoItemSize = 32
+
+// DeviceInfo identifies the Senso a device is connected to
+type DeviceInfo struct {
+	SerialNumber    string
+	FirmwareVersion string
+}
+
+// Request for device information, to be sent on the control channel
+func devInfoRequest() []byte {
+	request := make([]byte, packetHeaderSize+blockHeaderSize)
+	copy(request, packetHeader(1))
+	binary.LittleEndian.PutUint16(request[packetHeaderSize+2:], devInfoBlockType)
+	return request
+}
+
+// Device information if the data is an answer to a request for it
+func parseDevInfo(data []byte) (DeviceInfo, bool) {
+	if len(data) < packetHeaderSize+blockHeaderSize+devInfoItemSize {
+		return DeviceInfo{}, false
+	}
+	if binary.LittleEndian.Uint16(data[packetHeaderSize+2:]) != devInfoBlockType|responseBit {
+		return DeviceInfo{}, false
+	}
+	item := data[packetHeaderSize+blockHeaderSize:]
+	serial := item[16:devInfoItemSize]
+	if end := bytes.IndexByte(serial, 0); end >= 0 {
+		serial = serial[:end]
+	}
+	return DeviceInfo{
+		SerialNumber:    string(serial),
+		FirmwareVersion: fmt.Sprintf("%d.%d.%d.%d", item[11], item[10], item[9], item[8]),
+	}, true
+}
+
+// Latest device information of a connection
+type deviceInfo struct {
+	mutex sync.Mutex
+	info  *DeviceInfo
+	// Whether an answer to the driver's own request is expected
+	requested bool
+	// Closed with the next answer, nil if nobody waits for it
+	answered chan struct{}
+}
+
+func (d *deviceInfo) request() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.requested = true
+}
+
+// Update the information from an answer, returning whether it changed and
+// whether the answer was requested by the driver
+func (d *deviceInfo) update(info DeviceInfo) (bool, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	changed := d.info == nil || *d.info != info
+	d.info = &info
+	requested := d.requested
+	d.requested = false
+	if d.answered != nil {
+		close(d.answered)
+		d.answered = nil
+	}
+	return changed, requested
+}
+
+// Channel closed with the next answer
+func (d *deviceInfo) next() <-chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.answered == nil {
+		d.answered = make(chan struct{})
+	}
+	return d.answered
+}
+
+// Nil until the Senso answered
+func (d *deviceInfo) get() *DeviceInfo {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.info
+}
@@ -0,0 +1,68 @@
+package senso
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Answer to a request for device information, laid out like the answer of the
+// mock Senso in tools/replay/control.js
+func devInfoAnswer(serial string, version [4]byte) []byte {
+	block := make([]byte, blockHeaderSize, blockHeaderSize+devInfoItemSize*(len(plates)+1))
+	binary.LittleEndian.PutUint16(block, uint16(devInfoItemSize*(len(plates)+1)))
+	binary.LittleEndian.PutUint16(block[2:], devInfoBlockType|responseBit)
+	for i := 0; i <= len(plates); i++ {
+		item := make([]byte, devInfoItemSize)
+		// Major, minor, feature and fix from byte 11 down
+		item[11], item[10], item[9], item[8] = version[0], version[1], version[2], version[3]
+		if i == 0 {
+			copy(item[16:], serial)
+		} else {
+			copy(item[16:], "LED-BOARD")
+		}
+		block = append(block, item...)
+	}
+	return append(packetHeader(1), block...)
+}
+
+func TestDevInfoRequest(t *testing.T) {
+	request := devInfoRequest()
+	if !bytes.Equal(request[:packetHeaderSize], []byte{packetVersion, 1, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("Expected a packet header announcing one block, got % x", request[:packetHeaderSize])
+	}
+	if blockType := binary.LittleEndian.Uint16(request[packetHeaderSize+2:]); blockType != devInfoBlockType {
+		t.Errorf("Expected block type %#x, got %#x", devInfoBlockType, blockType)
+	}
+}
+
+func TestParseDevInfo(t *testing.T) {
+	info, ok := parseDevInfo(devInfoAnswer("SN0042", [4]byte{2, 1, 3, 4}))
+	if !ok {
+		t.Fatal("Expected the answer to be parsed")
+	}
+	if info.SerialNumber != "SN0042" {
+		t.Errorf("Expected serial number SN0042, got %q", info.SerialNumber)
+	}
+	if info.FirmwareVersion != "2.1.3.4" {
+		t.Errorf("Expected firmware version 2.1.3.4, got %q", info.FirmwareVersion)
+	}
+}
+
+func TestParseDevInfoIgnoresOtherPackets(t *testing.T) {
+	// Standard response to a command of type 1, as recorded in
+	// rec/senso/front-step.dat
+	standardResponse := append(packetHeader(1), 0x0c, 0x00, 0x01, 0x80)
+	standardResponse = append(standardResponse, make([]byte, 12)...)
+
+	for name, data := range map[string][]byte{
+		"standard response": standardResponse,
+		"request":           append(devInfoRequest(), make([]byte, devInfoItemSize)...),
+		"truncated answer":  devInfoAnswer("SN0042", [4]byte{2, 0, 0, 0})[:packetHeaderSize+blockHeaderSize+devInfoItemSize-1],
+		"empty":             {},
+	} {
+		if _, ok := parseDevInfo(data); ok {
+			t.Errorf("%s: expected no device information", name)
+		}
+	}
+}
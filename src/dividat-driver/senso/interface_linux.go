@@ -0,0 +1,12 @@
+package senso
+
+import (
+	"net"
+	"os"
+)
+
+// Wireless interfaces expose a `wireless` directory in sysfs
+func isWireless(iface net.Interface) bool {
+	_, err := os.Stat("/sys/class/net/" + iface.Name + "/wireless")
+	return err == nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package senso
+
+import (
+	"net"
+	"strings"
+)
+
+// Without a reliable system API, guess from common interface names
+func isWireless(iface net.Interface) bool {
+	name := strings.ToLower(iface.Name)
+	return strings.Contains(name, "wi-fi") || strings.Contains(name, "wlan") || strings.Contains(name, "wireless")
+}
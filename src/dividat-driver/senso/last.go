@@ -0,0 +1,114 @@
+package senso
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LastConnection is the Senso a device was last connected to
+type LastConnection struct {
+	Address string `json:"address"`
+	// Empty if connected by address
+	Serial    string    `json:"serial,omitempty"`
+	Connected time.Time `json:"connected"`
+}
+
+// LastConnections persists the Senso each device of each handle was last
+// connected to, so connections can be restored after a restart
+type LastConnections struct {
+	path  string
+	mutex sync.Mutex
+	// By handle, then device
+	connections map[string]map[string]LastConnection
+}
+
+// OpenLastConnections opens the store persisted at path, a missing file is an
+// empty store
+func OpenLastConnections(path string) (*LastConnections, error) {
+	store := LastConnections{path: path, connections: map[string]map[string]LastConnection{}}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(contents, &store.connections)
+	if err != nil {
+		return nil, err
+	}
+	return &store, nil
+}
+
+func (store *LastConnections) get(handle string) map[string]LastConnection {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	connections := map[string]LastConnection{}
+	for id, connection := range store.connections[handle] {
+		connections[id] = connection
+	}
+	return connections
+}
+
+func (store *LastConnections) set(handle string, id string, connection LastConnection) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.connections[handle] == nil {
+		store.connections[handle] = map[string]LastConnection{}
+	}
+	store.connections[handle][id] = connection
+
+	contents, err := json.MarshalIndent(store.connections, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(store.path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(store.path, contents, 0644)
+}
+
+// RememberConnections records the Senso each device connects to in the store,
+// under the given name of the handle. Must be called before clients connect.
+func (handle *Handle) RememberConnections(store *LastConnections, name string) {
+	handle.lastConnections = store
+	handle.name = name
+}
+
+// Reconnect connects each device to the Senso it was last connected to,
+// looking for Sensos remembered by serial on all their paths
+func (handle *Handle) Reconnect() {
+	if handle.lastConnections == nil {
+		return
+	}
+	for id, last := range handle.lastConnections.get(handle.name) {
+		log := handle.log.WithField("address", last.Address).WithField("serial", last.Serial)
+		if id != defaultDevice {
+			log = log.WithField("deviceId", id)
+		}
+		log.Info("Reconnecting to last connected Senso.")
+		if last.Serial != "" {
+			go handle.ConnectBySerial(handle.ctx, id, last.Serial)
+		} else {
+			go handle.Connect(id, last.Address)
+		}
+	}
+}
+
+// Remember a device's connection once all its channels are connected
+func (handle *Handle) remember(id string, device *device) {
+	if handle.lastConnections == nil {
+		return
+	}
+	err := handle.lastConnections.set(handle.name, id, LastConnection{Address: device.address, Serial: device.serial, Connected: time.Now().UTC()})
+	if err != nil {
+		handle.log.WithError(err).Warn("Could not persist last connected Senso.")
+	}
+}
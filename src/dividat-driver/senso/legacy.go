@@ -0,0 +1,105 @@
+package senso
+
+// Sensos with old firmware serve their channels on other ports. No capture of
+// their traffic is available, so data is passed on as received and commands
+// are written unchanged, only the ports differ.
+//
+// The protocol of each Senso is configured by address, or detected when
+// connecting by probing the control ports of both protocols. The ports of the
+// legacy protocol are not documented in this repository and must be
+// configured along with it.
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/sirupsen/logrus"
+)
+
+// Protocols spoken by Sensos
+const (
+	CurrentProtocol = "current"
+	LegacyProtocol  = "legacy"
+	// Detected when connecting
+	AutoProtocol = "auto"
+)
+
+// Protocols selects the protocol spoken with each Senso
+type Protocols struct {
+	// Protocol of Sensos not listed by address, CurrentProtocol if empty
+	Default   string
+	ByAddress map[string]string
+	// Ports of the channels of Sensos speaking the legacy protocol
+	LegacyPorts LegacyPorts
+}
+
+// LegacyPorts are the ports of the channels of Sensos speaking the legacy
+// protocol
+type LegacyPorts struct {
+	Data    string
+	Control string
+}
+
+func (protocols Protocols) of(address string) string {
+	if protocol, ok := protocols.ByAddress[address]; ok {
+		return protocol
+	}
+	if protocols.Default == "" {
+		return CurrentProtocol
+	}
+	return protocols.Default
+}
+
+// Port of the channel for the protocol
+func (protocols Protocols) port(ch channel, protocol string) string {
+	if protocol != LegacyProtocol {
+		return ch.port
+	}
+	if ch.name == dataChannel.name {
+		return protocols.LegacyPorts.Data
+	}
+	return protocols.LegacyPorts.Control
+}
+
+// Protocols that may be spoken with the Senso at the address, in the order
+// they are probed
+func (protocols Protocols) candidates(address string) []string {
+	if protocol := protocols.of(address); protocol != AutoProtocol {
+		return []string{protocol}
+	}
+	return []string{CurrentProtocol, LegacyProtocol}
+}
+
+// Probe the control ports of both protocols until one accepts a connection.
+// Returns false if the context is done first.
+func detectProtocol(ctx context.Context, log *logrus.Entry, address string, protocols Protocols, timeout time.Duration) (string, bool) {
+	var detected string
+	probe := func() error {
+		for _, protocol := range protocols.candidates(address) {
+			dialer := net.Dialer{Timeout: timeout}
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, protocols.port(controlChannel, protocol)))
+			if err == nil {
+				conn.Close()
+				detected = protocol
+				return nil
+			}
+		}
+		log.Info("Could not detect protocol of Senso, retrying.")
+		return errNotDetected
+	}
+
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = 0
+	expBackoff.MaxInterval = maxInterval
+	backoff.Retry(probe, backoff.WithContext(expBackoff, ctx))
+	if ctx.Err() != nil {
+		return "", false
+	}
+	log.WithField("protocol", detected).Info("Detected protocol of Senso.")
+	return detected, true
+}
+
+var errNotDetected = errors.New("no control port accepts connections")
@@ -0,0 +1,54 @@
+package senso
+
+import (
+	"net"
+	"testing"
+)
+
+func TestProtocolPorts(t *testing.T) {
+	protocols := Protocols{
+		Default:     AutoProtocol,
+		ByAddress:   map[string]string{"10.0.0.1": LegacyProtocol},
+		LegacyPorts: LegacyPorts{Data: "1002", Control: "1001"},
+	}
+	if protocol := protocols.of("10.0.0.1"); protocol != LegacyProtocol {
+		t.Errorf("Expected the protocol of the address, got %s", protocol)
+	}
+	if protocol := protocols.of("10.0.0.2"); protocol != AutoProtocol {
+		t.Errorf("Expected the default protocol, got %s", protocol)
+	}
+	if protocol := (Protocols{}).of("10.0.0.2"); protocol != CurrentProtocol {
+		t.Errorf("Expected the current protocol without default, got %s", protocol)
+	}
+
+	for _, test := range []struct {
+		ch       channel
+		protocol string
+		port     string
+	}{
+		{dataChannel, CurrentProtocol, dataChannel.port},
+		{controlChannel, CurrentProtocol, controlChannel.port},
+		{dataChannel, LegacyProtocol, "1002"},
+		{controlChannel, LegacyProtocol, "1001"},
+	} {
+		if port := protocols.port(test.ch, test.protocol); port != test.port {
+			t.Errorf("Expected port %s for the %s channel of the %s protocol, got %s", test.port, test.ch.name, test.protocol, port)
+		}
+	}
+}
+
+func TestProbeLegacyControlPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	for _, protocol := range []string{LegacyProtocol, AutoProtocol} {
+		protocols := Protocols{Default: protocol, LegacyPorts: LegacyPorts{Data: "1", Control: port}}
+		if c := probe("127.0.0.1", protocols); c.err != nil {
+			t.Errorf("Expected the legacy control port to be probed with the %s protocol, got %v", protocol, c.err)
+		}
+	}
+}
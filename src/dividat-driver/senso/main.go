@@ -5,41 +5,121 @@ import (
 	"sync"
 	"time"
 
-	"github.com/cskr/pubsub"
 	"github.com/sirupsen/logrus"
 
+	"github.com/dividat/driver/src/dividat-driver/broker"
+	"github.com/dividat/driver/src/dividat-driver/clock"
+	"github.com/dividat/driver/src/dividat-driver/confirm"
+	"github.com/dividat/driver/src/dividat-driver/drops"
 	"github.com/dividat/driver/src/dividat-driver/firmware"
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+	"github.com/dividat/driver/src/dividat-driver/hooks"
+	"github.com/dividat/driver/src/dividat-driver/logging"
+	"github.com/dividat/driver/src/dividat-driver/pairing"
+	"github.com/dividat/driver/src/dividat-driver/service"
+	"github.com/dividat/driver/src/dividat-driver/sessions"
 )
 
 // Handle for managing Senso
 type Handle struct {
-	broker *pubsub.PubSub
+	broker *broker.Broker
 
-	Address *string
+	// Data missed by clients falling behind
+	rxDrops *drops.Topic
 
 	ctx context.Context
 
-	cancelCurrentConnection context.CancelFunc
-	connectionChangeMutex   *sync.Mutex
+	connectionChangeMutex *sync.Mutex
+	// Connected devices by identifier
+	devices      map[string]*device
+	devicesMutex sync.Mutex
 
 	firmwareUpdate *firmware.Update
 
+	// Addresses that may be connected to, any if empty
+	allowedAddresses []string
+	// Addresses that are never connected to, e.g. those of other instances
+	excludedAddresses []string
+
+	// Paired devices, nil if pairing is not required
+	pairing *pairing.Store
+
+	// Callbacks observing WebSocket traffic, set before serving clients
+	Hooks hooks.Hooks
+
+	// Connected clients, firmware updates must not disrupt them
+	sessions   *sessions.Registry
+	busyPolicy sessions.Policy
+
+	// Timeouts of connections to Sensos
+	tcp TCPSettings
+
+	// Destructive commands awaiting confirmation by their client
+	confirmations *confirm.Pending
+
+	// Discoveries running for each client
+	discoveries discoveries
+	// Lists Flex devices in the unified discovery, nil if not available
+	listFlexDevices func() ([]enumerator.Device, error)
+
+	// Sensos last connected to, nil if not remembered
+	lastConnections *LastConnections
+	// Name of the handle in lastConnections
+	name string
+
+	// Called with the state of the default device whenever it changes, nil if
+	// not watched
+	onState func(state string)
+
+	// Hex dumps of received data on request of support, nil if not available
+	frameTrace *logging.FrameTrace
+
+	// Whether clients are warned about deprecated fields and behaviors
+	strict bool
+
+	// Protocol spoken with each Senso
+	protocols Protocols
+
+	// Whether Sensos are asked for their device information
+	deviceInfo bool
+
+	// When connected Sensos are considered degraded or stalled
+	health HealthSettings
+
+	// Conversion of readings to physical units, by Senso
+	unitCalibrations *UnitCalibrations
+	// Points recorded towards the next unit calibration of each device
+	calibrationPoints calibrationPoints
+
 	log *logrus.Entry
 }
 
 // New returns an initialized Senso handler
-func New(ctx context.Context, log *logrus.Entry) *Handle {
+func New(ctx context.Context, log *logrus.Entry, pairingStore *pairing.Store) *Handle {
 	handle := Handle{}
 
 	handle.ctx = ctx
 
 	handle.log = log
 
+	handle.pairing = pairingStore
+
 	handle.connectionChangeMutex = &sync.Mutex{}
+	handle.devices = map[string]*device{}
 	handle.firmwareUpdate = firmware.InitialUpdateState()
 
+	handle.sessions = sessions.NewRegistry()
+	handle.busyPolicy = sessions.Refuse
+	handle.tcp = DefaultTCPSettings
+	handle.health = DefaultHealthSettings
+
+	handle.confirmations = confirm.New()
+
+	handle.unitCalibrations = &UnitCalibrations{calibrations: map[string]UnitCalibration{}}
+
 	// PubSub broker
-	handle.broker = pubsub.New(32)
+	handle.broker = broker.New(32)
+	handle.rxDrops = drops.NewTopic("senso-rx")
 
 	// Clean up
 	go func() {
@@ -50,40 +130,311 @@ func New(ctx context.Context, log *logrus.Entry) *Handle {
 	return &handle
 }
 
-// Connect to a Senso, will create TCP connections to control and data ports
-func (handle *Handle) Connect(address string) {
+// Connect the device with given identifier to a Senso, will create TCP
+// connections to control and data ports, replacing the device's previous
+// connection
+func (handle *Handle) Connect(id string, address string) {
+	// Sensos are paired by serial number, which is looked up first
+	if handle.pairing != nil {
+		go func() {
+			handle.connect(id, address, lookupSerial(handle.ctx, address), nil)
+		}()
+		return
+	}
+	handle.connect(id, address, "", nil)
+}
+
+// Serial number announced via mDNS by the Senso at the address, empty if it
+// is not found
+func lookupSerial(ctx context.Context, address string) string {
+	found := service.Find(ctx, candidateDiscoveryTimeout, func(entry service.Service) bool {
+		return entry.Address == address && entry.Text.Serial != ""
+	})
+	if found == nil {
+		return ""
+	}
+	return found.Text.Serial
+}
+
+// Connect to a Senso whose serial is known if connecting by serial, along with
+// the other known paths to it
+func (handle *Handle) connect(id string, address string, serial string, alternatives []string) {
+	if !handle.mayConnect(address) {
+		handle.log.WithField("address", address).Warn("Refusing to connect with Senso not assigned to this driver instance.")
+		return
+	}
+	// Paired devices are identified by serial number, so that they can not be
+	// swapped for another at the same address
+	if handle.pairing != nil && serial == "" {
+		handle.log.WithField("address", address).Warn("Refusing to connect with Senso, its serial number is required for pairing but could not be found.")
+		return
+	}
 
 	// Only allow one connection change at a time
 	handle.connectionChangeMutex.Lock()
 	defer handle.connectionChangeMutex.Unlock()
 
 	// disconnect current connection first
-	handle.Disconnect()
-
-	// set address in handle
-	handle.Address = &address
+	handle.disconnect(id)
 
 	// Create a child context for a new connection. This allows an individual connection (attempt) to be cancelled without restarting the whole Senso handler
 	ctx, cancel := context.WithCancel(handle.ctx)
 
-	handle.log.WithField("address", address).Info("Attempting to connect with Senso.")
+	log := handle.log.WithField("address", address)
+	if id != defaultDevice {
+		log = log.WithField("deviceId", id)
+	}
+	log.Info("Attempting to connect with Senso.")
+
+	connection := &connectionState{degradedAfter: handle.health.DegradedAfter, stalledAfter: handle.health.StalledAfter}
+	current := &device{address: address, serial: serial, alternatives: alternatives, cancel: cancel, connection: connection, commands: make(chan clientCommand)}
 
+	// Hold back data from devices that have not been confirmed by an operator
+	paired := "senso:" + serial
+	awaitingPairing := handle.pairing != nil && !handle.pairing.IsPaired(paired)
+	if awaitingPairing {
+		handle.log.WithField("device", paired).Info("Waiting for confirmation to pair with Senso.")
+		current.pairing.await(paired)
+	}
+
+	publish := func(channel string, data []byte, frames []Frame) {
+		if current.pairing.pending() != "" {
+			return
+		}
+		label := channel
+		if id != defaultDevice {
+			label = id + "/" + channel
+		}
+		handle.frameTrace.Record(label, data)
+		current.publish(ctx, func() {
+			handle.broker.TryPub(packet{device: id, channel: channel, data: data, frames: frames, sequence: handle.rxDrops.Publish()}, "rx")
+		})
+	}
 	onReceive := func(data []byte) {
-		handle.broker.TryPub(data, "rx")
+		if info, ok := parseDevInfo(data); ok {
+			changed, requested := current.info.update(info)
+			if changed {
+				log.WithField("serial", info.SerialNumber).WithField("firmware", info.FirmwareVersion).Info("Senso reported device information.")
+				handle.Broadcast(handle.status())
+			}
+			if requested {
+				return
+			}
+		}
+		publish(controlChannel.name, data, nil)
 	}
 
-	go connectTCP(ctx, handle.log.WithField("channel", "data"), address+":55568", handle.broker.Sub("noTx"), onReceive)
-	time.Sleep(1000 * time.Millisecond)
-	go connectTCP(ctx, handle.log.WithField("channel", "control"), address+":55567", handle.broker.Sub("tx"), onReceive)
+	// Inform clients when channels connect or are lost, ignoring connections
+	// that are winding down after being replaced
+	onConnection := func(channel string) func(bool) {
+		return func(isConnected bool) {
+			if ctx.Err() != nil {
+				return
+			}
+			if connection.set(channel, isConnected) {
+				handle.Broadcast(handle.status())
+				if connection.get() == connected {
+					handle.remember(id, current)
+				}
+			}
+		}
+	}
+
+	// Frames on the data channel show that the Senso is alive
+	decoder := frameDecoder{}
+	onData := func(data []byte) {
+		connection.received(clock.Default.Now())
+		frames, err := decoder.decode(data)
+		if err != nil {
+			log.WithError(err).Debug("Can not decode Senso data.")
+		}
+		publish(dataChannel.name, data, frames)
+	}
+	onDataConnection := func(isConnected bool) {
+		decoder.reset()
+		onConnection(dataChannel.name)(isConnected)
+	}
+
+	// Ask for the serial number and firmware version once commands can be sent
+	onControlConnection := func(isConnected bool) {
+		onConnection(controlChannel.name)(isConnected)
+		if isConnected && ctx.Err() == nil && handle.deviceInfo {
+			current.info.request()
+			handle.broker.TryPub(devInfoRequest(), txTopic(id))
+		}
+	}
 
-	handle.cancelCurrentConnection = cancel
+	connection.reset()
+	handle.setDevice(id, current)
+	// Announced once the device can be confirmed
+	if awaitingPairing {
+		handle.Broadcast(Message{PairingRequired: &paired})
+	}
+	handle.Broadcast(handle.status())
+	go handle.watchHealth(ctx, id, current)
+	go handle.followAddress(ctx, id, address, serial)
+
+	connectChannels := func(protocol string) {
+		connection.setProtocol(protocol)
+		go handle.connectChannel(ctx, log, id, address, dataChannel, protocol, current.commands, onData, onDataConnection)
+		time.Sleep(channelDelay)
+		go handle.connectChannel(ctx, log, id, address, controlChannel, protocol, current.commands, onReceive, onControlConnection)
+	}
+
+	// Sensos with old firmware may be detected only once they can be reached
+	protocol := handle.protocols.of(address)
+	if protocol == AutoProtocol {
+		go func() {
+			detected, ok := detectProtocol(ctx, log, address, handle.protocols, handle.tcp.DialTimeout)
+			if ok {
+				connectChannels(detected)
+			}
+		}()
+	} else {
+		connectChannels(protocol)
+	}
 }
 
-// Disconnect from current connection
-func (handle *Handle) Disconnect() {
-	if handle.cancelCurrentConnection != nil {
-		handle.log.Info("Disconnecting from Senso.")
-		handle.cancelCurrentConnection()
-		handle.Address = nil
+// RestrictAddresses limits connections to the given Senso addresses (any if
+// empty) and never connects to the excluded ones. Must be called before clients
+// connect.
+func (handle *Handle) RestrictAddresses(include []string, exclude []string) {
+	handle.allowedAddresses = include
+	handle.excludedAddresses = exclude
+}
+
+// SetBusyPolicy decides whether firmware updates requested while other clients
+// are connected are refused or queued. Must be called before clients connect.
+func (handle *Handle) SetBusyPolicy(policy sessions.Policy) {
+	handle.busyPolicy = policy
+}
+
+// TraceFramesTo enables the TraceFrames command, writing traces to dir. Must be
+// called before clients connect.
+func (handle *Handle) TraceFramesTo(dir string) {
+	handle.frameTrace = logging.NewFrameTrace(dir)
+}
+
+// WarnDeprecations makes clients be warned about deprecated fields and
+// behaviors they rely on, and lists the default device among the devices of
+// the Status. Must be called before clients connect.
+func (handle *Handle) WarnDeprecations() {
+	handle.strict = true
+}
+
+// SetHealthSettings decides when connections made from now on are considered
+// degraded or stalled. Must be called before clients connect.
+func (handle *Handle) SetHealthSettings(settings HealthSettings) {
+	handle.health = settings
+}
+
+// SetProtocols selects the protocol spoken with each Senso, for fleets with old
+// firmware. Must be called before clients connect.
+func (handle *Handle) SetProtocols(protocols Protocols) {
+	handle.protocols = protocols
+}
+
+// RequestDeviceInfo asks Sensos for their serial number and firmware version
+// once connected, and allows measuring round trips to them. Must be called
+// before clients connect.
+func (handle *Handle) RequestDeviceInfo() {
+	handle.deviceInfo = true
+}
+
+// SetTCPSettings tunes connections made from now on. Must be called before
+// clients connect.
+func (handle *Handle) SetTCPSettings(settings TCPSettings) {
+	handle.tcp = settings
+}
+
+// ConfirmPairing remembers the given device announced in PairingRequired and
+// starts forwarding its data, if it is awaiting confirmation. Other devices
+// awaiting confirmation keep waiting.
+func (handle *Handle) ConfirmPairing(pending string) {
+	if handle.pairing == nil || !handle.confirmPairing(pending) {
+		handle.log.WithField("device", pending).Warn("Refusing to pair with Senso not awaiting confirmation.")
+		return
+	}
+
+	err := handle.pairing.Pair(pending)
+	if err != nil {
+		handle.log.WithError(err).Error("Could not persist paired device.")
+	}
+
+	handle.log.WithField("device", pending).Info("Paired with Senso.")
+	handle.Broadcast(Message{Paired: &pending})
+}
+
+// Broadcast sends a message to all connected clients
+func (handle *Handle) Broadcast(message Message) {
+	if message.Status != nil && handle.onState != nil {
+		handle.onState(message.Status.State())
 	}
+	// Unlike data, broadcasts are never dropped. Publishing waits for slow
+	// clients, whose writes time out.
+	handle.broker.Pub(message, "broadcast")
+}
+
+// WatchState calls onState with the state of the default device whenever a
+// status is broadcast: its connection, or its health while connected but not
+// healthy. Must be called before clients connect.
+func (handle *Handle) WatchState(onState func(state string)) {
+	handle.onState = onState
+	onState(handle.status().Status.State())
+}
+
+// State returns the connection of the default device, or its health if
+// degraded or stalled
+func (status *Status) State() string {
+	if status.Health != nil && status.Health.State != healthy {
+		return status.Health.State
+	}
+	return status.Connection
+}
+
+// Status returns the current status of the Senso connections
+func (handle *Handle) Status() Status {
+	return handle.currentStatus(false)
+}
+
+// Disconnect the device with given identifier from its Senso
+func (handle *Handle) Disconnect(id string) {
+	handle.connectionChangeMutex.Lock()
+	defer handle.connectionChangeMutex.Unlock()
+	handle.disconnect(id)
+}
+
+func (handle *Handle) disconnect(id string) {
+	device := handle.device(id)
+	if device == nil {
+		return
+	}
+
+	log := handle.log
+	if id != defaultDevice {
+		log = log.WithField("deviceId", id)
+	}
+	log.Info("Disconnecting from Senso.")
+
+	device.close()
+	device.connection.clear()
+	handle.setDevice(id, nil)
+	handle.Broadcast(handle.status())
+}
+
+// Data received from Senso, numbered to detect data missed by clients
+type packet struct {
+	// Identifier of the device that received the data
+	device string
+	// Name of the channel the data was received on
+	channel string
+	data    []byte
+	// Data frames completed by the data, if received on the data channel
+	frames   []Frame
+	sequence uint64
+}
+
+// Drops returns statistics of data missed by clients
+func (handle *Handle) Drops() drops.Snapshot {
+	return handle.rxDrops.Snapshot()
 }
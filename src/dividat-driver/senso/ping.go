@@ -0,0 +1,65 @@
+package senso
+
+// Round trips to a Senso are measured over the control channel with requests
+// for device information, which the Senso answers right away. Like the
+// driver's own request after connecting, the answers are not forwarded to
+// clients, and they are only sent with the `sensoDeviceInfo` feature.
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dividat/driver/src/dividat-driver/latency"
+)
+
+// Ping command, measures round trips to a device and to the client
+type Ping struct {
+	// Identifier of the device, the default device if empty
+	Device string `json:"device"`
+	// Round trips to measure, latency.DefaultCount if zero
+	Count int `json:"count"`
+}
+
+// Latency answers a Ping command, with round trips in milliseconds
+type Latency struct {
+	Device string `json:"device,omitempty"`
+	// Nil if the device could not be reached
+	DeviceRoundTrip *latency.Summary `json:"deviceRoundTrip"`
+	// Why the device could not be reached
+	DeviceError     string           `json:"deviceError,omitempty"`
+	ClientRoundTrip *latency.Summary `json:"clientRoundTrip"`
+}
+
+// Measure round trips to the device over its control channel
+func (handle *Handle) pingDevice(ctx context.Context, id string, count int) (latency.Summary, error) {
+	if !handle.deviceInfo {
+		return latency.Summary{}, errors.New("device information requests are not enabled")
+	}
+	device := handle.device(id)
+	if device == nil || device.connection.get() != connected {
+		return latency.Summary{}, errors.New("device is not connected")
+	}
+	return latency.Measure(ctx, count, func() (<-chan struct{}, error) {
+		answered := device.info.next()
+		device.info.request()
+		handle.broker.TryPub(devInfoRequest(), txTopic(id))
+		return answered, nil
+	})
+}
+
+// Measure round trips to the device and to the client
+func (handle *Handle) ping(ctx context.Context, command Ping, client *latency.ClientPinger) Latency {
+	count := latency.Count(command.Count)
+	result := Latency{Device: command.Device}
+	deviceRoundTrip, err := handle.pingDevice(ctx, command.Device, count)
+	if err != nil {
+		result.DeviceError = err.Error()
+	} else {
+		result.DeviceRoundTrip = &deviceRoundTrip
+	}
+	clientRoundTrip, err := client.Measure(ctx, count)
+	if err == nil {
+		result.ClientRoundTrip = &clientRoundTrip
+	}
+	return result
+}
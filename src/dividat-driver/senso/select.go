@@ -0,0 +1,180 @@
+package senso
+
+// Selection between multiple paths to the same Senso.
+//
+// A Senso may be reachable through several addresses, e.g. when it is
+// connected both via Ethernet and Wi-Fi. When connecting by serial number,
+// all advertised addresses are collected, ranked and the best one is used.
+// Paths over a wired interface of this machine are preferred, ties are broken
+// by the time it takes to open a TCP connection to the control port.
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/service"
+)
+
+// How long to collect mDNS announcements for a serial number
+const candidateDiscoveryTimeout = 3 * time.Second
+
+// How long to wait for a latency probe before giving up on a candidate
+const probeTimeout = 500 * time.Millisecond
+
+// Candidate path to a Senso
+type candidate struct {
+	address string
+	wired   bool
+	latency time.Duration
+	err     error
+}
+
+// ConnectBySerial discovers all paths to the Senso with given serial and connects the device using the best one
+func (handle *Handle) ConnectBySerial(ctx context.Context, id string, serial string) {
+	log := handle.log.WithField("serial", serial)
+	log.Info("Looking for Senso by serial.")
+
+	addresses := handle.allowed(discoverAddresses(ctx, serial))
+	if len(addresses) == 0 {
+		log.Warn("Could not find Senso with serial.")
+		return
+	}
+
+	handle.connectBest(id, serial, addresses)
+}
+
+// Addresses that may be connected to
+func (handle *Handle) allowed(addresses []string) []string {
+	allowed := []string{}
+	for _, address := range addresses {
+		if handle.mayConnect(address) {
+			allowed = append(allowed, address)
+		}
+	}
+	return allowed
+}
+
+// Whether the address is assigned to this handle, or to no other
+func (handle *Handle) mayConnect(address string) bool {
+	if contains(handle.excludedAddresses, address) {
+		return false
+	}
+	return len(handle.allowedAddresses) == 0 || contains(handle.allowedAddresses, address)
+}
+
+// Connect the device using the best of the paths to the Senso with given serial
+func (handle *Handle) connectBest(id string, serial string, addresses []string) {
+	candidates := rankCandidates(addresses, handle.protocols)
+
+	alternatives := []string{}
+	for _, c := range candidates[1:] {
+		alternatives = append(alternatives, c.address)
+	}
+
+	handle.log.WithField("serial", serial).WithField("address", candidates[0].address).WithField("alternatives", alternatives).Info("Selected path to Senso.")
+
+	handle.connect(id, candidates[0].address, serial, alternatives)
+}
+
+// Collect all IPv4 addresses advertised for a serial in application mode
+func discoverAddresses(ctx context.Context, serial string) []string {
+	ctx, cancel := context.WithTimeout(ctx, candidateDiscoveryTimeout)
+	defer cancel()
+
+	addresses := []string{}
+	for discovered := range service.Scan(ctx) {
+		if discovered.Text.Serial != serial || service.IsDfuService(discovered) {
+			continue
+		}
+		for _, ip := range discovered.ServiceEntry.AddrIPv4 {
+			if !contains(addresses, ip.String()) {
+				addresses = append(addresses, ip.String())
+			}
+		}
+	}
+	return addresses
+}
+
+// Probe and sort candidates, best first
+func rankCandidates(addresses []string, protocols Protocols) []candidate {
+	candidates := make([]candidate, len(addresses))
+
+	var wg sync.WaitGroup
+	for i, address := range addresses {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			candidates[i] = probe(address, protocols)
+		}(i, address)
+	}
+	wg.Wait()
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if (a.err == nil) != (b.err == nil) {
+			return a.err == nil
+		}
+		if a.wired != b.wired {
+			return a.wired
+		}
+		return a.latency < b.latency
+	})
+
+	return candidates
+}
+
+// Time opening a TCP connection to the control port of the protocol the Senso
+// at the address speaks, of the first accepting one if it is detected
+func probe(address string, protocols Protocols) candidate {
+	c := candidate{
+		address: address,
+		wired:   isWiredPath(net.ParseIP(address)),
+	}
+
+	for _, protocol := range protocols.candidates(address) {
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(address, protocols.port(controlChannel, protocol)), probeTimeout)
+		c.latency = time.Since(start)
+		c.err = err
+		if err == nil {
+			conn.Close()
+			break
+		}
+	}
+
+	return c
+}
+
+// Check whether the local interface on the same subnet as ip is a wired one
+func isWiredPath(ip net.IP) bool {
+	interfaces, err := net.Interfaces()
+	if err != nil || ip == nil {
+		return false
+	}
+
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.Contains(ip) {
+				return !isWireless(iface)
+			}
+		}
+	}
+
+	return false
+}
+
+func contains(slice []string, candidate string) bool {
+	for _, member := range slice {
+		if member == candidate {
+			return true
+		}
+	}
+	return false
+}
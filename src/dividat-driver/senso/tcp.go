@@ -11,24 +11,43 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// How long to wait before timeing out a tcp connection attempt
-const dialTimeout = 5 * time.Second
+// TCPSettings tune the connections to Sensos
+type TCPSettings struct {
+	// How long to wait before timing out a connection attempt
+	DialTimeout time.Duration
+	// Interval of TCP keep-alive probes, so a Senso that vanished without
+	// closing the connection is noticed even if nothing is written to it
+	KeepAlive time.Duration
+	// Reconnect if nothing is received on the data channel for this long,
+	// disabled if zero
+	ReadTimeout time.Duration
+}
+
+// DefaultTCPSettings are used unless configured otherwise
+var DefaultTCPSettings = TCPSettings{
+	DialTimeout: 5 * time.Second,
+	KeepAlive:   15 * time.Second,
+}
 
 // maximal interval to wait between connection retry
 const maxInterval = 30 * time.Second
 
 type onReceive = func([]byte)
 
-// connectTCP creates a persistent tcp connection to address
-func connectTCP(ctx context.Context, baseLogger *logrus.Entry, address string, tx chan interface{}, onReceive onReceive) {
-	var dialer net.Dialer
+// connectTCP creates a persistent tcp connection to address, reporting to
+// onConnection whenever it is established or lost. The connection is
+// re-established when keep-alive probes fail or the read timeout passes.
+// Nothing is written to the connection if tx and commands are nil. Commands
+// are only taken while connected and answered with the outcome of writing them.
+func connectTCP(ctx context.Context, baseLogger *logrus.Entry, address string, settings TCPSettings, tx <-chan interface{}, commands <-chan clientCommand, onReceive onReceive, onConnection func(bool)) {
+	dialer := net.Dialer{KeepAlive: settings.KeepAlive}
 
 	var log = baseLogger.WithField("address", address)
 
 	var conn net.Conn
 	dialTCP := func() error {
 
-		dialer.Deadline = time.Now().Add(dialTimeout)
+		dialer.Deadline = time.Now().Add(settings.DialTimeout)
 		var connErr error
 		if conn != nil {
 			conn.Close()
@@ -65,13 +84,14 @@ func connectTCP(ctx context.Context, baseLogger *logrus.Entry, address string, t
 		}
 
 		log.Info("Connected.")
+		onConnection(true)
 
 		// Close connection if we break or return
 		defer conn.Close()
 
 		// create channel for reading data and go read
 		readChannel := make(chan []byte)
-		go tcpReader(log, conn, readChannel)
+		go tcpReader(log, conn, settings.ReadTimeout, readChannel)
 
 		// Inner loop for handling data
 		disconnected := false
@@ -87,6 +107,7 @@ func connectTCP(ctx context.Context, baseLogger *logrus.Entry, address string, t
 					onReceive(receivedData)
 				} else {
 					disconnected = true
+					onConnection(false)
 					break
 				}
 
@@ -95,6 +116,16 @@ func connectTCP(ctx context.Context, baseLogger *logrus.Entry, address string, t
 				err := write(conn, data)
 				if err != nil {
 					disconnected = true
+					onConnection(false)
+					break
+				}
+
+			case command := <-commands:
+				err := write(conn, command.data)
+				command.done <- err
+				if err != nil {
+					disconnected = true
+					onConnection(false)
 					break
 				}
 			}
@@ -103,8 +134,9 @@ func connectTCP(ctx context.Context, baseLogger *logrus.Entry, address string, t
 	}
 }
 
-// Helper to read from TCP connection
-func tcpReader(log *logrus.Entry, conn net.Conn, channel chan<- []byte) {
+// Helper to read from TCP connection, closing the channel once the connection
+// fails or nothing is received within the read timeout (if not zero)
+func tcpReader(log *logrus.Entry, conn net.Conn, readTimeout time.Duration, channel chan<- []byte) {
 
 	defer close(channel)
 
@@ -112,13 +144,18 @@ func tcpReader(log *logrus.Entry, conn net.Conn, channel chan<- []byte) {
 
 	// Loop and read from connection.
 	for {
+		if readTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(readTimeout))
+		}
 		readN, readErr := conn.Read(buffer)
 
 		if readErr != nil {
 			if readErr == io.EOF {
 				return
 			} else if err, ok := readErr.(net.Error); ok && err.Timeout() {
-				// Read timeout, just continue Nothing
+				// Read deadline passed, or keep-alive probes were not answered
+				log.WithError(readErr).Warn("Senso stopped responding, reconnecting.")
+				return
 			} else {
 				// log.WithError(readErr).Error("Read error.")
 				return
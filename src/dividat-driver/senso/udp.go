@@ -0,0 +1,58 @@
+package senso
+
+// Native applications that used to read the data channel of the Senso
+// directly can run alongside clients of the driver: data packets of the
+// default device are re-emitted as UDP datagrams on a local port, one packet
+// per datagram. Packets are sent as received from the Senso, without unit
+// conversion.
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// ListenUDPBridge checks that the address is a local UDP address and opens a
+// socket to send to it
+func ListenUDPBridge(address string) (*net.UDPConn, error) {
+	target, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	if !target.IP.IsLoopback() {
+		return nil, fmt.Errorf("UDP bridge address %s is not local", address)
+	}
+	return net.DialUDP("udp", nil, target)
+}
+
+// RebroadcastUDP sends the data packets received from the default device to
+// the connection until the context is done
+func (handle *Handle) RebroadcastUDP(ctx context.Context, conn *net.UDPConn) {
+	defer conn.Close()
+	received := handle.broker.SubFor(ctx, "rx")
+	defer handle.broker.Unsub(received)
+
+	// Packets are split or joined by TCP, datagrams hold one each
+	var packets packetSplitter
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case i := <-received:
+			item, ok := i.(packet)
+			if !ok || item.device != defaultDevice || item.channel != dataChannel.name {
+				continue
+			}
+			// Data is dropped if the packet boundary is lost, e.g. after
+			// reconnecting
+			completed, _ := packets.split(item.data)
+			for _, packet := range completed {
+				if len(packetFrames(packet)) == 0 {
+					continue
+				}
+				// Errors are not logged, nothing may be listening
+				conn.Write(packet)
+			}
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package senso
+
+// Readings of a Senso are raw ADC counts. A unit calibration maps them to
+// physical units: known weights are placed on the plates, the mean of the
+// summed readings is recorded for each weight, and a line is fitted through
+// the points. Calibrations are persisted by Senso, and frames converted with
+// one carry its identifier, so values can be traced to their calibration.
+//
+// Clients connecting with the query parameter `units=kg` or `units=N` receive
+// decoded frames with converted values.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/clock"
+	"github.com/dividat/driver/src/dividat-driver/hooks"
+)
+
+// Units frames can be converted to
+const (
+	Kilograms = "kg"
+	Newtons   = "N"
+)
+
+const standardGravity = 9.80665
+
+// Recording time of a point if the client does not specify one
+const defaultPointDuration = 2 * time.Second
+
+// Longest recording time of a point, the weight must stay put meanwhile
+const maxPointDuration = 30 * time.Second
+
+// CalibrationPoint is the mean summed reading recorded with a known weight
+type CalibrationPoint struct {
+	// In kilograms
+	Weight  float64 `json:"weight"`
+	Reading float64 `json:"reading"`
+}
+
+// UnitCalibration converts readings of a Senso to kilograms
+type UnitCalibration struct {
+	ID string `json:"id"`
+	// Serial number of the Senso, or its address if connected by address
+	Senso  string             `json:"senso"`
+	Points []CalibrationPoint `json:"points"`
+	// Kilograms per count of the summed readings, and kilograms at no reading
+	Slope   float64   `json:"slope"`
+	Offset  float64   `json:"offset"`
+	Created time.Time `json:"created"`
+}
+
+// Fit a line through the points by least squares
+func fitCalibration(senso string, points []CalibrationPoint) (UnitCalibration, error) {
+	if len(points) < 2 {
+		return UnitCalibration{}, errors.New("at least two points must be recorded")
+	}
+	var meanReading, meanWeight float64
+	for _, point := range points {
+		meanReading += point.Reading
+		meanWeight += point.Weight
+	}
+	meanReading /= float64(len(points))
+	meanWeight /= float64(len(points))
+
+	var covariance, variance float64
+	for _, point := range points {
+		covariance += (point.Reading - meanReading) * (point.Weight - meanWeight)
+		variance += (point.Reading - meanReading) * (point.Reading - meanReading)
+	}
+	if variance == 0 {
+		return UnitCalibration{}, errors.New("points were recorded with the same reading")
+	}
+
+	slope := covariance / variance
+	return UnitCalibration{
+		ID:      hooks.NewID(),
+		Senso:   senso,
+		Points:  points,
+		Slope:   slope,
+		Offset:  meanWeight - slope*meanReading,
+		Created: time.Now().UTC(),
+	}, nil
+}
+
+// Convert the readings of each plate into the unit. The offset is shared
+// evenly among plates, so that their values add up to the total weight.
+func (calibration UnitCalibration) convert(frame Frame, unit string) Frame {
+	factor := 1.0
+	if unit == Newtons {
+		factor = standardGravity
+	}
+	converted := make([]Plate, len(frame.Plates))
+	for i, plate := range frame.Plates {
+		value := (calibration.Slope*float64(plate.sum()) + calibration.Offset/float64(len(frame.Plates))) * factor
+		plate.Value = &value
+		converted[i] = plate
+	}
+	frame.Plates = converted
+	frame.Unit = unit
+	frame.Calibration = calibration.ID
+	return frame
+}
+
+func (plate Plate) sum() int {
+	sum := 0
+	for _, force := range plate.Forces {
+		sum += int(force)
+	}
+	return sum
+}
+
+// UnitCalibrations persists the latest calibration of each Senso
+type UnitCalibrations struct {
+	// Calibrations are kept in memory only if empty
+	path  string
+	mutex sync.Mutex
+	// By serial number or address of the Senso
+	calibrations map[string]UnitCalibration
+}
+
+// OpenUnitCalibrations opens the store persisted at path, a missing file is an
+// empty store
+func OpenUnitCalibrations(path string) (*UnitCalibrations, error) {
+	store := UnitCalibrations{path: path, calibrations: map[string]UnitCalibration{}}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(contents, &store.calibrations)
+	if err != nil {
+		return nil, err
+	}
+	return &store, nil
+}
+
+func (store *UnitCalibrations) get(senso string) (UnitCalibration, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	calibration, ok := store.calibrations[senso]
+	return calibration, ok
+}
+
+func (store *UnitCalibrations) set(calibration UnitCalibration) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.calibrations[calibration.Senso] = calibration
+	if store.path == "" {
+		return nil
+	}
+
+	contents, err := json.MarshalIndent(store.calibrations, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(store.path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(store.path, contents, 0644)
+}
+
+// Points recorded for devices, by identifier
+type calibrationPoints struct {
+	mutex  sync.Mutex
+	points map[string][]CalibrationPoint
+}
+
+func (c *calibrationPoints) add(id string, point CalibrationPoint) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.points == nil {
+		c.points = map[string][]CalibrationPoint{}
+	}
+	c.points[id] = append(c.points[id], point)
+	return len(c.points[id])
+}
+
+func (c *calibrationPoints) take(id string) []CalibrationPoint {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	points := c.points[id]
+	delete(c.points, id)
+	return points
+}
+
+// UseUnitCalibrations persists unit calibrations in the store, instead of
+// keeping them in memory. Must be called before clients connect.
+func (handle *Handle) UseUnitCalibrations(store *UnitCalibrations) {
+	handle.unitCalibrations = store
+}
+
+// Serial number of the Senso the device is connected to, or its address
+func (device *device) senso() string {
+	if device.serial != "" {
+		return device.serial
+	}
+	return device.address
+}
+
+// RecordCalibrationPoint records the mean summed reading of the device with
+// the known weight in kilograms on its plates during the given duration,
+// returning the point and the number of points recorded so far
+func (handle *Handle) RecordCalibrationPoint(ctx context.Context, id string, weight float64, duration time.Duration) (CalibrationPoint, int, error) {
+	if duration <= 0 {
+		duration = defaultPointDuration
+	}
+	if duration > maxPointDuration {
+		duration = maxPointDuration
+	}
+	if handle.device(id) == nil {
+		return CalibrationPoint{}, 0, errors.New("device is not connected")
+	}
+
+	received := handle.broker.SubFor(ctx, "rx")
+	defer handle.broker.Unsub(received)
+	done := clock.Default.After(duration)
+
+	var sum, frames int
+	for collecting := true; collecting; {
+		select {
+		case <-ctx.Done():
+			return CalibrationPoint{}, 0, ctx.Err()
+		case <-done:
+			collecting = false
+		case i := <-received:
+			if item, ok := i.(packet); ok && item.device == id {
+				for _, frame := range item.frames {
+					for _, plate := range frame.Plates {
+						sum += plate.sum()
+					}
+					frames++
+				}
+			}
+		}
+	}
+	if frames == 0 {
+		return CalibrationPoint{}, 0, errors.New("no frames were received")
+	}
+
+	point := CalibrationPoint{Weight: weight, Reading: float64(sum) / float64(frames)}
+	return point, handle.calibrationPoints.add(id, point), nil
+}
+
+// FitUnitCalibration fits and persists a calibration of the device through
+// the points recorded since the last fit
+func (handle *Handle) FitUnitCalibration(id string) (UnitCalibration, error) {
+	points := handle.calibrationPoints.take(id)
+	device := handle.device(id)
+	if device == nil {
+		return UnitCalibration{}, errors.New("device is not connected")
+	}
+	calibration, err := fitCalibration(device.senso(), points)
+	if err != nil {
+		return UnitCalibration{}, err
+	}
+	return calibration, handle.unitCalibrations.set(calibration)
+}
+
+// Calibration of the Senso a device is connected to
+func (handle *Handle) unitCalibration(id string) (UnitCalibration, bool) {
+	device := handle.device(id)
+	if device == nil {
+		return UnitCalibration{}, false
+	}
+	return handle.unitCalibrations.get(device.senso())
+}
@@ -4,16 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
-	"fmt"
 	"io"
 
+	"github.com/dividat/driver/src/dividat-driver/catalog"
 	"github.com/dividat/driver/src/dividat-driver/firmware"
 )
 
 type SendMsg struct {
-	progress func(string)
-	failure  func(string)
-	success  func(string)
+	progress func(catalog.Text)
+	failure  func(catalog.Text)
+	success  func(catalog.Text)
 }
 
 // Disconnect from current connection
@@ -21,14 +21,14 @@ func (handle *Handle) ProcessFirmwareUpdateRequest(command UpdateFirmware, send
 	handle.log.Info("Processing firmware update request.")
 	handle.firmwareUpdate.SetUpdating(true)
 
-	if handle.cancelCurrentConnection != nil {
-		send.progress("Disconnecting from the Senso")
-		handle.cancelCurrentConnection()
+	if device := handle.device(defaultDevice); device != nil {
+		send.progress(catalog.New("senso.disconnecting"))
+		device.close()
 	}
 
 	image, err := decodeImage(command.Image)
 	if err != nil {
-		msg := fmt.Sprintf("Error decoding base64 string: %v", err)
+		msg := catalog.New("firmware.decodeFailed", "error", err.Error())
 		send.failure(msg)
 		handle.log.Error(msg)
 		return
@@ -36,11 +36,11 @@ func (handle *Handle) ProcessFirmwareUpdateRequest(command UpdateFirmware, send
 
 	err = firmware.UpdateBySerial(context.Background(), command.SerialNumber, image, send.progress)
 	if err != nil {
-		failureMsg := fmt.Sprintf("Failed to update firmware: %v", err)
+		failureMsg := catalog.New("firmware.updateFailed", "error", err.Error())
 		send.failure(failureMsg)
 		handle.log.Error(failureMsg)
 	} else {
-		send.success("Firmware successfully transmitted")
+		send.success(catalog.New("senso.firmwareTransmitted"))
 	}
 	handle.firmwareUpdate.SetUpdating(false)
 }
@@ -2,8 +2,10 @@ package senso
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"sync"
@@ -13,7 +15,19 @@ import (
 	"github.com/libp2p/zeroconf/v2"
 	"github.com/sirupsen/logrus"
 
+	"github.com/dividat/driver/src/dividat-driver/auth"
+	"github.com/dividat/driver/src/dividat-driver/catalog"
+	"github.com/dividat/driver/src/dividat-driver/clock"
+	"github.com/dividat/driver/src/dividat-driver/codec"
+	"github.com/dividat/driver/src/dividat-driver/config"
+	"github.com/dividat/driver/src/dividat-driver/confirm"
+	"github.com/dividat/driver/src/dividat-driver/deprecation"
+	"github.com/dividat/driver/src/dividat-driver/drops"
+	"github.com/dividat/driver/src/dividat-driver/hooks"
+	"github.com/dividat/driver/src/dividat-driver/latency"
+	"github.com/dividat/driver/src/dividat-driver/logging"
 	"github.com/dividat/driver/src/dividat-driver/service"
+	"github.com/dividat/driver/src/dividat-driver/sessions"
 )
 
 // WEBSOCKET PROTOCOL
@@ -26,7 +40,18 @@ type Command struct {
 	*Disconnect
 
 	*Discover
+	*CancelDiscover
 	*UpdateFirmware
+
+	*ConfirmPairing
+	*Confirm
+
+	*RecordCalibrationPoint
+	*FitUnitCalibration
+
+	*TraceFrames
+
+	*Ping
 }
 
 func prettyPrintCommand(command Command) string {
@@ -38,8 +63,22 @@ func prettyPrintCommand(command Command) string {
 		return "Disconnect"
 	} else if command.Discover != nil {
 		return "Discover"
+	} else if command.CancelDiscover != nil {
+		return "CancelDiscover"
 	} else if command.UpdateFirmware != nil {
 		return "UpdateFirmware"
+	} else if command.ConfirmPairing != nil {
+		return "ConfirmPairing"
+	} else if command.Confirm != nil {
+		return "Confirm"
+	} else if command.RecordCalibrationPoint != nil {
+		return "RecordCalibrationPoint"
+	} else if command.FitUnitCalibration != nil {
+		return "FitUnitCalibration"
+	} else if command.TraceFrames != nil {
+		return "TraceFrames"
+	} else if command.Ping != nil {
+		return "Ping"
 	}
 	return "Unknown"
 }
@@ -47,32 +86,87 @@ func prettyPrintCommand(command Command) string {
 // GetStatus command
 type GetStatus struct{}
 
-// Connect command
+// Connect command, either to a specific address or to the best path to the Senso with given serial
 type Connect struct {
 	Address string `json:"address"`
+	Serial  string `json:"serial"`
+	// Identifier of the device to connect, the default device if empty
+	Device string `json:"device"`
 }
 
 // Disconnect command
-type Disconnect struct{}
+type Disconnect struct {
+	// Identifier of the device to disconnect, the default device if empty
+	Device string `json:"device"`
+}
 
 // Discover command
 type Discover struct {
 	Duration int `json:"duration"`
+	// "senso" (default) or "all" to include Flex devices
+	Devices string `json:"devices"`
 }
 
 type UpdateFirmware struct {
 	SerialNumber string `json:"serialNumber"`
 	Image        string `json:"image"`
+	// Update even if other clients are connected
+	Force bool `json:"force"`
+}
+
+// ConfirmPairing command, confirms the device awaiting pairing
+type ConfirmPairing struct {
+	// Device awaiting pairing, as announced in PairingRequired
+	Device string `json:"device"`
+}
+
+// Confirm command, runs a destructive command held back until the client
+// echoes the token it was sent in a ConfirmationRequired message
+type Confirm struct {
+	Token string `json:"token"`
+}
+
+// RecordCalibrationPoint command, records the readings of a device with a known
+// weight on its plates during the given number of seconds
+type RecordCalibrationPoint struct {
+	// Identifier of the device, the default device if empty
+	Device string `json:"device"`
+	// In kilograms
+	Weight   float64 `json:"weight"`
+	Duration float64 `json:"duration"`
+}
+
+// FitUnitCalibration command, fits and persists a unit calibration through the
+// points recorded for a device
+type FitUnitCalibration struct {
+	// Identifier of the device, the default device if empty
+	Device string `json:"device"`
+}
+
+// TraceFrames command, writes hex dumps of all received data to a file for the
+// given number of seconds
+type TraceFrames struct {
+	Duration float64 `json:"duration"`
 }
 
 // UnmarshalJSON implements encoding/json Unmarshaler interface
 func (command *Command) UnmarshalJSON(data []byte) error {
+	return command.decode(data, json.Unmarshal)
+}
+
+// UnmarshalCBOR implements cbor.Unmarshaler interface
+func (command *Command) UnmarshalCBOR(data []byte) error {
+	return command.decode(data, codec.UnmarshalCBOR)
+}
+
+// Decode a command with the unmarshal function of the client's encoding
+func (command *Command) decode(data []byte, unmarshal func([]byte, interface{}) error) error {
 
 	// Helper struct to get type
 	temp := struct {
 		Type string `json:"type"`
 	}{}
-	if err := json.Unmarshal(data, &temp); err != nil {
+	if err := unmarshal(data, &temp); err != nil {
 		return err
 	}
 
@@ -80,26 +174,77 @@ func (command *Command) UnmarshalJSON(data []byte) error {
 		command.GetStatus = &GetStatus{}
 
 	} else if temp.Type == "Connect" {
-		err := json.Unmarshal(data, &command.Connect)
+		err := unmarshal(data, &command.Connect)
 		if err != nil {
 			return err
 		}
+		return validDevice(command.Connect.Device)
 
 	} else if temp.Type == "Disconnect" {
-		command.Disconnect = &Disconnect{}
+		err := unmarshal(data, &command.Disconnect)
+		if err != nil {
+			return err
+		}
 
 	} else if temp.Type == "Discover" {
 
-		err := json.Unmarshal(data, &command.Discover)
+		err := unmarshal(data, &command.Discover)
 		if err != nil {
 			return err
 		}
+		return command.Discover.validate()
+
+	} else if temp.Type == "CancelDiscover" {
+		command.CancelDiscover = &CancelDiscover{}
 
 	} else if temp.Type == "UpdateFirmware" {
-		err := json.Unmarshal(data, &command.UpdateFirmware)
+		err := unmarshal(data, &command.UpdateFirmware)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "ConfirmPairing" {
+		err := unmarshal(data, &command.ConfirmPairing)
+		if err != nil {
+			return err
+		}
+		if command.ConfirmPairing.Device == "" {
+			return errors.New("device awaiting pairing is required")
+		}
+
+	} else if temp.Type == "Confirm" {
+		err := unmarshal(data, &command.Confirm)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "RecordCalibrationPoint" {
+		err := unmarshal(data, &command.RecordCalibrationPoint)
+		if err != nil {
+			return err
+		}
+		return validDevice(command.RecordCalibrationPoint.Device)
+
+	} else if temp.Type == "FitUnitCalibration" {
+		err := unmarshal(data, &command.FitUnitCalibration)
 		if err != nil {
 			return err
 		}
+		return validDevice(command.FitUnitCalibration.Device)
+
+	} else if temp.Type == "TraceFrames" {
+		err := unmarshal(data, &command.TraceFrames)
+		if err != nil {
+			return err
+		}
+
+	} else if temp.Type == "Ping" {
+		err := unmarshal(data, &command.Ping)
+		if err != nil {
+			return err
+		}
+		return validDevice(command.Ping.Device)
+
 	} else {
 		return errors.New("can not decode unknown command")
 	}
@@ -111,33 +256,124 @@ func (command *Command) UnmarshalJSON(data []byte) error {
 type Message struct {
 	*Status
 	Discovered            *zeroconf.ServiceEntry
+	Rediscovered          *zeroconf.ServiceEntry
+	DiscoveredDevice      *DiscoveredDevice
 	FirmwareUpdateMessage *FirmwareUpdateMessage
+	ConfigReloaded        *config.Changes
+	PairingRequired       *string
+	Paired                *string
+	PermissionDenied      *auth.PermissionDenied
+	FirmwareUpdateBusy    *sessions.Busy
+	ConfirmationRequired  *confirm.Request
+	// Token of a confirmation that is unknown or expired
+	ConfirmationInvalid *string
+	// Why a binary command could not be written to the Senso
+	CommandFailed         *string
+	Frame                 *Frame
+	CalibrationPoint      *RecordedPoint
+	UnitCalibrated        *UnitCalibration
+	UnitCalibrationFailed *UnitCalibrationFailure
+	FrameTraceStarted     *logging.TraceStart
+	FrameTraceStopped     *logging.TraceSummary
+	Latency               *Latency
+	Deprecated            *deprecation.Warning
+
+	// Language catalog texts are rendered in
+	language string
 }
 
 // Status is a message containing status information
 type Status struct {
-	Address *string
+	// Of the default device
+	Address      *string
+	Alternatives []string
+	// "connected", "connecting" (also while reconnecting) or "disconnected"
+	Connection string
+	// Nil unless connected
+	Health *Health
+	// Named devices
+	Devices []DeviceStatus
+	// Device awaiting confirmation by an operator, nil if none
+	PairingRequired *string
+	// Data missed by clients
+	Drops drops.Snapshot
+	// Reported by the Senso, empty until known
+	SerialNumber    string
+	FirmwareVersion string
+	// "current" or "legacy", empty until detected
+	Protocol string
+}
+
+// RecordedPoint is a calibration point recorded for a device
+type RecordedPoint struct {
+	Device string `json:"device"`
+	CalibrationPoint
+	// Points recorded towards the next calibration
+	Points int `json:"points"`
+}
+
+// UnitCalibrationFailure tells why a point could not be recorded or a
+// calibration not be fitted
+type UnitCalibrationFailure struct {
+	Device string `json:"device"`
+	Error  string `json:"error"`
 }
 
 type FirmwareUpdateMessage struct {
-	FirmwareUpdateProgress *string
-	FirmwareUpdateSuccess  *string
-	FirmwareUpdateFailure  *string
+	FirmwareUpdateProgress *catalog.Text
+	FirmwareUpdateSuccess  *catalog.Text
+	FirmwareUpdateFailure  *catalog.Text
 }
 
-// MarshalJSON ipmlements JSON encoder for messages
+// MarshalJSON implements JSON encoder for messages
 func (message *Message) MarshalJSON() ([]byte, error) {
+	wire, err := message.wire()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(wire)
+}
+
+// MarshalCBOR implements cbor.Marshaler interface
+func (message *Message) MarshalCBOR() ([]byte, error) {
+	wire, err := message.wire()
+	if err != nil {
+		return nil, err
+	}
+	return codec.MarshalCBOR(wire)
+}
+
+// Message as encoded for the client, with its type
+func (message *Message) wire() (interface{}, error) {
 	if message.Status != nil {
-		return json.Marshal(&struct {
-			Type    string  `json:"type"`
-			Address *string `json:"address"`
+		return &struct {
+			Type            string         `json:"type"`
+			Address         *string        `json:"address"`
+			Alternatives    []string       `json:"alternatives,omitempty"`
+			Connection      string         `json:"connection"`
+			Health          *Health        `json:"health,omitempty"`
+			Devices         []DeviceStatus `json:"devices,omitempty"`
+			PairingRequired *string        `json:"pairingRequired,omitempty"`
+			Drops           drops.Snapshot `json:"drops"`
+			SerialNumber    string         `json:"serialNumber,omitempty"`
+			FirmwareVersion string         `json:"firmwareVersion,omitempty"`
+			Protocol        string         `json:"protocol,omitempty"`
 		}{
-			Type:    "Status",
-			Address: message.Status.Address,
-		})
+			Type:            "Status",
+			Address:         message.Status.Address,
+			Alternatives:    message.Status.Alternatives,
+			Connection:      message.Status.Connection,
+			Health:          message.Status.Health,
+			Devices:         message.Status.Devices,
+			PairingRequired: message.Status.PairingRequired,
+			Drops:           message.Status.Drops,
+			SerialNumber:    message.Status.SerialNumber,
+			FirmwareVersion: message.Status.FirmwareVersion,
+			Protocol:        message.Status.Protocol,
+		}, nil
 
 	} else if message.Discovered != nil {
-		return json.Marshal(&struct {
+		return &struct {
 			Type         string                 `json:"type"`
 			ServiceEntry *zeroconf.ServiceEntry `json:"service"`
 			IP           []net.IP               `json:"ip"`
@@ -145,12 +381,35 @@ func (message *Message) MarshalJSON() ([]byte, error) {
 			Type:         "Discovered",
 			ServiceEntry: message.Discovered,
 			IP:           append(message.Discovered.AddrIPv4, message.Discovered.AddrIPv6...),
-		})
+		}, nil
+
+	} else if message.Rediscovered != nil {
+		return &struct {
+			Type         string                 `json:"type"`
+			ServiceEntry *zeroconf.ServiceEntry `json:"service"`
+			IP           []net.IP               `json:"ip"`
+			Updated      bool                   `json:"updated"`
+		}{
+			Type:         "Discovered",
+			ServiceEntry: message.Rediscovered,
+			IP:           append(message.Rediscovered.AddrIPv4, message.Rediscovered.AddrIPv6...),
+			Updated:      true,
+		}, nil
+
+	} else if message.DiscoveredDevice != nil {
+		return &struct {
+			Type string `json:"type"`
+			DiscoveredDevice
+		}{
+			Type:             "DeviceInfo",
+			DiscoveredDevice: *message.DiscoveredDevice,
+		}, nil
 
 	} else if message.FirmwareUpdateMessage != nil {
 		fwUpdate := struct {
 			Type    string `json:"type"`
 			Message string `json:"message"`
+			catalog.Text
 		}{}
 
 		firmwareUpdateMessage := *message.FirmwareUpdateMessage
@@ -158,23 +417,176 @@ func (message *Message) MarshalJSON() ([]byte, error) {
 		if firmwareUpdateMessage.FirmwareUpdateProgress != nil {
 
 			fwUpdate.Type = "FirmwareUpdateProgress"
-			fwUpdate.Message = *firmwareUpdateMessage.FirmwareUpdateProgress
+			fwUpdate.Text = *firmwareUpdateMessage.FirmwareUpdateProgress
 
 		} else if firmwareUpdateMessage.FirmwareUpdateFailure != nil {
 
 			fwUpdate.Type = "FirmwareUpdateFailure"
-			fwUpdate.Message = *firmwareUpdateMessage.FirmwareUpdateFailure
+			fwUpdate.Text = *firmwareUpdateMessage.FirmwareUpdateFailure
 
 		} else if firmwareUpdateMessage.FirmwareUpdateSuccess != nil {
 
 			fwUpdate.Type = "FirmwareUpdateSuccess"
-			fwUpdate.Message = *firmwareUpdateMessage.FirmwareUpdateSuccess
+			fwUpdate.Text = *firmwareUpdateMessage.FirmwareUpdateSuccess
 
 		} else {
 			return nil, errors.New("could not marshal firmware update message")
 		}
 
-		return json.Marshal(fwUpdate)
+		fwUpdate.Message = fwUpdate.Text.In(message.language)
+		return fwUpdate, nil
+
+	} else if message.ConfigReloaded != nil {
+		return &struct {
+			Type            string   `json:"type"`
+			Applied         []string `json:"applied"`
+			RestartRequired []string `json:"restartRequired"`
+		}{
+			Type:            "ConfigReloaded",
+			Applied:         message.ConfigReloaded.Applied,
+			RestartRequired: message.ConfigReloaded.RestartRequired,
+		}, nil
+
+	} else if message.PairingRequired != nil {
+		return &struct {
+			Type   string `json:"type"`
+			Device string `json:"device"`
+		}{
+			Type:   "PairingRequired",
+			Device: *message.PairingRequired,
+		}, nil
+
+	} else if message.Paired != nil {
+		return &struct {
+			Type   string `json:"type"`
+			Device string `json:"device"`
+		}{
+			Type:   "Paired",
+			Device: *message.Paired,
+		}, nil
+
+	} else if message.FirmwareUpdateBusy != nil {
+		return &struct {
+			Type     string             `json:"type"`
+			Sessions []sessions.Session `json:"sessions"`
+			Queued   bool               `json:"queued"`
+		}{
+			Type:     "FirmwareUpdateBusy",
+			Sessions: message.FirmwareUpdateBusy.Sessions,
+			Queued:   message.FirmwareUpdateBusy.Queued,
+		}, nil
+
+	} else if message.PermissionDenied != nil {
+		return &struct {
+			Type     string `json:"type"`
+			Command  string `json:"command"`
+			Role     string `json:"role"`
+			Required string `json:"required"`
+		}{
+			Type:     "PermissionDenied",
+			Command:  message.PermissionDenied.Command,
+			Role:     message.PermissionDenied.Role,
+			Required: message.PermissionDenied.Required,
+		}, nil
+
+	} else if message.ConfirmationRequired != nil {
+		return &struct {
+			Type string `json:"type"`
+			confirm.Request
+		}{
+			Type:    "ConfirmationRequired",
+			Request: *message.ConfirmationRequired,
+		}, nil
+
+	} else if message.ConfirmationInvalid != nil {
+		return &struct {
+			Type  string `json:"type"`
+			Token string `json:"token"`
+		}{
+			Type:  "ConfirmationInvalid",
+			Token: *message.ConfirmationInvalid,
+		}, nil
+
+	} else if message.CommandFailed != nil {
+		return &struct {
+			Type  string `json:"type"`
+			Error string `json:"error"`
+		}{
+			Type:  "CommandFailed",
+			Error: *message.CommandFailed,
+		}, nil
+
+	} else if message.Frame != nil {
+		return &struct {
+			Type string `json:"type"`
+			*Frame
+		}{
+			Type:  "Frame",
+			Frame: message.Frame,
+		}, nil
+
+	} else if message.CalibrationPoint != nil {
+		return &struct {
+			Type string `json:"type"`
+			RecordedPoint
+		}{
+			Type:          "CalibrationPoint",
+			RecordedPoint: *message.CalibrationPoint,
+		}, nil
+
+	} else if message.UnitCalibrated != nil {
+		return &struct {
+			Type string `json:"type"`
+			UnitCalibration
+		}{
+			Type:            "UnitCalibrated",
+			UnitCalibration: *message.UnitCalibrated,
+		}, nil
+
+	} else if message.UnitCalibrationFailed != nil {
+		return &struct {
+			Type string `json:"type"`
+			UnitCalibrationFailure
+		}{
+			Type:                   "UnitCalibrationFailed",
+			UnitCalibrationFailure: *message.UnitCalibrationFailed,
+		}, nil
+
+	} else if message.FrameTraceStarted != nil {
+		return &struct {
+			Type string `json:"type"`
+			logging.TraceStart
+		}{
+			Type:       "FrameTraceStarted",
+			TraceStart: *message.FrameTraceStarted,
+		}, nil
+
+	} else if message.FrameTraceStopped != nil {
+		return &struct {
+			Type string `json:"type"`
+			logging.TraceSummary
+		}{
+			Type:         "FrameTraceStopped",
+			TraceSummary: *message.FrameTraceStopped,
+		}, nil
+
+	} else if message.Latency != nil {
+		return &struct {
+			Type string `json:"type"`
+			Latency
+		}{
+			Type:    "Latency",
+			Latency: *message.Latency,
+		}, nil
+
+	} else if message.Deprecated != nil {
+		return &struct {
+			Type string `json:"type"`
+			deprecation.Warning
+		}{
+			Type:    "Deprecated",
+			Warning: *message.Deprecated,
+		}, nil
 	}
 
 	return nil, errors.New("could not marshal message")
@@ -185,11 +597,25 @@ func (message *Message) MarshalJSON() ([]byte, error) {
 func (handle *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Set up logger
+	connectionID := hooks.NewID()
 	var log = handle.log.WithFields(logrus.Fields{
+		"connectionId":  connectionID,
 		"clientAddress": r.RemoteAddr,
 		"userAgent":     r.UserAgent(),
 	})
 
+	// Encoding of messages and commands
+	encoding, err := codec.Negotiate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if unit := r.URL.Query().Get("units"); unit != "" && unit != Kilograms && unit != Newtons {
+		http.Error(w, "Unknown unit, expected kg or N", http.StatusBadRequest)
+		return
+	}
+
 	// Update to WebSocket
 	conn, err := webSocketUpgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -200,12 +626,45 @@ func (handle *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	log.Info("WebSocket connection opened")
 
+	// Measures round trips for the Ping command
+	pinger := latency.NewClientPinger(conn)
+
+	// Role of clients authenticated with a token, unrestricted otherwise
+	role := auth.RoleFrom(r.Context())
+
+	// Language user-facing messages are rendered in
+	language := catalog.Language(r)
+
+	// Whether binary frames are wrapped in an envelope naming their device
+	tagged := r.URL.Query().Get("envelope") == "device"
+
+	// Whether data frames are decoded into messages, and the unit they are
+	// converted to if any
+	unit := r.URL.Query().Get("units")
+	decoded := r.URL.Query().Get("frames") == "json" || unit != ""
+
+	client := hooks.Client{ID: connectionID, Endpoint: "senso", Address: r.RemoteAddr, UserAgent: r.UserAgent()}
+	handle.Hooks.ClientConnected(client)
+	session := handle.sessions.Open(sessions.Session{Address: r.RemoteAddr, UserAgent: r.UserAgent(), Since: time.Now().UTC()})
+
 	// Create a mutex for writing to WebSocket (connection supports only one concurrent reader and one concurrent writer (https://godoc.org/github.com/gorilla/websocket#hdr-Concurrency))
 	writeMutex := sync.Mutex{}
 
 	// Create a context for this WebSocket connection
 	ctx, cancel := context.WithCancel(context.Background())
 
+	// Close connections of temporary sessions once they expire
+	if expires, ok := auth.ExpiryFrom(r.Context()); ok {
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Until(expires)):
+				log.Info("Closing WebSocket connection of expired session.")
+				conn.Close()
+			}
+		}()
+	}
+
 	// Send binary data up the WebSocket
 	sendBinary := func(data []byte) error {
 		writeMutex.Lock()
@@ -223,9 +682,15 @@ func (handle *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// send messgae up the WebSocket
 	sendMessage := func(message Message) error {
+		message.language = language
+		messageType, payload, err := encoding.Encode(&message)
+		if err != nil {
+			log.WithError(err).Error("Could not encode message.")
+			return err
+		}
 		writeMutex.Lock()
 		conn.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
-		err := conn.WriteJSON(&message)
+		err = conn.WriteMessage(messageType, payload)
 		writeMutex.Unlock()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
@@ -233,19 +698,61 @@ func (handle *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 			return err
 		}
+		if messageType == websocket.TextMessage {
+			handle.Hooks.TextExchanged(client, false, payload)
+		}
 		return nil
 	}
 
-	// Create channels with data received from Senso
-	rx := handle.broker.Sub("rx")
+	// Warn about the deprecated shape of the status, once per connection
+	if handle.strict {
+		deprecations := deprecation.Once{}
+		sendWithoutWarning := sendMessage
+		sendMessage = func(message Message) error {
+			if message.Status != nil && deprecations.First(deprecation.LegacyStatus) {
+				warning := deprecation.LegacyStatus
+				log.WithField("deprecation", warning.Feature).Warn("Client is sent deprecated Status fields.")
+				err := sendWithoutWarning(Message{Deprecated: &warning})
+				if err != nil {
+					return err
+				}
+			}
+			return sendWithoutWarning(message)
+		}
+	}
+
+	// Data received from Senso and messages meant for all clients share a
+	// channel, so they are sent in the order they were
+	// published, e.g. no data follows the status announcing a disconnection
+	outbound := handle.broker.SubFor(ctx, "rx", "broadcast")
+	received := handle.rxDrops.Subscribe(r.RemoteAddr)
+	go func() {
+		outbound_loop(ctx, outbound, received, tagged, handle.frameConverter(unit, decoded), func(data []byte) error {
+			err := sendBinary(data)
+			if err == nil {
+				handle.Hooks.FrameForwarded(client, data)
+			}
+			return err
+		}, sendMessage)
+		// Clients that can not be sent to are disconnected, ending the subscription
+		conn.Close()
+	}()
 
-	// send data from Control and Data channel
-	go rx_data_loop(ctx, rx, sendBinary)
+	// Mirrors are sent the status instead of asking for it
+	if role == auth.Mirror {
+		go status_loop(ctx, mirrorStatusInterval, func() error {
+			return sendMessage(handle.status())
+		})
+	}
 
 	// Helper function to close the connection
 	close := func() {
 		// Unsubscribe from broker
-		handle.broker.Unsub(rx)
+		handle.broker.Unsub(outbound)
+		received.Close()
+
+		handle.sessions.Close(session)
+		handle.confirmations.Drop(session)
 
 		// Cancel the context
 		cancel()
@@ -254,6 +761,8 @@ func (handle *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		conn.Close()
 
 		log.Info("Websocket connection closed")
+
+		handle.Hooks.ClientDisconnected(client)
 	}
 
 	// Main loop for the WebSocket connection
@@ -269,31 +778,58 @@ func (handle *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			if messageType == websocket.BinaryMessage {
+			// Commands in the negotiated encoding
+			var command Command
+			isCommand, decodeErr := encoding.Decode(messageType, msg, &command)
+
+			if messageType == websocket.BinaryMessage && !isCommand {
+
+				if !role.Allows(auth.Operator) {
+					denied := auth.Deny("binary", role, auth.Operator)
+					sendMessage(Message{PermissionDenied: &denied})
+					continue
+				}
 
 				if handle.firmwareUpdate.IsUpdating() {
 					handle.log.Debug("Ignoring Senso command during firmware update.")
 					continue
 				}
 
-				handle.broker.TryPub(msg, "tx")
+				id := defaultDevice
+				if tagged {
+					var err error
+					id, msg, err = unwrap(msg)
+					if err != nil {
+						log.WithError(err).Warning("Can not unwrap binary message.")
+						continue
+					}
+				}
+				if err := handle.sendCommand(id, msg); err != nil {
+					log.WithError(err).Info("Could not write command to Senso.")
+					failure := err.Error()
+					sendMessage(Message{CommandFailed: &failure})
+				}
 
-			} else if messageType == websocket.TextMessage {
+			} else if isCommand {
+				if messageType == websocket.TextMessage {
+					handle.Hooks.TextExchanged(client, true, msg)
+				}
 
-				var command Command
-				decodeErr := json.Unmarshal(msg, &command)
 				if decodeErr != nil {
 					log.WithField("rawCommand", msg).WithError(decodeErr).Warning("Can not decode command.")
 					continue
 				}
+				// Entries caused by the command can be told apart from those of other commands
+				log := log.WithField("commandId", hooks.NewID())
 				log.WithField("command", prettyPrintCommand(command)).Debug("Received command.")
+				handle.Hooks.CommandReceived(client, prettyPrintCommand(command))
 
 				if handle.firmwareUpdate.IsUpdating() && (command.GetStatus == nil || command.Discover == nil) {
 					log.WithField("command", prettyPrintCommand(command)).Debug("Ignoring command during firmware update.")
 					continue
 				}
 
-				err := handle.dispatchCommand(ctx, log, command, sendMessage)
+				err := handle.dispatchCommand(ctx, log, role, session, command, pinger, sendMessage)
 				if err != nil {
 					return
 				}
@@ -306,41 +842,116 @@ func (handle *Handle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // HELPERS
 
-// dispatchCommand handles incomming commands and sends responses back up the WebSocket
-func (handle *Handle) dispatchCommand(ctx context.Context, log *logrus.Entry, command Command, sendMessage func(Message) error) error {
+// Interval of status messages to mirrors
+const mirrorStatusInterval = 5 * time.Second
 
-	if command.GetStatus != nil {
+// Status of the Senso connections, as sent to clients
+func (handle *Handle) status() Message {
+	status := handle.currentStatus(handle.strict)
+	return Message{Status: &status}
+}
 
-		var message Message
+// Status of the Senso connections, listing the default device among the
+// devices if asked to
+func (handle *Handle) currentStatus(listDefault bool) Status {
+	now := clock.Default.Now()
+	status := Status{Connection: disconnected, Devices: handle.namedDevices(now, listDefault), PairingRequired: handle.pendingPairing(), Drops: handle.Drops()}
+	if device := handle.device(defaultDevice); device != nil {
+		address := device.address
+		status.Address = &address
+		status.Alternatives = device.alternatives
+		status.Connection = device.connection.get()
+		status.Health = device.connection.health(now)
+		status.Protocol = device.connection.getProtocol()
+		if info := device.info.get(); info != nil {
+			status.SerialNumber, status.FirmwareVersion = info.SerialNumber, info.FirmwareVersion
+		}
+	}
+	return status
+}
 
-		message.Status = &Status{Address: handle.Address}
+// Role a client needs to issue the command
+func requiredRole(command Command) auth.Role {
+	if command.UpdateFirmware != nil || command.TraceFrames != nil {
+		return auth.Maintenance
+	} else if command.Connect != nil || command.Disconnect != nil || command.ConfirmPairing != nil || command.RecordCalibrationPoint != nil || command.FitUnitCalibration != nil {
+		return auth.Operator
+	}
+	return auth.Observer
+}
+
+// dispatchCommand handles incomming commands and sends responses back up the WebSocket
+func (handle *Handle) dispatchCommand(ctx context.Context, log *logrus.Entry, role auth.Role, session int, command Command, pinger *latency.ClientPinger, sendMessage func(Message) error) error {
 
-		err := sendMessage(message)
+	if required := requiredRole(command); !role.Allows(required) {
+		denied := auth.Deny(prettyPrintCommand(command), role, required)
+		log.WithField("command", denied.Command).WithField("role", denied.Role).Info("Denying command not permitted for role.")
+		return sendMessage(Message{PermissionDenied: &denied})
+	}
+
+	if command.GetStatus != nil {
+
+		err := sendMessage(handle.status())
 
 		if err != nil {
 			return err
 		}
 
 	} else if command.Connect != nil {
-		handle.Connect(command.Connect.Address)
+		if command.Connect.Address == "" && command.Connect.Serial != "" {
+			go handle.ConnectBySerial(ctx, command.Connect.Device, command.Connect.Serial)
+		} else {
+			handle.Connect(command.Connect.Device, command.Connect.Address)
+		}
 		return nil
 
 	} else if command.Disconnect != nil {
-		handle.Disconnect()
+		handle.Disconnect(command.Disconnect.Device)
 		return nil
 
 	} else if command.Discover != nil {
 
-		discoveryCtx, _ := context.WithTimeout(ctx, time.Duration(command.Discover.Duration)*time.Second)
+		// Aborted when the client disconnects, can not be sent to anymore or
+		// cancels it
+		discoveryCtx, cancelDiscovery := context.WithTimeout(ctx, time.Duration(command.Discover.Duration)*time.Second)
+		finished := handle.discoveries.start(session, cancelDiscovery)
 
-		entries := service.Scan(discoveryCtx)
+		// Sensos announced recently are sent right away
+		entries := service.DefaultCache.Scan(discoveryCtx)
+		unified := command.Discover.Devices == allDevices
 
 		go func(entries chan service.Service) {
+			defer finished()
+			defer cancelDiscovery()
+			if unified {
+				for _, device := range handle.discoverFlex() {
+					device := device
+					err := sendMessage(Message{DiscoveredDevice: &device})
+					if err != nil {
+						return
+					}
+				}
+			}
+			// Services are announced repeatedly, clients are only told about
+			// new ones and changed addresses
+			seen := service.Seen{}
 			for entry := range entries {
+				change := seen.Observe(entry)
+				if change == service.Unchanged {
+					continue
+				}
 				log.WithField("service", entry).Debug("Discovered service.")
 
 				var message Message
-				message.Discovered = &entry.ServiceEntry
+				if unified {
+					device := discoveredSenso(entry)
+					device.Updated = change == service.Moved
+					message.DiscoveredDevice = &device
+				} else if change == service.Moved {
+					message.Rediscovered = &entry.ServiceEntry
+				} else {
+					message.Discovered = &entry.ServiceEntry
+				}
 
 				err := sendMessage(message)
 				if err != nil {
@@ -353,31 +964,123 @@ func (handle *Handle) dispatchCommand(ctx context.Context, log *logrus.Entry, co
 
 		return nil
 
+	} else if command.CancelDiscover != nil {
+		cancelled := handle.discoveries.cancel(session)
+		log.WithField("discoveries", cancelled).Debug("Cancelled discovery.")
+		return nil
+
+	} else if command.ConfirmPairing != nil {
+		handle.ConfirmPairing(command.ConfirmPairing.Device)
+		return nil
+
 	} else if command.UpdateFirmware != nil {
-		go handle.ProcessFirmwareUpdateRequest(*command.UpdateFirmware, SendMsg{
-			progress: func(msg string) {
-				sendMessage(firmwareUpdateProgress(msg))
-			},
-			failure: func(msg string) {
-				sendMessage(firmwareUpdateFailure(msg))
-			},
-			success: func(msg string) {
-				sendMessage(firmwareUpdateSuccess(msg))
-			},
+		request := handle.confirmations.Hold(session, "UpdateFirmware", describeFirmwareUpdate(*command.UpdateFirmware), func() {
+			go func() {
+				// Do not disrupt other clients, unless forced
+				if !command.UpdateFirmware.Force && !handle.sessions.Guard(ctx, session, handle.busyPolicy, func(busy sessions.Busy) {
+					log.WithField("sessions", len(busy.Sessions)).WithField("queued", busy.Queued).Info("Holding back firmware update while other clients are connected.")
+					sendMessage(Message{FirmwareUpdateBusy: &busy})
+				}) {
+					return
+				}
+
+				handle.ProcessFirmwareUpdateRequest(*command.UpdateFirmware, SendMsg{
+					progress: func(msg catalog.Text) {
+						sendMessage(firmwareUpdateProgress(msg))
+					},
+					failure: func(msg catalog.Text) {
+						sendMessage(firmwareUpdateFailure(msg))
+					},
+					success: func(msg catalog.Text) {
+						sendMessage(firmwareUpdateSuccess(msg))
+					},
+				})
+			}()
+		})
+		return sendMessage(Message{ConfirmationRequired: &request})
+
+	} else if command.RecordCalibrationPoint != nil {
+		record := *command.RecordCalibrationPoint
+		go func() {
+			point, points, err := handle.RecordCalibrationPoint(ctx, record.Device, record.Weight, time.Duration(record.Duration*float64(time.Second)))
+			if err != nil {
+				log.WithError(err).Info("Could not record calibration point.")
+				sendMessage(Message{UnitCalibrationFailed: &UnitCalibrationFailure{Device: record.Device, Error: err.Error()}})
+				return
+			}
+			log.WithField("weight", point.Weight).WithField("reading", point.Reading).Info("Recorded calibration point.")
+			sendMessage(Message{CalibrationPoint: &RecordedPoint{Device: record.Device, CalibrationPoint: point, Points: points}})
+		}()
+		return nil
+
+	} else if command.FitUnitCalibration != nil {
+		id := command.FitUnitCalibration.Device
+		calibration, err := handle.FitUnitCalibration(id)
+		if err != nil {
+			log.WithError(err).Info("Could not fit unit calibration.")
+			return sendMessage(Message{UnitCalibrationFailed: &UnitCalibrationFailure{Device: id, Error: err.Error()}})
+		}
+		log.WithField("calibration", calibration.ID).WithField("senso", calibration.Senso).Info("Fitted unit calibration.")
+		handle.Broadcast(Message{UnitCalibrated: &calibration})
+		return nil
+
+	} else if command.TraceFrames != nil {
+		if handle.frameTrace == nil {
+			log.Info("Can not trace frames, no directory is configured.")
+			return sendMessage(Message{FrameTraceStopped: &logging.TraceSummary{Reason: "error"}})
+		}
+		started, err := handle.frameTrace.Start("senso", time.Duration(command.TraceFrames.Duration*float64(time.Second)), func(summary logging.TraceSummary) {
+			log.WithField("file", summary.File).WithField("frames", summary.Frames).WithField("reason", summary.Reason).Info("Stopped tracing frames.")
+			sendMessage(Message{FrameTraceStopped: &summary})
 		})
+		if err != nil {
+			log.WithError(err).Warn("Could not start tracing frames.")
+			return sendMessage(Message{FrameTraceStopped: &logging.TraceSummary{Reason: "error"}})
+		}
+		log.WithField("file", started.File).WithField("duration", started.Duration).Info("Tracing frames.")
+		return sendMessage(Message{FrameTraceStarted: &started})
+
+	} else if command.Ping != nil {
+		// Pongs of the client are only noticed while its commands are read
+		ping := *command.Ping
+		go func() {
+			result := handle.ping(ctx, ping, pinger)
+			log.WithField("device", result.DeviceRoundTrip).WithField("client", result.ClientRoundTrip).Info("Measured latency.")
+			sendMessage(Message{Latency: &result})
+		}()
+		return nil
+
+	} else if command.Confirm != nil {
+		if !handle.confirmations.Confirm(session, command.Confirm.Token) {
+			log.Info("Refusing unknown or expired confirmation.")
+			return sendMessage(Message{ConfirmationInvalid: &command.Confirm.Token})
+		}
 	}
 	return nil
 }
 
-func firmwareUpdateSuccess(msg string) Message {
+// What a firmware update will do, for the client to confirm
+func describeFirmwareUpdate(command UpdateFirmware) string {
+	target := "the connected Senso"
+	if command.SerialNumber != "" {
+		target = fmt.Sprintf("the Senso with serial number %q", command.SerialNumber)
+	}
+	description := fmt.Sprintf("Flash a firmware image of %d bytes onto %s", base64.StdEncoding.DecodedLen(len(command.Image)), target)
+	if command.Force {
+		description += ", even if other clients are connected"
+	}
+	return description
+}
+
+func firmwareUpdateSuccess(msg catalog.Text) Message {
 	return firmwareUpdateMessage(FirmwareUpdateMessage{FirmwareUpdateSuccess: &msg})
 }
 
-func firmwareUpdateFailure(msg string) Message {
+func firmwareUpdateFailure(msg catalog.Text) Message {
 	return firmwareUpdateMessage(FirmwareUpdateMessage{FirmwareUpdateFailure: &msg})
 }
 
-func firmwareUpdateProgress(msg string) Message {
+func firmwareUpdateProgress(msg catalog.Text) Message {
 	return firmwareUpdateMessage(FirmwareUpdateMessage{FirmwareUpdateProgress: &msg})
 }
 
@@ -385,18 +1088,38 @@ func firmwareUpdateMessage(msg FirmwareUpdateMessage) Message {
 	return Message{FirmwareUpdateMessage: &msg}
 }
 
-// rx_data_loop reads data from Senso and forwards it up the WebSocket
-func rx_data_loop(ctx context.Context, rx chan interface{}, send func([]byte) error) {
+// outbound_loop forwards data from Senso and messages meant for all clients up
+// the WebSocket in order. Data is wrapped in an envelope naming the device if
+// tagged, else only data of the default device is sent. Data of the data
+// channel is sent as Frame messages, if converting them.
+func outbound_loop(ctx context.Context, outbound chan interface{}, received *drops.Subscriber, tagged bool, convert func(Frame) Frame, sendData func([]byte) error, sendMessage func(Message) error) {
+	// Broadcasts wait for all subscribers, so the channel is read until the
+	// subscription ends
+	defer func() {
+		for range outbound {
+		}
+	}()
 	var err error
 	for {
 		select {
 		case <-ctx.Done():
 			return
 
-		case i := <-rx:
-			data, ok := i.([]byte)
-			if ok {
-				err = send(data)
+		case i := <-outbound:
+			switch item := i.(type) {
+			case packet:
+				received.Received(item.sequence)
+				if convert != nil && item.channel == dataChannel.name {
+					if tagged || item.device == defaultDevice {
+						err = sendFrames(item, convert, sendMessage)
+					}
+				} else if tagged {
+					err = sendData(envelope(item.device, item.data))
+				} else if item.device == defaultDevice {
+					err = sendData(item.data)
+				}
+			case Message:
+				err = sendMessage(item)
 			}
 		}
 
@@ -406,6 +1129,53 @@ func rx_data_loop(ctx context.Context, rx chan interface{}, send func([]byte) er
 	}
 }
 
+// Send the data frames of a packet as messages
+func sendFrames(item packet, convert func(Frame) Frame, sendMessage func(Message) error) error {
+	for _, frame := range item.frames {
+		frame.Device = item.device
+		frame = convert(frame)
+		err := sendMessage(Message{Frame: &frame})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Conversion of frames sent to a client, nil if frames are not decoded. Frames
+// of devices without unit calibration are not converted.
+func (handle *Handle) frameConverter(unit string, decoded bool) func(Frame) Frame {
+	if !decoded {
+		return nil
+	}
+	return func(frame Frame) Frame {
+		if unit == "" {
+			return frame
+		}
+		calibration, ok := handle.unitCalibration(frame.Device)
+		if !ok {
+			return frame
+		}
+		return calibration.convert(frame, unit)
+	}
+}
+
+// status_loop sends the status to mirrors, which may not ask for it, on connection and periodically
+func status_loop(ctx context.Context, interval time.Duration, send func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if send() != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // Helper to upgrade http to WebSocket
 var webSocketUpgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
@@ -0,0 +1,59 @@
+package senso
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+
+	"github.com/dividat/driver/src/dividat-driver/codec"
+)
+
+func TestMessagesEncodeAlikeInJSONAndCBOR(t *testing.T) {
+	device := "a"
+	for _, message := range []Message{
+		{PairingRequired: &device},
+		{Paired: &device},
+	} {
+		_, text, err := codec.JSON.Encode(&message)
+		if err != nil {
+			t.Fatalf("could not encode %+v as JSON: %v", message, err)
+		}
+		_, payload, err := codec.CBOR.Encode(&message)
+		if err != nil {
+			t.Fatalf("could not encode %+v as CBOR: %v", message, err)
+		}
+
+		var fromJSON, fromCBOR map[string]interface{}
+		json.Unmarshal(text, &fromJSON)
+		// Numbers are compared as JSON reads them
+		var generic map[string]interface{}
+		if err := cbor.Unmarshal(payload[3:], &generic); err != nil {
+			t.Fatalf("could not decode %x: %v", payload, err)
+		}
+		converted, _ := json.Marshal(generic)
+		json.Unmarshal(converted, &fromCBOR)
+
+		if !reflect.DeepEqual(fromJSON, fromCBOR) {
+			t.Errorf("expected %v in CBOR, got %v", fromJSON, fromCBOR)
+		}
+	}
+}
+
+func TestCommandsDecodeFromCBOR(t *testing.T) {
+	_, payload, _ := codec.CBOR.Encode(map[string]interface{}{"type": "TraceFrames", "duration": 30})
+	var command Command
+	if ok, err := codec.CBOR.Decode(websocket.BinaryMessage, payload, &command); !ok || err != nil {
+		t.Fatalf("could not decode %x: %v", payload, err)
+	}
+	if command.TraceFrames == nil || command.TraceFrames.Duration != 30 {
+		t.Errorf("expected TraceFrames of 30 seconds, got %+v", command)
+	}
+
+	_, payload, _ = codec.CBOR.Encode(map[string]interface{}{"type": "ConfirmPairing"})
+	if _, err := codec.CBOR.Decode(websocket.BinaryMessage, payload, &Command{}); err == nil {
+		t.Errorf("expected commands to be validated")
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/libp2p/zeroconf/v2"
+	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/client"
+	"github.com/dividat/driver/src/dividat-driver/config"
+)
+
+// Announce the remote endpoint via mDNS until the context is cancelled
+func advertise(ctx context.Context, remote config.RemoteAccess, label string, machineId string, log *logrus.Entry) {
+	_, portString, err := net.SplitHostPort(remote.Address)
+	if err != nil {
+		log.WithError(err).Warn("Could not determine port to announce.")
+		return
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		log.WithError(err).Warn("Could not determine port to announce.")
+		return
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = machineId
+	}
+
+	instance := client.InstanceName(hostname, label)
+	announcement, err := zeroconf.Register(instance, client.ServiceType, "local.", port, client.Text(hostname, label, version, machineId), nil)
+	if err != nil {
+		log.WithError(err).Warn("Could not announce driver on the network.")
+		return
+	}
+
+	log.WithField("instance", instance).Info("Announcing driver on the network.")
+
+	<-ctx.Done()
+	announcement.Shutdown()
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/auth"
+	"github.com/dividat/driver/src/dividat-driver/config"
+	"github.com/dividat/driver/src/dividat-driver/outbound"
+)
+
+// Longest the driver waits for an authentication webhook to decide
+const webhookTimeout = 5 * time.Second
+
+// Decides whether to admit requests without a valid token, and with which role
+type authenticator interface {
+	authenticate(r *http.Request) (auth.Role, bool)
+}
+
+// Authenticators of an instance, validated when loading the configuration
+func authenticators(name string, cfg config.Authentication, settings config.Outbound, log *logrus.Entry) []authenticator {
+	authenticators := []authenticator{}
+	if len(cfg.Users) > 0 {
+		authenticators = append(authenticators, peerUsers(parseRoles(cfg.Users)))
+	}
+	if len(cfg.Certificates) > 0 {
+		authenticators = append(authenticators, certificateNames(parseRoles(cfg.Certificates)))
+	}
+	if cfg.Webhook != "" {
+		client, err := outbound.Client(settings, webhookTimeout)
+		if err != nil {
+			log.WithError(err).Warn("Could not set up authentication webhook, ignoring it.")
+		} else {
+			authenticators = append(authenticators, webhook{url: cfg.Webhook, instance: name, client: client, log: log})
+		}
+	}
+	return authenticators
+}
+
+func parseRoles(names map[string]string) map[string]auth.Role {
+	roles := map[string]auth.Role{}
+	for name, role := range names {
+		if parsed, err := auth.ParseRole(role); err == nil {
+			roles[name] = parsed
+		}
+	}
+	return roles
+}
+
+// Local users connecting through the Unix socket, identified by the kernel
+type peerUsers map[string]auth.Role
+
+func (users peerUsers) authenticate(r *http.Request) (auth.Role, bool) {
+	user, ok := peerFrom(r.Context())
+	if !ok {
+		return 0, false
+	}
+	role, ok := users[user]
+	return role, ok
+}
+
+// Remote clients presenting a certificate verified against the client CA
+type certificateNames map[string]auth.Role
+
+func (names certificateNames) authenticate(r *http.Request) (auth.Role, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return 0, false
+	}
+	role, ok := names[r.TLS.VerifiedChains[0][0].Subject.CommonName]
+	return role, ok
+}
+
+// External service deciding on requests. It is sent a JSON description of the
+// request and admits it by answering with status 200 and the granted role, e.g.
+// `{"role": "observer"}`.
+type webhook struct {
+	url      string
+	instance string
+	client   *http.Client
+	log      *logrus.Entry
+}
+
+type webhookRequest struct {
+	Instance      string `json:"instance"`
+	Path          string `json:"path"`
+	RemoteAddress string `json:"remoteAddress"`
+	// Credentials presented by the client, e.g. a token the service knows
+	Authorization string `json:"authorization,omitempty"`
+	Token         string `json:"token,omitempty"`
+}
+
+func (hook webhook) authenticate(r *http.Request) (auth.Role, bool) {
+	body, err := json.Marshal(webhookRequest{
+		Instance:      hook.instance,
+		Path:          r.URL.Path,
+		RemoteAddress: r.RemoteAddr,
+		Authorization: r.Header.Get("Authorization"),
+		Token:         r.URL.Query().Get("token"),
+	})
+	if err != nil {
+		return 0, false
+	}
+
+	response, err := hook.client.Post(hook.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		hook.log.WithError(err).Warn("Could not reach authentication webhook.")
+		return 0, false
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, false
+	}
+
+	var decision struct {
+		Role string `json:"role"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&decision)
+	if err != nil {
+		hook.log.WithError(err).Warn("Could not decode decision of authentication webhook.")
+		return 0, false
+	}
+	role, err := auth.ParseRole(strings.ToLower(decision.Role))
+	if err != nil {
+		hook.log.WithError(err).Warn("Authentication webhook granted an unknown role.")
+		return 0, false
+	}
+	return role, true
+}
@@ -0,0 +1,51 @@
+//go:build debug
+// +build debug
+
+package server
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/clock"
+	"github.com/dividat/driver/src/dividat-driver/flex/enumerator"
+	"github.com/dividat/driver/src/dividat-driver/hooks"
+	"github.com/dividat/driver/src/dividat-driver/inspector"
+)
+
+// Environment variable running background logic on a fake clock, advanced
+// through /debug/clock
+const fakeClockVariable = "DIVIDAT_DRIVER_FAKE_CLOCK"
+
+var fakeClock *clock.Fake
+
+// Substitute the clock before any background logic starts
+func init() {
+	if os.Getenv(fakeClockVariable) != "" {
+		fakeClock = clock.NewFake(time.Now())
+		clock.Default = fakeClock
+	}
+}
+
+// Endpoints only available in builds with the `debug` tag
+func setupDebugEndpoints(mux *http.ServeMux, origins *originList, log *logrus.Entry, instances []instance) {
+	log.Warn("Debug build, mock device and traffic inspector endpoints are enabled.")
+
+	if fakeClock != nil {
+		log.Warn("Background logic runs on a fake clock, advance it through /debug/clock.")
+		mux.Handle("/debug/clock", originMiddleware(origins, log, fakeClock))
+	}
+
+	mux.Handle("/debug/mock-devices", originMiddleware(origins, log, enumerator.Mock))
+
+	// Trace the protocol of all instances
+	traffic := inspector.New()
+	for _, instance := range instances {
+		instance.senso.Hooks = hooks.Join(instance.senso.Hooks, traffic.Hooks())
+		instance.flex.Hooks = hooks.Join(instance.flex.Hooks, traffic.Hooks())
+	}
+	mux.Handle("/debug/traffic", originMiddleware(origins, log, traffic))
+}
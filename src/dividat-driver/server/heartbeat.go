@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/clock"
+	"github.com/dividat/driver/src/dividat-driver/config"
+	"github.com/dividat/driver/src/dividat-driver/heartbeat"
+	"github.com/dividat/driver/src/dividat-driver/outbound"
+)
+
+// Time to wait for the heartbeat endpoint to answer
+const heartbeatTimeout = 30 * time.Second
+
+// Report the state of the driver and its devices to the heartbeat endpoint
+// until the context is cancelled
+func startHeartbeat(ctx context.Context, log *logrus.Entry, cfg *config.Config, machineId string, instances []instance) error {
+	client, err := heartbeatClient(cfg.Heartbeat, cfg.Outbound)
+	if err != nil {
+		return err
+	}
+
+	started := clock.Default.Now()
+	publisher := &heartbeat.Publisher{
+		URL:       cfg.Heartbeat.URL,
+		MachineID: machineId,
+		Token:     cfg.Heartbeat.Token,
+		Client:    client,
+		Spool:     filepath.Join(cfg.DataDirectory, "heartbeats"),
+		Collect: func() heartbeat.Report {
+			return heartbeat.Report{
+				Label:   cfg.Label,
+				Version: version,
+				Uptime:  clock.Default.Now().Sub(started).Seconds(),
+				Devices: heartbeatDevices(instances),
+			}
+		},
+	}
+	go publisher.Run(ctx, log, cfg.Heartbeat.Every())
+	return nil
+}
+
+func heartbeatClient(settings config.Heartbeat, outboundSettings config.Outbound) (*http.Client, error) {
+	if settings.CertFile == "" {
+		return outbound.Client(outboundSettings, heartbeatTimeout)
+	}
+	certificate, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load client certificate: %v", err)
+	}
+	return outbound.ClientWithCertificate(outboundSettings, certificate, heartbeatTimeout)
+}
+
+// Devices of all instances, Sensos first
+func heartbeatDevices(instances []instance) []heartbeat.Device {
+	devices := []heartbeat.Device{}
+	for _, instance := range instances {
+		status := instance.senso.Status()
+		sensoDevice := heartbeat.Device{Instance: instance.name, Kind: "senso", SerialNumber: status.SerialNumber, State: status.State()}
+		if status.Address != nil {
+			sensoDevice.Address = *status.Address
+		}
+		devices = append(devices, sensoDevice)
+		for _, named := range status.Devices {
+			devices = append(devices, heartbeat.Device{
+				Instance:     instance.name,
+				Kind:         "senso",
+				Device:       named.Device,
+				Address:      named.Address,
+				SerialNumber: named.SerialNumber,
+				State:        named.State(),
+			})
+		}
+
+		flexDevice := heartbeat.Device{Instance: instance.name, Kind: "flex", State: "disconnected"}
+		if info := instance.flex.Device(); info != nil {
+			flexDevice.Address, flexDevice.SerialNumber, flexDevice.State = info.Path, info.SerialNumber, "connected"
+		}
+		devices = append(devices, flexDevice)
+	}
+	return devices
+}
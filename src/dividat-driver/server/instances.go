@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/auth"
+	"github.com/dividat/driver/src/dividat-driver/config"
+	"github.com/dividat/driver/src/dividat-driver/drops"
+	"github.com/dividat/driver/src/dividat-driver/flex"
+	"github.com/dividat/driver/src/dividat-driver/pairing"
+	"github.com/dividat/driver/src/dividat-driver/senso"
+)
+
+// Device handlers of one logical driver
+type instance struct {
+	name  string
+	senso *senso.Handle
+	flex  *flex.Handle
+}
+
+// Statistics of data missed by clients of the instance
+func (i instance) drops() []drops.Snapshot {
+	return append([]drops.Snapshot{i.senso.Drops()}, i.flex.Drops()...)
+}
+
+// Create the handlers of a configured instance and mount them below `/<name>/`
+// of each mux
+func mountInstance(ctx context.Context, muxes []*http.ServeMux, origins *originList, log *logrus.Entry, pairingStore *pairing.Store, settings config.Outbound, cfg config.Instance, all []config.Instance) instance {
+	log = log.WithField("instance", cfg.Name)
+
+	sensoHandle := senso.New(ctx, log.WithField("package", "senso"), pairingStore)
+	// Devices of other instances are left alone, also by instances listing none
+	others := []config.Instance{}
+	for _, other := range all {
+		if other.Name != cfg.Name {
+			others = append(others, other)
+		}
+	}
+	sensoHandle.RestrictAddresses(cfg.SensoAddresses, claimedSensoAddresses(others))
+
+	flexHandle := flex.New(ctx, log.WithField("package", "flex"), pairingStore)
+	flexHandle.RestrictDevices(cfg.FlexSerialNumbers, claimedFlexDevices(others))
+
+	prefix := "/" + cfg.Name
+	grants := tokenGrants(cfg)
+	backends := authenticators(cfg.Name, cfg.Authentication, settings, log)
+	for _, mux := range muxes {
+		mux.Handle(prefix+"/senso", originMiddleware(origins, log, tokenMiddleware(cfg.Name, grants, backends, log, sensoHandle)))
+		mux.Handle(prefix+"/flex", originMiddleware(origins, log, tokenMiddleware(cfg.Name, grants, backends, log, flexHandle)))
+		mux.Handle(prefix+"/senso/mirror", originMiddleware(origins, log, tokenMiddleware(cfg.Name, grants, backends, log, mirrorMiddleware(sensoHandle))))
+		mux.Handle(prefix+"/flex/mirror", originMiddleware(origins, log, tokenMiddleware(cfg.Name, grants, backends, log, mirrorMiddleware(flexHandle))))
+	}
+
+	log.WithField("prefix", prefix).Info("Serving driver instance.")
+	if len(cfg.SensoAddresses) == 0 {
+		log.Warn("Instance lists no Senso addresses and shares all unclaimed Sensos with the default driver and other such instances.")
+	}
+	if len(cfg.FlexSerialNumbers) == 0 {
+		log.Warn("Instance lists no Flex serial numbers and shares all unclaimed Flex devices with the default driver and other such instances.")
+	}
+
+	return instance{name: cfg.Name, senso: sensoHandle, flex: flexHandle}
+}
+
+// Senso addresses assigned to any of the instances, which the default driver
+// and other instances must leave alone
+func claimedSensoAddresses(instances []config.Instance) []string {
+	claimed := []string{}
+	for _, instance := range instances {
+		claimed = append(claimed, instance.SensoAddresses...)
+	}
+	return claimed
+}
+
+// Flex devices assigned to any of the instances, which the default driver and
+// other instances must leave alone
+func claimedFlexDevices(instances []config.Instance) []string {
+	claimed := []string{}
+	for _, instance := range instances {
+		claimed = append(claimed, instance.FlexSerialNumbers...)
+	}
+	return claimed
+}
+
+// Token and the role it grants
+type tokenGrant struct {
+	token string
+	role  auth.Role
+}
+
+// Tokens of an instance, validated when loading the configuration
+func tokenGrants(cfg config.Instance) []tokenGrant {
+	grants := []tokenGrant{}
+	if cfg.Token != "" {
+		grants = append(grants, tokenGrant{token: cfg.Token, role: auth.Maintenance})
+	}
+	for _, token := range cfg.Tokens {
+		role, err := auth.ParseRole(token.Role)
+		if err != nil {
+			continue
+		}
+		grants = append(grants, tokenGrant{token: token.Token, role: role})
+	}
+	return grants
+}
+
+// Middleware to only admit requests presenting a token of an instance, and
+// attach the role granted by the token to the request.
+//
+// Browsers can not set headers on WebSocket connections, so the token may
+// also be given as `token` query parameter. Temporary sessions signed with a
+// maintenance token are given as `session` query parameter. Requests without
+// valid token or session are passed to the instance's further authenticators.
+func tokenMiddleware(name string, grants []tokenGrant, backends []authenticator, log *logrus.Entry, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := r.URL.Query().Get("token")
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			presented = strings.TrimPrefix(header, "Bearer ")
+		}
+
+		for _, grant := range grants {
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(grant.token)) == 1 {
+				next.ServeHTTP(w, r.WithContext(auth.WithRole(r.Context(), grant.role)))
+				return
+			}
+		}
+
+		// Temporary sessions only observe, connections are closed once they expire
+		if session := r.URL.Query().Get("session"); session != "" {
+			for _, grant := range grants {
+				if grant.role != auth.Maintenance {
+					continue
+				}
+				if expires, ok := auth.VerifySession(grant.token, name, session, time.Now()); ok {
+					log.WithField("expires", expires).Info("Admitting request of temporary session.")
+					ctx := auth.WithExpiry(auth.WithRole(r.Context(), auth.Observer), expires)
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+			}
+		}
+
+		for _, backend := range backends {
+			if role, ok := backend.authenticate(r); ok {
+				log.WithField("role", role).Info("Admitting authenticated request.")
+				next.ServeHTTP(w, r.WithContext(auth.WithRole(r.Context(), role)))
+				return
+			}
+		}
+
+		log.WithField("path", r.URL.Path).Info("Denying request without valid token.")
+		w.WriteHeader(401)
+	})
+}
+
+// Serve a handler to clients that only receive data and status, e.g. wall-mounted
+// dashboards, whatever role they were admitted with
+func mirrorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(auth.WithRole(r.Context(), auth.Mirror)))
+	})
+}
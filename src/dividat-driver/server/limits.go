@@ -0,0 +1,32 @@
+package server
+
+import (
+	"github.com/dividat/driver/src/dividat-driver/flex"
+	"github.com/dividat/driver/src/dividat-driver/limits"
+	"github.com/dividat/driver/src/dividat-driver/storage"
+)
+
+// Use of capped resources, limits are zero if unlimited
+type resourceReport struct {
+	Clients     limits.Resource `json:"clients"`
+	SerialPorts limits.Resource `json:"serialPorts"`
+	MemoryBytes limits.Resource `json:"memoryBytes"`
+	// Omitted if usage of the data directory can not be determined
+	RecordingBytes *limits.Resource `json:"recordingBytes,omitempty"`
+	StorageBytes   *limits.Resource `json:"storageBytes,omitempty"`
+}
+
+func reportLimits(resourceLimits *limits.Limits, storageManager *storage.Manager) resourceReport {
+	openPorts, maxPorts := flex.SerialPorts()
+	report := resourceReport{
+		Clients:     resourceLimits.Clients(),
+		SerialPorts: limits.Resource{Used: int64(openPorts), Max: int64(maxPorts)},
+		MemoryBytes: resourceLimits.Memory(),
+	}
+	if usage, err := storageManager.Usage(); err == nil {
+		recordings := usage.Features["recordings"]
+		report.RecordingBytes = &limits.Resource{Used: recordings.Bytes, Max: recordings.MaxBytes}
+		report.StorageBytes = &limits.Resource{Used: usage.Bytes, Max: usage.MaxBytes}
+	}
+	return report
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// First file descriptor passed by systemd socket activation
+const listenFdsStart = 3
+
+// Acquire the listener of the local server. If the driver was started by
+// systemd socket activation the inherited socket is used, otherwise the
+// address is bound.
+func listen(address string) (listener net.Listener, activated bool, err error) {
+	listener, err = systemdListener()
+	if err != nil {
+		return nil, false, err
+	}
+	if listener != nil {
+		return listener, true, nil
+	}
+
+	listener, err = net.Listen("tcp", address)
+	return listener, false, err
+}
+
+// Socket passed by systemd (see sd_listen_fds(3)), nil if not socket activated
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+
+	// Do not pass the sockets on to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(uintptr(listenFdsStart), "systemd-socket")
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not use socket passed by systemd: %v", err)
+	}
+	return listener, nil
+}
+
+// Counts open connections, including those taken over by WebSockets
+type connectionTracker struct {
+	mutex      sync.Mutex
+	open       int
+	lastClosed time.Time
+}
+
+func newConnectionTracker() *connectionTracker {
+	return &connectionTracker{lastClosed: time.Now()}
+}
+
+func (tracker *connectionTracker) opened() {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.open++
+}
+
+func (tracker *connectionTracker) closed() {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.open--
+	tracker.lastClosed = time.Now()
+}
+
+// How long no connection has been open, zero if any is open
+func (tracker *connectionTracker) idleFor() time.Duration {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.open > 0 {
+		return 0
+	}
+	return time.Since(tracker.lastClosed)
+}
+
+// Listener registering accepted connections with a tracker
+type trackingListener struct {
+	net.Listener
+	tracker *connectionTracker
+}
+
+func (listener trackingListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	listener.tracker.opened()
+	return &trackedConn{Conn: conn, tracker: listener.tracker}, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	tracker *connectionTracker
+	once    sync.Once
+}
+
+func (conn *trackedConn) Close() error {
+	conn.once.Do(conn.tracker.closed)
+	return conn.Conn.Close()
+}
+
+// Call onIdle once no connection has been open for the timeout
+func exitWhenIdle(ctx context.Context, tracker *connectionTracker, timeout time.Duration, log *logrus.Entry, onIdle func()) {
+	interval := 10 * time.Second
+	if timeout < interval {
+		interval = timeout
+	}
+
+	for {
+		if tracker.idleFor() >= timeout {
+			log.WithField("timeout", timeout).Info("No connections, exiting while idle.")
+			onIdle()
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
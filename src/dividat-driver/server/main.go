@@ -3,14 +3,23 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"net"
 	"net/http"
+	"path/filepath"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/dividat/driver/src/dividat-driver/config"
 	"github.com/dividat/driver/src/dividat-driver/flex"
+	"github.com/dividat/driver/src/dividat-driver/indicator"
+	"github.com/dividat/driver/src/dividat-driver/limits"
 	"github.com/dividat/driver/src/dividat-driver/logging"
+	"github.com/dividat/driver/src/dividat-driver/pairing"
 	"github.com/dividat/driver/src/dividat-driver/rfid"
 	"github.com/dividat/driver/src/dividat-driver/senso"
+	"github.com/dividat/driver/src/dividat-driver/sessions"
+	"github.com/dividat/driver/src/dividat-driver/storage"
 )
 
 // Uncomment following line for profiling. And run `go tool pprof http://localhost:8382/debug/pprof/profile` or `go tool pprof http://localhost:8382/debug/pprof/heap`
@@ -21,12 +30,26 @@ var version string
 
 const serverPort = "8382"
 
-// Start the driver server
-func Start(logger *logrus.Logger, origins []string) context.CancelFunc {
+// LocalAddress is where the driver serves clients on this machine
+const LocalAddress = "127.0.0.1:" + serverPort
+
+// Start the driver server. If an idle timeout is configured and the driver was
+// socket activated, onIdle is called once no connection has been open for that long.
+func Start(logger *logrus.Logger, cfg *config.Config, onIdle func()) context.CancelFunc {
+	origins := newOriginList(cfg.PermissibleOrigins)
+	remote := cfg.Remote
+
 	// Log Server
 	logServer := logging.NewLogServer()
 	logger.AddHook(logServer)
 
+	// Keep entries of all levels to write them out when an error is logged
+	setLevel := logger.SetLevel
+	if window := cfg.Incidents(); window > 0 {
+		recorder := logging.CaptureIncidents(logger, filepath.Join(cfg.DataDirectory, "incidents"), window)
+		setLevel = recorder.SetLevel
+	}
+
 	baseLog := logger.WithFields(logrus.Fields{
 		"version": version,
 	})
@@ -45,64 +68,417 @@ func Start(logger *logrus.Logger, origins []string) context.CancelFunc {
 
 	baseLog.Info("Dividat Driver starting")
 
+	for feature, enabled := range cfg.Features {
+		if enabled {
+			baseLog.WithField("feature", feature).Info("Experimental feature enabled.")
+		}
+	}
+
+	// All endpoints, served locally
+	mux := http.NewServeMux()
+
+	// Endpoints served to remote connections and on the Unix socket. Only
+	// endpoints of instances, which admit clients presenting a token, and the
+	// description of the driver are mounted, so that nobody on the network
+	// controls devices of the default endpoints.
+	protectedMux := http.NewServeMux()
+
 	// Setup log endpoint
-	http.Handle("/log", originMiddleware(origins, baseLog, logServer))
+	mux.Handle("/log", originMiddleware(origins, baseLog, logServer))
 
 	// Setup a context
 	ctx, cancel := context.WithCancel(context.Background())
 
+	// Setup pairing of devices
+	var pairingStore *pairing.Store
+	if cfg.RequirePairing {
+		pairingStore, err = pairing.Open(filepath.Join(cfg.DataDirectory, "paired-devices.json"))
+		if err != nil {
+			baseLog.WithError(err).Panic("Could not open store of paired devices.")
+		}
+	}
+
+	// Additional Flex hardware and scanning, also applied on reload
+	setFlexDevices(baseLog, cfg.FlexDevices)
+	flex.SetScanInterval(cfg.FlexScan())
+	for _, profile := range cfg.FlexProfiles {
+		err := registerFlexProfile(profile)
+		if err != nil {
+			baseLog.WithError(err).WithField("revision", profile.Revision).Warn("Ignoring invalid Flex profile.")
+			continue
+		}
+		baseLog.WithField("revision", profile.Revision).Info("Registered Flex firmware profile.")
+	}
+	for _, entry := range cfg.FlexSerialSettings {
+		vid, pid, err := config.ParseUSBID(entry.Device)
+		if err == nil {
+			err = flex.RegisterSerialSettings(vid, pid, flex.SerialSettings{BaudRate: entry.BaudRate, Parity: entry.Parity, DataBits: entry.DataBits, StopBits: entry.StopBits})
+		}
+		if err != nil {
+			baseLog.WithError(err).WithField("device", entry.Device).Warn("Ignoring invalid Flex serial settings.")
+			continue
+		}
+		baseLog.WithField("device", entry.Device).Info("Using custom serial settings for Flex device.")
+	}
+	if cfg.FlexDeviceProfiles != "" {
+		profiles, err := flex.LoadDeviceProfiles(cfg.FlexDeviceProfiles)
+		if err != nil {
+			baseLog.WithError(err).WithField("path", cfg.FlexDeviceProfiles).Error("Could not load Flex device profiles.")
+		}
+		for _, profile := range profiles {
+			baseLog.WithField("profile", profile.Name).WithField("device", profile.Device).Info("Registered Flex device profile.")
+		}
+	}
+
 	// Setup Senso
-	sensoHandle := senso.New(ctx, baseLog.WithField("package", "senso"))
-	http.Handle("/senso", originMiddleware(origins, baseLog, sensoHandle))
+	sensoHandle := senso.New(ctx, baseLog.WithField("package", "senso"), pairingStore)
+	sensoHandle.RestrictAddresses(nil, claimedSensoAddresses(cfg.Instances))
+	mux.Handle("/senso", originMiddleware(origins, baseLog, sensoHandle))
+	mux.Handle("/senso/mirror", originMiddleware(origins, baseLog, mirrorMiddleware(sensoHandle)))
 
 	// Setup SensingTex reader
-	flexHandle := flex.New(ctx, baseLog.WithField("package", "flex"))
-	http.Handle("/flex", originMiddleware(origins, baseLog, flexHandle))
+	flexHandle := flex.New(ctx, baseLog.WithField("package", "flex"), pairingStore)
+	flexHandle.RestrictDevices(nil, claimedFlexDevices(cfg.Instances))
+	mux.Handle("/flex", originMiddleware(origins, baseLog, flexHandle))
+	mux.Handle("/flex/mirror", originMiddleware(origins, baseLog, mirrorMiddleware(flexHandle)))
+
+	// Setup additional driver instances
+	instances := []instance{{name: "default", senso: sensoHandle, flex: flexHandle}}
+	for _, instanceConfig := range cfg.Instances {
+		instances = append(instances, mountInstance(ctx, []*http.ServeMux{mux, protectedMux}, origins, baseLog, pairingStore, cfg.Outbound, instanceConfig, cfg.Instances))
+	}
+
+	// Drop implausible Flex sets instead of forwarding them
+	if cfg.Features.Enabled("flexFrameValidation") {
+		for _, instance := range instances {
+			instance.flex.ValidateFrames()
+		}
+	}
+
+	// Ask Sensos for device information, not yet verified against hardware
+	if cfg.Features.Enabled("sensoDeviceInfo") {
+		for _, instance := range instances {
+			instance.senso.RequestDeviceInfo()
+		}
+	}
+
+	// Help client teams migrate off deprecated protocol paths
+	if cfg.StrictProtocol {
+		for _, instance := range instances {
+			instance.senso.WarnDeprecations()
+			instance.flex.WarnDeprecations()
+		}
+	}
+
+	// Firmware updates must not disrupt other clients, validated when loading the configuration
+	busyPolicy, _ := sessions.ParsePolicy(cfg.FirmwareUpdateWhenBusy)
+	for _, instance := range instances {
+		instance.senso.SetBusyPolicy(busyPolicy)
+		instance.flex.SetBusyPolicy(busyPolicy)
+	}
+
+	// Restart acquisition from Flex devices that stop sending
+	if timeout := cfg.FlexData(); timeout > 0 {
+		for _, instance := range instances {
+			instance.flex.WatchData(timeout)
+		}
+	}
+
+	// Pace polling of Flex devices that do not stream
+	if cfg.FlexPollRate > 0 {
+		for _, instance := range instances {
+			instance.flex.PollAt(cfg.FlexPollRate)
+		}
+	}
+
+	// Summarize each connection in the access log
+	if cfg.AccessLog != "" {
+		accessLog, err := logging.OpenAccessLog(cfg.AccessLog)
+		if err != nil {
+			baseLog.WithError(err).WithField("path", cfg.AccessLog).Error("Could not open access log.")
+		} else {
+			for _, instance := range instances {
+				instance.senso.Hooks = accessLog.Hooks()
+				instance.flex.Hooks = accessLog.Hooks()
+			}
+		}
+	}
+
+	// Remember dead cells of Flex devices across restarts
+	masks, err := flex.OpenMasks(filepath.Join(cfg.DataDirectory, "flex-dead-cells.json"))
+	if err != nil {
+		baseLog.WithError(err).Warn("Could not open store of dead Flex cells, masks will not be persisted.")
+	} else {
+		for _, instance := range instances {
+			instance.flex.UseMasks(masks)
+		}
+	}
+
+	// Notice Sensos that stop responding on unreliable networks
+	tcpSettings := senso.DefaultTCPSettings
+	dialTimeout, keepAlive, readTimeout := cfg.SensoTCP.Durations()
+	if dialTimeout > 0 {
+		tcpSettings.DialTimeout = dialTimeout
+	}
+	if keepAlive > 0 {
+		tcpSettings.KeepAlive = keepAlive
+	}
+	tcpSettings.ReadTimeout = readTimeout
+	for _, instance := range instances {
+		instance.senso.SetTCPSettings(tcpSettings)
+	}
+
+	// Speak the protocol of old firmware with Sensos that need it
+	sensoProtocols := senso.Protocols{Default: cfg.SensoProtocol.Default, ByAddress: cfg.SensoProtocol.Addresses}
+	sensoProtocols.LegacyPorts.Data, sensoProtocols.LegacyPorts.Control = cfg.SensoProtocol.LegacyPorts.Strings()
+	for _, instance := range instances {
+		instance.senso.SetProtocols(sensoProtocols)
+	}
+
+	// Report Sensos that stop sending data, and reconnect them if configured
+	healthSettings := senso.DefaultHealthSettings
+	degradedAfter, stalledAfter := cfg.SensoHealth.Durations()
+	if degradedAfter > 0 {
+		healthSettings.DegradedAfter = degradedAfter
+	}
+	if stalledAfter > 0 {
+		healthSettings.StalledAfter = stalledAfter
+	}
+	healthSettings.Reconnect = cfg.SensoHealth.Reconnect
+	for _, instance := range instances {
+		instance.senso.SetHealthSettings(healthSettings)
+	}
+
+	// Offer a single discovery of Senso and Flex devices
+	for _, instance := range instances {
+		instance.senso.SetFlexDevices(flex.ListDevices)
+	}
+
+	// Remember the Sensos connected to, and connect to them again on startup
+	lastConnections, err := senso.OpenLastConnections(filepath.Join(cfg.DataDirectory, "senso-connections.json"))
+	if err != nil {
+		baseLog.WithError(err).Warn("Could not open store of last connected Sensos, connections will not be restored.")
+	} else {
+		for _, instance := range instances {
+			instance.senso.RememberConnections(lastConnections, instance.name)
+			if cfg.SensoReconnect {
+				instance.senso.Reconnect()
+			}
+		}
+	}
+
+	// Let support trace device data of live sessions into the data directory
+	for _, instance := range instances {
+		instance.senso.TraceFramesTo(filepath.Join(cfg.DataDirectory, "traces"))
+		instance.flex.TraceFramesTo(filepath.Join(cfg.DataDirectory, "traces"))
+	}
+
+	// Persist calibrations converting Senso readings to physical units
+	unitCalibrations, err := senso.OpenUnitCalibrations(filepath.Join(cfg.DataDirectory, "senso-calibrations.json"))
+	if err != nil {
+		baseLog.WithError(err).Warn("Could not open store of Senso unit calibrations, calibrations will not be persisted.")
+	} else {
+		for _, instance := range instances {
+			instance.senso.UseUnitCalibrations(unitCalibrations)
+		}
+	}
+
+	// Report device state to tray applications and status indicators
+	if cfg.IndicatorSocket != "" {
+		indicatorListener, err := indicator.Listen(cfg.IndicatorSocket)
+		if err != nil {
+			baseLog.WithError(err).WithField("socket", cfg.IndicatorSocket).Warn("Could not listen on indicator socket.")
+		} else {
+			hub := indicator.New()
+			for _, instance := range instances {
+				name := instance.name
+				instance.senso.WatchState(func(state string) { hub.Set(name, "senso", state) })
+				instance.flex.WatchState(func(state string) { hub.Set(name, "flex", state) })
+			}
+			baseLog.WithField("socket", cfg.IndicatorSocket).Info("Reporting device state on indicator socket.")
+			go hub.Serve(ctx, baseLog.WithField("package", "indicator"), indicatorListener)
+		}
+	}
+
+	// Report version and device state to the fleet's heartbeat endpoint
+	if cfg.Heartbeat.Enabled() {
+		err := startHeartbeat(ctx, baseLog.WithField("package", "heartbeat"), cfg, systemInfo.MachineId, instances)
+		if err != nil {
+			baseLog.WithError(err).Warn("Could not set up heartbeat, not reporting to endpoint.")
+		} else {
+			baseLog.WithField("url", cfg.Heartbeat.URL).Info("Reporting heartbeats to endpoint.")
+		}
+	}
+
+	// Re-emit Senso data for native applications running alongside clients
+	if cfg.SensoUDPBridge != "" {
+		bridge, err := senso.ListenUDPBridge(cfg.SensoUDPBridge)
+		if err != nil {
+			baseLog.WithError(err).WithField("address", cfg.SensoUDPBridge).Warn("Could not set up Senso UDP bridge.")
+		} else {
+			baseLog.WithField("address", cfg.SensoUDPBridge).Info("Re-emitting Senso data over UDP.")
+			go sensoHandle.RebroadcastUDP(ctx, bridge)
+		}
+	}
+
+	// Keep the data directory within its quotas and report usage
+	storageManager := storage.New(cfg.DataDirectory, cfg.Storage.MaxBytes(), cfg.Storage.QuotaBytes())
+	go storageManager.Run(ctx, baseLog.WithField("package", "storage"))
+	mux.Handle("/storage", originMiddleware(origins, baseLog, storageManager))
+
+	// Cap clients, serial ports and memory on constrained hardware
+	resourceLimits := limits.New(cfg.Limits.MaxClients, cfg.Limits.MaxMemoryBytes())
+	go resourceLimits.Run(ctx, baseLog.WithField("package", "limits"))
+	flex.LimitSerialPorts(cfg.Limits.MaxSerialPorts)
 
 	// Setup RFID scanner
 	rfidHandle := rfid.NewHandle(ctx, baseLog.WithField("package", "rfid"))
 	// net/http performs a redirect from `/rfid` if only `/rfid/` is mounted
-	http.Handle("/rfid", originMiddleware(origins, baseLog, rfidHandle))
-	http.Handle("/rfid/", originMiddleware(origins, baseLog, rfidHandle))
+	mux.Handle("/rfid", originMiddleware(origins, baseLog, rfidHandle))
+	mux.Handle("/rfid/", originMiddleware(origins, baseLog, rfidHandle))
 
 	// Create a logger for server
 	log := baseLog.WithField("package", "server")
 
+	// Setup endpoints for testing, only in debug builds
+	setupDebugEndpoints(mux, origins, log, instances)
+
+	// Apply configuration changes while running
+	if cfg.Path != "" {
+		running := *cfg
+		go cfg.Watch(ctx, func(reloaded *config.Config) {
+			changes := config.Diff(&running, reloaded)
+			if changes.IsEmpty() {
+				return
+			}
+
+			setLevel(reloaded.Level())
+			origins.set(reloaded.PermissibleOrigins)
+			setFlexDevices(log, reloaded.FlexDevices)
+			flex.SetScanInterval(reloaded.FlexScan())
+			running.LogLevel = reloaded.LogLevel
+			running.PermissibleOrigins = reloaded.PermissibleOrigins
+			running.FlexDevices = reloaded.FlexDevices
+			running.FlexScanInterval = reloaded.FlexScanInterval
+
+			log.WithField("applied", changes.Applied).WithField("restartRequired", changes.RestartRequired).Info("Configuration reloaded.")
+			for _, instance := range instances {
+				instance.senso.Broadcast(senso.Message{ConfigReloaded: &changes})
+				instance.flex.Broadcast(flex.Message{ConfigReloaded: &changes})
+			}
+		}, func(err error) {
+			log.WithError(err).Warn("Could not reload configuration, keeping current configuration.")
+		})
+	}
+
 	// Start the monitor
-	go startMonitor(baseLog.WithField("package", "monitor"))
+	go startMonitor(baseLog.WithField("package", "monitor"), instances)
+	mux.Handle("/metrics", originMiddleware(origins, baseLog, metricsHandler(instances)))
 
 	// Setup HTTP Server
-	server := http.Server{Addr: "127.0.0.1:" + serverPort}
+	handler := resourceLimits.Middleware(log, mux)
+	server := http.Server{Addr: LocalAddress, Handler: handler}
 
-	// Server root
-	rootMsg, _ := json.Marshal(map[string]string{
-		"message":   "Dividat Driver",
-		"version":   version,
-		"machineId": systemInfo.MachineId,
-		"os":        systemInfo.Os,
-		"arch":      systemInfo.Arch,
-	})
-	http.Handle("/", originMiddleware(origins, baseLog, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	// Server root, describing the driver
+	root := originMiddleware(origins, baseLog, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		w.Write(rootMsg)
-	})))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":        "Dividat Driver",
+			"version":        version,
+			"machineId":      systemInfo.MachineId,
+			"os":             systemInfo.Os,
+			"arch":           systemInfo.Arch,
+			"features":       cfg.Features,
+			"resourceLimits": reportLimits(resourceLimits, storageManager),
+		})
+	}))
+	mux.Handle("/", root)
+	protectedMux.Handle("/", exactPath("/", root))
+	protectedHandler := resourceLimits.Middleware(log, protectedMux)
 
 	// Start the server
-	log.WithField("port", serverPort).Info("Starting HTTP server.")
+	connections := newConnectionTracker()
+	listener, activated, err := listen(server.Addr)
+	if err != nil {
+		log.Panic(err)
+	}
+	if activated {
+		log.WithField("address", listener.Addr().String()).Info("Starting HTTP server on socket passed by systemd.")
+	} else {
+		log.WithField("port", serverPort).Info("Starting HTTP server.")
+	}
 
 	go func() {
-		serverErr := server.ListenAndServe()
+		serverErr := server.Serve(trackingListener{Listener: listener, tracker: connections})
 		if serverErr != http.ErrServerClosed {
 			log.Panic(serverErr)
 		}
 	}()
 
+	// Exit when unused, systemd starts the driver again on the next connection
+	if idleTimeout := cfg.Idle(); idleTimeout > 0 {
+		if activated {
+			go exitWhenIdle(ctx, connections, idleTimeout, log, onIdle)
+		} else {
+			log.Warn("Ignoring idle timeout, the driver was not socket activated.")
+		}
+	}
+
+	// Start the remote server
+	var remoteServer *http.Server
+	if remote.Enabled() {
+		remoteServer, err = newRemoteServer(remote, protectedHandler)
+		if err != nil {
+			log.WithError(err).Panic("Could not set up remote server.")
+		}
+
+		log.WithField("address", remote.Address).Info("Starting HTTPS server for remote connections.")
+
+		remoteListener, err := net.Listen("tcp", remote.Address)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		go advertise(ctx, remote, cfg.Label, systemInfo.MachineId, log)
+
+		go func() {
+			serverErr := remoteServer.ServeTLS(trackingListener{Listener: remoteListener, tracker: connections}, remote.CertFile, remote.KeyFile)
+			if serverErr != http.ErrServerClosed {
+				log.Panic(serverErr)
+			}
+		}()
+	}
+
+	// Start the server on the Unix socket, identifying local users
+	var socketServer *http.Server
+	if cfg.Socket != "" {
+		socketListener, err := listenSocket(cfg.Socket)
+		if err != nil {
+			log.WithError(err).WithField("socket", cfg.Socket).Warn("Could not listen on Unix socket.")
+		} else {
+			log.WithField("socket", cfg.Socket).Info("Starting HTTP server on Unix socket.")
+			socketServer = &http.Server{Handler: protectedHandler, ConnContext: withPeer}
+			go func() {
+				serverErr := socketServer.Serve(trackingListener{Listener: socketListener, tracker: connections})
+				if serverErr != http.ErrServerClosed {
+					log.Panic(serverErr)
+				}
+			}()
+		}
+	}
+
 	// cleanup routine
 	go func() {
 		<-ctx.Done()
 
 		log.Info("Server closing down.")
 		server.Close()
+		if remoteServer != nil {
+			remoteServer.Close()
+		}
+		if socketServer != nil {
+			socketServer.Close()
+		}
 
 	}()
 
@@ -115,13 +491,13 @@ func Start(logger *logrus.Logger, origins []string) context.CancelFunc {
 // to the loopback address. In order to protect WebSocket endpoints, for which
 // CORS pre-flight requests are not performed, we fully deny requests from
 // unknown origins instead of just withholding CORS headers.
-func originMiddleware(origins []string, log *logrus.Entry, next http.Handler) http.Handler {
+func originMiddleware(origins *originList, log *logrus.Entry, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
 
 		// Check whether a request was made from a permissible origin.
 		// An absent Origin header indicates a non-browser request and is permissible.
-		if origin != "" && !contains(origins, origin) {
+		if origin != "" && !contains(origins.get(), origin) {
 			log.WithField("origin", r.Header.Get("Origin")).Info("Denying request from untrusted origin.")
 			w.WriteHeader(403)
 			return
@@ -146,6 +522,44 @@ func originMiddleware(origins []string, log *logrus.Entry, next http.Handler) ht
 	})
 }
 
+// Treat the additional USB devices as Flex devices, validated when loading the
+// configuration
+func setFlexDevices(log *logrus.Entry, ids []string) {
+	devices := []flex.USBID{}
+	for _, id := range ids {
+		vid, pid, err := config.ParseUSBID(id)
+		if err != nil {
+			log.WithError(err).Warn("Ignoring invalid Flex device.")
+			continue
+		}
+		devices = append(devices, flex.USBID{VID: vid, PID: pid})
+		log.WithField("device", id).Info("Treating additional USB device as Flex device.")
+	}
+	flex.SetAdditionalDevices(devices)
+}
+
+// List of permissible origins, may change when configuration is reloaded
+type originList struct {
+	mutex   sync.RWMutex
+	origins []string
+}
+
+func newOriginList(origins []string) *originList {
+	return &originList{origins: origins}
+}
+
+func (list *originList) get() []string {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+	return list.origins
+}
+
+func (list *originList) set(origins []string) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.origins = origins
+}
+
 func contains(slice []string, candidate string) bool {
 	for _, member := range slice {
 		if member == candidate {
@@ -154,3 +568,51 @@ func contains(slice []string, candidate string) bool {
 	}
 	return false
 }
+
+// Register a Flex firmware profile from the configuration, validated when loading it
+func registerFlexProfile(profile config.FlexProfile) error {
+	vid, pid, err := profile.USB()
+	if err != nil {
+		return err
+	}
+	minBcd, maxBcd, err := profile.BcdRange()
+	if err != nil {
+		return err
+	}
+	command := func(text string) []byte {
+		if text == "" {
+			return nil
+		}
+		return []byte(text)
+	}
+	return flex.RegisterProfile(flex.Profile{
+		VID:          vid,
+		PID:          pid,
+		MinBcdDevice: minBcd,
+		MaxBcdDevice: maxBcd,
+		Capabilities: flex.Capabilities{
+			Revision:  profile.Revision,
+			Streaming: profile.Streaming,
+			PollRate:  profile.PollRate,
+			Bitdepths: profile.Bitdepths,
+			Commands: flex.Commands{
+				Start:   command(profile.Commands.Start),
+				Poll:    command(profile.Commands.Poll),
+				Stop:    command(profile.Commands.Stop),
+				Sleep:   command(profile.Commands.Sleep),
+				Version: command(profile.Commands.Version),
+			},
+		},
+	})
+}
+
+// Serve only the given path, e.g. the root without catching all other paths
+func exactPath(path string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
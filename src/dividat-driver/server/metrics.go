@@ -0,0 +1,40 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dividat/driver/src/dividat-driver/drops"
+)
+
+// Serve statistics of the instances in the Prometheus text format
+func metricsHandler(instances []instance) http.Handler {
+	metrics := []struct {
+		name  string
+		kind  string
+		help  string
+		value func(drops.Snapshot) string
+	}{
+		{"dividat_driver_published_total", "counter", "Messages published to clients.", func(s drops.Snapshot) string { return fmt.Sprint(s.Published) }},
+		{"dividat_driver_dropped_total", "counter", "Messages missed by clients falling behind.", func(s drops.Snapshot) string { return fmt.Sprint(s.Dropped) }},
+		{"dividat_driver_recent_drop_rate", "gauge", "Messages missed per second during the last window.", func(s drops.Snapshot) string { return fmt.Sprint(s.Recent.Rate) }},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		snapshots := map[string][]drops.Snapshot{}
+		for _, instance := range instances {
+			snapshots[instance.name] = instance.drops()
+		}
+
+		for _, metric := range metrics {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+			for _, instance := range instances {
+				for _, snapshot := range snapshots[instance.name] {
+					fmt.Fprintf(w, "%s{instance=%q,topic=%q} %s\n", metric.name, instance.name, snapshot.Topic, metric.value(snapshot))
+				}
+			}
+		}
+	})
+}
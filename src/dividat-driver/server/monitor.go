@@ -5,9 +5,11 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/dividat/driver/src/dividat-driver/drops"
 )
 
-func startMonitor(log *logrus.Entry) {
+func startMonitor(log *logrus.Entry, instances []instance) {
 	var m runtime.MemStats
 
 	c := time.NewTicker(30 * time.Second).C
@@ -15,5 +17,27 @@ func startMonitor(log *logrus.Entry) {
 	for range c {
 		runtime.ReadMemStats(&m)
 		log.WithField("heapAlloc", m.HeapAlloc).WithField("routines", runtime.NumGoroutine()).Info("Monitoring runtime.")
+
+		for _, instance := range instances {
+			stats := instance.flex.FrameStats()
+			if stats.Received == 0 && stats.Dropped == 0 {
+				continue
+			}
+			log.WithField("instance", instance.name).WithField("received", stats.Received).WithField("dropped", stats.Dropped).WithField("resynced", stats.Resynced).Info("Monitoring Flex sets.")
+		}
+
+		for _, instance := range instances {
+			for _, snapshot := range instance.drops() {
+				if snapshot.Published == 0 {
+					continue
+				}
+				entry := log.WithField("instance", instance.name).WithField("topic", snapshot.Topic).WithField("published", snapshot.Published).WithField("dropped", snapshot.Dropped).WithField("recentRate", snapshot.Recent.Rate)
+				if snapshot.Recent.Share > drops.WarningShare {
+					entry.WithField("recentShare", snapshot.Recent.Share).Warn("Clients miss messages, they may not keep up with the data rate.")
+				} else {
+					entry.Debug("Monitoring dropped messages.")
+				}
+			}
+		}
 	}
 }
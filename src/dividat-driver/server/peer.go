@@ -0,0 +1,26 @@
+package server
+
+import (
+	"context"
+	"net"
+)
+
+type peerKey struct{}
+
+// Attach the user connected through a Unix socket to the connection's context
+func withPeer(ctx context.Context, conn net.Conn) context.Context {
+	if tracked, ok := conn.(*trackedConn); ok {
+		conn = tracked.Conn
+	}
+	user, ok := peerUser(conn)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, peerKey{}, user)
+}
+
+// User name of the peer, if connected through a Unix socket
+func peerFrom(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(peerKey{}).(string)
+	return user, ok
+}
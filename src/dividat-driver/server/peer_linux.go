@@ -0,0 +1,57 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// Listen on a Unix socket accessible to all local users, who are identified by
+// their credentials
+func listenSocket(path string) (net.Listener, error) {
+	// Left behind by a previous run
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0666)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// User name of the process at the other end of a Unix socket
+func peerUser(conn net.Conn) (string, bool) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return "", false
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return "", false
+	}
+
+	var credentials *syscall.Ucred
+	var credentialsErr error
+	err = raw.Control(func(fd uintptr) {
+		credentials, credentialsErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credentialsErr != nil {
+		return "", false
+	}
+
+	account, err := user.LookupId(strconv.Itoa(int(credentials.Uid)))
+	if err != nil {
+		return "", false
+	}
+	return account.Username, true
+}
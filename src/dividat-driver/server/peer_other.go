@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+// Credentials of peers are only read on Linux
+func listenSocket(path string) (net.Listener, error) {
+	return nil, errors.New("Unix socket is only supported on Linux")
+}
+
+func peerUser(conn net.Conn) (string, bool) {
+	return "", false
+}
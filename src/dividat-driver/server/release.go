@@ -0,0 +1,14 @@
+//go:build !debug
+// +build !debug
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Debug endpoints are not available in release builds
+func setupDebugEndpoints(mux *http.ServeMux, origins *originList, log *logrus.Entry, instances []instance) {
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/dividat/driver/src/dividat-driver/config"
+)
+
+// Create the TLS server for remote connections.
+//
+// Device data may be health-related, so the remote listener is only ever
+// served over TLS. Only TLS 1.2 and later with ECDHE key exchange are
+// accepted. This way every session negotiates its own ephemeral key and
+// recorded traffic can not be decrypted later, even if the certificate's
+// private key leaks.
+//
+// If a client CA is configured, clients are asked for a certificate, which
+// instances may accept instead of a token. Clients without certificate are
+// still admitted to the TLS handshake.
+func newRemoteServer(remote config.RemoteAccess, handler http.Handler) (*http.Server, error) {
+	server := &http.Server{
+		Addr:    remote.Address,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+			},
+		},
+	}
+
+	if remote.ClientCA != "" {
+		pem, err := ioutil.ReadFile(remote.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", remote.ClientCA)
+		}
+		server.TLSConfig.ClientCAs = pool
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return server, nil
+}
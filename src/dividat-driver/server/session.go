@@ -0,0 +1,99 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/auth"
+	"github.com/dividat/driver/src/dividat-driver/config"
+)
+
+// SessionCommand prints links granting read-only access to the endpoints of an
+// instance for a limited time, e.g. for remote troubleshooting by support staff.
+func SessionCommand(flags []string) {
+	sessionFlags := flag.NewFlagSet("support-link", flag.ExitOnError)
+	configPath := sessionFlags.String("config", "", "Path to the JSON configuration file of the running driver")
+	instanceName := sessionFlags.String("instance", "", "Name of the instance to grant access to")
+	minutes := sessionFlags.Int("minutes", 30, "Minutes until access is revoked")
+	address := sessionFlags.String("address", "", "Address (host:port) the links point to, default is the configured remote address")
+	sessionFlags.Parse(flags)
+
+	if *configPath == "" || *instanceName == "" {
+		sessionFlags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	duration := time.Duration(*minutes) * time.Minute
+	if duration <= 0 || duration > auth.MaxSessionDuration {
+		fmt.Printf("Session must last between 1 minute and %v.\n", auth.MaxSessionDuration)
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Printf("Could not load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	key, err := sessionKey(cfg, *instanceName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	host := *address
+	if host == "" {
+		host, err = advertisedHost(cfg.Remote.Address)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	expires := time.Now().Add(duration)
+	query := url.Values{"session": {auth.SignSession(key, *instanceName, expires)}}.Encode()
+
+	fmt.Printf("Read-only access to instance %s until %s:\n", *instanceName, expires.Format(time.RFC1123))
+	for _, endpoint := range []string{"senso", "flex"} {
+		link := url.URL{Scheme: "wss", Host: host, Path: "/" + *instanceName + "/" + endpoint, RawQuery: query}
+		fmt.Println(link.String())
+	}
+}
+
+// Maintenance token of the instance, used to sign sessions
+func sessionKey(cfg *config.Config, name string) (string, error) {
+	for _, instance := range cfg.Instances {
+		if instance.Name != name {
+			continue
+		}
+		for _, grant := range tokenGrants(instance) {
+			if grant.role == auth.Maintenance {
+				return grant.token, nil
+			}
+		}
+		return "", fmt.Errorf("instance %q has no maintenance token to sign sessions with", name)
+	}
+	return "", fmt.Errorf("no instance named %q is configured", name)
+}
+
+// Address of the remote server as reachable from other machines
+func advertisedHost(address string) (string, error) {
+	if address == "" {
+		return "", fmt.Errorf("remote access is not configured, specify an address")
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host, err = os.Hostname()
+		if err != nil {
+			return "", err
+		}
+	}
+	return net.JoinHostPort(host, port), nil
+}
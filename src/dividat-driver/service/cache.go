@@ -0,0 +1,86 @@
+package service
+
+// Clients retry discoveries every few seconds, while Sensos take a moment to
+// answer mDNS queries. Services announced recently are kept in a cache, so that
+// a discovery can report them right away. The scan of each discovery keeps
+// running in the background and refreshes the cache with its announcements.
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dividat/driver/src/dividat-driver/clock"
+)
+
+// Services not announced for this long are dropped from the cache
+const CacheTTL = 30 * time.Second
+
+// Cache of recently announced services
+type Cache struct {
+	ttl time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]cachedService
+}
+
+type cachedService struct {
+	service     Service
+	announcedAt time.Time
+}
+
+// DefaultCache is shared by all discoveries of the driver
+var DefaultCache = NewCache(CacheTTL)
+
+// NewCache returns an empty cache keeping services for the ttl
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{ttl: ttl, entries: make(map[string]cachedService)}
+}
+
+// Scan like `Scan`, sending cached services first. Services announced by the
+// scan are cached.
+func (cache *Cache) Scan(ctx context.Context) chan Service {
+	services := make(chan Service)
+	cached := cache.fresh()
+	live := Scan(ctx)
+	go func() {
+		defer close(services)
+		for _, service := range cached {
+			select {
+			case services <- service:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for service := range live {
+			cache.store(service)
+			select {
+			case services <- service:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return services
+}
+
+func (cache *Cache) store(service Service) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.entries[identify(service)] = cachedService{service: service, announcedAt: clock.Default.Now()}
+}
+
+// Services announced within the ttl, dropping older ones
+func (cache *Cache) fresh() []Service {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	now := clock.Default.Now()
+	services := []Service{}
+	for key, entry := range cache.entries {
+		if now.Sub(entry.announcedAt) >= cache.ttl {
+			delete(cache.entries, key)
+			continue
+		}
+		services = append(services, entry.service)
+	}
+	return services
+}
@@ -51,7 +51,13 @@ const (
 	BootloaderMode  = "Bootloader"
 )
 
+// Browses for services until the context is cancelled, closing the entries
+// channel when done. May be substituted for testing.
+var browse = zeroconf.Browse
+
 // Scan for services of a specific type, ie `SensoUpdate` or `SensoControl`.
+// Scanning stops as soon as the context is cancelled, even if nobody reads the
+// results anymore.
 func scanForType(ctx context.Context, t ServiceType, results chan<- Service, wg *sync.WaitGroup) {
 	wg.Add(2)
 	// Zeroconf closes the channel on context cancellation,
@@ -62,19 +68,27 @@ func scanForType(ctx context.Context, t ServiceType, results chan<- Service, wg
 	// then forward the discovered service entries to the main results channel in
 	// a separate goroutine.
 	localEntries := make(chan *zeroconf.ServiceEntry)
+	// Read before starting the goroutine, so tests may substitute browsing
+	// while scans wind down
+	browseFor := browse
 	go func() {
 		defer wg.Done()
-		err := zeroconf.Browse(ctx, string(t), "local.", localEntries)
+		err := browseFor(ctx, string(t), "local.", localEntries)
 		if err != nil {
 			fmt.Println("Discovery error:", err)
 		}
 	}()
 
-	// Forward entries from localEntries to the main results channel
+	// Forward entries from localEntries to the main results channel. After
+	// cancellation entries are drained until zeroconf closes the channel, so
+	// that its goroutines are not blocked either.
 	go func() {
 		defer wg.Done()
 		entriesWithoutSerial := 0
 		for entry := range localEntries {
+			if ctx.Err() != nil {
+				continue
+			}
 			if entry != nil {
 				text := getText(*entry)
 				if text.Serial == "" {
@@ -87,10 +101,13 @@ func scanForType(ctx context.Context, t ServiceType, results chan<- Service, wg
 				} else {
 					continue
 				}
-				results <- Service{
+				select {
+				case results <- Service{
 					Address:      address,
 					Text:         text,
 					ServiceEntry: *entry,
+				}:
+				case <-ctx.Done():
 				}
 			}
 		}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/libp2p/zeroconf/v2"
+
+	"github.com/dividat/driver/src/dividat-driver/clock"
+)
+
+// Emits entries until cancelled, then closes the channel like zeroconf does
+func fakeBrowse(ctx context.Context, service string, domain string, entries chan<- *zeroconf.ServiceEntry, opts ...zeroconf.ClientOption) error {
+	defer close(entries)
+	entry := &zeroconf.ServiceEntry{
+		ServiceRecord: zeroconf.ServiceRecord{Service: service},
+		Text:          []string{"ser_no=SENSO1"},
+		AddrIPv4:      []net.IP{net.IPv4(192, 168, 1, 10)},
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case entries <- entry:
+		}
+	}
+}
+
+// Substitute browsing, returns a function restoring it
+func withFakeBrowse() func() {
+	original := browse
+	browse = fakeBrowse
+	return func() { browse = original }
+}
+
+// Wait for goroutines started by a test to end
+func waitForGoroutines(t *testing.T, baseline int) {
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines survived scanning", runtime.NumGoroutine()-baseline)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestScanStopsWhenAbandoned(t *testing.T) {
+	defer withFakeBrowse()()
+	baseline := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	services := Scan(ctx)
+
+	// Read a single result, then go away like a disconnecting client
+	<-services
+	cancel()
+
+	waitForGoroutines(t, baseline)
+}
+
+func TestScanClosesResultsOnCancel(t *testing.T) {
+	defer withFakeBrowse()()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	services := Scan(ctx)
+	cancel()
+
+	select {
+	case <-drain(services):
+	case <-time.After(time.Second):
+		t.Fatal("Results were not closed after cancellation")
+	}
+}
+
+func TestFindStopsScanning(t *testing.T) {
+	defer withFakeBrowse()()
+	baseline := runtime.NumGoroutine()
+
+	found := Find(context.Background(), time.Minute, SerialNumberFilter("SENSO1"))
+	if found == nil || found.Address != "192.168.1.10" {
+		t.Fatalf("Unexpected result: %v", found)
+	}
+
+	waitForGoroutines(t, baseline)
+}
+
+func drain(services chan Service) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range services {
+		}
+		close(done)
+	}()
+	return done
+}
+
+func TestSeenReportsNewAndMovedServices(t *testing.T) {
+	announce := func(serial string, instance string, address string) Service {
+		entry := zeroconf.ServiceEntry{ServiceRecord: zeroconf.ServiceRecord{Instance: instance, Service: string(SensoControl)}}
+		if serial != "" {
+			entry.Text = []string{"ser_no=" + serial}
+		}
+		return Service{Text: getText(entry), Address: address, ServiceEntry: entry}
+	}
+
+	seen := Seen{}
+	for i, step := range []struct {
+		service Service
+		change  Change
+	}{
+		{announce("SENSO1", "Senso 1", "192.168.1.10"), Added},
+		{announce("SENSO1", "Senso 1", "192.168.1.10"), Unchanged},
+		{announce("SENSO2", "Senso 2", "192.168.1.11"), Added},
+		{announce("SENSO1", "Senso 1", "192.168.1.12"), Moved},
+		{announce("SENSO1", "Senso 1", "192.168.1.12"), Unchanged},
+		{announce("", "Bootloader", "192.168.1.13"), Added},
+		{announce("", "Bootloader", "192.168.1.13"), Unchanged},
+	} {
+		if change := seen.Observe(step.service); change != step.change {
+			t.Errorf("Announcement %d: expected change %d, got %d", i, step.change, change)
+		}
+	}
+}
+
+// Emits no entries, like a network where Sensos are slow to answer
+func silentBrowse(ctx context.Context, service string, domain string, entries chan<- *zeroconf.ServiceEntry, opts ...zeroconf.ClientOption) error {
+	defer close(entries)
+	<-ctx.Done()
+	return nil
+}
+
+func TestCacheAnswersFromRecentScans(t *testing.T) {
+	original := browse
+	browse = silentBrowse
+	defer func() { browse = original }()
+	fake := clock.NewFake(time.Now())
+	originalClock := clock.Default
+	clock.Default = fake
+	defer func() { clock.Default = originalClock }()
+
+	cache := NewCache(time.Minute)
+	entry := zeroconf.ServiceEntry{ServiceRecord: zeroconf.ServiceRecord{Service: string(SensoControl)}, Text: []string{"ser_no=SENSO1"}}
+	cache.store(Service{Text: getText(entry), Address: "192.168.1.10", ServiceEntry: entry})
+
+	// Cached services are sent even if nothing is announced
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	services := cache.Scan(ctx)
+	select {
+	case service := <-services:
+		if service.Text.Serial != "SENSO1" {
+			t.Errorf("Unexpected cached service: %v", service)
+		}
+	case <-ctx.Done():
+		t.Error("Cached service was not sent")
+	}
+	cancel()
+	<-drain(services)
+
+	// Until they expire
+	fake.Advance(time.Minute)
+	if cached := cache.fresh(); len(cached) != 0 {
+		t.Fatalf("Expected cached services to expire, got %v", cached)
+	}
+}
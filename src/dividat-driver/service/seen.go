@@ -0,0 +1,55 @@
+package service
+
+import (
+	"fmt"
+)
+
+// mDNS reports the same service repeatedly within a scan, e.g. once per
+// network interface or address family and on every re-announcement. Clients
+// listing discovered Sensos are only told about new services and services
+// whose address changed.
+
+// Change of a service within a scan
+type Change int
+
+const (
+	// Announced before with the same address
+	Unchanged Change = iota
+	// First announcement within the scan
+	Added
+	// Announced before with another address
+	Moved
+)
+
+// Seen tracks the services announced within a scan
+type Seen struct {
+	addresses map[string]string
+}
+
+// Observe an announced service, returning how it changed since it was last
+// announced
+func (seen *Seen) Observe(service Service) Change {
+	if seen.addresses == nil {
+		seen.addresses = make(map[string]string)
+	}
+	key := identify(service)
+	address, known := seen.addresses[key]
+	seen.addresses[key] = service.Address
+	if !known {
+		return Added
+	} else if address != service.Address {
+		return Moved
+	}
+	return Unchanged
+}
+
+// Identify a service by its serial number, or by its instance name if it does
+// not announce one. A Senso announces distinct services in bootloader and
+// application mode.
+func identify(service Service) string {
+	entry := service.ServiceEntry
+	if getText(entry).Serial == "" {
+		return fmt.Sprintf("%s/instance/%s", entry.Service, entry.Instance)
+	}
+	return fmt.Sprintf("%s/serial/%s", entry.Service, service.Text.Serial)
+}
@@ -0,0 +1,129 @@
+package sessions
+
+/* WebSocket clients connected to a device handler.
+
+Handlers register their clients, so that disruptive operations like firmware
+updates can take them into account.
+
+*/
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Session of a client streaming from a device handler
+type Session struct {
+	Address   string    `json:"address"`
+	UserAgent string    `json:"userAgent"`
+	Since     time.Time `json:"since"`
+}
+
+// Registry of open sessions
+type Registry struct {
+	mutex sync.Mutex
+	next  int
+	open  map[int]Session
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{open: map[int]Session{}}
+}
+
+// Open registers a session and returns its ID
+func (registry *Registry) Open(session Session) int {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.next++
+	registry.open[registry.next] = session
+	return registry.next
+}
+
+// Close removes the session with given ID
+func (registry *Registry) Close(id int) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	delete(registry.open, id)
+}
+
+// Get returns the open session with given ID
+func (registry *Registry) Get(id int) (Session, bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	session, ok := registry.open[id]
+	return session, ok
+}
+
+// Others returns the open sessions except the one with given ID
+func (registry *Registry) Others(id int) []Session {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	others := []Session{}
+	for other, session := range registry.open {
+		if other != id {
+			others = append(others, session)
+		}
+	}
+	return others
+}
+
+// Policy for disruptive operations requested while other sessions are open
+type Policy string
+
+const (
+	// Refuse the operation
+	Refuse Policy = "refuse"
+	// Wait until the other sessions are closed
+	Queue Policy = "queue"
+)
+
+// ParsePolicy parses a policy as used in configuration, refusing if empty
+func ParsePolicy(name string) (Policy, error) {
+	switch Policy(name) {
+	case "", Refuse:
+		return Refuse, nil
+	case Queue:
+		return Queue, nil
+	default:
+		return "", fmt.Errorf("unknown policy %q", name)
+	}
+}
+
+// Busy reports the sessions holding up an operation
+type Busy struct {
+	Sessions []Session `json:"sessions"`
+	// Whether the operation proceeds once the sessions are closed
+	Queued bool `json:"queued"`
+}
+
+// Guard returns whether an operation requested by the session with given ID
+// may proceed. If other sessions are open, onBusy is called and the operation
+// is refused or, if queued, Guard blocks until they are closed or the context
+// is done.
+func (registry *Registry) Guard(ctx context.Context, id int, policy Policy, onBusy func(Busy)) bool {
+	others := registry.Others(id)
+	if len(others) == 0 {
+		return true
+	}
+
+	onBusy(Busy{Sessions: others, Queued: policy == Queue})
+	if policy != Queue {
+		return false
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			if len(registry.Others(id)) == 0 {
+				return true
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package storage
+
+/* Disk quotas for data kept by driver features.
+
+Features keep their files in a subdirectory of the data directory, named after
+the feature (e.g. `recordings`). When the files of a feature exceed its quota,
+or all files exceed the overall limit, the oldest files are removed until usage
+is within bounds again. Files directly in the data directory hold state, such as
+paired devices, count towards usage but are never removed.
+
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Interval at which quotas are enforced while running
+const EnforceInterval = time.Minute
+
+// Usage of the data directory in bytes, limits are zero if unlimited
+type Usage struct {
+	Bytes    int64                   `json:"bytes"`
+	MaxBytes int64                   `json:"maxBytes"`
+	Features map[string]FeatureUsage `json:"features"`
+}
+
+// FeatureUsage is the usage of a feature's subdirectory
+type FeatureUsage struct {
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"maxBytes"`
+	Files    int   `json:"files"`
+}
+
+// Manager keeps usage of the data directory within quotas
+type Manager struct {
+	root     string
+	maxBytes int64
+	quotas   map[string]int64
+
+	// Serializes eviction
+	mutex sync.Mutex
+}
+
+// New returns a manager for the data directory, with limits in bytes (zero for unlimited)
+func New(root string, maxBytes int64, quotas map[string]int64) *Manager {
+	return &Manager{root: root, maxBytes: maxBytes, quotas: quotas}
+}
+
+// Dir returns the directory for files of a feature, creating it if necessary
+func (manager *Manager) Dir(feature string) (string, error) {
+	dir := filepath.Join(manager.root, feature)
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+type file struct {
+	path     string
+	feature  string
+	size     int64
+	modified time.Time
+}
+
+// List files of the data directory, state files have an empty feature
+func (manager *Manager) list() ([]file, error) {
+	files := []file{}
+	err := filepath.Walk(manager.root, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == manager.root {
+			return filepath.SkipDir
+		} else if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relative, err := filepath.Rel(manager.root, path)
+		if err != nil {
+			return err
+		}
+		feature := ""
+		if dir := filepath.Dir(relative); dir != "." {
+			feature = firstElement(dir)
+		}
+		files = append(files, file{path: path, feature: feature, size: info.Size(), modified: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// First element of a relative path
+func firstElement(path string) string {
+	for {
+		dir := filepath.Dir(path)
+		if dir == "." {
+			return path
+		}
+		path = dir
+	}
+}
+
+// Usage returns the current usage of the data directory
+func (manager *Manager) Usage() (Usage, error) {
+	files, err := manager.list()
+	if err != nil {
+		return Usage{}, err
+	}
+	return manager.usage(files), nil
+}
+
+func (manager *Manager) usage(files []file) Usage {
+	usage := Usage{MaxBytes: manager.maxBytes, Features: map[string]FeatureUsage{}}
+	for feature, quota := range manager.quotas {
+		usage.Features[feature] = FeatureUsage{MaxBytes: quota}
+	}
+	for _, f := range files {
+		usage.Bytes += f.size
+		if f.feature == "" {
+			continue
+		}
+		feature := usage.Features[f.feature]
+		feature.Bytes += f.size
+		feature.Files++
+		usage.Features[f.feature] = feature
+	}
+	return usage
+}
+
+// Enforce removes the oldest files of features until all quotas are met,
+// returning the removed paths
+func (manager *Manager) Enforce() ([]string, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	files, err := manager.list()
+	if err != nil {
+		return nil, err
+	}
+	usage := manager.usage(files)
+
+	// Oldest first, state files can not be evicted
+	evictable := []file{}
+	for _, f := range files {
+		if f.feature != "" {
+			evictable = append(evictable, f)
+		}
+	}
+	sort.Slice(evictable, func(i, j int) bool { return evictable[i].modified.Before(evictable[j].modified) })
+
+	removed := []string{}
+	remove := func(f file) error {
+		err := os.Remove(f.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		feature := usage.Features[f.feature]
+		feature.Bytes -= f.size
+		feature.Files--
+		usage.Features[f.feature] = feature
+		usage.Bytes -= f.size
+		removed = append(removed, f.path)
+		return nil
+	}
+
+	kept := []file{}
+	for _, f := range evictable {
+		quota := manager.quotas[f.feature]
+		if quota > 0 && usage.Features[f.feature].Bytes > quota {
+			if err := remove(f); err != nil {
+				return removed, err
+			}
+			continue
+		}
+		kept = append(kept, f)
+	}
+
+	for _, f := range kept {
+		if manager.maxBytes <= 0 || usage.Bytes <= manager.maxBytes {
+			break
+		}
+		if err := remove(f); err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// Run enforces quotas periodically until the context is done
+func (manager *Manager) Run(ctx context.Context, log *logrus.Entry) {
+	ticker := time.NewTicker(EnforceInterval)
+	defer ticker.Stop()
+	for {
+		removed, err := manager.Enforce()
+		if err != nil {
+			log.WithError(err).Warn("Could not enforce storage quotas.")
+		} else if len(removed) > 0 {
+			log.WithField("files", len(removed)).Info("Removed oldest files to stay within storage quotas.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ServeHTTP reports usage as JSON
+func (manager *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	usage, err := manager.Usage()
+	if err != nil {
+		http.Error(w, "Could not determine storage usage", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
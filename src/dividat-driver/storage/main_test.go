@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Write a file of the given size, modified the given duration ago
+func writeFile(t *testing.T, path string, size int, age time.Duration) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path, make([]byte, size), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(-age)
+	err = os.Chtimes(path, modified, modified)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestEnforceEvictsOldestFirst(t *testing.T) {
+	root, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	state := filepath.Join(root, "paired-devices.json")
+	oldRecording := filepath.Join(root, "recordings", "old")
+	newRecording := filepath.Join(root, "recordings", "new")
+	oldCapture := filepath.Join(root, "captures", "old")
+	newCapture := filepath.Join(root, "captures", "nested", "new")
+	writeFile(t, state, 100, 4*time.Hour)
+	writeFile(t, oldRecording, 100, 3*time.Hour)
+	writeFile(t, newRecording, 100, time.Minute)
+	writeFile(t, oldCapture, 100, 2*time.Hour)
+	writeFile(t, newCapture, 100, time.Minute)
+
+	// The recordings quota removes the old recording, the overall limit then the old capture
+	manager := New(root, 300, map[string]int64{"recordings": 150})
+	removed, err := manager.Enforce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || exists(oldRecording) || exists(oldCapture) {
+		t.Errorf("Expected old recording and capture to be removed, removed %v", removed)
+	}
+	if !exists(state) || !exists(newRecording) || !exists(newCapture) {
+		t.Error("Expected state and recent files to be kept")
+	}
+
+	usage, err := manager.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Bytes != 300 || usage.Features["recordings"].Files != 1 || usage.Features["captures"].Bytes != 100 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+}
+
+func TestUsageOfMissingDirectory(t *testing.T) {
+	manager := New(filepath.Join(os.TempDir(), "storage-missing"), 0, nil)
+	usage, err := manager.Usage()
+	if err != nil || usage.Bytes != 0 {
+		t.Errorf("Expected empty usage, got %+v, %v", usage, err)
+	}
+}
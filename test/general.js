@@ -27,6 +27,13 @@ it('Get message and version with HTTP get.', async () => {
   })
 })
 
+it('Advertise feature flags with HTTP get.', async () => {
+  return getJSON('http://127.0.0.1:8382')
+  .then((response) => {
+    expect(response).to.have.property('features').that.is.an('object')
+  })
+})
+
 it('Opening a second instance of the driver fails.', (done) => {
   // the beforeEach hook already started the first running instance for us
   startDriver().on('exit', (c) => {
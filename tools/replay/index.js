@@ -64,6 +64,11 @@ function Replayer (recFile) {
     var stream = new fs.createReadStream(recFile).pipe(split())
 
     stream.on('data', (data) => {
+      // Skip capture header and trailer
+      if (data[0] === 0x23) {
+        return
+      }
+
       stream.pause()
 
       var items = data.toString().split(',')